@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/cidrmath"
+)
+
+// errNotContained makes calc contains exit with status 1 without printing
+// an error, so it can be used in shell conditions
+var errNotContained = errors.New("not contained")
+
+// calcOp is one subcommand of calc
+type calcOp struct {
+	name    string
+	args    string
+	summary string
+	run     func(fs *flag.FlagSet, args []string, e env) error
+}
+
+var calcOps = []calcOp{
+	{"split", "CIDR LEN", "split a prefix into subnets of length LEN", calcSplit},
+	{"summarize", "CIDR|IP|FIRST-LAST...", "print the fewest prefixes covering the given prefixes and ranges", calcSummarize},
+	{"contains", "CIDR CIDR|IP...", "report whether the first prefix covers each of the others", calcContains},
+	{"random-host", "[-n N] [-seed S] CIDR", "print random host addresses of a prefix", calcRandomHost},
+	{"distance", "CIDR CIDR", "print the addresses between two prefixes and their smallest supernet", calcDistance},
+}
+
+func runCalc(fs *flag.FlagSet, args []string, e env) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		fmt.Fprintln(e.stderr, "usage: trie-network calc <operation> [flags] [args]")
+		fmt.Fprintln(e.stderr)
+		fmt.Fprintln(e.stderr, "Operations:")
+		for _, op := range calcOps {
+			fmt.Fprintf(e.stderr, "  %-12s %s\n", op.name, op.summary)
+		}
+		return flag.ErrHelp
+	}
+
+	for _, op := range calcOps {
+		if op.name != args[0] {
+			continue
+		}
+		sub := flag.NewFlagSet("calc "+op.name, flag.ContinueOnError)
+		sub.SetOutput(e.stderr)
+		sub.Usage = func() {
+			fmt.Fprintf(e.stderr, "usage: trie-network calc %s %s\n\n%s\n", op.name, op.args, op.summary)
+			sub.PrintDefaults()
+		}
+		return op.run(sub, args[1:], e)
+	}
+	return fmt.Errorf("unknown calc operation %q", args[0])
+}
+
+// parseArgs parses fs and checks it was given at least min arguments, and
+// at most max unless max is negative
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < min || max >= 0 && fs.NArg() > max {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	return nil
+}
+
+func calcSplit(fs *flag.FlagSet, args []string, e env) error {
+	if err := parseArgs(fs, args, 2, 2); err != nil {
+		return err
+	}
+	p, err := cidrmath.ParsePrefix(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	bits, err := strconv.Atoi(strings.TrimPrefix(fs.Arg(1), "/"))
+	if err != nil {
+		return fmt.Errorf("invalid prefix length %q", fs.Arg(1))
+	}
+	subnets, err := cidrmath.Split(p, bits)
+	if err != nil {
+		return err
+	}
+	for _, s := range subnets {
+		fmt.Fprintln(e.stdout, s)
+	}
+	return nil
+}
+
+func calcSummarize(fs *flag.FlagSet, args []string, e env) error {
+	if err := parseArgs(fs, args, 1, -1); err != nil {
+		return err
+	}
+	var all []netip.Prefix
+	for _, arg := range fs.Args() {
+		if first, last, ok := strings.Cut(arg, "-"); ok {
+			a, err := netip.ParseAddr(strings.TrimSpace(first))
+			if err != nil {
+				return fmt.Errorf("invalid range %q: %v", arg, err)
+			}
+			b, err := netip.ParseAddr(strings.TrimSpace(last))
+			if err != nil {
+				return fmt.Errorf("invalid range %q: %v", arg, err)
+			}
+			ps, err := cidrmath.Range(a, b)
+			if err != nil {
+				return err
+			}
+			all = append(all, ps...)
+			continue
+		}
+		p, err := cidrmath.ParsePrefix(arg)
+		if err != nil {
+			return err
+		}
+		all = append(all, p)
+	}
+	for _, p := range cidrmath.Summarize(all) {
+		fmt.Fprintln(e.stdout, p)
+	}
+	return nil
+}
+
+func calcContains(fs *flag.FlagSet, args []string, e env) error {
+	if err := parseArgs(fs, args, 2, -1); err != nil {
+		return err
+	}
+	outer, err := cidrmath.ParsePrefix(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	all := true
+	for _, arg := range fs.Args()[1:] {
+		inner, err := cidrmath.ParsePrefix(arg)
+		if err != nil {
+			return err
+		}
+		answer := "yes"
+		if !cidrmath.Contains(outer, inner) {
+			answer, all = "no", false
+		}
+		fmt.Fprintf(e.stdout, "%s\t%s\n", arg, answer)
+	}
+	if !all {
+		return errNotContained
+	}
+	return nil
+}
+
+func calcRandomHost(fs *flag.FlagSet, args []string, e env) error {
+	n := fs.Int("n", 1, "number of addresses to print")
+	seed := fs.Int64("seed", 0, "random seed, for repeatable output; 0 picks one")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+	p, err := cidrmath.ParsePrefix(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(*seed))
+	for i := 0; i < *n; i++ {
+		fmt.Fprintln(e.stdout, cidrmath.RandomHost(p, r))
+	}
+	return nil
+}
+
+func calcDistance(fs *flag.FlagSet, args []string, e env) error {
+	if err := parseArgs(fs, args, 2, 2); err != nil {
+		return err
+	}
+	a, err := cidrmath.ParsePrefix(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := cidrmath.ParsePrefix(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	distance := "overlapping"
+	gap, err := cidrmath.Distance(a, b)
+	switch {
+	case err == nil:
+		distance = gap.String() + " addresses"
+	case !errors.Is(err, cidrmath.ErrOverlap):
+		return err
+	}
+	super, err := cidrmath.Supernet(a, b)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "distance\t%s\n", distance)
+	fmt.Fprintf(e.stdout, "supernet\t%s\n", super)
+	return nil
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	_ "net/http/pprof"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/bundle"
+	"github.com/metajar/trie-network/pkg/computed"
+	"github.com/metajar/trie-network/pkg/drift"
+	"github.com/metajar/trie-network/pkg/server"
+	"github.com/metajar/trie-network/pkg/trie"
+	"github.com/metajar/trie-network/pkg/triedebug"
+)
+
+// result is the JSON form of one lookup
+type result struct {
+	IP        string                 `json:"ip"`
+	CIDR      string                 `json:"cidr,omitempty"`
+	PrefixLen int                    `json:"prefix_len,omitempty"`
+	Host      bool                   `json:"host,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// lookup finds the most specific match for ip
+func lookup(t *trie.IPTrie, ip string) result {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		return result{IP: ip, Error: "invalid IP address"}
+	}
+	m, ok := t.FindMatch(addr)
+	if !ok {
+		return result{IP: ip, Error: "no matching CIDR found"}
+	}
+	return result{IP: ip, CIDR: m.CIDR, PrefixLen: m.PrefixLen, Host: m.Host, Metadata: m.Metadata}
+}
+
+func runLoad(fs *flag.FlagSet, args []string, e env) error {
+	format := fs.String("format", "", "format of the files, instead of guessing from the extension")
+	out := fs.String("o", "", "save the loaded prefixes to `FILE`, as bundle, gob, jsonl or csv by extension and JSON otherwise")
+	var m bundle.Manifest
+	fs.StringVar(&m.Name, "name", "", "dataset name for bundles, defaulting to the output file name")
+	fs.StringVar(&m.Version, "version", "", "dataset version for bundles")
+	fs.StringVar(&m.Description, "description", "", "dataset description for bundles")
+	fs.StringVar(&m.License, "license", "", "license of the data for bundles")
+	fs.StringVar(&m.Source, "source", "", "where the data came from, for bundles")
+	var exprs fileList
+	fs.Var(&exprs, "computed", "`NAME=EXPR` field computed at lookup time, for bundles, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(exprs) > 0 {
+		var err error
+		if m.Computed, err = parseComputed(exprs); err != nil {
+			return err
+		}
+		if _, err := computed.Compile(m.Computed); err != nil {
+			return err
+		}
+	}
+
+	t, err := loadFiles(fs.Args(), *format, e.stdin)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "%d prefixes (%d IPv4, %d IPv6)\n", t.Len(), t.LenV4(), t.LenV6())
+	if *out == "" {
+		return nil
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	switch formatOf(*out) {
+	case "bundle":
+		if m.Name == "" {
+			m.Name = strings.TrimSuffix(filepath.Base(*out), filepath.Ext(*out))
+		}
+		err = bundle.Write(f, t, m)
+	case "gob":
+		var data []byte
+		if data, err = t.MarshalBinary(); err == nil {
+			_, err = f.Write(data)
+		}
+	case "jsonl":
+		err = t.ExportJSONL(f)
+	case "csv":
+		err = t.ExportCSV(f)
+	default:
+		var data []byte
+		if data, err = t.MarshalJSON(); err == nil {
+			_, err = f.Write(data)
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func runInfo(fs *flag.FlagSet, args []string, e env) error {
+	asJSON := fs.Bool("json", false, "print the manifests as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	manifests, err := readManifests(fs.Args())
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(e.stdout)
+	for i, m := range manifests {
+		if *asJSON {
+			if err := enc.Encode(m); err != nil {
+				return err
+			}
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintln(e.stdout)
+		}
+		fmt.Fprintf(e.stdout, "%s\n", fs.Arg(i))
+		w := tabwriter.NewWriter(e.stdout, 0, 0, 1, ' ', 0)
+		for _, f := range [][2]string{
+			{"name", m.Name},
+			{"version", m.Version},
+			{"description", m.Description},
+			{"license", m.License},
+			{"source", m.Source},
+			{"built", m.Built.Format(time.RFC3339)},
+			{"prefixes", fmt.Sprintf("%d (%d IPv4, %d IPv6)", m.Contents.Prefixes, m.Contents.PrefixesV4, m.Contents.PrefixesV6)},
+			{"sha256", m.Contents.Checksum},
+		} {
+			if f[1] != "" {
+				fmt.Fprintf(w, "  %s:\t%s\n", f[0], f[1])
+			}
+		}
+		names := make([]string, 0, len(m.Computed))
+		for name := range m.Computed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  computed %s:\t%s\n", name, m.Computed[name])
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readManifests reads the manifest of each bundle in paths
+func readManifests(paths []string) ([]bundle.Manifest, error) {
+	manifests := make([]bundle.Manifest, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		m, err := bundle.ReadManifest(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		manifests = append(manifests, m)
+	}
+	return manifests, nil
+}
+
+func runLookup(fs *flag.FlagSet, args []string, e env) error {
+	var data fileList
+	fs.Var(&data, "d", "dataset `FILE` to search, repeatable")
+	format := fs.String("format", "", "format of the dataset files")
+	all := fs.Bool("all", false, "print every matching prefix, most specific first")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	t, err := loadFiles(data, *format, e.stdin)
+	if err != nil {
+		return err
+	}
+	for _, ip := range fs.Args() {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || addr.Zone() != "" {
+			return fmt.Errorf("%s: invalid IP address", ip)
+		}
+		var matches []trie.Match
+		if *all {
+			matches, _ = t.FindAll(ip)
+		} else if m, ok := t.FindMatch(addr); ok {
+			matches = []trie.Match{m}
+		}
+		if len(matches) == 0 {
+			fmt.Fprintf(e.stdout, "%s\t-\n", ip)
+			continue
+		}
+		for _, m := range matches {
+			md, _ := json.Marshal(m.Metadata)
+			fmt.Fprintf(e.stdout, "%s\t%s\t%s\n", ip, m.CIDR, md)
+		}
+	}
+	return nil
+}
+
+func runLookupBatch(fs *flag.FlagSet, args []string, e env) error {
+	var data fileList
+	fs.Var(&data, "d", "dataset `FILE` to search, repeatable")
+	format := fs.String("format", "", "format of the dataset files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	t, err := loadFiles(data, *format, nil)
+	if err != nil {
+		return err
+	}
+	in := e.stdin
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	w := bufio.NewWriter(e.stdout)
+	enc := json.NewEncoder(w)
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		ip := strings.TrimSpace(sc.Text())
+		if ip == "" {
+			continue
+		}
+		if err := enc.Encode(lookup(t, ip)); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func runExport(fs *flag.FlagSet, args []string, e env) error {
+	format := fs.String("format", "", "format of the input files")
+	to := fs.String("to", "jsonl", "output format: csv, jsonl or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	t, err := loadFiles(fs.Args(), *format, e.stdin)
+	if err != nil {
+		return err
+	}
+	switch *to {
+	case "csv":
+		return t.ExportCSV(e.stdout)
+	case "jsonl":
+		return t.ExportJSONL(e.stdout)
+	case "json":
+		data, err := t.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(e.stdout, "%s\n", data)
+		return err
+	}
+	return fmt.Errorf("unknown output format %q", *to)
+}
+
+func runDiff(fs *flag.FlagSet, args []string, e env) error {
+	format := fs.String("format", "", "format of the input files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	old, err := loadFiles(fs.Args()[:1], *format, e.stdin)
+	if err != nil {
+		return err
+	}
+	cur, err := loadFiles(fs.Args()[1:], *format, e.stdin)
+	if err != nil {
+		return err
+	}
+
+	r := drift.Compare(old, cur, metadataKeys(old, cur)...)
+	for _, m := range r.Missing {
+		fmt.Fprintf(e.stdout, "- %s\n", m.CIDR)
+	}
+	for _, m := range r.Unexpected {
+		fmt.Fprintf(e.stdout, "+ %s\n", m.CIDR)
+	}
+	for _, c := range r.Changed {
+		fmt.Fprintf(e.stdout, "~ %s %s\n", c.CIDR, strings.Join(c.Keys, ","))
+	}
+	if !r.Clean() {
+		return errDiffer
+	}
+	return nil
+}
+
+// metadataKeys returns every metadata key used in any of the tries
+func metadataKeys(tries ...*trie.IPTrie) []string {
+	seen := make(map[string]bool)
+	for _, t := range tries {
+		t.Walk(func(prefix string, md map[string]interface{}) bool {
+			for k := range md {
+				seen[k] = true
+			}
+			return true
+		})
+	}
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func runServe(fs *flag.FlagSet, args []string, e env) error {
+	var data fileList
+	fs.Var(&data, "d", "dataset `FILE` to serve, repeatable")
+	format := fs.String("format", "", "format of the dataset files")
+	addr := fs.String("addr", ":8080", "listen address")
+	readOnly := fs.Bool("read-only", false, "reject PUT and DELETE requests")
+	debugAddr := fs.String("debug-addr", "", "also serve /debug/vars, /debug/pprof and /debug/trie on `ADDR`")
+	var exprs fileList
+	fs.Var(&exprs, "computed", "`NAME=EXPR` field computed at lookup time, overriding the datasets' own, repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	t, err := loadFiles(data, *format, e.stdin)
+	if err != nil {
+		return err
+	}
+	var bundles []string
+	for _, path := range data {
+		if path != "-" && (*format == "bundle" || *format == "" && formatOf(path) == "bundle") {
+			bundles = append(bundles, path)
+		}
+	}
+	manifests, err := readManifests(bundles)
+	if err != nil {
+		return err
+	}
+	fields, err := computedFields(manifests, exprs)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "serving %d prefixes from %s on %s\n", t.Len(), strings.Join(baseNames(data), ", "), *addr)
+	holder := trie.NewTrieHolder()
+	holder.Store(t)
+	if *debugAddr != "" {
+		// net/http/pprof and expvar register on the default mux
+		triedebug.Publish("trie", holder)
+		http.Handle("/debug/trie", triedebug.Handler(holder))
+		go func() {
+			if err := http.ListenAndServe(*debugAddr, nil); err != nil {
+				fmt.Fprintln(e.stderr, "trie-network: debug server:", err)
+			}
+		}()
+	}
+	return http.ListenAndServe(*addr, server.New(holder, server.Config{ReadOnly: *readOnly, Manifests: manifests, Computed: fields}))
+}
+
+// parseComputed parses NAME=EXPR computed field definitions
+func parseComputed(exprs []string) (map[string]string, error) {
+	out := make(map[string]string, len(exprs))
+	for _, def := range exprs {
+		name, expr, ok := strings.Cut(def, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return nil, fmt.Errorf("computed field %q is not NAME=EXPR", def)
+		}
+		out[name] = expr
+	}
+	return out, nil
+}
+
+// computedFields compiles the computed fields of the manifests and then
+// those in exprs, later definitions of a name replacing earlier ones
+func computedFields(manifests []bundle.Manifest, exprs []string) (*computed.Fields, error) {
+	all := make(map[string]string)
+	for _, m := range manifests {
+		for name, expr := range m.Computed {
+			all[name] = expr
+		}
+	}
+	flags, err := parseComputed(exprs)
+	if err != nil {
+		return nil, err
+	}
+	for name, expr := range flags {
+		all[name] = expr
+	}
+	return computed.Compile(all)
+}
+
+// baseNames returns the file names of paths without their directories
+func baseNames(paths []string) []string {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		out[i] = filepath.Base(p)
+	}
+	return out
+}
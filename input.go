@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/metajar/trie-network/pkg/bundle"
+	"github.com/metajar/trie-network/pkg/policy"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// formats lists the data formats understood by loadFile
+const formats = "bundle, json, jsonl, csv, tsv, yaml, gob or lines"
+
+// fileList is a repeatable string flag
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// loadFiles reads every file into one trie. Later files override earlier
+// ones for prefixes they both define. "-" reads stdin, which needs format
+// since there is no extension to go by.
+func loadFiles(paths []string, format string, stdin io.Reader) (*trie.IPTrie, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no data files given")
+	}
+	t := trie.NewIPTrie()
+	for _, path := range paths {
+		next, err := loadFile(path, format, stdin)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if err := t.Merge(next, trie.Overwrite); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if err := mergeStates(t, next); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return t, nil
+}
+
+// mergeStates gives the prefixes just merged from next into t the
+// lifecycle states and dual-stack pairs they have in next, which Merge
+// leaves behind
+func mergeStates(t, next *trie.IPTrie) error {
+	var err error
+	next.Walk(func(prefix string, _ map[string]interface{}) bool {
+		state, _ := next.State(prefix)
+		if current, _ := t.State(prefix); current != state {
+			md, _ := t.FindExact(prefix)
+			if err = t.InsertWithState(prefix, state, md); err != nil {
+				return false
+			}
+		}
+		other, ok := next.Counterpart(prefix)
+		if !ok {
+			return true
+		}
+		p, perr := netip.ParsePrefix(prefix)
+		if perr != nil {
+			err = perr
+			return false
+		}
+		if p.Addr().Is4() {
+			err = t.Pair(prefix, other.CIDR)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// loadFile reads one file in the given format, or the one its extension
+// implies when format is empty
+func loadFile(path, format string, stdin io.Reader) (*trie.IPTrie, error) {
+	if format == "" {
+		format = formatOf(path)
+	}
+	if format == "yaml" {
+		if path == "-" {
+			return nil, fmt.Errorf("yaml cannot be read from stdin")
+		}
+		return policy.LoadConfig(path)
+	}
+
+	r := stdin
+	if path == "-" && stdin == nil {
+		return nil, fmt.Errorf("stdin is already used for input")
+	} else if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	switch format {
+	case "bundle":
+		t, _, err := bundle.Read(r)
+		return t, err
+	case "json":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		t := trie.NewIPTrie()
+		return t, t.UnmarshalJSON(data)
+	case "gob":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		t := trie.NewIPTrie()
+		return t, t.UnmarshalBinary(data)
+	case "jsonl":
+		return readJSONL(r)
+	case "csv":
+		return trie.LoadCSV(r, trie.CSVOptions{})
+	case "tsv":
+		return trie.LoadCSV(r, trie.CSVOptions{Comma: '\t'})
+	case "lines":
+		return readLines(r)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected %s", format, formats)
+}
+
+// formatOf guesses a file's format from its extension
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".bundle":
+		return "bundle"
+	case ".json":
+		return "json"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	case ".csv":
+		return "csv"
+	case ".tsv":
+		return "tsv"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".gob", ".bin":
+		return "gob"
+	}
+	return "lines"
+}
+
+// readJSONL reads the output of ExportJSONL, including lifecycle states
+// and dual-stack pairs
+func readJSONL(r io.Reader) (*trie.IPTrie, error) {
+	t := trie.NewIPTrie()
+	pairs := map[string]string{}
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var e struct {
+			CIDR     string                 `json:"cidr"`
+			Metadata map[string]interface{} `json:"metadata"`
+			State    string                 `json:"state"`
+			Pair     string                 `json:"pair"`
+		}
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", line, err)
+		}
+		if e.Metadata == nil {
+			e.Metadata = map[string]interface{}{}
+		}
+		state := trie.StateActive
+		if e.State != "" {
+			var err error
+			if state, err = trie.ParseState(e.State); err != nil {
+				return nil, fmt.Errorf("entry %d: %v", line, err)
+			}
+		}
+		if err := t.InsertWithState(e.CIDR, state, e.Metadata); err != nil {
+			return nil, fmt.Errorf("entry %d: %v", line, err)
+		}
+		if e.Pair != "" {
+			pairs[e.CIDR] = e.Pair
+		}
+	}
+	// Pairs can name prefixes further down the file
+	for cidr4, cidr6 := range pairs {
+		if err := t.Pair(cidr4, cidr6); err != nil {
+			return nil, fmt.Errorf("invalid pair %s: %v", cidr4, err)
+		}
+	}
+	return t, nil
+}
+
+// readLines reads one prefix per line, optionally followed by key=value
+// metadata, e.g. "10.0.0.0/8 owner=netops site=ams1". Blank lines and
+// lines starting with # are skipped.
+func readLines(r io.Reader) (*trie.IPTrie, error) {
+	t := trie.NewIPTrie()
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		md := make(map[string]interface{})
+		for _, f := range fields[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value, got %q", line, f)
+			}
+			md[k] = v
+		}
+		if err := t.Insert(fields[0], md); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return t, sc.Err()
+}
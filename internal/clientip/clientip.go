@@ -0,0 +1,51 @@
+// Package clientip holds the client address logic shared by the HTTP and
+// gRPC middlewares: walking a chain of forwarded addresses past trusted
+// proxies and classifying the address found against a trie.
+package clientip
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Trusted reports whether addr belongs to one of the trusted proxies
+func Trusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromChain returns the client behind a chain of forwarded addresses,
+// oldest first, that reached us from remote. Unless remote is a trusted
+// proxy it is the client itself; otherwise the chain is read from the
+// right and the first address not belonging to a trusted proxy is the
+// client.
+func FromChain(remote netip.Addr, chain []string, trusted []netip.Prefix) netip.Addr {
+	if !Trusted(remote, trusted) {
+		return remote
+	}
+	client := remote
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(chain[i]))
+		if err != nil {
+			// Anything left of garbage cannot be trusted
+			break
+		}
+		client = addr.Unmap()
+		if !Trusted(client, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+// Classify returns the most specific prefix of t containing ip, whatever
+// the trie's match order
+func Classify(t *trie.IPTrie, ip netip.Addr) (trie.Match, bool) {
+	return t.FindMatch(ip)
+}
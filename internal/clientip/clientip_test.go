@@ -0,0 +1,43 @@
+package clientip
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func TestFromChain(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		remote string
+		chain  []string
+		want   string
+	}{
+		{remote: "203.0.113.1", chain: []string{"198.51.100.7"}, want: "203.0.113.1"},
+		{remote: "10.0.0.1", chain: []string{"198.51.100.7", " 10.0.0.2"}, want: "198.51.100.7"},
+		{remote: "10.0.0.1", chain: []string{"198.51.100.7", "garbage", "10.0.0.2"}, want: "10.0.0.2"},
+		{remote: "10.0.0.1", chain: []string{"::ffff:198.51.100.7"}, want: "198.51.100.7"},
+		{remote: "10.0.0.1", chain: nil, want: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		got := FromChain(netip.MustParseAddr(tt.remote), tt.chain, trusted)
+		if got.String() != tt.want {
+			t.Errorf("%s %v: expected %s, got %s", tt.remote, tt.chain, tt.want, got)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tr := trie.NewIPTrie(trie.WithMatchOrder(trie.LeastSpecificFirst))
+	tr.Insert("10.0.0.0/8", nil)
+	tr.Insert("10.1.2.3/32", nil)
+
+	m, ok := Classify(tr, netip.MustParseAddr("10.1.2.3"))
+	if !ok || m.CIDR != "10.1.2.3/32" || m.PrefixLen != 32 || !m.Host {
+		t.Errorf("Expected the host route, got %+v", m)
+	}
+	if _, ok := Classify(tr, netip.MustParseAddr("192.0.2.1")); ok {
+		t.Errorf("Expected no match")
+	}
+}
@@ -1,39 +1,115 @@
+// Command trie-network loads prefix datasets and answers longest prefix
+// match queries against them from the command line.
+//
+// Usage:
+//
+//	trie-network <command> [flags] [args]
+//
+// Run "trie-network help" for the list of commands.
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-
-	"github.com/metajar/trie-network/pkg/trie"
 )
 
-func main() {
-	tmap := trie.NewIPTrie()
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// env is what a command reads from and writes to
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
 
-	metadata := map[string]interface{}{
-		"region":      "us-east-1",
-		"environment": "production",
-		"owner":       "jared",
+// command is one subcommand of the CLI
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(fs *flag.FlagSet, args []string, e env) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"load", "[-format F] [-o OUT] [-name N -version V ...] FILE...", "load and validate datasets, optionally saving them as one snapshot or bundle", runLoad},
+		{"info", "BUNDLE...", "print the manifest of dataset bundles", runInfo},
+		{"lookup", "-d FILE [-all] IP...", "print the prefixes matching each IP", runLookup},
+		{"lookup-batch", "-d FILE [IPFILE]", "look up one IP per line from IPFILE or stdin, printing JSON lines", runLookupBatch},
+		{"export", "[-format F] [-to csv|jsonl|json] FILE...", "write datasets as CSV, JSON lines or a JSON snapshot", runExport},
+		{"diff", "OLD NEW", "list prefixes added, removed or changed between two datasets", runDiff},
+		{"serve", "-d FILE [-addr ADDR] [-read-only] [-computed NAME=EXPR] [-debug-addr ADDR]", "serve the datasets over the HTTP REST API", runServe},
+		{"calc", "OPERATION [args]", "subnet calculator: split, summarize, contains, random-host, distance", runCalc},
+		{"version", "", "print the version", func(fs *flag.FlagSet, args []string, e env) error {
+			if err := fs.Parse(args); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintln(e.stdout, "trie-network", version)
+			return err
+		}},
 	}
-	err := tmap.Insert("10.20.20.0/24", metadata)
-	if err != nil {
-		fmt.Println(err)
+}
+
+// errDiffer makes the process exit with status 1 without printing an
+// error, like diff(1) when its inputs differ
+var errDiffer = errors.New("inputs differ")
+
+func main() {
+	err := run(os.Args[1:], env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr})
+	switch {
+	case err == nil:
+	case errors.Is(err, errDiffer), errors.Is(err, errNotContained):
+		os.Exit(1)
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "trie-network:", err)
 		os.Exit(1)
 	}
+}
 
-	err = tmap.Insert("2001:dead:beef::2/120", metadata)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// run dispatches args to a command
+func run(args []string, e env) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(e.stderr)
+		if len(args) == 0 {
+			return flag.ErrHelp
+		}
+		return nil
 	}
 
-	matches, err := tmap.FindAll("10.20.20.100")
-	for _, match := range matches {
-		fmt.Printf("Matching CIDR: %s, Metadata: %v\n", match.CIDR, match.Metadata)
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		fs.SetOutput(e.stderr)
+		fs.Usage = func() {
+			fmt.Fprintf(e.stderr, "usage: trie-network %s %s\n\n%s\n", c.name, c.args, c.summary)
+			fs.PrintDefaults()
+		}
+		return c.run(fs, args[1:], e)
 	}
+	usage(e.stderr)
+	return fmt.Errorf("unknown command %q", args[0])
+}
 
-	matches, err = tmap.FindAll("2001:dead:beef::ff")
-	for _, match := range matches {
-		fmt.Printf("Matching CIDR: %s, Metadata: %v\n", match.CIDR, match.Metadata)
+// usage lists the commands
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: trie-network <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Datasets may be %s files, chosen by extension,\n", formats)
+	fmt.Fprintln(w, `and "-" reads one from stdin. "lines" files hold one prefix per line,`)
+	fmt.Fprintln(w, "optionally followed by key=value metadata.")
 }
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/bundle"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// runCLI runs the CLI with args and returns its output
+func runCLI(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(args, env{stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr})
+	return stdout.String(), err
+}
+
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testLines = `# test data
+10.0.0.0/8 owner=netops
+10.1.0.0/16 owner=dev site=ams
+2001:db8::/32 owner=v6
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	in := writeFile(t, dir, "data.txt", testLines)
+
+	for _, name := range []string{"out.json", "out.gob", "out.jsonl", "out.csv"} {
+		out := filepath.Join(dir, name)
+		got, err := runCLI(t, "", "load", "-o", out, in)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got != "3 prefixes (2 IPv4, 1 IPv6)\n" {
+			t.Errorf("%s: unexpected output %q", name, got)
+		}
+
+		// The saved file must load back to the same prefixes
+		got, err = runCLI(t, "", "load", out)
+		if err != nil {
+			t.Fatalf("%s: reload: %v", name, err)
+		}
+		if got != "3 prefixes (2 IPv4, 1 IPv6)\n" {
+			t.Errorf("%s: reload: unexpected output %q", name, got)
+		}
+	}
+
+	if _, err := runCLI(t, "", "load", writeFile(t, dir, "bad.txt", "10.0.0.0/33\n")); err == nil {
+		t.Errorf("Expected error for invalid prefix")
+	}
+	if _, err := runCLI(t, testLines, "load", "-format", "lines", "-"); err != nil {
+		t.Errorf("Expected stdin to load, got %v", err)
+	}
+}
+
+func TestLoadStatesAndPairs(t *testing.T) {
+	dir := t.TempDir()
+	jsonl := writeFile(t, dir, "data.jsonl", `{"cidr":"10.0.0.0/8","metadata":{"owner":"netops"},"state":"deprecated"}
+{"cidr":"10.1.0.0/16","metadata":{"owner":"dev"},"pair":"2001:db8::/32"}
+{"cidr":"2001:db8::/32","metadata":{"owner":"v6"}}
+`)
+	override := writeFile(t, dir, "override.txt", "10.0.0.0/8 owner=core\n")
+
+	for _, tt := range []struct {
+		inputs []string
+		state  trie.PrefixState
+		owner  string
+	}{
+		{[]string{jsonl}, trie.StateDeprecated, "netops"},
+		// A later file overrides the state along with the metadata
+		{[]string{jsonl, override}, trie.StateActive, "core"},
+		{[]string{override, jsonl}, trie.StateDeprecated, "netops"},
+	} {
+		out := filepath.Join(dir, "out.json")
+		if _, err := runCLI(t, "", append([]string{"load", "-o", out}, tt.inputs...)...); err != nil {
+			t.Fatalf("%v: %v", tt.inputs, err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded := trie.NewIPTrie()
+		if err := loaded.UnmarshalJSON(data); err != nil {
+			t.Fatal(err)
+		}
+
+		if state, _ := loaded.State("10.0.0.0/8"); state != tt.state {
+			t.Errorf("%v: expected 10.0.0.0/8 to be %s, got %s", tt.inputs, tt.state, state)
+		}
+		if md, _ := loaded.FindExact("10.0.0.0/8"); md["owner"] != tt.owner {
+			t.Errorf("%v: expected owner %s, got %v", tt.inputs, tt.owner, md)
+		}
+		if m, ok := loaded.Counterpart("10.1.0.0/16"); !ok || m.CIDR != "2001:db8::/32" {
+			t.Errorf("%v: expected 10.1.0.0/16 to stay paired, got %v %v", tt.inputs, m, ok)
+		}
+	}
+
+	bad := writeFile(t, dir, "bad.jsonl", `{"cidr":"10.0.0.0/8","state":"bogus"}`+"\n")
+	if _, err := runCLI(t, "", "load", bad); err == nil {
+		t.Errorf("Expected error for an unknown state")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	data := writeFile(t, t.TempDir(), "data.txt", testLines)
+
+	got, err := runCLI(t, "", "lookup", "-d", data, "10.1.2.3", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "10.1.2.3\t10.1.0.0/16\t{\"owner\":\"dev\",\"site\":\"ams\"}\n192.0.2.1\t-\n"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	got, err = runCLI(t, "", "lookup", "-d", data, "-all", "10.1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(got, "\n"); n != 2 {
+		t.Errorf("Expected 2 matches with -all, got %d: %q", n, got)
+	}
+
+	if _, err := runCLI(t, "", "lookup", "-d", data, "not-an-ip"); err == nil {
+		t.Errorf("Expected error for invalid IP")
+	}
+	if _, err := runCLI(t, "", "lookup", "-d", data); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Expected usage error without IPs, got %v", err)
+	}
+}
+
+func TestLookupBatch(t *testing.T) {
+	data := writeFile(t, t.TempDir(), "data.txt", testLines)
+
+	got, err := runCLI(t, "10.1.2.3\n\n2001:db8::1\n192.0.2.1\nbogus\n", "lookup-batch", "-d", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 results, got %d: %q", len(lines), got)
+	}
+
+	tests := []struct {
+		cidr    string
+		prefix  int
+		isError bool
+	}{
+		{"10.1.0.0/16", 16, false},
+		{"2001:db8::/32", 32, false},
+		{"", 0, true},
+		{"", 0, true},
+	}
+	for i, tt := range tests {
+		var r result
+		if err := json.Unmarshal([]byte(lines[i]), &r); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if r.CIDR != tt.cidr || r.PrefixLen != tt.prefix || (r.Error != "") != tt.isError {
+			t.Errorf("line %d: unexpected result %+v", i, r)
+		}
+	}
+
+	if _, err := runCLI(t, "", "lookup-batch", "-d", "-"); err == nil {
+		t.Errorf("Expected error when both the dataset and IPs come from stdin")
+	}
+}
+
+func TestExport(t *testing.T) {
+	data := writeFile(t, t.TempDir(), "data.txt", testLines)
+
+	got, err := runCLI(t, "", "export", "-to", "csv", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "cidr,") || !strings.Contains(got, "10.1.0.0/16") {
+		t.Errorf("Unexpected CSV output %q", got)
+	}
+
+	got, err = runCLI(t, "", "export", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(got, "\n"); n != 3 {
+		t.Errorf("Expected 3 JSON lines, got %d", n)
+	}
+
+	if _, err := runCLI(t, "", "export", "-to", "xml", data); err == nil {
+		t.Errorf("Expected error for unknown output format")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	old := writeFile(t, dir, "old.txt", testLines)
+	cur := writeFile(t, dir, "new.txt", `10.0.0.0/8 owner=netops
+10.1.0.0/16 owner=ops site=ams
+192.168.0.0/16 owner=lab
+`)
+
+	got, err := runCLI(t, "", "diff", old, old)
+	if err != nil || got != "" {
+		t.Errorf("Expected no differences, got %q, %v", got, err)
+	}
+
+	got, err = runCLI(t, "", "diff", old, cur)
+	if !errors.Is(err, errDiffer) {
+		t.Errorf("Expected errDiffer, got %v", err)
+	}
+	expected := "- 2001:db8::/32\n+ 192.168.0.0/16\n~ 10.1.0.0/16 owner\n"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestBundle(t *testing.T) {
+	dir := t.TempDir()
+	in := writeFile(t, dir, "data.txt", testLines)
+	out := filepath.Join(dir, "corp.bundle")
+
+	if _, err := runCLI(t, "", "load", "-o", out, "-version", "7", "-license", "CC0", "-source", "https://example.com",
+		"-computed", "host=hostOffset(ip, cidr)", in); err != nil {
+		t.Fatal(err)
+	}
+	got, err := runCLI(t, "", "info", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"name:", "corp\n", "version:", "7\n", "license:", "CC0\n", "3 (2 IPv4, 1 IPv6)", "computed host:", "hostOffset(ip, cidr)\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected info output to contain %q, got %q", want, got)
+		}
+	}
+
+	got, err = runCLI(t, "", "info", "-json", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m bundle.Manifest
+	if err := json.Unmarshal([]byte(got), &m); err != nil || m.Source != "https://example.com" || m.Computed["host"] != "hostOffset(ip, cidr)" {
+		t.Errorf("Unexpected JSON manifest %q, %v", got, err)
+	}
+
+	got, err = runCLI(t, "", "lookup", "-d", out, "10.1.2.3")
+	if err != nil || !strings.Contains(got, "10.1.0.0/16") {
+		t.Errorf("Expected lookup in bundle to match, got %q, %v", got, err)
+	}
+	if _, err := runCLI(t, "", "info", in); err == nil {
+		t.Errorf("Expected error for info on a file that is not a bundle")
+	}
+	for _, def := range []string{"host=hostOffset(", "=cidr", "cidr"} {
+		if _, err := runCLI(t, "", "load", "-o", out, "-computed", def, in); err == nil {
+			t.Errorf("Expected error for computed field %q", def)
+		}
+	}
+}
+
+func TestComputedFields(t *testing.T) {
+	manifests := []bundle.Manifest{
+		{Name: "a", Computed: map[string]string{"x": "1", "y": "2"}},
+		{Name: "b", Computed: map[string]string{"y": "3"}},
+	}
+	fields, err := computedFields(manifests, []string{"z=cidr + '!'", "x = prefix_len"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := fields.Apply("10.1.2.3", trie.Match{CIDR: "10.0.0.0/8", PrefixLen: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md["x"] != int64(8) || md["y"] != int64(3) || md["z"] != "10.0.0.0/8!" {
+		t.Errorf("Unexpected computed fields %v", md)
+	}
+}
+
+func TestCalc(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+		err  error
+	}{
+		{[]string{"split", "10.0.0.0/23", "24"}, "10.0.0.0/24\n10.0.1.0/24\n", nil},
+		{[]string{"split", "2001:db8::/32", "/33"}, "2001:db8::/33\n2001:db8:8000::/33\n", nil},
+		{[]string{"summarize", "10.0.1.0/24", "10.0.0.0/24", "192.0.2.0-192.0.2.127", "192.0.2.128/25"}, "10.0.0.0/23\n192.0.2.0/24\n", nil},
+		{[]string{"contains", "10.0.0.0/8", "10.1.2.3", "10.9.0.0/16"}, "10.1.2.3\tyes\n10.9.0.0/16\tyes\n", nil},
+		{[]string{"contains", "10.0.0.0/8", "10.1.2.3", "192.0.2.0/24"}, "10.1.2.3\tyes\n192.0.2.0/24\tno\n", errNotContained},
+		{[]string{"random-host", "-n", "2", "192.0.2.5/32"}, "192.0.2.5\n192.0.2.5\n", nil},
+		{[]string{"distance", "10.0.0.0/24", "10.0.3.0/24"}, "distance\t512 addresses\nsupernet\t10.0.0.0/22\n", nil},
+		{[]string{"distance", "10.0.0.0/8", "10.0.3.0/24"}, "distance\toverlapping\nsupernet\t10.0.0.0/8\n", nil},
+		{[]string{"split", "10.0.0.0/23"}, "", flag.ErrHelp},
+		{[]string{}, "", flag.ErrHelp},
+	}
+	for _, tt := range tests {
+		got, err := runCLI(t, "", append([]string{"calc"}, tt.args...)...)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("calc %v: expected %q, %v, got %q, %v", tt.args, tt.want, tt.err, got, err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"split", "10.0.0.0/24", "23"},
+		{"split", "10.0.0.0/24", "x"},
+		{"summarize", "10.0.0.9-10.0.0.1"},
+		{"contains", "bogus", "10.0.0.1"},
+		{"distance", "10.0.0.0/8", "2001:db8::/32"},
+		{"frobnicate"},
+	} {
+		if _, err := runCLI(t, "", append([]string{"calc"}, args...)...); err == nil || errors.Is(err, flag.ErrHelp) {
+			t.Errorf("calc %v: expected an error, got %v", args, err)
+		}
+	}
+
+	// Random hosts are repeatable with a seed and avoid the network and
+	// broadcast addresses
+	a, _ := runCLI(t, "", "calc", "random-host", "-n", "50", "-seed", "7", "192.0.2.0/30")
+	b, _ := runCLI(t, "", "calc", "random-host", "-n", "50", "-seed", "7", "192.0.2.0/30")
+	if a != b || strings.Contains(a, "192.0.2.0\n") || strings.Contains(a, "192.0.2.3\n") {
+		t.Errorf("Unexpected random hosts %q", a)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if _, err := runCLI(t, "", "frobnicate"); err == nil {
+		t.Errorf("Expected error for unknown command")
+	}
+	if got, err := runCLI(t, "", "version"); err != nil || got != "trie-network dev\n" {
+		t.Errorf("Expected version dev, got %q, %v", got, err)
+	}
+	if _, err := runCLI(t, "", "help"); err != nil {
+		t.Errorf("Expected help to succeed, got %v", err)
+	}
+}
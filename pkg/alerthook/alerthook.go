@@ -0,0 +1,155 @@
+// Package alerthook delivers the trie's threshold alerts to an HTTP
+// endpoint as JSON. Alerts are queued and posted by a single background
+// worker, so Observe never blocks on the network and a slow or unreachable
+// endpoint cannot pile up requests; alerts arriving while the queue is full
+// are dropped and counted.
+//
+//	hook := alerthook.New("https://alerts.example.com/hook", alerthook.Config{})
+//	defer hook.Close()
+//	t.OnThreshold(trie.Threshold{DistinctIPs: 500}, hook.Send)
+package alerthook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Config tunes delivery
+type Config struct {
+	// Client sends the requests. If nil, a client with Timeout is used.
+	Client *http.Client
+	// Timeout bounds each request of the default client, 10 seconds if
+	// zero
+	Timeout time.Duration
+	// QueueSize is the number of alerts that can wait for delivery, 256 if
+	// zero
+	QueueSize int
+}
+
+// Stats counts what happened to the alerts passed to Send
+type Stats struct {
+	Sent uint64 `json:"sent"`
+	// Failed alerts got an error or a non-2xx status from the endpoint
+	Failed uint64 `json:"failed"`
+	// Dropped alerts found the queue full or the webhook closed
+	Dropped uint64 `json:"dropped"`
+}
+
+// Webhook posts alerts to one URL
+type Webhook struct {
+	url    string
+	client *http.Client
+	queue  chan []byte
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+// New starts a webhook delivering to url. Call Close to stop it.
+func New(url string, cfg Config) *Webhook {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 256
+	}
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+	w := &Webhook{
+		url:    url,
+		client: client,
+		queue:  make(chan []byte, cfg.QueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Send queues an alert without blocking. It has the signature OnThreshold
+// expects.
+func (w *Webhook) Send(a trie.Alert) {
+	// Encode now: the alert's metadata belongs to the trie
+	body, err := json.Marshal(a)
+	if err != nil {
+		w.failed.Add(1)
+		return
+	}
+	select {
+	case <-w.stop:
+		w.dropped.Add(1)
+		return
+	default:
+	}
+	select {
+	case w.queue <- body:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Close delivers the alerts already queued and stops the worker
+func (w *Webhook) Close() {
+	w.closeOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// Stats returns the delivery counts so far
+func (w *Webhook) Stats() Stats {
+	return Stats{Sent: w.sent.Load(), Failed: w.failed.Load(), Dropped: w.dropped.Load()}
+}
+
+// run delivers queued alerts until Close, then drains the queue
+func (w *Webhook) run() {
+	defer close(w.done)
+	for {
+		select {
+		case body := <-w.queue:
+			w.deliver(body)
+		case <-w.stop:
+			for {
+				select {
+				case body := <-w.queue:
+					w.deliver(body)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver posts one alert
+func (w *Webhook) deliver(body []byte) {
+	if err := w.post(body); err != nil {
+		w.failed.Add(1)
+		return
+	}
+	w.sent.Add(1)
+}
+
+func (w *Webhook) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
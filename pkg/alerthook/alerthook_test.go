@@ -0,0 +1,104 @@
+package alerthook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func TestWebhook(t *testing.T) {
+	received := make(chan trie.Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a trie.Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		received <- a
+	}))
+	defer srv.Close()
+
+	hook := New(srv.URL, Config{Client: srv.Client()})
+	defer hook.Close()
+	tr := trie.NewIPTrie()
+	_ = tr.Insert("10.0.0.0/8", nil)
+	tr.OnThreshold(trie.Threshold{RequestsPerMinute: 1}, hook.Send)
+
+	_ = tr.Observe("10.0.0.1")
+	_ = tr.Observe("10.0.0.1")
+
+	select {
+	case a := <-received:
+		if a.CIDR != "10.0.0.0/8" || a.Kind != trie.AlertRequestsPerMinute {
+			t.Errorf("Unexpected webhook payload: %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook was not delivered")
+	}
+}
+
+func TestWebhookBounded(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	hook := New(srv.URL, Config{Client: srv.Client(), QueueSize: 2})
+	// One alert in flight and two queued; the rest do not fit
+	hook.Send(trie.Alert{CIDR: "10.0.0.0/8"})
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		hook.Send(trie.Alert{CIDR: "10.0.0.0/8"})
+	}
+	if st := hook.Stats(); st.Dropped != 3 {
+		t.Errorf("Expected 3 alerts dropped, got %+v", st)
+	}
+
+	// Close delivers what was queued, and later alerts are dropped
+	close(release)
+	hook.Close()
+	hook.Send(trie.Alert{CIDR: "10.0.0.0/8"})
+	if st := hook.Stats(); st.Sent != 3 || st.Failed != 0 || st.Dropped != 4 {
+		t.Errorf("Expected 3 sent and 4 dropped, got %+v", st)
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	hook := New(srv.URL, Config{Timeout: 50 * time.Millisecond})
+	hook.Send(trie.Alert{CIDR: "10.0.0.0/8"})
+	done := make(chan struct{})
+	go func() {
+		hook.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the request to time out")
+	}
+	if st := hook.Stats(); st.Failed != 1 || st.Sent != 0 {
+		t.Errorf("Expected 1 failed alert, got %+v", st)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	hook = New(failing.URL, Config{})
+	hook.Send(trie.Alert{CIDR: "10.0.0.0/8"})
+	hook.Close()
+	if st := hook.Stats(); st.Failed != 1 {
+		t.Errorf("Expected a 503 to count as failed, got %+v", st)
+	}
+}
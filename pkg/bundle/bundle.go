@@ -0,0 +1,164 @@
+// Package bundle packages a trie snapshot together with a manifest saying
+// where the data came from, so whoever serves it can always tell which
+// dataset, version and license they are running with.
+//
+// A bundle is a gzip compressed tar archive holding manifest.json followed
+// by snapshot.gob, the trie's MarshalBinary encoding. The manifest comes
+// first so it can be read without decoding the snapshot.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+const (
+	manifestName = "manifest.json"
+	snapshotName = "snapshot.gob"
+)
+
+// ErrNotBundle is returned when a file is not a bundle
+var ErrNotBundle = errors.New("not a dataset bundle")
+
+// Manifest describes the provenance and contents of a bundle
+type Manifest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	License     string `json:"license,omitempty"`
+	// Source is where the data was obtained, usually a URL
+	Source string    `json:"source,omitempty"`
+	Built  time.Time `json:"built"`
+	// Computed maps field names to expressions deriving them at lookup
+	// time, as compiled by the computed package
+	Computed map[string]string `json:"computed,omitempty"`
+	// Contents is filled in by Write and checked by Read
+	Contents trie.Manifest `json:"contents"`
+}
+
+// Write writes t and m to w as a bundle. Contents is computed from t, and
+// Built is set to the current time if it is zero.
+func Write(w io.Writer, t *trie.IPTrie, m Manifest) error {
+	if m.Name == "" {
+		return fmt.Errorf("bundle has no name")
+	}
+	contents, err := t.Manifest()
+	if err != nil {
+		return err
+	}
+	m.Contents = contents
+	if m.Built.IsZero() {
+		m.Built = time.Now().UTC()
+	}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	snapshot, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{manifestName, append(manifest, '\n')}, {snapshotName, snapshot}} {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), ModTime: m.Built}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Read reads a bundle and checks the snapshot against the manifest,
+// returning an error wrapping trie.ErrIntegrity if they disagree
+func Read(r io.Reader, opts ...trie.Option) (*trie.IPTrie, Manifest, error) {
+	tr, m, err := open(r)
+	if err != nil {
+		return nil, Manifest{}, err
+	}
+
+	hdr, err := tr.Next()
+	if err == io.EOF || (err == nil && hdr.Name != snapshotName) {
+		return nil, Manifest{}, fmt.Errorf("%w: missing %s", ErrNotBundle, snapshotName)
+	} else if err != nil {
+		return nil, Manifest{}, err
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, Manifest{}, err
+	}
+	t := trie.NewIPTrie(opts...)
+	if err := t.UnmarshalBinary(data); err != nil {
+		return nil, Manifest{}, err
+	}
+	if err := t.Verify(m.Contents); err != nil {
+		return nil, Manifest{}, err
+	}
+	return t, m, nil
+}
+
+// ReadManifest reads only the manifest of a bundle
+func ReadManifest(r io.Reader) (Manifest, error) {
+	_, m, err := open(r)
+	return m, err
+}
+
+// open reads the manifest and leaves tr positioned after it
+func open(r io.Reader) (*tar.Reader, Manifest, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, Manifest{}, fmt.Errorf("%w: %v", ErrNotBundle, err)
+	}
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, Manifest{}, fmt.Errorf("%w: missing %s", ErrNotBundle, manifestName)
+	}
+	var m Manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, Manifest{}, fmt.Errorf("%s: %v", manifestName, err)
+	}
+	return tr, m, nil
+}
+
+// Create writes a bundle to path
+func Create(path string, t *trie.IPTrie, m Manifest) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(f, t, m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Open reads the bundle at path
+func Open(path string, opts ...trie.Option) (*trie.IPTrie, Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, Manifest{}, err
+	}
+	defer f.Close()
+	return Read(f, opts...)
+}
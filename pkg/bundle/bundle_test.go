@@ -0,0 +1,126 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func testTrie(t *testing.T) *trie.IPTrie {
+	tr := trie.NewIPTrie()
+	entries := map[string]map[string]interface{}{
+		"10.0.0.0/8":    {"owner": "netops"},
+		"10.1.0.0/16":   {"owner": "dev", "vlan": 10},
+		"2001:db8::/32": {"owner": "v6"},
+	}
+	for cidr, md := range entries {
+		if err := tr.Insert(cidr, md); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", cidr, err)
+		}
+	}
+	return tr
+}
+
+func TestRoundTrip(t *testing.T) {
+	tr := testTrie(t)
+	built := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "corp.bundle")
+	err := Create(path, tr, Manifest{
+		Name:    "corp",
+		Version: "2024.05.01",
+		License: "internal",
+		Source:  "https://ipam.example.com/export",
+		Built:   built,
+	})
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+
+	got, m, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	if m.Name != "corp" || m.Version != "2024.05.01" || m.License != "internal" || !m.Built.Equal(built) {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+	if m.Contents.Prefixes != 3 || m.Contents.PrefixesV4 != 2 || m.Contents.PrefixesV6 != 1 || m.Contents.Checksum == "" {
+		t.Errorf("Unexpected contents %+v", m.Contents)
+	}
+
+	cidr, md, err := got.Find("10.1.2.3")
+	if err != nil || cidr != "10.1.0.0/16" || md["vlan"] != 10 {
+		t.Errorf("Expected 10.1.0.0/16 with vlan 10, got %s %v %v", cidr, md, err)
+	}
+}
+
+func TestWriteDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testTrie(t), Manifest{}); err == nil {
+		t.Errorf("Expected error for bundle without a name")
+	}
+
+	before := time.Now()
+	if err := Write(&buf, testTrie(t), Manifest{Name: "corp"}); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	m, err := ReadManifest(&buf)
+	if err != nil {
+		t.Fatalf("ReadManifest returned error: %v", err)
+	}
+	if m.Built.Before(before.Add(-time.Second)) {
+		t.Errorf("Expected Built to default to now, got %v", m.Built)
+	}
+}
+
+// writeRaw builds a bundle from raw files, to test damaged ones
+func writeRaw(t *testing.T, files ...string) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for i := 0; i+1 < len(files); i += 2 {
+		tw.WriteHeader(&tar.Header{Name: files[i], Mode: 0o644, Size: int64(len(files[i+1]))})
+		tw.Write([]byte(files[i+1]))
+	}
+	tw.Close()
+	zw.Close()
+	return &buf
+}
+
+func TestReadErrors(t *testing.T) {
+	snapshot, err := testTrie(t).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data *bytes.Buffer
+		err  error
+	}{
+		{"not gzip", bytes.NewBufferString("10.0.0.0/8\n"), ErrNotBundle},
+		{"no manifest", writeRaw(t, snapshotName, string(snapshot)), ErrNotBundle},
+		{"no snapshot", writeRaw(t, manifestName, `{"name":"corp"}`), ErrNotBundle},
+		{"wrong count", writeRaw(t, manifestName, `{"name":"corp","contents":{"prefixes":2}}`, snapshotName, string(snapshot)), trie.ErrIntegrity},
+		{"wrong checksum", writeRaw(t, manifestName, `{"name":"corp","contents":{"prefixes":3,"prefixes_v4":2,"prefixes_v6":1,"sha256":"00"}}`, snapshotName, string(snapshot)), trie.ErrIntegrity},
+	}
+	for _, tt := range tests {
+		_, _, err := Read(tt.data)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	if _, _, err := Read(writeRaw(t, manifestName, `{"name":"corp","contents":{"prefixes":3,"prefixes_v4":2,"prefixes_v6":1}}`, snapshotName, string(snapshot))); err != nil {
+		t.Errorf("Expected bundle without checksum to load, got %v", err)
+	}
+	if _, err := ReadManifest(writeRaw(t, manifestName, "{")); err == nil || !strings.Contains(err.Error(), manifestName) {
+		t.Errorf("Expected manifest decode error, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+// Package cidrmath does prefix arithmetic without a trie: splitting,
+// summarizing prefixes and address ranges, containment, random hosts and
+// the distance between prefixes. All functions work on masked prefixes of
+// either family and never mix IPv4 with IPv6.
+package cidrmath
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/netip"
+	"strings"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// ErrOverlap is returned by Distance for prefixes that share addresses
+var ErrOverlap = errors.New("prefixes overlap")
+
+// ParsePrefix parses a CIDR, or a bare address as its /32 or /128, and
+// masks it. IPv4-mapped IPv6 addresses are unmapped.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil || addr.Zone() != "" {
+			return netip.Prefix{}, fmt.Errorf("invalid address or CIDR %q", s)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR: %v", err)
+	}
+	if p.Addr().Is4In6() {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: IPv4-mapped prefixes are not supported", s)
+	}
+	return p.Masked(), nil
+}
+
+// Split breaks p into the equal subnets of length bits, in address order
+func Split(p netip.Prefix, bits int) ([]netip.Prefix, error) {
+	return trie.Split(p.String(), bits)
+}
+
+// Summarize returns the fewest prefixes covering exactly the addresses of
+// prefixes, sorted, joining adjacent siblings and dropping covered ones
+func Summarize(prefixes []netip.Prefix) []netip.Prefix {
+	return trie.Aggregate(prefixes)
+}
+
+// Range returns the fewest prefixes covering exactly the addresses from
+// first to last inclusive, in address order
+func Range(first, last netip.Addr) ([]netip.Prefix, error) {
+	first, last = first.Unmap(), last.Unmap()
+	if !first.IsValid() || !last.IsValid() || first.Is4() != last.Is4() {
+		return nil, fmt.Errorf("range %s-%s mixes address families", first, last)
+	}
+	if last.Less(first) {
+		return nil, fmt.Errorf("range %s-%s ends before it starts", first, last)
+	}
+
+	var out []netip.Prefix
+	for start := first; ; {
+		// The shortest prefix starting at start that ends by last
+		var p netip.Prefix
+		for bits := 0; bits <= start.BitLen(); bits++ {
+			p = netip.PrefixFrom(start, bits)
+			if p.Masked().Addr() == start && !last.Less(Last(p)) {
+				break
+			}
+		}
+		out = append(out, p)
+		end := Last(p)
+		if end == last {
+			return out, nil
+		}
+		start = end.Next()
+	}
+}
+
+// Contains reports whether outer covers every address of inner
+func Contains(outer, inner netip.Prefix) bool {
+	return outer.Bits() <= inner.Bits() && outer.Masked().Contains(inner.Addr())
+}
+
+// Size returns the number of addresses in p
+func Size(p netip.Prefix) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(p.Addr().BitLen()-p.Bits()))
+}
+
+// Last returns the highest address in p
+func Last(p netip.Prefix) netip.Addr {
+	n := toInt(p.Masked().Addr())
+	n.Add(n, Size(p))
+	n.Sub(n, big.NewInt(1))
+	return fromInt(n, p.Addr().Is4())
+}
+
+// RandomHost returns a random address of p. For IPv4 prefixes shorter than
+// /31 it avoids the network and broadcast addresses.
+func RandomHost(p netip.Prefix, r *rand.Rand) netip.Addr {
+	p = p.Masked()
+	size := Size(p)
+	offset := big.NewInt(0)
+	if p.Addr().Is4() && p.Bits() < 31 {
+		// 1 to size-2
+		offset.Rand(r, new(big.Int).Sub(size, big.NewInt(2)))
+		offset.Add(offset, big.NewInt(1))
+	} else {
+		offset.Rand(r, size)
+	}
+	n := toInt(p.Addr())
+	return fromInt(n.Add(n, offset), p.Addr().Is4())
+}
+
+// Distance returns the number of addresses lying strictly between a and b,
+// zero for adjacent prefixes. It fails with ErrOverlap if they overlap.
+func Distance(a, b netip.Prefix) (*big.Int, error) {
+	a, b = a.Masked(), b.Masked()
+	if a.Addr().Is4() != b.Addr().Is4() {
+		return nil, fmt.Errorf("%s and %s are of different address families", a, b)
+	}
+	if a.Overlaps(b) {
+		return nil, ErrOverlap
+	}
+	if b.Addr().Less(a.Addr()) {
+		a, b = b, a
+	}
+	gap := toInt(b.Addr())
+	gap.Sub(gap, toInt(Last(a)))
+	return gap.Sub(gap, big.NewInt(1)), nil
+}
+
+// Supernet returns the longest prefix covering both a and b
+func Supernet(a, b netip.Prefix) (netip.Prefix, error) {
+	if a.Addr().Is4() != b.Addr().Is4() {
+		return netip.Prefix{}, fmt.Errorf("%s and %s are of different address families", a, b)
+	}
+	for bits := min(a.Bits(), b.Bits()); ; bits-- {
+		p := netip.PrefixFrom(a.Addr(), bits).Masked()
+		if p.Contains(b.Addr()) {
+			return p, nil
+		}
+	}
+}
+
+func toInt(addr netip.Addr) *big.Int {
+	return new(big.Int).SetBytes(addr.AsSlice())
+}
+
+func fromInt(n *big.Int, is4 bool) netip.Addr {
+	if is4 {
+		var b [4]byte
+		n.FillBytes(b[:])
+		return netip.AddrFrom4(b)
+	}
+	var b [16]byte
+	n.FillBytes(b[:])
+	return netip.AddrFrom16(b)
+}
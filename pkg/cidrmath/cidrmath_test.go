@@ -0,0 +1,172 @@
+package cidrmath
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+func prefixes(ss ...string) []netip.Prefix {
+	out := make([]netip.Prefix, len(ss))
+	for i, s := range ss {
+		out[i] = netip.MustParsePrefix(s)
+	}
+	return out
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		isError bool
+	}{
+		{"10.1.2.3/16", "10.1.0.0/16", false},
+		{"10.1.2.3", "10.1.2.3/32", false},
+		{"::ffff:10.1.2.3", "10.1.2.3/32", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"2001:db8::1/32", "2001:db8::/32", false},
+		{"::ffff:10.0.0.0/104", "", true},
+		{"fe80::1%eth0", "", true},
+		{"10.0.0.0/33", "", true},
+		{"bogus", "", true},
+	}
+	for _, tt := range tests {
+		p, err := ParsePrefix(tt.in)
+		if (err != nil) != tt.isError || !tt.isError && p.String() != tt.want {
+			t.Errorf("ParsePrefix(%q): expected %q (error %v), got %v, %v", tt.in, tt.want, tt.isError, p, err)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		first, last string
+		want        string
+	}{
+		{"10.0.0.0", "10.0.0.255", "[10.0.0.0/24]"},
+		{"10.0.0.1", "10.0.0.6", "[10.0.0.1/32 10.0.0.2/31 10.0.0.4/31 10.0.0.6/32]"},
+		{"192.0.2.0", "192.0.3.127", "[192.0.2.0/24 192.0.3.0/25]"},
+		{"0.0.0.0", "255.255.255.255", "[0.0.0.0/0]"},
+		{"255.255.255.254", "255.255.255.255", "[255.255.255.254/31]"},
+		{"2001:db8::", "2001:db8::1:0", "[2001:db8::/112 2001:db8::1:0/128]"},
+		{"10.0.0.5", "10.0.0.5", "[10.0.0.5/32]"},
+	}
+	for _, tt := range tests {
+		got, err := Range(netip.MustParseAddr(tt.first), netip.MustParseAddr(tt.last))
+		if err != nil || fmt.Sprint(got) != tt.want {
+			t.Errorf("Range(%s, %s): expected %s, got %v, %v", tt.first, tt.last, tt.want, got, err)
+		}
+	}
+
+	if _, err := Range(netip.MustParseAddr("10.0.0.9"), netip.MustParseAddr("10.0.0.1")); err == nil {
+		t.Errorf("Expected error for a reversed range")
+	}
+	if _, err := Range(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2001:db8::1")); err == nil {
+		t.Errorf("Expected error for a range across families")
+	}
+}
+
+func TestSplitSummarize(t *testing.T) {
+	subnets, err := Split(netip.MustParsePrefix("10.0.0.0/22"), 24)
+	if err != nil || fmt.Sprint(subnets) != "[10.0.0.0/24 10.0.1.0/24 10.0.2.0/24 10.0.3.0/24]" {
+		t.Errorf("Unexpected split %v, %v", subnets, err)
+	}
+	if got := Summarize(subnets); fmt.Sprint(got) != "[10.0.0.0/22]" {
+		t.Errorf("Expected the split to summarize back, got %v", got)
+	}
+	if got := Summarize(prefixes("10.0.1.0/24", "10.0.0.0/24", "10.0.0.128/25", "10.0.3.0/24")); fmt.Sprint(got) != "[10.0.0.0/23 10.0.3.0/24]" {
+		t.Errorf("Unexpected summary %v", got)
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		outer, inner string
+		want         bool
+	}{
+		{"10.0.0.0/8", "10.1.0.0/16", true},
+		{"10.0.0.0/8", "10.0.0.0/8", true},
+		{"10.1.0.0/16", "10.0.0.0/8", false},
+		{"10.0.0.0/8", "11.0.0.0/16", false},
+		{"0.0.0.0/0", "2001:db8::/32", false},
+		{"::/0", "2001:db8::/32", true},
+	}
+	for _, tt := range tests {
+		if got := Contains(netip.MustParsePrefix(tt.outer), netip.MustParsePrefix(tt.inner)); got != tt.want {
+			t.Errorf("Contains(%s, %s): expected %v", tt.outer, tt.inner, tt.want)
+		}
+	}
+}
+
+func TestRandomHost(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, s := range []string{"10.1.0.0/16", "192.0.2.0/30", "192.0.2.0/31", "192.0.2.9/32", "2001:db8::/32", "::/0"} {
+		p := netip.MustParsePrefix(s)
+		for i := 0; i < 200; i++ {
+			addr := RandomHost(p, r)
+			if !p.Contains(addr) {
+				t.Fatalf("%s: %s is outside the prefix", s, addr)
+			}
+			if p.Addr().Is4() && p.Bits() < 31 && (addr == p.Addr() || addr == Last(p)) {
+				t.Fatalf("%s: got network or broadcast address %s", s, addr)
+			}
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+		err  error
+	}{
+		{"10.0.0.0/24", "10.0.1.0/24", "0", nil},
+		{"10.0.1.0/24", "10.0.0.0/24", "0", nil},
+		{"10.0.0.0/24", "10.0.2.0/24", "256", nil},
+		{"10.0.0.0/32", "10.0.0.10/32", "9", nil},
+		{"2001:db8::/64", "2001:db8:0:2::/64", "18446744073709551616", nil},
+		{"10.0.0.0/8", "10.1.0.0/16", "", ErrOverlap},
+	}
+	for _, tt := range tests {
+		got, err := Distance(netip.MustParsePrefix(tt.a), netip.MustParsePrefix(tt.b))
+		if !errors.Is(err, tt.err) || err == nil && got.String() != tt.want {
+			t.Errorf("Distance(%s, %s): expected %s %v, got %v %v", tt.a, tt.b, tt.want, tt.err, got, err)
+		}
+	}
+	if _, err := Distance(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")); err == nil {
+		t.Errorf("Expected error across families")
+	}
+}
+
+func TestSupernet(t *testing.T) {
+	tests := []struct{ a, b, want string }{
+		{"10.0.0.0/24", "10.0.1.0/24", "10.0.0.0/23"},
+		{"10.0.0.0/24", "10.0.2.0/24", "10.0.0.0/22"},
+		{"10.0.0.0/8", "10.1.0.0/16", "10.0.0.0/8"},
+		{"10.0.0.0/8", "192.0.2.0/24", "0.0.0.0/0"},
+		{"2001:db8::/48", "2001:db8:1::/48", "2001:db8::/47"},
+	}
+	for _, tt := range tests {
+		got, err := Supernet(netip.MustParsePrefix(tt.a), netip.MustParsePrefix(tt.b))
+		if err != nil || got.String() != tt.want {
+			t.Errorf("Supernet(%s, %s): expected %s, got %v, %v", tt.a, tt.b, tt.want, got, err)
+		}
+	}
+}
+
+func TestSizeLast(t *testing.T) {
+	if s := Size(netip.MustParsePrefix("10.0.0.0/22")); s.Int64() != 1024 {
+		t.Errorf("Expected 1024, got %v", s)
+	}
+	if s := Size(netip.MustParsePrefix("::/0")); s.String() != "340282366920938463463374607431768211456" {
+		t.Errorf("Unexpected size of ::/0: %v", s)
+	}
+	if l := Last(netip.MustParsePrefix("10.0.0.0/22")); l.String() != "10.0.3.255" {
+		t.Errorf("Expected 10.0.3.255, got %v", l)
+	}
+	if l := Last(netip.MustParsePrefix("2001:db8::/32")); l.String() != "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff" {
+		t.Errorf("Unexpected last address %v", l)
+	}
+}
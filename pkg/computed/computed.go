@@ -0,0 +1,199 @@
+// Package computed derives extra result fields at lookup time from a
+// match's stored metadata and the address looked up, so values such as a
+// host's offset within its prefix or the /64 of an IPv6 client need not be
+// precomputed into every entry.
+//
+// Each field is a CEL expression (https://cel.dev) with these variables:
+//
+//	ip          the address looked up, as given
+//	cidr        the matching prefix
+//	prefix_len  its length
+//	metadata    its stored metadata
+//
+// and, besides the standard CEL functions and the string extensions:
+//
+//	hostOffset(ip, cidr)  position of ip within cidr, 0 being its first address
+//	mask(ip, bits)        prefix of length bits containing ip, e.g. "2001:db8:1:2::/64"
+//
+// For example:
+//
+//	fields, err := computed.Compile(map[string]string{
+//	    "host":   "hostOffset(ip, cidr)",
+//	    "net64":  "mask(ip, 64)",
+//	    "region": "has(metadata.site) ? metadata.site.split('-')[0] : 'unknown'",
+//	})
+//	md, err := fields.Apply(ip, match)
+package computed
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"sort"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// costLimit bounds the work one expression may do per lookup, so a
+// careless comprehension cannot stall the lookup path
+const costLimit = 10000
+
+// Fields is a compiled set of computed fields. It is safe for concurrent
+// use.
+type Fields struct {
+	fields []field
+}
+
+type field struct {
+	name string
+	prg  cel.Program
+}
+
+// Compile compiles exprs, a map from field name to CEL expression
+func Compile(exprs map[string]string) (*Fields, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("ip", cel.StringType),
+		cel.Variable("cidr", cel.StringType),
+		cel.Variable("prefix_len", cel.IntType),
+		cel.Variable("metadata", cel.MapType(cel.StringType, cel.DynType)),
+		ext.Strings(),
+		cel.Function("hostOffset",
+			cel.Overload("hostOffset_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.IntType,
+				cel.BinaryBinding(hostOffset))),
+		cel.Function("mask",
+			cel.Overload("mask_string_int", []*cel.Type{cel.StringType, cel.IntType}, cel.StringType,
+				cel.BinaryBinding(mask))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(exprs))
+	for name := range exprs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f := &Fields{fields: make([]field, 0, len(names))}
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("computed field with empty name")
+		}
+		ast, iss := env.Compile(exprs[name])
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("computed field %s: %v", name, iss.Err())
+		}
+		prg, err := env.Program(ast, cel.CostLimit(costLimit))
+		if err != nil {
+			return nil, fmt.Errorf("computed field %s: %v", name, err)
+		}
+		f.fields = append(f.fields, field{name, prg})
+	}
+	return f, nil
+}
+
+// Len returns the number of fields
+func (f *Fields) Len() int {
+	if f == nil {
+		return 0
+	}
+	return len(f.fields)
+}
+
+// Apply returns m's metadata with the computed fields for a lookup of ip
+// added, overriding stored keys of the same name. The stored metadata is
+// not modified. A field whose expression fails, for example by reading a
+// key the metadata lacks, is left out and its error returned along with
+// the others; the returned metadata is usable either way.
+func (f *Fields) Apply(ip string, m trie.Match) (map[string]interface{}, error) {
+	if f.Len() == 0 {
+		return m.Metadata, nil
+	}
+	stored := m.Metadata
+	if stored == nil {
+		stored = map[string]interface{}{}
+	}
+	vars := map[string]interface{}{
+		"ip":         ip,
+		"cidr":       m.CIDR,
+		"prefix_len": m.PrefixLen,
+		"metadata":   stored,
+	}
+
+	md := make(map[string]interface{}, len(stored)+len(f.fields))
+	for k, v := range stored {
+		md[k] = v
+	}
+	var errs []error
+	for _, fld := range f.fields {
+		out, _, err := fld.prg.Eval(vars)
+		if err == nil {
+			var v interface{}
+			if v, err = native(out); err == nil {
+				md[fld.name] = v
+				continue
+			}
+		}
+		errs = append(errs, fmt.Errorf("computed field %s: %v", fld.name, err))
+	}
+	return md, errors.Join(errs...)
+}
+
+// native converts a CEL result to the plain Go values metadata holds, with
+// lists and maps converted as JSON would
+func native(v ref.Val) (interface{}, error) {
+	switch x := v.Value().(type) {
+	case bool, int64, uint64, float64, string, nil:
+		return x, nil
+	}
+	pv, err := v.ConvertToNative(reflect.TypeOf(&structpb.Value{}))
+	if err != nil {
+		return nil, err
+	}
+	return pv.(*structpb.Value).AsInterface(), nil
+}
+
+// hostOffset implements hostOffset(ip, cidr)
+func hostOffset(ipVal, cidrVal ref.Val) ref.Val {
+	addr, err := netip.ParseAddr(string(ipVal.(types.String)))
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	p, err := netip.ParsePrefix(string(cidrVal.(types.String)))
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	if p.Addr().Is4() {
+		addr = addr.Unmap()
+	}
+	if !p.Contains(addr) {
+		return types.NewErr("%s is not in %s", addr, p)
+	}
+	off := new(big.Int).SetBytes(addr.AsSlice())
+	off.Sub(off, new(big.Int).SetBytes(p.Masked().Addr().AsSlice()))
+	if !off.IsInt64() {
+		return types.NewErr("offset of %s in %s overflows an int", addr, p)
+	}
+	return types.Int(off.Int64())
+}
+
+// mask implements mask(ip, bits)
+func mask(ipVal, bitsVal ref.Val) ref.Val {
+	addr, err := netip.ParseAddr(string(ipVal.(types.String)))
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	p, err := addr.Unmap().WithZone("").Prefix(int(bitsVal.(types.Int)))
+	if err != nil {
+		return types.WrapErr(err)
+	}
+	return types.String(p.String())
+}
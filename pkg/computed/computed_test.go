@@ -0,0 +1,118 @@
+package computed
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func TestApply(t *testing.T) {
+	fields, err := Compile(map[string]string{
+		"host":   "hostOffset(ip, cidr)",
+		"net56":  "mask(ip, 56)",
+		"region": "has(metadata.site) ? metadata.site.split('-')[0] : 'unknown'",
+		"big":    "prefix_len < 24",
+		"tags":   "metadata.tags.map(t, t.upperAscii())",
+		"owner":  "'computed'",
+	})
+	if err != nil {
+		t.Fatalf("Compile returned error: %v", err)
+	}
+	if fields.Len() != 6 {
+		t.Errorf("Expected 6 fields, got %d", fields.Len())
+	}
+
+	stored := map[string]interface{}{"owner": "netops", "site": "fra-2", "tags": []string{"a", "b"}}
+	m := trie.Match{CIDR: "2001:db8:1:2::/64", PrefixLen: 64, Metadata: stored}
+	md, err := fields.Apply("2001:db8:1:2::1:5", m)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	want := map[string]interface{}{
+		"owner":  "computed",
+		"site":   "fra-2",
+		"tags":   []interface{}{"A", "B"},
+		"host":   int64(0x1_0005),
+		"net56":  "2001:db8:1::/56",
+		"region": "fra",
+		"big":    false,
+	}
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("Expected %v, got %v", want, md)
+	}
+	if stored["owner"] != "netops" || len(stored) != 3 {
+		t.Errorf("Expected the stored metadata to be unchanged, got %v", stored)
+	}
+}
+
+func TestApplyIPv4(t *testing.T) {
+	fields, err := Compile(map[string]string{
+		"host":  "hostOffset(ip, cidr)",
+		"net24": "mask(ip, 24)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"10.1.2.3", "::ffff:10.1.2.3"} {
+		md, err := fields.Apply(ip, trie.Match{CIDR: "10.1.0.0/16", PrefixLen: 16})
+		if err != nil {
+			t.Fatalf("%s: Apply returned error: %v", ip, err)
+		}
+		if md["host"] != int64(515) || md["net24"] != "10.1.2.0/24" {
+			t.Errorf("%s: unexpected fields %v", ip, md)
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	fields, err := Compile(map[string]string{
+		"site": "metadata.site",
+		"host": "hostOffset(ip, cidr)",
+		"ok":   "cidr",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing key fails only its own field
+	md, err := fields.Apply("10.1.2.3", trie.Match{CIDR: "10.0.0.0/8", PrefixLen: 8})
+	if err == nil || !strings.Contains(err.Error(), "computed field site") {
+		t.Errorf("Expected an error for the site field, got %v", err)
+	}
+	if md["ok"] != "10.0.0.0/8" || md["host"] != int64(0x010203) {
+		t.Errorf("Expected the other fields to be computed, got %v", md)
+	}
+	if _, found := md["site"]; found {
+		t.Errorf("Expected the failed field to be left out, got %v", md)
+	}
+
+	// Offsets beyond an int are errors
+	_, err = fields.Apply("2001:db8::ffff:ffff:ffff:ffff", trie.Match{CIDR: "2001:db8::/32", PrefixLen: 32, Metadata: map[string]interface{}{"site": "x"}})
+	if err == nil || !strings.Contains(err.Error(), "overflows") {
+		t.Errorf("Expected an overflow error, got %v", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, exprs := range []map[string]string{
+		{"bad": "ip +"},
+		{"bad": "unknown_var"},
+		{"bad": "mask(ip, 'x')"},
+		{"": "ip"},
+	} {
+		if _, err := Compile(exprs); err == nil {
+			t.Errorf("Expected Compile(%v) to fail", exprs)
+		}
+	}
+}
+
+func TestNoFields(t *testing.T) {
+	var fields *Fields
+	md := map[string]interface{}{"a": 1}
+	got, err := fields.Apply("10.0.0.1", trie.Match{Metadata: md})
+	if err != nil || !reflect.DeepEqual(got, md) {
+		t.Errorf("Expected the stored metadata, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,276 @@
+// Package conformance holds machine-readable test vectors for longest
+// prefix match semantics and a runner for them, so every implementation
+// of those semantics — this module's trie, its REST and gRPC services, and
+// bindings or clients in other languages — can show it agrees with the Go
+// core.
+//
+// The vectors live in the vectors directory as JSON files, one scenario
+// each, and are embedded in the package. A scenario is a list of steps run
+// in order against a fresh, empty implementation:
+//
+//	{"op": "insert", "cidr": "10.0.0.0/8", "metadata": {"name": "ten"}}
+//	{"op": "find", "ip": "10.1.2.3", "expect": {"cidr": "10.0.0.0/8"}}
+//	{"op": "find_all", "ip": "10.1.2.3", "expect": {"cidrs": ["10.0.0.0/8"]}}
+//	{"op": "delete", "cidr": "10.0.0.0/8", "expect": {"found": true}}
+//
+// An expect of {} for find means no prefix matches, and {"error": true}
+// means the operation must fail. Metadata, when given, must match after
+// both sides go through JSON, so numbers compare as float64. Runners in
+// other languages read the same files.
+package conformance
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+//go:embed vectors/*.json
+var vectorFiles embed.FS
+
+// Impl is the implementation under test
+type Impl interface {
+	Insert(cidr string, metadata map[string]interface{}) error
+	// Delete reports whether cidr was stored
+	Delete(cidr string) (bool, error)
+	// Find returns the most specific match, with an empty cidr when there
+	// is none
+	Find(ip string) (cidr string, metadata map[string]interface{}, err error)
+}
+
+// AllFinder is implemented by an Impl that can list every matching prefix.
+// The runner skips find_all steps for implementations without it.
+type AllFinder interface {
+	// FindAll returns every matching CIDR, most specific first
+	FindAll(ip string) ([]string, error)
+}
+
+// Scenario is one vector file
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Steps       []Step `json:"steps"`
+}
+
+// Step is one operation and its expected outcome
+type Step struct {
+	Op       string                 `json:"op"`
+	CIDR     string                 `json:"cidr,omitempty"`
+	IP       string                 `json:"ip,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Expect   *Expect                `json:"expect,omitempty"`
+}
+
+// Expect is the outcome a step must have. Fields not used by the step's
+// op are ignored.
+type Expect struct {
+	Error    bool                   `json:"error,omitempty"`
+	CIDR     string                 `json:"cidr,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	CIDRs    []string               `json:"cidrs,omitempty"`
+	Found    bool                   `json:"found,omitempty"`
+}
+
+// Failure describes a step whose outcome differed from the vector
+type Failure struct {
+	Scenario string
+	Step     int
+	Op       string
+	Message  string
+}
+
+func (f Failure) Error() string {
+	return fmt.Sprintf("%s step %d (%s): %s", f.Scenario, f.Step, f.Op, f.Message)
+}
+
+// Scenarios returns the embedded vectors, sorted by name
+func Scenarios() ([]Scenario, error) {
+	names, err := fs.Glob(vectorFiles, "vectors/*.json")
+	if err != nil {
+		return nil, err
+	}
+	scenarios := make([]Scenario, 0, len(names))
+	for _, name := range names {
+		data, err := vectorFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var s Scenario
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("%s: %v", path.Base(name), err)
+		}
+		scenarios = append(scenarios, s)
+	}
+	sort.Slice(scenarios, func(i, j int) bool { return scenarios[i].Name < scenarios[j].Name })
+	return scenarios, nil
+}
+
+// Run runs every scenario against a fresh implementation from newImpl and
+// returns the steps that failed. A scenario stops at its first failure,
+// since later steps depend on earlier ones.
+func Run(newImpl func() (Impl, error)) ([]Failure, error) {
+	scenarios, err := Scenarios()
+	if err != nil {
+		return nil, err
+	}
+	var failures []Failure
+	for _, s := range scenarios {
+		impl, err := newImpl()
+		if err != nil {
+			return nil, err
+		}
+		if f, failed := RunScenario(s, impl); failed {
+			failures = append(failures, f)
+		}
+	}
+	return failures, nil
+}
+
+// RunScenario runs one scenario against impl, returning the first failed
+// step if any
+func RunScenario(s Scenario, impl Impl) (Failure, bool) {
+	for i, step := range s.Steps {
+		expect := step.Expect
+		if expect == nil {
+			expect = &Expect{}
+		}
+		if msg := runStep(impl, step, expect); msg != "" {
+			return Failure{Scenario: s.Name, Step: i + 1, Op: step.Op, Message: msg}, true
+		}
+	}
+	return Failure{}, false
+}
+
+// runStep runs one step, describing how its outcome differed from expect
+func runStep(impl Impl, step Step, expect *Expect) string {
+	switch step.Op {
+	case "insert":
+		md := step.Metadata
+		if md == nil {
+			md = map[string]interface{}{}
+		}
+		return checkError(impl.Insert(step.CIDR, md), expect.Error)
+
+	case "delete":
+		found, err := impl.Delete(step.CIDR)
+		if msg := checkError(err, expect.Error); msg != "" || err != nil {
+			return msg
+		}
+		if found != expect.Found {
+			return fmt.Sprintf("delete %s: found %v, expected %v", step.CIDR, found, expect.Found)
+		}
+
+	case "find":
+		cidr, md, err := impl.Find(step.IP)
+		if msg := checkError(err, expect.Error); msg != "" || err != nil {
+			return msg
+		}
+		if cidr != expect.CIDR {
+			return fmt.Sprintf("find %s: got %s, expected %s", step.IP, orNone(cidr), orNone(expect.CIDR))
+		}
+		if expect.Metadata != nil && !sameJSON(md, expect.Metadata) {
+			return fmt.Sprintf("find %s: metadata %v, expected %v", step.IP, md, expect.Metadata)
+		}
+
+	case "find_all":
+		all, ok := impl.(AllFinder)
+		if !ok {
+			return ""
+		}
+		cidrs, err := all.FindAll(step.IP)
+		if msg := checkError(err, expect.Error); msg != "" || err != nil {
+			return msg
+		}
+		if len(cidrs) != len(expect.CIDRs) || (len(cidrs) > 0 && !reflect.DeepEqual(cidrs, expect.CIDRs)) {
+			return fmt.Sprintf("find_all %s: got [%s], expected [%s]", step.IP,
+				strings.Join(cidrs, " "), strings.Join(expect.CIDRs, " "))
+		}
+
+	default:
+		return fmt.Sprintf("unknown op %q", step.Op)
+	}
+	return ""
+}
+
+func checkError(err error, want bool) string {
+	switch {
+	case err != nil && !want:
+		return fmt.Sprintf("unexpected error: %v", err)
+	case err == nil && want:
+		return "expected an error"
+	}
+	return ""
+}
+
+func orNone(cidr string) string {
+	if cidr == "" {
+		return "no match"
+	}
+	return cidr
+}
+
+// sameJSON reports whether got and want are equal once both are passed
+// through JSON. A nil map equals an empty one.
+func sameJSON(got, want map[string]interface{}) bool {
+	normalize := func(md map[string]interface{}) interface{} {
+		if md == nil {
+			md = map[string]interface{}{}
+		}
+		data, err := json.Marshal(md)
+		if err != nil {
+			return err.Error()
+		}
+		var out interface{}
+		json.Unmarshal(data, &out)
+		return out
+	}
+	return reflect.DeepEqual(normalize(got), normalize(want))
+}
+
+// Trie returns an Impl factory for IPTries built with opts
+func Trie(opts ...trie.Option) func() (Impl, error) {
+	return func() (Impl, error) {
+		return trieImpl{trie.NewIPTrie(opts...)}, nil
+	}
+}
+
+// trieImpl adapts IPTrie to Impl
+type trieImpl struct {
+	t *trie.IPTrie
+}
+
+func (ti trieImpl) Insert(cidr string, md map[string]interface{}) error {
+	return ti.t.Insert(cidr, md)
+}
+
+func (ti trieImpl) Delete(cidr string) (bool, error) {
+	_, found, err := ti.t.Remove(cidr)
+	return found, err
+}
+
+func (ti trieImpl) Find(ip string) (string, map[string]interface{}, error) {
+	matches, err := ti.t.FindAll(ip)
+	if err != nil || len(matches) == 0 {
+		return "", nil, err
+	}
+	return matches[0].CIDR, matches[0].Metadata, nil
+}
+
+func (ti trieImpl) FindAll(ip string) ([]string, error) {
+	matches, err := ti.t.FindAll(ip)
+	if err != nil {
+		return nil, err
+	}
+	cidrs := make([]string, len(matches))
+	for i, m := range matches {
+		cidrs[i] = m.CIDR
+	}
+	return cidrs, nil
+}
@@ -0,0 +1,220 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/metajar/trie-network/pkg/grpcapi"
+	"github.com/metajar/trie-network/pkg/server"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func TestScenarios(t *testing.T) {
+	scenarios, err := Scenarios()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenarios) == 0 {
+		t.Fatal("Expected embedded scenarios")
+	}
+	ops := map[string]bool{}
+	for _, s := range scenarios {
+		if s.Name == "" || s.Description == "" || len(s.Steps) == 0 {
+			t.Errorf("Scenario %q is incomplete", s.Name)
+		}
+		for _, step := range s.Steps {
+			ops[step.Op] = true
+		}
+	}
+	for _, op := range []string{"insert", "delete", "find", "find_all"} {
+		if !ops[op] {
+			t.Errorf("Expected some scenario to use %s", op)
+		}
+	}
+}
+
+// runAll fails the test for every step that newImpl gets wrong
+func runAll(t *testing.T, newImpl func() (Impl, error)) {
+	failures, err := Run(newImpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range failures {
+		t.Error(f)
+	}
+}
+
+func TestTrie(t *testing.T) {
+	runAll(t, Trie())
+}
+
+// brokenImpl returns the least specific match instead of the most
+type brokenImpl struct {
+	trieImpl
+}
+
+func (b brokenImpl) Find(ip string) (string, map[string]interface{}, error) {
+	matches, err := b.t.FindAll(ip)
+	if err != nil || len(matches) == 0 {
+		return "", nil, err
+	}
+	last := matches[len(matches)-1]
+	return last.CIDR, last.Metadata, nil
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	failures, err := Run(func() (Impl, error) {
+		return brokenImpl{trieImpl{trie.NewIPTrie()}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) == 0 {
+		t.Fatal("Expected failures from a broken implementation")
+	}
+	for _, f := range failures {
+		if f.Scenario == "" || f.Step == 0 || f.Op != "find" || f.Message == "" {
+			t.Errorf("Unexpected failure %+v", f)
+		}
+	}
+}
+
+// restImpl runs the steps against the REST server. The API has no
+// endpoint listing every match, so find_all steps are skipped.
+type restImpl struct {
+	url string
+}
+
+func (r restImpl) do(method, path string, body interface{}) (int, []byte, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, nil, err
+		}
+	}
+	req, err := http.NewRequest(method, r.url+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(res.Body)
+	return res.StatusCode, buf.Bytes(), err
+}
+
+func (r restImpl) Insert(cidr string, md map[string]interface{}) error {
+	code, body, err := r.do(http.MethodPut, "/prefix/"+url.PathEscape(cidr), md)
+	if err == nil && code != http.StatusCreated && code != http.StatusOK {
+		err = fmt.Errorf("status %d: %s", code, body)
+	}
+	return err
+}
+
+func (r restImpl) Delete(cidr string) (bool, error) {
+	code, body, err := r.do(http.MethodDelete, "/prefix/"+url.PathEscape(cidr), nil)
+	switch {
+	case err != nil:
+		return false, err
+	case code == http.StatusNoContent:
+		return true, nil
+	case code == http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("status %d: %s", code, body)
+}
+
+func (r restImpl) Find(ip string) (string, map[string]interface{}, error) {
+	code, body, err := r.do(http.MethodGet, "/lookup/"+url.PathEscape(ip), nil)
+	switch {
+	case err != nil:
+		return "", nil, err
+	case code == http.StatusNotFound:
+		return "", nil, nil
+	case code != http.StatusOK:
+		return "", nil, fmt.Errorf("status %d: %s", code, body)
+	}
+	var res server.LookupResult
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", nil, err
+	}
+	return res.CIDR, res.Metadata, nil
+}
+
+func TestREST(t *testing.T) {
+	runAll(t, func() (Impl, error) {
+		ts := httptest.NewServer(server.New(trie.NewTrieHolder(), server.Config{}))
+		t.Cleanup(ts.Close)
+		return restImpl{ts.URL}, nil
+	})
+}
+
+// grpcImpl runs the steps against the gRPC service
+type grpcImpl struct {
+	c *grpcapi.Client
+}
+
+func (g grpcImpl) Insert(cidr string, md map[string]interface{}) error {
+	_, err := g.c.Insert(context.Background(), cidr, md)
+	return err
+}
+
+func (g grpcImpl) Delete(cidr string) (bool, error) {
+	return g.c.Delete(context.Background(), cidr)
+}
+
+func (g grpcImpl) Find(ip string) (string, map[string]interface{}, error) {
+	res, err := g.c.Lookup(context.Background(), ip)
+	if err != nil || !res.Found {
+		return "", nil, err
+	}
+	return res.Match.CIDR, res.Match.Metadata, nil
+}
+
+func (g grpcImpl) FindAll(ip string) ([]string, error) {
+	res, err := g.c.LookupAll(context.Background(), ip)
+	if err != nil {
+		return nil, err
+	}
+	cidrs := make([]string, len(res.Matches))
+	for i, m := range res.Matches {
+		cidrs[i] = m.CIDR
+	}
+	return cidrs, nil
+}
+
+func TestGRPC(t *testing.T) {
+	runAll(t, func() (Impl, error) {
+		lis := bufconn.Listen(1 << 20)
+		s := grpc.NewServer(grpc.ForceServerCodec(grpcapi.Codec{}))
+		grpcapi.Register(s, grpcapi.NewServer(trie.NewTrieHolder(), grpcapi.Config{}))
+		go s.Serve(lis)
+		t.Cleanup(s.Stop)
+
+		cc, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() { cc.Close() })
+		return grpcImpl{grpcapi.NewClient(cc)}, nil
+	})
+}
@@ -0,0 +1,133 @@
+// Package devconf extracts prefix objects, such as prefix lists and static
+// routes, from router configuration files into named datasets, so policy
+// deployed on devices can be compared with the source of truth kept in a
+// trie.
+package devconf
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Kind is the type of configuration object a dataset was read from
+type Kind string
+
+const (
+	// PrefixList datasets come from JunOS prefix-lists and IOS-XR
+	// prefix-sets
+	PrefixList Kind = "prefix-list"
+	// StaticRoutes datasets hold the static routes of one routing instance
+	// or VRF
+	StaticRoutes Kind = "static"
+)
+
+// Entry is one prefix of a dataset
+type Entry struct {
+	Prefix netip.Prefix
+	// GE and LE are the prefix-set length bounds, zero when not given
+	GE int
+	LE int
+	// NextHops lists the next hops of a static route, e.g. an address,
+	// an interface, "discard" or "Null0"
+	NextHops []string
+	// Line is the line of the configuration the entry was read from
+	Line int
+}
+
+// Dataset is a named group of prefixes read from a configuration
+type Dataset struct {
+	// Name is the prefix list or prefix-set name, or "static" for static
+	// routes, qualified as "<instance>/static" for routing instances and
+	// VRFs
+	Name    string
+	Kind    Kind
+	Entries []Entry
+}
+
+// Trie returns the dataset as a trie, for diffing against an intended
+// dataset with Subtract and Intersect. Each prefix carries "dataset" and
+// "kind" metadata plus "next_hops", "ge" and "le" when set. A prefix listed
+// twice keeps its last entry.
+func (d Dataset) Trie() (*trie.IPTrie, error) {
+	t := trie.NewIPTrie()
+	for _, e := range d.Entries {
+		md := map[string]interface{}{
+			"dataset": d.Name,
+			"kind":    string(d.Kind),
+		}
+		if len(e.NextHops) > 0 {
+			md["next_hops"] = e.NextHops
+		}
+		if e.GE > 0 {
+			md["ge"] = e.GE
+		}
+		if e.LE > 0 {
+			md["le"] = e.LE
+		}
+		if err := t.Insert(e.Prefix.String(), md); err != nil {
+			return nil, fmt.Errorf("line %d: %v", e.Line, err)
+		}
+	}
+	return t, nil
+}
+
+// datasets collects datasets in order of first appearance
+type datasets struct {
+	list  []*Dataset
+	index map[string]*Dataset
+}
+
+// get returns the dataset with the given name, creating it if needed
+func (ds *datasets) get(name string, kind Kind) *Dataset {
+	key := string(kind) + "\x00" + name
+	if d, ok := ds.index[key]; ok {
+		return d
+	}
+	if ds.index == nil {
+		ds.index = make(map[string]*Dataset)
+	}
+	d := &Dataset{Name: name, Kind: kind}
+	ds.index[key] = d
+	ds.list = append(ds.list, d)
+	return d
+}
+
+// addRoute records a static route, merging next hops of a prefix that is
+// configured more than once
+func (ds *datasets) addRoute(name string, prefix netip.Prefix, nextHops []string, line int) {
+	d := ds.get(name, StaticRoutes)
+	for i := range d.Entries {
+		if d.Entries[i].Prefix != prefix {
+			continue
+		}
+		for _, hop := range nextHops {
+			if indexOf(d.Entries[i].NextHops, hop) < 0 {
+				d.Entries[i].NextHops = append(d.Entries[i].NextHops, hop)
+			}
+		}
+		return
+	}
+	d.Entries = append(d.Entries, Entry{Prefix: prefix, NextHops: nextHops, Line: line})
+}
+
+// result returns the collected datasets
+func (ds *datasets) result() []Dataset {
+	out := make([]Dataset, len(ds.list))
+	for i, d := range ds.list {
+		out[i] = *d
+	}
+	return out
+}
+
+// parsePrefix parses a prefix, accepting a bare address as a host route
+func parsePrefix(s string) (netip.Prefix, bool) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		return p.Masked(), true
+	}
+	if a, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(a, a.BitLen()), true
+	}
+	return netip.Prefix{}, false
+}
@@ -0,0 +1,147 @@
+package devconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// xrRouteOptions are the words that end the next hop part of an IOS-XR
+// static route
+var xrRouteOptions = map[string]bool{
+	"bfd":         true,
+	"description": true,
+	"metric":      true,
+	"permanent":   true,
+	"tag":         true,
+	"track":       true,
+	"tunnel-id":   true,
+	"vrflabel":    true,
+}
+
+// ParseIOSXR extracts prefix-sets and static routes from an IOS-XR
+// configuration. Each static route line yields one next hop, joining an
+// interface and address given together; the same prefix on several lines
+// collects all of them.
+func ParseIOSXR(r io.Reader) ([]Dataset, error) {
+	var (
+		ds       datasets
+		set      *Dataset
+		inStatic bool
+		vrf      string
+		vrfDepth int
+	)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		raw := sc.Text()
+		line := strings.TrimSpace(raw)
+		depth := len(raw) - len(strings.TrimLeft(raw, " \t"))
+
+		switch {
+		case set != nil:
+			if line == "end-set" {
+				set = nil
+				continue
+			}
+			if err := xrSetEntry(set, line, n); err != nil {
+				return nil, err
+			}
+			continue
+		case depth == 0:
+			inStatic = line == "router static"
+			vrf = ""
+			if name, ok := strings.CutPrefix(line, "prefix-set "); ok {
+				set = ds.get(strings.TrimSpace(name), PrefixList)
+			}
+			continue
+		case !inStatic || line == "!" || line == "":
+			continue
+		}
+
+		if vrf != "" && depth <= vrfDepth {
+			vrf = ""
+		}
+		words := strings.Fields(line)
+		if words[0] == "vrf" && len(words) > 1 {
+			vrf, vrfDepth = words[1], depth
+			continue
+		}
+
+		prefix, ok := parsePrefix(words[0])
+		if !ok {
+			// address-family and other sub-modes
+			continue
+		}
+		var hop []string
+		for _, w := range words[1:] {
+			if xrRouteOptions[w] {
+				break
+			}
+			// A bare number is the administrative distance
+			if _, err := strconv.Atoi(w); err == nil {
+				continue
+			}
+			hop = append(hop, w)
+		}
+
+		name := "static"
+		if vrf != "" {
+			name = vrf + "/static"
+		}
+		var nextHops []string
+		if len(hop) > 0 {
+			nextHops = []string{strings.Join(hop, " ")}
+		}
+		ds.addRoute(name, prefix, nextHops, n)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if set != nil {
+		return nil, fmt.Errorf("prefix-set %s has no end-set", set.Name)
+	}
+	return ds.result(), nil
+}
+
+// xrSetEntry parses one prefix-set line such as "10.0.0.0/8 le 24,"
+func xrSetEntry(set *Dataset, line string, n int) error {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), ","))
+	if line == "" {
+		return nil
+	}
+
+	words := strings.Fields(line)
+	prefix, ok := parsePrefix(words[0])
+	if !ok {
+		return fmt.Errorf("line %d: invalid prefix %q in prefix-set %s", n, words[0], set.Name)
+	}
+	if len(words)%2 == 0 {
+		return fmt.Errorf("line %d: incomplete prefix-set entry %q", n, line)
+	}
+	e := Entry{Prefix: prefix, Line: n}
+	for i := 1; i+1 < len(words); i += 2 {
+		v, err := strconv.Atoi(words[i+1])
+		if err != nil {
+			return fmt.Errorf("line %d: invalid length %q", n, words[i+1])
+		}
+		switch words[i] {
+		case "ge":
+			e.GE = v
+		case "le":
+			e.LE = v
+		case "eq":
+			e.GE, e.LE = v, v
+		default:
+			return fmt.Errorf("line %d: unknown prefix-set operator %q", n, words[i])
+		}
+	}
+	set.Entries = append(set.Entries, e)
+	return nil
+}
@@ -0,0 +1,74 @@
+package devconf
+
+import (
+	"strings"
+	"testing"
+)
+
+const xrConfig = `!! IOS XR Configuration
+prefix-set BOGONS
+  # RFC 1918
+  10.0.0.0/8 le 32,
+  172.16.0.0/12 ge 16 le 24,
+  192.168.0.0/16 eq 24,
+  2001:db8::/32
+end-set
+!
+router static
+ address-family ipv4 unicast
+  0.0.0.0/0 192.0.2.1
+  10.9.0.0/16 192.0.2.2 200
+  10.9.0.0/16 192.0.2.3 200
+  10.66.0.0/16 Null0 tag 66
+  10.70.0.0/16 GigabitEthernet0/0/0/1 192.0.2.5 description "to lab"
+ !
+ address-family ipv6 unicast
+  ::/0 2001:db8::1
+ !
+ vrf CUST-A
+  address-family ipv4 unicast
+   172.16.0.0/12 198.51.100.1
+  !
+ !
+ address-family ipv4 multicast
+  10.200.0.0/16 192.0.2.8
+ !
+!
+interface Loopback0
+ ipv4 address 192.0.2.255 255.255.255.255
+!
+`
+
+func TestParseIOSXR(t *testing.T) {
+	datasets, err := ParseIOSXR(strings.NewReader(xrConfig))
+	if err != nil {
+		t.Fatalf("ParseIOSXR returned error: %v", err)
+	}
+
+	want := `prefix-list BOGONS: 10.0.0.0/8[0-32] 172.16.0.0/12[16-24] 192.168.0.0/16[24-24] 2001:db8::/32
+static static: 0.0.0.0/0[192.0.2.1] 10.9.0.0/16[192.0.2.2 192.0.2.3] 10.66.0.0/16[Null0] 10.70.0.0/16[GigabitEthernet0/0/0/1 192.0.2.5] ::/0[2001:db8::1] 10.200.0.0/16[192.0.2.8]
+static CUST-A/static: 172.16.0.0/12[198.51.100.1]
+`
+	if got := summarize(datasets); got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestParseIOSXRErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "bad prefix", config: "prefix-set X\n  10.0.0.0/33\nend-set\n"},
+		{name: "bad operator", config: "prefix-set X\n  10.0.0.0/8 lt 24\nend-set\n"},
+		{name: "incomplete", config: "prefix-set X\n  10.0.0.0/8 le\nend-set\n"},
+		{name: "missing end-set", config: "prefix-set X\n  10.0.0.0/8\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseIOSXR(strings.NewReader(tt.config)); err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
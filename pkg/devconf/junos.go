@@ -0,0 +1,257 @@
+package devconf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// statement is one complete JunOS configuration statement with the
+// hierarchy leading to it, e.g. [routing-options static route 0.0.0.0/0
+// next-hop 192.0.2.1]
+type statement struct {
+	words []string
+	line  int
+}
+
+// ParseJunos extracts prefix-lists and static routes from a JunOS
+// configuration in either the curly brace format of "show configuration" or
+// the "display set" format. Inactive statements are skipped in the curly
+// brace format; deactivate lines in the set format are ignored.
+func ParseJunos(r io.Reader) ([]Dataset, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var stmts []statement
+	if isSetFormat(string(data)) {
+		stmts = setStatements(string(data))
+	} else if stmts, err = braceStatements(string(data)); err != nil {
+		return nil, err
+	}
+
+	var ds datasets
+	for _, s := range stmts {
+		if err := junosStatement(&ds, s); err != nil {
+			return nil, err
+		}
+	}
+	return ds.result(), nil
+}
+
+// junosStatement adds the prefix objects a statement defines, if any
+func junosStatement(ds *datasets, s statement) error {
+	w := s.words
+
+	if i := indexOf(w, "prefix-list"); i > 0 && w[i-1] == "policy-options" && len(w) > i+1 {
+		name := w[i+1]
+		d := ds.get(name, PrefixList)
+		// Only literal entries; apply-path and the like are skipped
+		if len(w) == i+3 && !strings.HasPrefix(w[i+2], "apply-path") {
+			prefix, ok := parsePrefix(w[i+2])
+			if !ok {
+				return fmt.Errorf("line %d: invalid prefix %q in prefix-list %s", s.line, w[i+2], name)
+			}
+			d.Entries = append(d.Entries, Entry{Prefix: prefix, Line: s.line})
+		}
+		return nil
+	}
+
+	i := indexOf(w, "static")
+	if i < 0 || indexOf(w[:i], "routing-options") < 0 || len(w) < i+3 || w[i+1] != "route" {
+		return nil
+	}
+	prefix, ok := parsePrefix(w[i+2])
+	if !ok {
+		return fmt.Errorf("line %d: invalid static route %q", s.line, w[i+2])
+	}
+
+	name := "static"
+	if j := indexOf(w, "routing-instances"); j >= 0 && j+1 < i {
+		name = w[j+1] + "/static"
+	}
+
+	var nextHops []string
+	rest := w[i+3:]
+	for k := 0; k < len(rest); k++ {
+		switch rest[k] {
+		case "next-hop":
+			if k+1 < len(rest) && rest[k+1] == "[" {
+				for k += 2; k < len(rest) && rest[k] != "]"; k++ {
+					nextHops = append(nextHops, rest[k])
+				}
+			} else if k+1 < len(rest) {
+				k++
+				nextHops = append(nextHops, rest[k])
+			}
+		case "qualified-next-hop":
+			// The words after the address are the next hop's own options
+			if k+1 < len(rest) {
+				nextHops = append(nextHops, rest[k+1])
+			}
+			k = len(rest)
+		case "discard", "reject", "receive":
+			nextHops = append(nextHops, rest[k])
+		}
+	}
+	ds.addRoute(name, prefix, nextHops, s.line)
+	return nil
+}
+
+// isSetFormat reports whether a configuration is made of set commands
+func isSetFormat(data string) bool {
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return strings.HasPrefix(line, "set ")
+	}
+	return false
+}
+
+// setStatements turns "set" lines into statements
+func setStatements(data string) []statement {
+	var stmts []statement
+	sc := bufio.NewScanner(strings.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		words := junosWords(sc.Text())
+		if len(words) > 1 && words[0] == "set" {
+			stmts = append(stmts, statement{words: words[1:], line: n})
+		}
+	}
+	return stmts
+}
+
+// braceStatements flattens the curly brace hierarchy into statements
+func braceStatements(data string) ([]statement, error) {
+	var (
+		stmts    []statement
+		path     [][]string
+		inactive []bool
+		current  []string
+		start    int
+	)
+	skipping := func() bool {
+		for _, off := range inactive {
+			if off {
+				return true
+			}
+		}
+		return false
+	}
+
+	for n, line := range strings.Split(stripBlockComments(data), "\n") {
+		for _, word := range junosWords(line) {
+			if len(current) == 0 {
+				start = n + 1
+			}
+			switch word {
+			case "{":
+				off := len(current) > 0 && current[0] == "inactive:"
+				if off {
+					current = current[1:]
+				}
+				path = append(path, current)
+				inactive = append(inactive, off)
+				current = nil
+			case "}":
+				if len(path) == 0 {
+					return nil, fmt.Errorf("line %d: unbalanced }", n+1)
+				}
+				path = path[:len(path)-1]
+				inactive = inactive[:len(inactive)-1]
+				current = nil
+			case ";":
+				if len(current) > 0 && current[0] != "inactive:" && !skipping() {
+					var words []string
+					for _, p := range path {
+						words = append(words, p...)
+					}
+					stmts = append(stmts, statement{words: append(words, current...), line: start})
+				}
+				current = nil
+			default:
+				current = append(current, word)
+			}
+		}
+	}
+	if len(path) > 0 {
+		return nil, fmt.Errorf("unbalanced {: %d blocks not closed", len(path))
+	}
+	return stmts, nil
+}
+
+// stripBlockComments blanks /* */ comments, keeping line breaks so line
+// numbers stay correct
+func stripBlockComments(data string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(data, "/*")
+		if i < 0 {
+			b.WriteString(data)
+			return b.String()
+		}
+		b.WriteString(data[:i])
+		j := strings.Index(data[i:], "*/")
+		if j < 0 {
+			return b.String()
+		}
+		b.WriteString(strings.Repeat("\n", strings.Count(data[i:i+j], "\n")))
+		data = data[i+j+2:]
+	}
+}
+
+// junosWords splits a line into words, treating ; { } [ ] as words of their
+// own, keeping quoted strings whole and dropping # comments
+func junosWords(line string) []string {
+	var (
+		words  []string
+		word   strings.Builder
+		quoted bool
+	)
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range line {
+		switch {
+		case quoted:
+			if r == '"' {
+				quoted = false
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"':
+			quoted = true
+		case r == '#':
+			flush()
+			return words
+		case r == ';' || r == '{' || r == '}' || r == '[' || r == ']':
+			flush()
+			words = append(words, string(r))
+		case r == ' ' || r == '\t' || r == '\r':
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return words
+}
+
+// indexOf returns the index of the first occurrence of word, or -1
+func indexOf(words []string, word string) int {
+	for i, w := range words {
+		if w == word {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,160 @@
+package devconf
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const junosBraces = `
+/* edge router */
+policy-options {
+    prefix-list BOGONS {
+        10.0.0.0/8;
+        192.168.0.0/16;
+        2001:db8::/32;
+    }
+    prefix-list LOCAL {
+        apply-path "interfaces <*> unit <*> family inet address <*>";
+    }
+    inactive: prefix-list OLD {
+        203.0.113.0/24;
+    }
+}
+routing-options {
+    static {
+        route 0.0.0.0/0 next-hop 192.0.2.1;
+        route 10.9.0.0/16 {
+            next-hop [ 192.0.2.2 192.0.2.3 ];
+            preference 200;
+        }
+        route 10.66.0.0/16 discard;
+        inactive: route 10.77.0.0/16 next-hop 192.0.2.9;
+    }
+    rib inet6.0 {
+        static {
+            route ::/0 next-hop 2001:db8::1; # upstream
+        }
+    }
+}
+routing-instances {
+    CUST-A {
+        routing-options {
+            static {
+                route 172.16.0.0/12 {
+                    qualified-next-hop 198.51.100.1 {
+                        preference 10;
+                    }
+                }
+            }
+        }
+    }
+}
+`
+
+const junosSet = `# display set
+set policy-options prefix-list BOGONS 10.0.0.0/8
+set policy-options prefix-list BOGONS 192.168.0.0/16
+set policy-options prefix-list BOGONS 2001:db8::/32
+set policy-options prefix-list LOCAL apply-path "interfaces <*> unit <*> family inet address <*>"
+set routing-options static route 0.0.0.0/0 next-hop 192.0.2.1
+set routing-options static route 10.9.0.0/16 next-hop 192.0.2.2
+set routing-options static route 10.9.0.0/16 next-hop 192.0.2.3
+set routing-options static route 10.9.0.0/16 preference 200
+set routing-options static route 10.66.0.0/16 discard
+set routing-options rib inet6.0 static route ::/0 next-hop 2001:db8::1
+set routing-instances CUST-A routing-options static route 172.16.0.0/12 qualified-next-hop 198.51.100.1 preference 10
+`
+
+// summarize renders datasets compactly for comparison
+func summarize(datasets []Dataset) string {
+	var b strings.Builder
+	for _, d := range datasets {
+		fmt.Fprintf(&b, "%s %s:", d.Kind, d.Name)
+		for _, e := range d.Entries {
+			fmt.Fprintf(&b, " %s", e.Prefix)
+			if e.GE > 0 || e.LE > 0 {
+				fmt.Fprintf(&b, "[%d-%d]", e.GE, e.LE)
+			}
+			if len(e.NextHops) > 0 {
+				fmt.Fprintf(&b, "%v", e.NextHops)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestParseJunos(t *testing.T) {
+	want := `prefix-list BOGONS: 10.0.0.0/8 192.168.0.0/16 2001:db8::/32
+prefix-list LOCAL:
+static static: 0.0.0.0/0[192.0.2.1] 10.9.0.0/16[192.0.2.2 192.0.2.3] 10.66.0.0/16[discard] ::/0[2001:db8::1]
+static CUST-A/static: 172.16.0.0/12[198.51.100.1]
+`
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "curly braces", config: junosBraces},
+		{name: "display set", config: junosSet},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			datasets, err := ParseJunos(strings.NewReader(tt.config))
+			if err != nil {
+				t.Fatalf("ParseJunos returned error: %v", err)
+			}
+			if got := summarize(datasets); got != want {
+				t.Errorf("Expected\n%s\ngot\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestParseJunosErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "bad prefix", config: "policy-options { prefix-list X { 10.0.0.0/33; } }"},
+		{name: "unclosed block", config: "policy-options { prefix-list X { 10.0.0.0/8; }"},
+		{name: "extra brace", config: "policy-options { } }"},
+		{name: "bad route", config: "set routing-options static route bogus next-hop 192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJunos(strings.NewReader(tt.config)); err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestDatasetTrie(t *testing.T) {
+	datasets, err := ParseJunos(strings.NewReader(junosSet))
+	if err != nil {
+		t.Fatalf("ParseJunos returned error: %v", err)
+	}
+	static, err := datasets[2].Trie()
+	if err != nil {
+		t.Fatalf("Trie returned error: %v", err)
+	}
+
+	cidr, md, err := static.Find("10.9.1.1")
+	if err != nil || cidr != "10.9.0.0/16" {
+		t.Fatalf("Expected 10.9.0.0/16, got %q (%v)", cidr, err)
+	}
+	if md["dataset"] != "static" || md["kind"] != "static" || fmt.Sprint(md["next_hops"]) != "[192.0.2.2 192.0.2.3]" {
+		t.Errorf("Unexpected metadata %v", md)
+	}
+
+	// Diff what the router has against the intended list
+	deployed, _ := datasets[0].Trie()
+	intended, _ := datasets[0].Trie()
+	intended.Delete("192.168.0.0/16")
+	extra := deployed.Subtract(intended)
+	if fmt.Sprint(extra) != "[192.168.0.0/16]" {
+		t.Errorf("Expected 192.168.0.0/16 to be extra, got %v", extra)
+	}
+}
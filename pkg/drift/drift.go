@@ -0,0 +1,155 @@
+// Package drift compares intended allocations with the prefixes actually
+// configured on devices and reports the differences, on demand or on a
+// schedule.
+package drift
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Change is a prefix present on both sides whose compared metadata differs
+type Change struct {
+	CIDR     string                 `json:"cidr"`
+	Intended map[string]interface{} `json:"intended,omitempty"`
+	Actual   map[string]interface{} `json:"actual,omitempty"`
+	// Keys lists the compared metadata keys that differ
+	Keys []string `json:"keys"`
+}
+
+// Report is the difference between intent and reality
+type Report struct {
+	Generated time.Time `json:"generated"`
+	// Missing prefixes are intended but not configured
+	Missing []trie.Match `json:"missing"`
+	// Unexpected prefixes are configured but not intended
+	Unexpected []trie.Match `json:"unexpected"`
+	// Changed prefixes are on both sides with differing metadata
+	Changed []Change `json:"changed"`
+}
+
+// Clean reports whether intent and reality agree
+func (r Report) Clean() bool {
+	return len(r.Missing) == 0 && len(r.Unexpected) == 0 && len(r.Changed) == 0
+}
+
+// Compare matches the prefixes of intended and actual exactly and, for
+// prefixes on both sides, compares the metadata values under keys, such as
+// "next_hops". Values are compared by their printed form, so a []string
+// from a config parser equals a []interface{} decoded from JSON.
+func Compare(intended, actual *trie.IPTrie, keys ...string) Report {
+	r := Report{
+		Generated:  time.Now(),
+		Missing:    []trie.Match{},
+		Unexpected: []trie.Match{},
+		Changed:    []Change{},
+	}
+
+	want, _, _ := intended.Enumerate("", 0, "")
+	for _, m := range want {
+		got, ok := actual.FindExact(m.CIDR)
+		if !ok {
+			r.Missing = append(r.Missing, m)
+			continue
+		}
+		var differ []string
+		for _, k := range keys {
+			if fmt.Sprint(m.Metadata[k]) != fmt.Sprint(got[k]) {
+				differ = append(differ, k)
+			}
+		}
+		if len(differ) > 0 {
+			r.Changed = append(r.Changed, Change{CIDR: m.CIDR, Intended: m.Metadata, Actual: got, Keys: differ})
+		}
+	}
+
+	have, _, _ := actual.Enumerate("", 0, "")
+	for _, m := range have {
+		if _, ok := intended.FindExact(m.CIDR); !ok {
+			r.Unexpected = append(r.Unexpected, m)
+		}
+	}
+	return r
+}
+
+// Job builds and delivers a drift report on a schedule
+type Job struct {
+	// Interval between reports, one hour if zero
+	Interval time.Duration
+	// Intended and Actual load the two sides afresh for every report,
+	// e.g. from the source of truth and from parsed device configurations
+	Intended func() (*trie.IPTrie, error)
+	Actual   func() (*trie.IPTrie, error)
+	// Keys are the metadata keys compared for prefixes on both sides
+	Keys []string
+	// Deliver sends or stores a finished report
+	Deliver func(Report) error
+}
+
+// Report loads both sides and compares them once
+func (j Job) Report() (Report, error) {
+	intended, err := j.Intended()
+	if err != nil {
+		return Report{}, fmt.Errorf("load intended: %v", err)
+	}
+	actual, err := j.Actual()
+	if err != nil {
+		return Report{}, fmt.Errorf("load actual: %v", err)
+	}
+	return Compare(intended, actual, j.Keys...), nil
+}
+
+// Run builds and delivers a report immediately and then every Interval
+// until ctx is cancelled. Failures are passed to onError, which may be nil.
+func (j Job) Run(ctx context.Context, onError func(error)) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r, err := j.Report()
+		if err == nil {
+			err = j.Deliver(r)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Webhook returns a Deliver function that POSTs each report as JSON to url.
+// A nil client uses http.DefaultClient.
+func Webhook(url string, client *http.Client) func(Report) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(r Report) error {
+		body, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+	}
+}
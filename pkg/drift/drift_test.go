@@ -0,0 +1,98 @@
+package drift
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func newTrie(entries map[string]map[string]interface{}) *trie.IPTrie {
+	t := trie.NewIPTrie()
+	for cidr, md := range entries {
+		t.Insert(cidr, md)
+	}
+	return t
+}
+
+func TestCompare(t *testing.T) {
+	intended := newTrie(map[string]map[string]interface{}{
+		"0.0.0.0/0":    {"next_hops": []interface{}{"192.0.2.1"}},
+		"10.9.0.0/16":  {"next_hops": []interface{}{"192.0.2.2"}},
+		"10.10.0.0/16": {"next_hops": []interface{}{"192.0.2.3"}},
+	})
+	actual := newTrie(map[string]map[string]interface{}{
+		"0.0.0.0/0":    {"next_hops": []string{"192.0.2.1"}},
+		"10.9.0.0/16":  {"next_hops": []string{"192.0.2.9"}},
+		"10.66.0.0/16": {"next_hops": []string{"discard"}},
+	})
+
+	r := Compare(intended, actual, "next_hops")
+	if r.Clean() {
+		t.Fatalf("Expected drift")
+	}
+	if len(r.Missing) != 1 || r.Missing[0].CIDR != "10.10.0.0/16" || r.Missing[0].PrefixLen != 16 {
+		t.Errorf("Expected 10.10.0.0/16 missing, got %v", r.Missing)
+	}
+	if len(r.Unexpected) != 1 || r.Unexpected[0].CIDR != "10.66.0.0/16" {
+		t.Errorf("Expected 10.66.0.0/16 unexpected, got %v", r.Unexpected)
+	}
+	if len(r.Changed) != 1 || r.Changed[0].CIDR != "10.9.0.0/16" || fmt.Sprint(r.Changed[0].Keys) != "[next_hops]" {
+		t.Errorf("Expected 10.9.0.0/16 changed, got %v", r.Changed)
+	}
+
+	if !Compare(intended, intended.Clone(), "next_hops").Clean() {
+		t.Errorf("Expected identical tries to compare clean")
+	}
+}
+
+func TestJobRun(t *testing.T) {
+	var got Report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Invalid report body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	job := Job{
+		Interval: time.Hour,
+		Intended: func() (*trie.IPTrie, error) {
+			return newTrie(map[string]map[string]interface{}{"10.0.0.0/8": nil}), nil
+		},
+		Actual: func() (*trie.IPTrie, error) {
+			return trie.NewIPTrie(), nil
+		},
+		Deliver: Webhook(srv.URL, nil),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	job.Run(ctx, func(err error) { t.Errorf("Unexpected error: %v", err) })
+
+	if len(got.Missing) != 1 || got.Missing[0].CIDR != "10.0.0.0/8" {
+		t.Errorf("Expected the delivered report to list 10.0.0.0/8 missing, got %+v", got)
+	}
+
+	job.Actual = func() (*trie.IPTrie, error) { return nil, errors.New("device unreachable") }
+	if _, err := job.Report(); err == nil {
+		t.Errorf("Expected error when a side fails to load")
+	}
+}
+
+func TestWebhookStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	if err := Webhook(srv.URL, nil)(Report{}); err == nil {
+		t.Errorf("Expected error for a failed delivery")
+	}
+}
@@ -0,0 +1,389 @@
+// Package feed fetches remote prefix datasets over HTTP and hands them to
+// registered importers, so adding a new remote source is a matter of
+// configuration rather than code.
+package feed
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Importer consumes one complete, decompressed copy of a feed
+type Importer func(r io.Reader) error
+
+var (
+	importersMu sync.RWMutex
+	importers   = make(map[string]Importer)
+)
+
+// Register makes an importer available to sources by name. Registering the
+// same name twice replaces the earlier importer.
+func Register(name string, imp Importer) {
+	importersMu.Lock()
+	defer importersMu.Unlock()
+	importers[name] = imp
+}
+
+// lookupImporter returns the importer registered under name
+func lookupImporter(name string) (Importer, error) {
+	importersMu.RLock()
+	defer importersMu.RUnlock()
+	imp, ok := importers[name]
+	if !ok {
+		return nil, fmt.Errorf("no importer registered as %q", name)
+	}
+	return imp, nil
+}
+
+// Source describes one remote feed
+type Source struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Interval Duration `json:"interval"`
+	// Importer is the registered importer name the feed is handed to
+	Importer string `json:"importer"`
+	// SHA256 is the expected hex digest of the downloaded body, before
+	// decompression. Leave empty to skip verification.
+	SHA256 string `json:"sha256,omitempty"`
+	// Compression is "gzip", "bzip2", "none", or empty to detect it from
+	// the response
+	Compression string `json:"compression,omitempty"`
+	// Staleness decides what happens when refreshes keep failing
+	Staleness StalePolicy `json:"staleness,omitempty"`
+}
+
+// StaleAction is what a fetcher does once its data is older than MaxAge
+type StaleAction string
+
+const (
+	// KeepStale keeps serving the last good data and flags it as stale.
+	// This is the default.
+	KeepStale StaleAction = "keep"
+	// DropStale imports an empty feed, so the importer discards the data
+	DropStale StaleAction = "drop"
+	// FailReadiness keeps the data but makes Ready report an error, so
+	// orchestration can pull the instance out of rotation
+	FailReadiness StaleAction = "fail-readiness"
+)
+
+// StalePolicy bounds how old a feed's data may get. A zero MaxAge disables
+// the policy.
+type StalePolicy struct {
+	MaxAge Duration    `json:"max_age"`
+	Action StaleAction `json:"action,omitempty"`
+}
+
+// Duration is a time.Duration that reads and writes as a string like "1h"
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes a Go duration string such as "15m"
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration: %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %v", err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadSources reads a JSON array of sources
+func LoadSources(r io.Reader) ([]Source, error) {
+	var sources []Source
+	if err := json.NewDecoder(r).Decode(&sources); err != nil {
+		return nil, fmt.Errorf("invalid feed config: %v", err)
+	}
+	return sources, nil
+}
+
+// Fetcher polls one source with conditional requests and passes changed
+// content to the source's importer
+type Fetcher struct {
+	Source Source
+	Client *http.Client
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	started      time.Time
+	lastSuccess  time.Time
+	lastErr      error
+	dropped      bool
+}
+
+// NewFetcher returns a fetcher for src. A nil client uses
+// http.DefaultClient.
+func NewFetcher(src Source, client *http.Client) *Fetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Fetcher{Source: src, Client: client, started: time.Now()}
+}
+
+// Fetch downloads the source if it changed since the last successful fetch,
+// verifies it, and runs the importer over it. It reports whether new content
+// was imported; an unchanged feed is not an error.
+func (f *Fetcher) Fetch(ctx context.Context) (bool, error) {
+	updated, err := f.fetch(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastErr = err
+	if err == nil {
+		f.lastSuccess = time.Now()
+		if updated {
+			f.dropped = false
+		}
+	}
+	return updated, err
+}
+
+// fetch performs one conditional download and import
+func (f *Fetcher) fetch(ctx context.Context) (bool, error) {
+	imp, err := lookupImporter(f.Source.Importer)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.Source.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("feed %s: %v", f.Source.Name, err)
+	}
+	f.mu.Lock()
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	f.mu.Unlock()
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("feed %s: %v", f.Source.Name, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return false, nil
+	case resp.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("feed %s: unexpected status %s", f.Source.Name, resp.Status)
+	}
+
+	// Spool to disk so the checksum can be verified before anything is
+	// imported, without holding large feeds in memory
+	tmp, err := os.CreateTemp("", "trie-feed-*")
+	if err != nil {
+		return false, fmt.Errorf("feed %s: %v", f.Source.Name, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return false, fmt.Errorf("feed %s: download: %v", f.Source.Name, err)
+	}
+	if want := f.Source.SHA256; want != "" {
+		if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, want) {
+			return false, fmt.Errorf("feed %s: checksum mismatch: got %s, expected %s", f.Source.Name, got, want)
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("feed %s: %v", f.Source.Name, err)
+	}
+	body, err := decompress(bufio.NewReader(tmp), f.Source.Compression, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false, fmt.Errorf("feed %s: %v", f.Source.Name, err)
+	}
+	if err := imp(body); err != nil {
+		return false, fmt.Errorf("feed %s: import: %v", f.Source.Name, err)
+	}
+
+	f.mu.Lock()
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	f.mu.Unlock()
+	return true, nil
+}
+
+// decompress wraps r according to the configured compression, detecting it
+// from the content type or magic bytes when unset
+func decompress(r *bufio.Reader, compression, contentType string) (io.Reader, error) {
+	if compression == "" {
+		compression = "none"
+		magic, _ := r.Peek(3)
+		switch {
+		case strings.Contains(contentType, "gzip") || (len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b):
+			compression = "gzip"
+		case strings.Contains(contentType, "bzip2") || string(magic) == "BZh":
+			compression = "bzip2"
+		}
+	}
+
+	switch compression {
+	case "none":
+		return r, nil
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %v", err)
+		}
+		return zr, nil
+	case "bzip2":
+		return bzip2.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// LastSuccess returns when the feed was last fetched without error, whether
+// or not it had changed
+func (f *Fetcher) LastSuccess() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastSuccess
+}
+
+// LastError returns the error from the most recent fetch, or nil
+func (f *Fetcher) LastError() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastErr
+}
+
+// Staleness returns how long it has been since the last successful fetch,
+// or since the fetcher was created if no fetch has succeeded yet
+func (f *Fetcher) Staleness() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.staleness()
+}
+
+// staleness is Staleness without locking
+func (f *Fetcher) staleness() time.Duration {
+	if f.lastSuccess.IsZero() {
+		return time.Since(f.started)
+	}
+	return time.Since(f.lastSuccess)
+}
+
+// stale reports whether the data has outlived the policy's MaxAge
+func (f *Fetcher) stale() bool {
+	maxAge := time.Duration(f.Source.Staleness.MaxAge)
+	return maxAge > 0 && f.staleness() > maxAge
+}
+
+// Ready returns an error when the source uses FailReadiness and its data is
+// stale, for wiring into readiness probes
+func (f *Fetcher) Ready() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Source.Staleness.Action == FailReadiness && f.stale() {
+		return fmt.Errorf("feed %s: data is %s old, exceeding %s", f.Source.Name,
+			f.staleness().Round(time.Second), time.Duration(f.Source.Staleness.MaxAge))
+	}
+	return nil
+}
+
+// Enforce applies the DropStale action once the data is too old. It
+// returns an error only if the importer rejects the empty feed.
+func (f *Fetcher) Enforce() error {
+	f.mu.Lock()
+	drop := f.Source.Staleness.Action == DropStale && f.stale() && !f.dropped
+	f.mu.Unlock()
+	if !drop {
+		return nil
+	}
+
+	imp, err := lookupImporter(f.Source.Importer)
+	if err != nil {
+		return err
+	}
+	if err := imp(strings.NewReader("")); err != nil {
+		return fmt.Errorf("feed %s: drop stale data: %v", f.Source.Name, err)
+	}
+
+	f.mu.Lock()
+	f.dropped = true
+	// The next fetch must download the feed again: a 304 would leave the
+	// dropped data empty while counting as a fresh success
+	f.etag, f.lastModified = "", ""
+	f.mu.Unlock()
+	return nil
+}
+
+// Status is a point-in-time health summary of a fetcher, suitable for
+// exporting as metrics
+type Status struct {
+	Name        string        `json:"name"`
+	LastSuccess time.Time     `json:"last_success"`
+	Staleness   time.Duration `json:"staleness_ns"`
+	Stale       bool          `json:"stale"`
+	Dropped     bool          `json:"dropped"`
+	LastError   string        `json:"last_error,omitempty"`
+}
+
+// Status returns the fetcher's current health
+func (f *Fetcher) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st := Status{
+		Name:        f.Source.Name,
+		LastSuccess: f.lastSuccess,
+		Staleness:   f.staleness(),
+		Stale:       f.stale(),
+		Dropped:     f.dropped,
+	}
+	if f.lastErr != nil {
+		st.LastError = f.lastErr.Error()
+	}
+	return st
+}
+
+// Run fetches immediately and then on every Interval until ctx is done,
+// enforcing the staleness policy after each attempt. Errors are passed to
+// onError, which may be nil.
+func (f *Fetcher) Run(ctx context.Context, onError func(error)) {
+	interval := time.Duration(f.Source.Interval)
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := f.Fetch(ctx); err != nil && onError != nil {
+			onError(err)
+		}
+		if err := f.Enforce(); err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
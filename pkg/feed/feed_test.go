@@ -0,0 +1,266 @@
+package feed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestFetchConditional(t *testing.T) {
+	body := gzipped(t, "10.0.0.0/8\n192.168.0.0/16\n")
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	var imported string
+	Register("test-lines", func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		imported = string(data)
+		return err
+	})
+
+	sum := sha256.Sum256(body)
+	f := NewFetcher(Source{
+		Name:     "lines",
+		URL:      srv.URL,
+		Importer: "test-lines",
+		SHA256:   hex.EncodeToString(sum[:]),
+	}, srv.Client())
+
+	updated, err := f.Fetch(context.Background())
+	if err != nil || !updated {
+		t.Fatalf("Expected first fetch to import, got updated=%v err=%v", updated, err)
+	}
+	if imported != "10.0.0.0/8\n192.168.0.0/16\n" {
+		t.Errorf("Expected decompressed feed to reach the importer, got %q", imported)
+	}
+
+	imported = ""
+	updated, err = f.Fetch(context.Background())
+	if err != nil || updated {
+		t.Fatalf("Expected unchanged feed to be skipped, got updated=%v err=%v", updated, err)
+	}
+	if imported != "" || requests != 2 {
+		t.Errorf("Expected a conditional request and no import, got %d requests", requests)
+	}
+	if f.LastSuccess().IsZero() || f.LastError() != nil {
+		t.Errorf("Expected fetcher to record a successful fetch")
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("10.0.0.0/8\n"))
+	}))
+	defer srv.Close()
+
+	called := false
+	Register("test-errors", func(r io.Reader) error {
+		called = true
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		src     Source
+		wantErr string
+	}{
+		{
+			name:    "checksum mismatch",
+			src:     Source{Name: "bad-sum", URL: srv.URL, Importer: "test-errors", SHA256: strings.Repeat("0", 64)},
+			wantErr: "checksum mismatch",
+		},
+		{
+			name:    "http error",
+			src:     Source{Name: "missing", URL: srv.URL + "/missing", Importer: "test-errors"},
+			wantErr: "unexpected status",
+		},
+		{
+			name:    "unknown importer",
+			src:     Source{Name: "orphan", URL: srv.URL, Importer: "nope"},
+			wantErr: "no importer",
+		},
+		{
+			name:    "bad compression",
+			src:     Source{Name: "xz", URL: srv.URL, Importer: "test-errors", Compression: "xz"},
+			wantErr: "unsupported compression",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			f := NewFetcher(tt.src, srv.Client())
+			_, err := f.Fetch(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if called {
+				t.Errorf("Expected importer not to run")
+			}
+			if f.LastError() == nil {
+				t.Errorf("Expected LastError to record the failure")
+			}
+		})
+	}
+}
+
+func TestLoadSources(t *testing.T) {
+	config := `[{"name":"bogons","url":"https://example.com/bogons.txt.gz","interval":"6h","importer":"lines"}]`
+	sources, err := LoadSources(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("LoadSources returned error: %v", err)
+	}
+	if len(sources) != 1 || time.Duration(sources[0].Interval) != 6*time.Hour || sources[0].Importer != "lines" {
+		t.Errorf("Unexpected sources: %+v", sources)
+	}
+
+	if _, err := LoadSources(strings.NewReader(`[{"interval":"soon"}]`)); err == nil {
+		t.Errorf("Expected error for invalid interval")
+	}
+}
+
+func TestDropStaleRecovery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` || r.Header.Get("If-Modified-Since") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Write([]byte("10.0.0.0/8\n"))
+	}))
+	defer srv.Close()
+
+	var contents string
+	Register("test-recover", func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		contents = string(data)
+		return err
+	})
+	f := NewFetcher(Source{
+		Name:      "recover",
+		URL:       srv.URL,
+		Importer:  "test-recover",
+		Staleness: StalePolicy{MaxAge: Duration(20 * time.Millisecond), Action: DropStale},
+	}, srv.Client())
+
+	if _, err := f.Fetch(context.Background()); err != nil {
+		t.Fatalf("Initial fetch failed: %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := f.Enforce(); err != nil || !f.Status().Dropped || contents != "" {
+		t.Fatalf("Expected stale data to be dropped, got %+v, %q, %v", f.Status(), contents, err)
+	}
+
+	// The source never changed, but the dropped data must come back rather
+	// than being answered with 304 Not Modified
+	updated, err := f.Fetch(context.Background())
+	if err != nil || !updated {
+		t.Fatalf("Expected the fetch after a drop to import, got updated=%v err=%v", updated, err)
+	}
+	if st := f.Status(); st.Dropped || st.Stale || contents != "10.0.0.0/8\n" {
+		t.Errorf("Expected the feed to recover, got %+v with contents %q", st, contents)
+	}
+	if updated, err := f.Fetch(context.Background()); err != nil || updated {
+		t.Errorf("Expected conditional fetches to resume, got updated=%v err=%v", updated, err)
+	}
+}
+
+func TestStalePolicies(t *testing.T) {
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("10.0.0.0/8\n"))
+	}))
+	defer srv.Close()
+
+	var contents string
+	Register("test-stale", func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		contents = string(data)
+		return err
+	})
+
+	tests := []struct {
+		action      StaleAction
+		wantReady   bool
+		wantDropped bool
+	}{
+		{action: KeepStale, wantReady: true},
+		{action: DropStale, wantReady: true, wantDropped: true},
+		{action: FailReadiness, wantReady: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.action), func(t *testing.T) {
+			healthy = true
+			f := NewFetcher(Source{
+				Name:      "stale",
+				URL:       srv.URL,
+				Importer:  "test-stale",
+				Staleness: StalePolicy{MaxAge: Duration(20 * time.Millisecond), Action: tt.action},
+			}, srv.Client())
+
+			if _, err := f.Fetch(context.Background()); err != nil {
+				t.Fatalf("Initial fetch failed: %v", err)
+			}
+			if err := f.Enforce(); err != nil || f.Ready() != nil || f.Status().Stale {
+				t.Fatalf("Expected fresh data to be healthy")
+			}
+
+			healthy = false
+			time.Sleep(40 * time.Millisecond)
+			if _, err := f.Fetch(context.Background()); err == nil {
+				t.Fatalf("Expected refresh to fail")
+			}
+			if err := f.Enforce(); err != nil {
+				t.Fatalf("Enforce returned error: %v", err)
+			}
+
+			st := f.Status()
+			if !st.Stale || st.LastError == "" || st.Staleness < 20*time.Millisecond {
+				t.Errorf("Expected status to report stale data, got %+v", st)
+			}
+			if (f.Ready() == nil) != tt.wantReady {
+				t.Errorf("Expected ready=%v, got error %v", tt.wantReady, f.Ready())
+			}
+			if st.Dropped != tt.wantDropped || (contents == "") != tt.wantDropped {
+				t.Errorf("Expected dropped=%v, got %v with contents %q", tt.wantDropped, st.Dropped, contents)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Package geoip loads the MaxMind GeoLite2 and GeoIP2 CSV databases, Country
+// or City edition, into a trie, giving a pure Go geolocation lookup without
+// the binary MMDB reader.
+package geoip
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// location is the part of a locations file row kept as metadata
+type location map[string]interface{}
+
+// locationColumns maps locations file columns to metadata keys
+var locationColumns = map[string]string{
+	"continent_code":         "continent",
+	"country_iso_code":       "country",
+	"country_name":           "country_name",
+	"subdivision_1_iso_code": "subdivision",
+	"city_name":              "city",
+	"time_zone":              "time_zone",
+}
+
+// Load builds a trie from a locations file, such as
+// GeoLite2-City-Locations-en.csv, and any number of blocks files, such as
+// GeoLite2-City-Blocks-IPv4.csv and GeoLite2-City-Blocks-IPv6.csv. Each
+// network gets whichever of these its edition provides:
+//
+//	country, country_name, continent  from its location, or failing that
+//	                                  its registered country
+//	registered_country                where the network is registered
+//	subdivision, city, time_zone      City edition only
+//	postal_code, latitude, longitude,
+//	accuracy_radius                   City edition only
+//	anonymous_proxy, satellite        true when set
+func Load(locations io.Reader, blocks ...io.Reader) (*trie.IPTrie, error) {
+	locs, err := readLocations(locations)
+	if err != nil {
+		return nil, fmt.Errorf("locations: %v", err)
+	}
+
+	t := trie.NewIPTrie()
+	for i, b := range blocks {
+		if err := readBlocks(b, locs, t); err != nil {
+			return nil, fmt.Errorf("blocks file %d: %v", i+1, err)
+		}
+	}
+	return t, nil
+}
+
+// readLocations indexes a locations file by geoname_id
+func readLocations(r io.Reader) (map[string]location, error) {
+	rows, header, err := open(r, "geoname_id")
+	if err != nil {
+		return nil, err
+	}
+
+	locs := make(map[string]location)
+	for {
+		rec, err := rows.Read()
+		if err == io.EOF {
+			return locs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		loc := location{}
+		for col, key := range locationColumns {
+			if v := field(rec, header, col); v != "" {
+				loc[key] = v
+			}
+		}
+		locs[field(rec, header, "geoname_id")] = loc
+	}
+}
+
+// readBlocks inserts every network of a blocks file into t
+func readBlocks(r io.Reader, locs map[string]location, t *trie.IPTrie) error {
+	rows, header, err := open(r, "network")
+	if err != nil {
+		return err
+	}
+
+	for {
+		rec, err := rows.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := rows.FieldPos(0)
+
+		md := map[string]interface{}{}
+		geoname := field(rec, header, "geoname_id")
+		registered := field(rec, header, "registered_country_geoname_id")
+		if geoname == "" {
+			geoname = registered
+		}
+		for k, v := range locs[geoname] {
+			md[k] = v
+		}
+		if reg, ok := locs[registered]["country"]; ok {
+			md["registered_country"] = reg
+		}
+
+		if v := field(rec, header, "postal_code"); v != "" {
+			md["postal_code"] = v
+		}
+		for _, col := range []string{"latitude", "longitude"} {
+			if v := field(rec, header, col); v != "" {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return fmt.Errorf("line %d: invalid %s %q", line, col, v)
+				}
+				md[col] = f
+			}
+		}
+		if v := field(rec, header, "accuracy_radius"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid accuracy_radius %q", line, v)
+			}
+			md["accuracy_radius"] = n
+		}
+		if field(rec, header, "is_anonymous_proxy") == "1" {
+			md["anonymous_proxy"] = true
+		}
+		if field(rec, header, "is_satellite_provider") == "1" {
+			md["satellite"] = true
+		}
+
+		if err := t.Insert(field(rec, header, "network"), md); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+}
+
+// open starts reading a CSV file and indexes its header, which must have
+// the required column
+func open(r io.Reader, required string) (*csv.Reader, map[string]int, error) {
+	rows := csv.NewReader(r)
+	rows.FieldsPerRecord = -1
+	rows.ReuseRecord = true
+
+	names, err := rows.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read header: %v", err)
+	}
+	header := make(map[string]int, len(names))
+	for i, name := range names {
+		header[name] = i
+	}
+	if _, ok := header[required]; !ok {
+		return nil, nil, fmt.Errorf("missing %s column", required)
+	}
+	return rows, header, nil
+}
+
+// field returns the named column of rec, or "" if the file lacks it
+func field(rec []string, header map[string]int, name string) string {
+	i, ok := header[name]
+	if !ok || i >= len(rec) {
+		return ""
+	}
+	return rec[i]
+}
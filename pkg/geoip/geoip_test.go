@@ -0,0 +1,105 @@
+package geoip
+
+import (
+	"strings"
+	"testing"
+)
+
+const cityLocations = `geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,subdivision_1_iso_code,subdivision_1_name,subdivision_2_iso_code,subdivision_2_name,city_name,metro_code,time_zone,is_in_european_union
+2950159,en,EU,Europe,DE,Germany,BE,"Land Berlin",,,Berlin,,Europe/Berlin,1
+2921044,en,EU,Europe,DE,Germany,,,,,,,Europe/Berlin,1
+6252001,en,NA,"North America",US,"United States",,,,,,,America/Chicago,0
+`
+
+const cityBlocksV4 = `network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius,is_anycast
+5.10.0.0/16,2950159,2921044,,0,0,10115,52.5244,13.4105,50,
+5.11.0.0/16,,6252001,,1,0,,,,,
+`
+
+const cityBlocksV6 = `network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius,is_anycast
+2001:db8::/32,6252001,6252001,,0,0,,37.751,-97.822,1000,
+`
+
+func TestLoadCity(t *testing.T) {
+	tr, err := Load(strings.NewReader(cityLocations), strings.NewReader(cityBlocksV4), strings.NewReader(cityBlocksV6))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		cidr string
+		want map[string]interface{}
+	}{
+		{"5.10.1.1", "5.10.0.0/16", map[string]interface{}{
+			"country": "DE", "country_name": "Germany", "continent": "EU", "registered_country": "DE",
+			"subdivision": "BE", "city": "Berlin", "time_zone": "Europe/Berlin",
+			"postal_code": "10115", "latitude": 52.5244, "longitude": 13.4105, "accuracy_radius": 50,
+		}},
+		// Falls back to the registered country
+		{"5.11.1.1", "5.11.0.0/16", map[string]interface{}{
+			"country": "US", "country_name": "United States", "continent": "NA", "registered_country": "US",
+			"time_zone": "America/Chicago", "anonymous_proxy": true,
+		}},
+		{"2001:db8::1", "2001:db8::/32", map[string]interface{}{
+			"country": "US", "country_name": "United States", "continent": "NA", "registered_country": "US",
+			"time_zone": "America/Chicago", "latitude": 37.751, "longitude": -97.822, "accuracy_radius": 1000,
+		}},
+	}
+	for _, tt := range tests {
+		cidr, md, err := tr.Find(tt.ip)
+		if err != nil || cidr != tt.cidr {
+			t.Errorf("Find(%s) = %s, %v", tt.ip, cidr, err)
+			continue
+		}
+		if len(md) != len(tt.want) {
+			t.Errorf("Find(%s) metadata %v, expected %v", tt.ip, md, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if md[k] != v {
+				t.Errorf("Find(%s)[%s] = %v, expected %v", tt.ip, k, md[k], v)
+			}
+		}
+	}
+}
+
+func TestLoadCountry(t *testing.T) {
+	locations := `geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,is_in_european_union
+2921044,en,EU,Europe,DE,Germany,1
+`
+	blocks := `network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider
+5.10.0.0/16,2921044,2921044,,0,1
+`
+	tr, err := Load(strings.NewReader(locations), strings.NewReader(blocks))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	_, md, err := tr.Find("5.10.0.1")
+	if err != nil || md["country"] != "DE" || md["satellite"] != true || md["city"] != nil {
+		t.Errorf("Unexpected metadata %v (%v)", md, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	header := "network,geoname_id,registered_country_geoname_id,latitude\n"
+	tests := []struct {
+		name      string
+		locations string
+		blocks    string
+		want      string
+	}{
+		{"empty locations", "", "", "locations: read header: EOF"},
+		{"no network column", "geoname_id\n", "foo,bar\n", "blocks file 1: missing network column"},
+		{"bad network", "geoname_id\n", header + "5.10.0.0/33,,,\n", "blocks file 1: line 2: invalid CIDR"},
+		{"bad latitude", "geoname_id\n", header + "5.10.0.0/16,,,north\n", `blocks file 1: line 2: invalid latitude "north"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(tt.locations), strings.NewReader(tt.blocks))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("Expected error %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
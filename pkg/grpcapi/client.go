@@ -0,0 +1,85 @@
+package grpcapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Client calls the TrieNetwork service
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient returns a Client using cc
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc: cc}
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, res interface{}, opts []grpc.CallOption) error {
+	opts = append([]grpc.CallOption{grpc.ForceCodec(Codec{})}, opts...)
+	return c.cc.Invoke(ctx, "/"+ServiceName+"/"+method, req, res, opts...)
+}
+
+// Lookup returns the most specific prefix containing ip
+func (c *Client) Lookup(ctx context.Context, ip string, opts ...grpc.CallOption) (*LookupResponse, error) {
+	res := new(LookupResponse)
+	return res, c.invoke(ctx, "Lookup", &LookupRequest{IP: ip}, res, opts)
+}
+
+// LookupAll returns every prefix containing ip, most specific first
+func (c *Client) LookupAll(ctx context.Context, ip string, opts ...grpc.CallOption) (*LookupAllResponse, error) {
+	res := new(LookupAllResponse)
+	return res, c.invoke(ctx, "LookupAll", &LookupRequest{IP: ip}, res, opts)
+}
+
+// Insert stores cidr with md and reports whether it was new
+func (c *Client) Insert(ctx context.Context, cidr string, md map[string]interface{}, opts ...grpc.CallOption) (bool, error) {
+	res := new(InsertResponse)
+	err := c.invoke(ctx, "Insert", &InsertRequest{CIDR: cidr, Metadata: md}, res, opts)
+	return res.Created, err
+}
+
+// Delete removes cidr and reports whether it was stored
+func (c *Client) Delete(ctx context.Context, cidr string, opts ...grpc.CallOption) (bool, error) {
+	res := new(DeleteResponse)
+	err := c.invoke(ctx, "Delete", &DeleteRequest{CIDR: cidr}, res, opts)
+	return res.Deleted, err
+}
+
+// BulkStream is an open BulkLookup stream. Send and Recv may be called
+// from different goroutines to keep batches in flight.
+type BulkStream struct {
+	stream grpc.ClientStream
+}
+
+// BulkLookup opens a BulkLookup stream
+func (c *Client) BulkLookup(ctx context.Context, opts ...grpc.CallOption) (*BulkStream, error) {
+	opts = append([]grpc.CallOption{grpc.ForceCodec(Codec{})}, opts...)
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/BulkLookup", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &BulkStream{stream: stream}, nil
+}
+
+// Send sends a batch of addresses
+func (s *BulkStream) Send(ips []string) error {
+	return s.stream.SendMsg(&LookupBatch{IPs: ips})
+}
+
+// Recv returns the results of the next batch, in the order it was sent.
+// It returns io.EOF once CloseSend has been called and every batch has
+// been answered.
+func (s *BulkStream) Recv() ([]LookupResponse, error) {
+	var res LookupBatchResponse
+	if err := s.stream.RecvMsg(&res); err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+// CloseSend tells the server no more batches follow
+func (s *BulkStream) CloseSend() error {
+	return s.stream.CloseSend()
+}
@@ -0,0 +1,259 @@
+// Package grpcapi serves a trie over gRPC, as defined in trienetwork.proto,
+// so flow collectors and other services can enrich addresses at high rates
+// over long-lived streams.
+//
+// The messages are plain Go structs that encode themselves in the protobuf
+// wire format, so the server needs its codec installed:
+//
+//	s := grpc.NewServer(grpc.ForceServerCodec(grpcapi.Codec{}))
+//	grpcapi.Register(s, grpcapi.NewServer(holder, grpcapi.Config{}))
+//
+// The codec falls back to the standard protobuf encoding for any other
+// message type, so other services can share the server. Clients generated
+// from trienetwork.proto in any language need nothing special; Go clients
+// can use NewClient.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// ServiceName is the full name of the gRPC service
+const ServiceName = "trienetwork.v1.TrieNetwork"
+
+// maxBatch bounds the addresses accepted in one LookupBatch
+const maxBatch = 10000
+
+// Codec is the gRPC codec for this package's messages. It registers as
+// "proto", since the bytes it produces are ordinary protobuf.
+type Codec struct{}
+
+// Name implements encoding.Codec
+func (Codec) Name() string {
+	return "proto"
+}
+
+// Marshal implements encoding.Codec
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case message:
+		return m.appendWire(nil)
+	case proto.Message:
+		return proto.Marshal(m)
+	}
+	return nil, fmt.Errorf("grpcapi: cannot marshal %T", v)
+}
+
+// Unmarshal implements encoding.Codec
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case message:
+		return m.readWire(data)
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	}
+	return fmt.Errorf("grpcapi: cannot unmarshal into %T", v)
+}
+
+// Config controls the service
+type Config struct {
+	// ReadOnly rejects Insert and Delete with codes.PermissionDenied
+	ReadOnly bool
+}
+
+// Server implements the TrieNetwork service on the trie held by a
+// TrieHolder. Like the REST server, writes are serialized and swap in a
+// modified copy, so lookups never wait on them.
+type Server struct {
+	holder *trie.TrieHolder
+	cfg    Config
+	mu     sync.Mutex // serializes writes
+}
+
+// NewServer returns a Server for the trie in holder
+func NewServer(holder *trie.TrieHolder, cfg Config) *Server {
+	return &Server{holder: holder, cfg: cfg}
+}
+
+// Lookup returns the most specific prefix containing an IP. Invalid
+// addresses fail with codes.InvalidArgument.
+func (s *Server) Lookup(ctx context.Context, req *LookupRequest) (*LookupResponse, error) {
+	res := lookup(s.holder.Load(), req.IP)
+	if res.Error != "" {
+		return nil, status.Error(codes.InvalidArgument, res.Error)
+	}
+	return &res, nil
+}
+
+// LookupAll returns every prefix containing an IP, most specific first
+func (s *Server) LookupAll(ctx context.Context, req *LookupRequest) (*LookupAllResponse, error) {
+	matches, err := s.holder.Load().FindAll(req.IP)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	res := &LookupAllResponse{IP: req.IP, Matches: make([]Prefix, 0, len(matches))}
+	for _, m := range matches {
+		res.Matches = append(res.Matches, prefixOf(m))
+	}
+	return res, nil
+}
+
+// Insert stores a prefix
+func (s *Server) Insert(ctx context.Context, req *InsertRequest) (*InsertResponse, error) {
+	if s.cfg.ReadOnly {
+		return nil, status.Error(codes.PermissionDenied, "server is read-only")
+	}
+	if err := checkCIDR(req.CIDR); err != nil {
+		return nil, err
+	}
+	md := req.Metadata
+	if md == nil {
+		md = map[string]interface{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.holder.Load().Clone()
+	_, existed := next.FindExact(req.CIDR)
+	if err := next.Insert(req.CIDR, md); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.holder.Store(next)
+	return &InsertResponse{Created: !existed}, nil
+}
+
+// Delete removes a prefix. Deleting one that is not stored is not an
+// error; the response says whether anything was removed.
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	if s.cfg.ReadOnly {
+		return nil, status.Error(codes.PermissionDenied, "server is read-only")
+	}
+	if err := checkCIDR(req.CIDR); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.holder.Load().FindExact(req.CIDR); !found {
+		return &DeleteResponse{}, nil
+	}
+	next := s.holder.Load().Clone()
+	if _, _, err := next.Remove(req.CIDR); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	s.holder.Store(next)
+	return &DeleteResponse{Deleted: true}, nil
+}
+
+// BulkLookup answers every batch on the stream until the client closes
+// it. Each batch is looked up against one trie, so its results are
+// consistent even while the trie is being replaced; invalid addresses get
+// an Error in their result rather than failing the stream.
+func (s *Server) BulkLookup(stream grpc.ServerStream) error {
+	for {
+		var batch LookupBatch
+		if err := stream.RecvMsg(&batch); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(batch.IPs) > maxBatch {
+			return status.Errorf(codes.InvalidArgument, "batch of %d addresses, at most %d allowed", len(batch.IPs), maxBatch)
+		}
+
+		t := s.holder.Load()
+		res := LookupBatchResponse{Results: make([]LookupResponse, len(batch.IPs))}
+		for i, ip := range batch.IPs {
+			res.Results[i] = lookup(t, ip)
+		}
+		if err := stream.SendMsg(&res); err != nil {
+			return err
+		}
+	}
+}
+
+// checkCIDR rejects what the REST API rejects: prefixes that do not parse,
+// including zoned ones, and IPv4-mapped IPv6 prefixes, whose family is
+// ambiguous
+func checkCIDR(cidr string) error {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil || p.Addr().Is4In6() {
+		return status.Errorf(codes.InvalidArgument, "invalid CIDR %q", cidr)
+	}
+	return nil
+}
+
+// lookup finds the most specific match for ip
+func lookup(t *trie.IPTrie, ip string) LookupResponse {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		return LookupResponse{IP: ip, Error: "invalid IP address"}
+	}
+	m, found := t.FindMatch(addr)
+	if !found {
+		return LookupResponse{IP: ip}
+	}
+	p := prefixOf(m)
+	return LookupResponse{IP: ip, Found: true, Match: &p}
+}
+
+func prefixOf(m trie.Match) Prefix {
+	return Prefix{CIDR: m.CIDR, PrefixLen: int32(m.PrefixLen), Metadata: m.Metadata, Host: m.Host}
+}
+
+// Register adds the TrieNetwork service to a gRPC server, which must use
+// Codec
+func Register(s *grpc.Server, srv *Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Lookup", Handler: unaryHandler("Lookup", (*Server).Lookup)},
+		{MethodName: "LookupAll", Handler: unaryHandler("LookupAll", (*Server).LookupAll)},
+		{MethodName: "Insert", Handler: unaryHandler("Insert", (*Server).Insert)},
+		{MethodName: "Delete", Handler: unaryHandler("Delete", (*Server).Delete)},
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "BulkLookup",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			return srv.(*Server).BulkLookup(stream)
+		},
+	}},
+	Metadata: "trienetwork.proto",
+}
+
+// unaryHandler adapts a Server method to grpc.MethodDesc, running it
+// through the server's interceptors
+func unaryHandler[Req any, Res any](name string, fn func(*Server, context.Context, *Req) (*Res, error)) grpc.MethodHandler {
+	fullMethod := "/" + ServiceName + "/" + name
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := new(Req)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return fn(srv.(*Server), ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+		return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return fn(srv.(*Server), ctx, req.(*Req))
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func testHolder(t *testing.T) *trie.TrieHolder {
+	holder := trie.NewTrieHolder()
+	err := holder.ReplaceAll([]trie.Match{
+		{CIDR: "10.0.0.0/8", Metadata: map[string]interface{}{"owner": "netops"}},
+		{CIDR: "10.1.0.0/16", Metadata: map[string]interface{}{"owner": "dev", "vlan": 10, "tags": []string{"a", "b"}}},
+		{CIDR: "2001:db8::/32", Metadata: map[string]interface{}{"owner": "v6"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return holder
+}
+
+// dial starts a server for holder on an in-memory listener
+func dial(t *testing.T, holder *trie.TrieHolder, cfg Config) *Client {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.ForceServerCodec(Codec{}))
+	Register(s, NewServer(holder, cfg))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return NewClient(cc)
+}
+
+func TestLookup(t *testing.T) {
+	c := dial(t, testHolder(t), Config{})
+	ctx := context.Background()
+
+	res, err := c.Lookup(ctx, "10.1.2.3")
+	if err != nil {
+		t.Fatalf("Lookup returned error: %v", err)
+	}
+	if !res.Found || res.Match.CIDR != "10.1.0.0/16" || res.Match.PrefixLen != 16 {
+		t.Fatalf("Unexpected result %+v", res)
+	}
+	md := res.Match.Metadata
+	if md["owner"] != "dev" || md["vlan"] != float64(10) || len(md["tags"].([]interface{})) != 2 {
+		t.Errorf("Unexpected metadata %v", md)
+	}
+
+	if res, err := c.Lookup(ctx, "192.0.2.1"); err != nil || res.Found || res.Match != nil {
+		t.Errorf("Expected no match, got %+v, %v", res, err)
+	}
+	if _, err := c.Lookup(ctx, "bogus"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+
+	all, err := c.LookupAll(ctx, "10.1.2.3")
+	if err != nil || len(all.Matches) != 2 || all.Matches[0].CIDR != "10.1.0.0/16" || all.Matches[1].CIDR != "10.0.0.0/8" {
+		t.Errorf("Unexpected LookupAll result %+v, %v", all, err)
+	}
+}
+
+func TestLookupMatchOrder(t *testing.T) {
+	holder := trie.NewTrieHolder(trie.WithMatchOrder(trie.LeastSpecificFirst))
+	if err := holder.ReplaceAll([]trie.Match{{CIDR: "10.0.0.0/8"}, {CIDR: "10.1.0.0/16"}}); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, holder, Config{})
+
+	// Lookup answers with the longest match, LookupAll follows the trie's order
+	res, err := c.Lookup(context.Background(), "10.1.2.3")
+	if err != nil || !res.Found || res.Match.CIDR != "10.1.0.0/16" {
+		t.Errorf("Expected 10.1.0.0/16, got %+v, %v", res, err)
+	}
+	all, err := c.LookupAll(context.Background(), "10.1.2.3")
+	if err != nil || len(all.Matches) != 2 || all.Matches[0].CIDR != "10.0.0.0/8" {
+		t.Errorf("Unexpected LookupAll result %+v, %v", all, err)
+	}
+}
+
+func TestInsertDelete(t *testing.T) {
+	holder := testHolder(t)
+	c := dial(t, holder, Config{})
+	ctx := context.Background()
+
+	created, err := c.Insert(ctx, "192.0.2.0/24", map[string]interface{}{"owner": "docs"})
+	if err != nil || !created {
+		t.Fatalf("Expected a new prefix, got %v, %v", created, err)
+	}
+	if created, err := c.Insert(ctx, "192.0.2.0/24", nil); err != nil || created {
+		t.Errorf("Expected replacing a prefix, got %v, %v", created, err)
+	}
+	for _, cidr := range []string{"192.0.2.0/33", "::ffff:0.0.0.0/100", "fe80::%eth0/64"} {
+		if _, err := c.Insert(ctx, cidr, nil); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument for %s, got %v", cidr, err)
+		}
+	}
+	if cidr, _, _ := holder.Load().Find("192.0.2.1"); cidr != "192.0.2.0/24" {
+		t.Errorf("Expected the holder to see the insert, got %q", cidr)
+	}
+
+	if deleted, err := c.Delete(ctx, "192.0.2.0/24"); err != nil || !deleted {
+		t.Errorf("Expected delete, got %v, %v", deleted, err)
+	}
+	if deleted, err := c.Delete(ctx, "192.0.2.0/24"); err != nil || deleted {
+		t.Errorf("Expected nothing to delete, got %v, %v", deleted, err)
+	}
+	for _, cidr := range []string{"bogus", "::ffff:10.0.0.0/104"} {
+		if _, err := c.Delete(ctx, cidr); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument for %s, got %v", cidr, err)
+		}
+	}
+
+	ro := dial(t, holder, Config{ReadOnly: true})
+	if _, err := ro.Insert(ctx, "192.0.2.0/24", nil); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied, got %v", err)
+	}
+	if _, err := ro.Delete(ctx, "10.0.0.0/8"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied, got %v", err)
+	}
+}
+
+func TestBulkLookup(t *testing.T) {
+	c := dial(t, testHolder(t), Config{})
+	stream, err := c.BulkLookup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const batches = 20
+	go func() {
+		for i := 0; i < batches; i++ {
+			ips := make([]string, 0, 500)
+			for j := 0; j < 500; j++ {
+				ips = append(ips, fmt.Sprintf("10.%d.%d.%d", j%3, i, j%256))
+			}
+			ips[0] = "bogus"
+			stream.Send(ips)
+		}
+		stream.CloseSend()
+	}()
+
+	got := 0
+	for {
+		results, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Recv returned error: %v", err)
+		}
+		if len(results) != 500 {
+			t.Fatalf("Expected 500 results, got %d", len(results))
+		}
+		if results[0].Error == "" || results[0].Found {
+			t.Errorf("Expected an error for an invalid address, got %+v", results[0])
+		}
+		for _, r := range results[1:] {
+			want := "10.0.0.0/8"
+			if r.IP[:5] == "10.1." {
+				want = "10.1.0.0/16"
+			}
+			if !r.Found || r.Match.CIDR != want {
+				t.Fatalf("%s: expected %s, got %+v", r.IP, want, r)
+			}
+		}
+		got++
+	}
+	if got != batches {
+		t.Errorf("Expected %d batches, got %d", batches, got)
+	}
+}
+
+// TestWireFormat decodes a message by hand to check it follows the field
+// numbers of trienetwork.proto
+func TestWireFormat(t *testing.T) {
+	res := LookupResponse{IP: "10.1.2.3", Found: true, Match: &Prefix{
+		CIDR: "10.1.2.3/32", PrefixLen: 32, Host: true, Metadata: map[string]interface{}{"owner": "dev"},
+	}}
+	data, err := Codec{}.Marshal(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ip, cidr string
+	var found, host bool
+	var plen int32
+	var md *structpb.Struct
+	err = readFields(data, func(num protowire.Number, f field) error {
+		switch num {
+		case 1:
+			return f.string(&ip)
+		case 2:
+			return f.bool(&found)
+		case 3:
+			return readFields(f.bytes, func(num protowire.Number, f field) error {
+				switch num {
+				case 1:
+					return f.string(&cidr)
+				case 2:
+					return f.int32(&plen)
+				case 3:
+					md = &structpb.Struct{}
+					return Codec{}.Unmarshal(f.bytes, md)
+				case 4:
+					return f.bool(&host)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "10.1.2.3" || !found || cidr != "10.1.2.3/32" || plen != 32 || !host || md.Fields["owner"].GetStringValue() != "dev" {
+		t.Errorf("Unexpected fields %q %v %q %d %v %v", ip, found, cidr, plen, host, md)
+	}
+
+	// Unknown fields are skipped and wrong wire types rejected
+	var req LookupRequest
+	if err := req.readWire([]byte{0x10, 0x01, 0x0a, 0x01, 'x'}); err != nil || req.IP != "x" {
+		t.Errorf("Expected unknown field to be skipped, got %+v, %v", req, err)
+	}
+	if err := req.readWire([]byte{0x08, 0x01}); err == nil {
+		t.Errorf("Expected error for a varint in a string field")
+	}
+	if err := req.readWire([]byte{0x0a, 0x05, 'x'}); err == nil {
+		t.Errorf("Expected error for a truncated message")
+	}
+}
+
+// TestWireFormatReference checks every message against the encoding
+// protobuf itself derives from trienetwork.proto, in both directions
+func TestWireFormatReference(t *testing.T) {
+	compiler := protocompile.Compiler{Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{})}
+	files, err := compiler.Compile(context.Background(), "trienetwork.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := files[0].Messages()
+
+	md := map[string]interface{}{"owner": "dev", "vlan": 10.0, "tags": []interface{}{"a", "b"}, "nested": map[string]interface{}{"ok": true}}
+	prefix := Prefix{CIDR: "10.1.0.0/16", PrefixLen: 16, Metadata: md}
+	prefixJSON := `{"cidr": "10.1.0.0/16", "prefixLen": 16, "metadata": {"owner": "dev", "vlan": 10, "tags": ["a", "b"], "nested": {"ok": true}}}`
+	tests := []struct {
+		name  string
+		msg   message
+		empty func() message
+		json  string
+	}{
+		{"LookupRequest", &LookupRequest{IP: "10.1.2.3"}, func() message { return &LookupRequest{} }, `{"ip": "10.1.2.3"}`},
+		{"LookupRequest", &LookupRequest{}, func() message { return &LookupRequest{} }, `{}`},
+		{"Prefix", &prefix, func() message { return &Prefix{} }, prefixJSON},
+		{"Prefix", &Prefix{CIDR: "2001:db8::1/128", PrefixLen: 128, Host: true, Metadata: map[string]interface{}{}},
+			func() message { return &Prefix{} }, `{"cidr": "2001:db8::1/128", "prefixLen": 128, "host": true, "metadata": {}}`},
+		{"LookupResponse", &LookupResponse{IP: "10.1.2.3", Found: true, Match: &prefix},
+			func() message { return &LookupResponse{} }, `{"ip": "10.1.2.3", "found": true, "match": ` + prefixJSON + `}`},
+		{"LookupResponse", &LookupResponse{IP: "bogus", Error: "invalid IP"},
+			func() message { return &LookupResponse{} }, `{"ip": "bogus", "error": "invalid IP"}`},
+		{"LookupAllResponse", &LookupAllResponse{IP: "10.1.2.3", Matches: []Prefix{prefix, {CIDR: "10.0.0.0/8", PrefixLen: 8}}},
+			func() message { return &LookupAllResponse{} }, `{"ip": "10.1.2.3", "matches": [` + prefixJSON + `, {"cidr": "10.0.0.0/8", "prefixLen": 8}]}`},
+		{"InsertRequest", &InsertRequest{CIDR: "10.1.0.0/16", Metadata: md},
+			func() message { return &InsertRequest{} }, `{"cidr": "10.1.0.0/16", "metadata": {"owner": "dev", "vlan": 10, "tags": ["a", "b"], "nested": {"ok": true}}}`},
+		{"InsertResponse", &InsertResponse{Created: true}, func() message { return &InsertResponse{} }, `{"created": true}`},
+		{"DeleteRequest", &DeleteRequest{CIDR: "10.1.0.0/16"}, func() message { return &DeleteRequest{} }, `{"cidr": "10.1.0.0/16"}`},
+		{"DeleteResponse", &DeleteResponse{Deleted: true}, func() message { return &DeleteResponse{} }, `{"deleted": true}`},
+		{"LookupBatch", &LookupBatch{IPs: []string{"10.1.2.3", "", "2001:db8::1"}},
+			func() message { return &LookupBatch{} }, `{"ips": ["10.1.2.3", "", "2001:db8::1"]}`},
+		{"LookupBatchResponse", &LookupBatchResponse{Results: []LookupResponse{{IP: "10.1.2.3", Found: true, Match: &prefix}, {IP: "11.0.0.1"}}},
+			func() message { return &LookupBatchResponse{} }, `{"results": [{"ip": "10.1.2.3", "found": true, "match": ` + prefixJSON + `}, {"ip": "11.0.0.1"}]}`},
+	}
+	for _, tt := range tests {
+		desc := messages.ByName(protoreflect.Name(tt.name))
+		if desc == nil {
+			t.Fatalf("%s is not in trienetwork.proto", tt.name)
+		}
+		want := dynamicpb.NewMessage(desc)
+		if err := protojson.Unmarshal([]byte(tt.json), want); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		// What we encode decodes to the reference message
+		data, err := tt.msg.appendWire(nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := dynamicpb.NewMessage(desc)
+		if err := proto.Unmarshal(data, got); err != nil {
+			t.Errorf("%s: reference decoding failed: %v", tt.name, err)
+		} else if !proto.Equal(got, want) {
+			t.Errorf("%s: expected %v, reference decoded %v", tt.name, want, got)
+		}
+
+		// and the reference encoding decodes to our message
+		data, err = proto.Marshal(want)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		decoded := tt.empty()
+		if err := decoded.readWire(data); err != nil {
+			t.Errorf("%s: decoding the reference failed: %v", tt.name, err)
+		} else if !reflect.DeepEqual(decoded, tt.msg) {
+			t.Errorf("%s: expected %+v, decoded %+v", tt.name, tt.msg, decoded)
+		}
+	}
+}
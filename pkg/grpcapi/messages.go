@@ -0,0 +1,422 @@
+package grpcapi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The messages of trienetwork.proto. Each encodes itself in the protobuf
+// wire format, so they interoperate with generated clients without this
+// package depending on generated code.
+
+// LookupRequest asks for the prefixes containing IP
+type LookupRequest struct {
+	IP string
+}
+
+// Prefix is a stored prefix and its metadata
+type Prefix struct {
+	CIDR      string
+	PrefixLen int32
+	Metadata  map[string]interface{}
+	// Host is set for /32 and /128 host routes
+	Host bool
+}
+
+// LookupResponse is the most specific match for IP, if Found. Error is set
+// instead for addresses that cannot be parsed.
+type LookupResponse struct {
+	IP    string
+	Found bool
+	Match *Prefix
+	Error string
+}
+
+// LookupAllResponse lists every prefix containing IP, most specific first
+type LookupAllResponse struct {
+	IP      string
+	Matches []Prefix
+}
+
+// InsertRequest stores CIDR with Metadata
+type InsertRequest struct {
+	CIDR     string
+	Metadata map[string]interface{}
+}
+
+// InsertResponse reports whether Insert added a new prefix or replaced one
+type InsertResponse struct {
+	Created bool
+}
+
+// DeleteRequest removes CIDR
+type DeleteRequest struct {
+	CIDR string
+}
+
+// DeleteResponse reports whether the prefix was stored
+type DeleteResponse struct {
+	Deleted bool
+}
+
+// LookupBatch is one batch of addresses sent on a BulkLookup stream
+type LookupBatch struct {
+	IPs []string
+}
+
+// LookupBatchResponse holds the results of one LookupBatch, in order
+type LookupBatchResponse struct {
+	Results []LookupResponse
+}
+
+// message is implemented by every message type
+type message interface {
+	appendWire(b []byte) ([]byte, error)
+	readWire(b []byte) error
+}
+
+func (m *LookupRequest) appendWire(b []byte) ([]byte, error) {
+	return appendString(b, 1, m.IP), nil
+}
+
+func (m *LookupRequest) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		if num == 1 {
+			return f.string(&m.IP)
+		}
+		return nil
+	})
+}
+
+func (m *Prefix) appendWire(b []byte) ([]byte, error) {
+	b = appendString(b, 1, m.CIDR)
+	if m.PrefixLen != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(m.PrefixLen))
+	}
+	b, err := appendMetadata(b, 3, m.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return appendBool(b, 4, m.Host), nil
+}
+
+func (m *Prefix) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		switch num {
+		case 1:
+			return f.string(&m.CIDR)
+		case 2:
+			return f.int32(&m.PrefixLen)
+		case 3:
+			return f.metadata(&m.Metadata)
+		case 4:
+			return f.bool(&m.Host)
+		}
+		return nil
+	})
+}
+
+func (m *LookupResponse) appendWire(b []byte) ([]byte, error) {
+	b = appendString(b, 1, m.IP)
+	b = appendBool(b, 2, m.Found)
+	if m.Match != nil {
+		var err error
+		if b, err = appendMessage(b, 3, m.Match); err != nil {
+			return nil, err
+		}
+	}
+	return appendString(b, 4, m.Error), nil
+}
+
+func (m *LookupResponse) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		switch num {
+		case 1:
+			return f.string(&m.IP)
+		case 2:
+			return f.bool(&m.Found)
+		case 3:
+			m.Match = &Prefix{}
+			return f.message(m.Match)
+		case 4:
+			return f.string(&m.Error)
+		}
+		return nil
+	})
+}
+
+func (m *LookupAllResponse) appendWire(b []byte) ([]byte, error) {
+	b = appendString(b, 1, m.IP)
+	for i := range m.Matches {
+		var err error
+		if b, err = appendMessage(b, 2, &m.Matches[i]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (m *LookupAllResponse) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		switch num {
+		case 1:
+			return f.string(&m.IP)
+		case 2:
+			var p Prefix
+			if err := f.message(&p); err != nil {
+				return err
+			}
+			m.Matches = append(m.Matches, p)
+		}
+		return nil
+	})
+}
+
+func (m *InsertRequest) appendWire(b []byte) ([]byte, error) {
+	return appendMetadata(appendString(b, 1, m.CIDR), 2, m.Metadata)
+}
+
+func (m *InsertRequest) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		switch num {
+		case 1:
+			return f.string(&m.CIDR)
+		case 2:
+			return f.metadata(&m.Metadata)
+		}
+		return nil
+	})
+}
+
+func (m *InsertResponse) appendWire(b []byte) ([]byte, error) {
+	return appendBool(b, 1, m.Created), nil
+}
+
+func (m *InsertResponse) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		if num == 1 {
+			return f.bool(&m.Created)
+		}
+		return nil
+	})
+}
+
+func (m *DeleteRequest) appendWire(b []byte) ([]byte, error) {
+	return appendString(b, 1, m.CIDR), nil
+}
+
+func (m *DeleteRequest) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		if num == 1 {
+			return f.string(&m.CIDR)
+		}
+		return nil
+	})
+}
+
+func (m *DeleteResponse) appendWire(b []byte) ([]byte, error) {
+	return appendBool(b, 1, m.Deleted), nil
+}
+
+func (m *DeleteResponse) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		if num == 1 {
+			return f.bool(&m.Deleted)
+		}
+		return nil
+	})
+}
+
+func (m *LookupBatch) appendWire(b []byte) ([]byte, error) {
+	for _, ip := range m.IPs {
+		// Repeated strings are written even when empty
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, ip)
+	}
+	return b, nil
+}
+
+func (m *LookupBatch) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		if num == 1 {
+			var ip string
+			if err := f.string(&ip); err != nil {
+				return err
+			}
+			m.IPs = append(m.IPs, ip)
+		}
+		return nil
+	})
+}
+
+func (m *LookupBatchResponse) appendWire(b []byte) ([]byte, error) {
+	for i := range m.Results {
+		var err error
+		if b, err = appendMessage(b, 1, &m.Results[i]); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (m *LookupBatchResponse) readWire(b []byte) error {
+	return readFields(b, func(num protowire.Number, f field) error {
+		if num == 1 {
+			var r LookupResponse
+			if err := f.message(&r); err != nil {
+				return err
+			}
+			m.Results = append(m.Results, r)
+		}
+		return nil
+	})
+}
+
+// appendString appends a string field, omitting it when empty as proto3
+// does for scalar defaults
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendMessage(b []byte, num protowire.Number, m message) ([]byte, error) {
+	inner, err := m.appendWire(nil)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, inner), nil
+}
+
+// appendMetadata appends metadata as a google.protobuf.Struct. Values go
+// through JSON first so that types structpb does not know, such as
+// []string or custom structs, are converted the way the REST API would.
+func appendMetadata(b []byte, num protowire.Number, md map[string]interface{}) ([]byte, error) {
+	if md == nil {
+		return b, nil
+	}
+	data, err := json.Marshal(md)
+	if err != nil {
+		return nil, fmt.Errorf("encode metadata: %v", err)
+	}
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("encode metadata: %v", err)
+	}
+	s, err := structpb.NewStruct(generic)
+	if err != nil {
+		return nil, fmt.Errorf("encode metadata: %v", err)
+	}
+	inner, err := proto.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("encode metadata: %v", err)
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, inner), nil
+}
+
+// field is the value of one decoded field
+type field struct {
+	typ   protowire.Type
+	bytes []byte
+	n     uint64
+}
+
+// readFields calls fn for every field in b. Unknown fields are skipped,
+// as protobuf parsers do.
+func readFields(b []byte, fn func(num protowire.Number, f field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.n, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if err := fn(num, f); err != nil {
+			return fmt.Errorf("field %d: %v", num, err)
+		}
+	}
+	return nil
+}
+
+func (f field) want(typ protowire.Type) error {
+	if f.typ != typ {
+		return fmt.Errorf("unexpected wire type %d", f.typ)
+	}
+	return nil
+}
+
+func (f field) string(s *string) error {
+	if err := f.want(protowire.BytesType); err != nil {
+		return err
+	}
+	*s = string(f.bytes)
+	return nil
+}
+
+func (f field) bool(v *bool) error {
+	if err := f.want(protowire.VarintType); err != nil {
+		return err
+	}
+	*v = f.n != 0
+	return nil
+}
+
+func (f field) int32(v *int32) error {
+	if err := f.want(protowire.VarintType); err != nil {
+		return err
+	}
+	*v = int32(f.n)
+	return nil
+}
+
+func (f field) message(m message) error {
+	if err := f.want(protowire.BytesType); err != nil {
+		return err
+	}
+	return m.readWire(f.bytes)
+}
+
+// metadata decodes a google.protobuf.Struct. Numbers come back as float64,
+// as with JSON.
+func (f field) metadata(md *map[string]interface{}) error {
+	if err := f.want(protowire.BytesType); err != nil {
+		return err
+	}
+	var s structpb.Struct
+	if err := proto.Unmarshal(f.bytes, &s); err != nil {
+		return err
+	}
+	*md = s.AsMap()
+	return nil
+}
@@ -0,0 +1,134 @@
+// Package grpcmw provides gRPC server interceptors that classify each
+// call's peer address against a trie and make the match available to
+// handlers through the call context.
+package grpcmw
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/metajar/trie-network/internal/clientip"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Result is what an interceptor found for a call
+type Result struct {
+	// ClientIP is the peer address that was looked up
+	ClientIP netip.Addr
+	// Match is the most specific stored prefix containing ClientIP
+	Match trie.Match
+	// Found is false when no stored prefix contains ClientIP
+	Found bool
+}
+
+// Config customizes the interceptors
+type Config struct {
+	// OnClassify is called for every classified call, before the handler
+	// runs, e.g. to count calls per owner with a trie.LabelSet or to set
+	// attributes on the current trace span
+	OnClassify func(ctx context.Context, fullMethod string, res Result)
+	// Strategy determines the client address of a call, PeerAddr if nil
+	Strategy Strategy
+}
+
+// Strategy determines the client address of a call
+type Strategy func(ctx context.Context) (netip.Addr, bool)
+
+// ForwardedChain returns a Strategy for calls arriving through layer 7
+// proxies such as Envoy, which report the client in a metadata key like
+// x-forwarded-for. When the peer is a trusted proxy, the chain is read from
+// the right and the first address not belonging to a trusted proxy is the
+// client.
+func ForwardedChain(key string, trusted []netip.Prefix) Strategy {
+	return func(ctx context.Context) (netip.Addr, bool) {
+		remote, ok := PeerAddr(ctx)
+		if !ok {
+			return remote, false
+		}
+		var chain []string
+		for _, v := range metadata.ValueFromIncomingContext(ctx, key) {
+			chain = append(chain, strings.Split(v, ",")...)
+		}
+		return clientip.FromChain(remote, chain, trusted), true
+	}
+}
+
+type contextKey struct{}
+
+// FromContext returns the classification an interceptor stored in ctx
+func FromContext(ctx context.Context) (Result, bool) {
+	r, ok := ctx.Value(contextKey{}).(Result)
+	return r, ok
+}
+
+// UnaryServerInterceptor classifies the peer of every unary call and stores
+// the Result in the handler's context. Calls are always passed on, whether
+// or not the address matched.
+func UnaryServerInterceptor(holder *trie.TrieHolder, cfg Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(cfg.classify(ctx, holder, info.FullMethod), req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls
+func StreamServerInterceptor(holder *trie.TrieHolder, cfg Config) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := cfg.classify(ss.Context(), holder, info.FullMethod)
+		return handler(srv, &classifiedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// classifiedStream overrides the context of a server stream
+type classifiedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *classifiedStream) Context() context.Context {
+	return s.ctx
+}
+
+// classify looks up the peer of ctx and returns ctx carrying the Result
+func (cfg Config) classify(ctx context.Context, holder *trie.TrieHolder, fullMethod string) context.Context {
+	strategy := cfg.Strategy
+	if strategy == nil {
+		strategy = PeerAddr
+	}
+	ip, ok := strategy(ctx)
+	if !ok {
+		return ctx
+	}
+
+	res := Result{ClientIP: ip}
+	res.Match, res.Found = clientip.Classify(holder.Load(), ip)
+	ctx = context.WithValue(ctx, contextKey{}, res)
+	if cfg.OnClassify != nil {
+		cfg.OnClassify(ctx, fullMethod, res)
+	}
+	return ctx
+}
+
+// PeerAddr is the Strategy that uses the IP address of the call's peer. Behind
+// a load balancer speaking the PROXY protocol, serve through a pkg/proxyproto
+// listener so the peer is the original client.
+func PeerAddr(ctx context.Context) (netip.Addr, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return netip.Addr{}, false
+	}
+	if tcp, ok := p.Addr.(*net.TCPAddr); ok {
+		addr, ok := netip.AddrFromSlice(tcp.IP)
+		return addr.Unmap(), ok
+	}
+	ap, err := netip.ParseAddrPort(p.Addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
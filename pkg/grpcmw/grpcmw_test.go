@@ -0,0 +1,134 @@
+package grpcmw
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func peerContext(addr net.Addr) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+}
+
+func testHolder() *trie.TrieHolder {
+	holder := trie.NewTrieHolder()
+	holder.ReplaceAll([]trie.Match{
+		{CIDR: "203.0.113.0/24", Metadata: map[string]interface{}{"owner": "acme"}},
+		{CIDR: "2001:db8::/32", Metadata: map[string]interface{}{"owner": "lab"}},
+	})
+	return holder
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var classified []string
+	cfg := Config{OnClassify: func(ctx context.Context, method string, res Result) {
+		classified = append(classified, method+" "+res.Match.CIDR)
+	}}
+	interceptor := UnaryServerInterceptor(testHolder(), cfg)
+	info := &grpc.UnaryServerInfo{FullMethod: "/svc/Get"}
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		found bool
+		cidr  string
+	}{
+		{name: "IPv4 peer", ctx: peerContext(&net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 5000}), found: true, cidr: "203.0.113.0/24"},
+		{name: "IPv6 peer", ctx: peerContext(&net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 5000}), found: true, cidr: "2001:db8::/32"},
+		{name: "unknown peer", ctx: peerContext(&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 5000})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Result
+			var ok bool
+			_, err := interceptor(tt.ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				got, ok = FromContext(ctx)
+				return nil, nil
+			})
+			if err != nil || !ok || got.Found != tt.found || got.Match.CIDR != tt.cidr {
+				t.Errorf("Unexpected result %+v (%v, %v)", got, ok, err)
+			}
+		})
+	}
+
+	if len(classified) != 3 || classified[0] != "/svc/Get 203.0.113.0/24" {
+		t.Errorf("Unexpected OnClassify calls %v", classified)
+	}
+
+	// Calls without a peer address still reach the handler
+	called := false
+	interceptor(peerContext(&net.UnixAddr{Name: "@sock", Net: "unix"}), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		if _, ok := FromContext(ctx); ok {
+			t.Errorf("Expected no result for a unix socket peer")
+		}
+		return nil, nil
+	})
+	if !called {
+		t.Errorf("Expected the handler to run")
+	}
+}
+
+// fakeStream is the minimum of a server stream needed by the interceptor
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(testHolder(), Config{})
+	ss := &fakeStream{ctx: peerContext(&net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 5000})}
+
+	err := interceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: "/svc/Watch"}, func(srv interface{}, stream grpc.ServerStream) error {
+		res, ok := FromContext(stream.Context())
+		if !ok || res.Match.Metadata["owner"] != "acme" || res.Match.PrefixLen != 24 {
+			t.Errorf("Unexpected result %+v (%v)", res, ok)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Interceptor returned error: %v", err)
+	}
+}
+
+func TestForwardedChain(t *testing.T) {
+	strategy := ForwardedChain("x-forwarded-for", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+
+	tests := []struct {
+		name string
+		peer string
+		xff  []string
+		want string
+	}{
+		{name: "direct", peer: "198.51.100.7", want: "198.51.100.7"},
+		{name: "untrusted sender ignores metadata", peer: "198.51.100.7", xff: []string{"1.2.3.4"}, want: "198.51.100.7"},
+		{name: "proxy chain", peer: "10.0.0.5", xff: []string{"6.6.6.6, 203.0.113.9, 10.1.1.1"}, want: "203.0.113.9"},
+		{name: "repeated key", peer: "10.0.0.5", xff: []string{"6.6.6.6", "203.0.113.9"}, want: "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := peerContext(&net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 5000})
+			md := metadata.MD{}
+			for _, v := range tt.xff {
+				md.Append("x-forwarded-for", v)
+			}
+			ctx = metadata.NewIncomingContext(ctx, md)
+
+			got, ok := strategy(ctx)
+			if !ok || got.String() != tt.want {
+				t.Errorf("Expected %s, got %s (%v)", tt.want, got, ok)
+			}
+		})
+	}
+}
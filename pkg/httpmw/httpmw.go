@@ -0,0 +1,173 @@
+// Package httpmw provides net/http middleware that classifies each request's
+// client address against a trie and makes the match available to handlers
+// through the request context.
+package httpmw
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/metajar/trie-network/internal/clientip"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Config controls how the client address is determined
+type Config struct {
+	// TrustedProxies are the load balancers and proxies allowed to report
+	// the client address in Header. Without any, Header is ignored and the
+	// connection's remote address is used.
+	TrustedProxies []netip.Prefix
+	// Header carries the chain of forwarded addresses, X-Forwarded-For if
+	// empty. "Forwarded" (RFC 7239) is read from its for= parameters.
+	Header string
+	// Strategy, if set, replaces the forwarded chain walk of ClientIP
+	Strategy Strategy
+}
+
+// Strategy determines the client address of a request
+type Strategy func(r *http.Request) (netip.Addr, bool)
+
+// RemoteAddr is the Strategy that ignores headers and uses the connection's
+// remote address, e.g. behind a load balancer speaking the PROXY protocol
+// through a pkg/proxyproto listener
+func RemoteAddr(r *http.Request) (netip.Addr, bool) {
+	return remoteAddr(r.RemoteAddr)
+}
+
+// SingleHeader returns a Strategy for load balancers that put only the
+// client address in a header, such as X-Real-IP or CF-Connecting-IP. The
+// header is only believed when the connection comes from a trusted proxy.
+func SingleHeader(header string, trusted []netip.Prefix) Strategy {
+	cfg := Config{TrustedProxies: trusted}
+	return func(r *http.Request) (netip.Addr, bool) {
+		remote, ok := remoteAddr(r.RemoteAddr)
+		if !ok || !cfg.trusted(remote) {
+			return remote, ok
+		}
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get(header))); err == nil {
+			return addr.Unmap(), true
+		}
+		return remote, true
+	}
+}
+
+// FirstOf returns a Strategy that asks each of strategies in turn and uses
+// the first address found
+func FirstOf(strategies ...Strategy) Strategy {
+	return func(r *http.Request) (netip.Addr, bool) {
+		for _, s := range strategies {
+			if addr, ok := s(r); ok {
+				return addr, true
+			}
+		}
+		return netip.Addr{}, false
+	}
+}
+
+// Result is what the middleware found for a request
+type Result struct {
+	// ClientIP is the address that was looked up
+	ClientIP netip.Addr
+	// Match is the most specific stored prefix containing ClientIP
+	Match trie.Match
+	// Found is false when no stored prefix contains ClientIP
+	Found bool
+}
+
+type contextKey struct{}
+
+// FromContext returns the classification the middleware stored in ctx
+func FromContext(ctx context.Context) (Result, bool) {
+	r, ok := ctx.Value(contextKey{}).(Result)
+	return r, ok
+}
+
+// Middleware looks up every request's client address in the holder's
+// current trie and stores the Result in the request context. Requests are
+// always passed on, whether or not the address matched.
+func Middleware(holder *trie.TrieHolder, cfg Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, ok := cfg.ClientIP(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res := Result{ClientIP: ip}
+			res.Match, res.Found = clientip.Classify(holder.Load(), ip)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, res)))
+		})
+	}
+}
+
+// ClientIP returns the address of the client that sent r, using Strategy
+// if one is set. Otherwise, when the connection comes from a trusted
+// proxy, the forwarded chain is read from the right and the first address
+// not belonging to a trusted proxy is the client, so clients cannot spoof
+// their address by sending the header themselves.
+func (cfg Config) ClientIP(r *http.Request) (netip.Addr, bool) {
+	if cfg.Strategy != nil {
+		return cfg.Strategy(r)
+	}
+
+	remote, ok := remoteAddr(r.RemoteAddr)
+	if !ok || !cfg.trusted(remote) {
+		return remote, ok
+	}
+
+	header := cfg.Header
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	var chain []string
+	for _, v := range r.Header.Values(header) {
+		chain = append(chain, strings.Split(v, ",")...)
+	}
+	if http.CanonicalHeaderKey(header) == "Forwarded" {
+		chain = forwardedFor(chain)
+	}
+	return clientip.FromChain(remote, chain, cfg.TrustedProxies), true
+}
+
+// forwardedFor returns the for= addresses of RFC 7239 Forwarded elements.
+// Elements without one become empty entries, which stop the chain walk like
+// any other unusable address.
+func forwardedFor(elements []string) []string {
+	out := make([]string, len(elements))
+	for i, elem := range elements {
+		for _, pair := range strings.Split(elem, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !strings.EqualFold(key, "for") {
+				continue
+			}
+			value = strings.Trim(value, `"`)
+			if host, _, err := net.SplitHostPort(value); err == nil {
+				value = host
+			}
+			out[i] = strings.Trim(value, "[]")
+		}
+	}
+	return out
+}
+
+// trusted reports whether addr belongs to a trusted proxy
+func (cfg Config) trusted(addr netip.Addr) bool {
+	return clientip.Trusted(addr, cfg.TrustedProxies)
+}
+
+// remoteAddr parses the host part of a connection's remote address
+func remoteAddr(s string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		host = s
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
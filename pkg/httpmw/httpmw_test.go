@@ -0,0 +1,133 @@
+package httpmw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func TestClientIP(t *testing.T) {
+	cfg := Config{TrustedProxies: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{name: "direct", remote: "198.51.100.7:5555", want: "198.51.100.7"},
+		{name: "untrusted sender ignores header", remote: "198.51.100.7:5555", xff: []string{"1.2.3.4"}, want: "198.51.100.7"},
+		{name: "one proxy", remote: "10.0.0.5:443", xff: []string{"203.0.113.9"}, want: "203.0.113.9"},
+		{name: "proxy chain", remote: "10.0.0.5:443", xff: []string{"6.6.6.6, 203.0.113.9, 10.1.1.1"}, want: "203.0.113.9"},
+		{name: "repeated header", remote: "10.0.0.5:443", xff: []string{"6.6.6.6", "203.0.113.9"}, want: "203.0.113.9"},
+		{name: "garbage stops the walk", remote: "10.0.0.5:443", xff: []string{"203.0.113.9, junk, 10.1.1.1"}, want: "10.1.1.1"},
+		{name: "all trusted", remote: "10.0.0.5:443", xff: []string{"10.2.2.2"}, want: "10.2.2.2"},
+		{name: "IPv6 proxy", remote: "[2001:db8:ffff::1]:443", xff: []string{"2001:db8:1::5"}, want: "2001:db8:1::5"},
+		{name: "mapped address", remote: "[::ffff:198.51.100.7]:80", want: "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			got, ok := cfg.ClientIP(r)
+			if !ok || got.String() != tt.want {
+				t.Errorf("Expected %s, got %s (%v)", tt.want, got, ok)
+			}
+		})
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name     string
+		strategy Config
+		remote   string
+		header   string
+		value    string
+		want     string
+	}{
+		{name: "remote address", strategy: Config{Strategy: RemoteAddr}, remote: "10.0.0.5:443", header: "X-Forwarded-For", value: "203.0.113.9", want: "10.0.0.5"},
+		{name: "single header", strategy: Config{Strategy: SingleHeader("X-Real-IP", trusted)}, remote: "10.0.0.5:443", header: "X-Real-IP", value: "203.0.113.9", want: "203.0.113.9"},
+		{name: "single header untrusted", strategy: Config{Strategy: SingleHeader("X-Real-IP", trusted)}, remote: "198.51.100.7:443", header: "X-Real-IP", value: "203.0.113.9", want: "198.51.100.7"},
+		{name: "single header missing", strategy: Config{Strategy: SingleHeader("X-Real-IP", trusted)}, remote: "10.0.0.5:443", want: "10.0.0.5"},
+		{name: "forwarded", strategy: Config{TrustedProxies: trusted, Header: "Forwarded"}, remote: "10.0.0.5:443", header: "Forwarded", value: `for=6.6.6.6, for=203.0.113.9;proto=https, for="10.1.1.1:8080"`, want: "203.0.113.9"},
+		{name: "forwarded IPv6", strategy: Config{TrustedProxies: trusted, Header: "forwarded"}, remote: "10.0.0.5:443", header: "Forwarded", value: `for="[2001:db8::1]:4711"`, want: "2001:db8::1"},
+		{name: "forwarded obfuscated", strategy: Config{TrustedProxies: trusted, Header: "Forwarded"}, remote: "10.0.0.5:443", header: "Forwarded", value: "for=_hidden, for=10.1.1.1", want: "10.1.1.1"},
+		{name: "first of", strategy: Config{Strategy: FirstOf(
+			func(r *http.Request) (netip.Addr, bool) { return netip.Addr{}, false },
+			RemoteAddr,
+		)}, remote: "198.51.100.7:443", want: "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			got, ok := tt.strategy.ClientIP(r)
+			if !ok || got.String() != tt.want {
+				t.Errorf("Expected %s, got %s (%v)", tt.want, got, ok)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	holder := trie.NewTrieHolder()
+	holder.ReplaceAll([]trie.Match{
+		{CIDR: "203.0.113.0/24", Metadata: map[string]interface{}{"owner": "acme"}},
+	})
+
+	var got Result
+	var seen bool
+	handler := Middleware(holder, Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, seen = FromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		remote string
+		found  bool
+		cidr   string
+	}{
+		{name: "match", remote: "203.0.113.9:1234", found: true, cidr: "203.0.113.0/24"},
+		{name: "no match", remote: "198.51.100.1:1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if !seen || got.Found != tt.found || got.Match.CIDR != tt.cidr {
+				t.Fatalf("Unexpected result %+v (%v)", got, seen)
+			}
+			if tt.found && (got.Match.PrefixLen != 24 || got.Match.Metadata["owner"] != "acme") {
+				t.Errorf("Unexpected match %+v", got.Match)
+			}
+		})
+	}
+
+	// Requests without a usable address still reach the handler
+	seen = false
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "@"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if seen {
+		t.Errorf("Expected no result for an unparsable remote address")
+	}
+}
@@ -0,0 +1,282 @@
+// Package mmap compiles a trie into a flat, read-only file that processes
+// map into memory instead of loading. Every process on a host that maps the
+// same file shares one copy in the page cache, so per-core workers do not
+// each hold their own copy of a multi-gigabyte dataset.
+//
+// The file holds the address space split into ranges, each pointing at the
+// most specific prefix covering it, so a lookup is a binary search. Metadata
+// is stored as JSON and decoded on each lookup, which means numbers come
+// back as float64 and lists as []interface{}, as with IPTrie.UnmarshalJSON.
+package mmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// File layout, all integers little endian:
+//
+//	header    magic, version, entry count, IPv4 and IPv6 range counts
+//	v4 ranges 4 byte start address, big endian, and int32 entry index
+//	v6 ranges 16 byte start address and int32 entry index
+//	index     uint32 offset and length of each entry in the blob
+//	blob      one JSON object per entry with its CIDR and metadata
+//
+// A range's entry index is -1 where no prefix covers it.
+const (
+	magic      = "TRIEMMAP"
+	version    = 1
+	headerLen  = 24
+	v4RangeLen = 8
+	v6RangeLen = 20
+	indexLen   = 8
+)
+
+// entry is the JSON form of one stored prefix
+type entry struct {
+	CIDR     string                 `json:"cidr"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// rangeStart is the first address of a range and the entry covering it
+type rangeStart struct {
+	addr  netip.Addr
+	entry int32
+}
+
+// Write compiles t into w in the format read by Open
+func Write(w io.Writer, t *trie.IPTrie) error {
+	var entries []entry
+	index := make(map[string]int32)
+	bounds := map[bool]map[netip.Addr]bool{
+		true:  {netip.IPv4Unspecified(): true},
+		false: {netip.IPv6Unspecified(): true},
+	}
+
+	t.Walk(func(cidr string, md map[string]interface{}) bool {
+		index[cidr] = int32(len(entries))
+		entries = append(entries, entry{CIDR: cidr, Metadata: md})
+
+		p, ok := parsePrefix(cidr)
+		if !ok {
+			return true
+		}
+		family := bounds[p.Addr().Is4()]
+		family[p.Addr()] = true
+		if next := lastAddr(p).Next(); next.IsValid() {
+			family[next] = true
+		}
+		return true
+	})
+
+	ranges := map[bool][]rangeStart{}
+	for _, is4 := range []bool{true, false} {
+		starts := make([]netip.Addr, 0, len(bounds[is4]))
+		for addr := range bounds[is4] {
+			starts = append(starts, addr)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Less(starts[j]) })
+
+		for _, addr := range starts {
+			e := int32(-1)
+			if cidr, _, err := t.Find(addr.String()); err == nil {
+				e = index[cidr]
+			}
+			if n := len(ranges[is4]); n > 0 && ranges[is4][n-1].entry == e {
+				continue
+			}
+			ranges[is4] = append(ranges[is4], rangeStart{addr: addr, entry: e})
+		}
+	}
+
+	var blob bytes.Buffer
+	offsets := make([][2]uint32, len(entries))
+	for i, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("encode %s: %v", e.CIDR, err)
+		}
+		offsets[i] = [2]uint32{uint32(blob.Len()), uint32(len(data))}
+		blob.Write(data)
+	}
+
+	bw := bufio.NewWriter(w)
+	var hdr [headerLen]byte
+	copy(hdr[:], magic)
+	binary.LittleEndian.PutUint32(hdr[8:], version)
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(entries)))
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(len(ranges[true])))
+	binary.LittleEndian.PutUint32(hdr[20:], uint32(len(ranges[false])))
+	bw.Write(hdr[:])
+
+	for _, is4 := range []bool{true, false} {
+		for _, r := range ranges[is4] {
+			bw.Write(r.addr.AsSlice())
+			binary.Write(bw, binary.LittleEndian, r.entry)
+		}
+	}
+	for _, off := range offsets {
+		binary.Write(bw, binary.LittleEndian, off)
+	}
+	bw.Write(blob.Bytes())
+	return bw.Flush()
+}
+
+// parsePrefix parses a stored CIDR into its masked form, unmapping
+// IPv4-mapped IPv6 prefixes the way the trie stores them
+func parsePrefix(cidr string) (netip.Prefix, bool) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked(), true
+}
+
+// lastAddr returns the highest address in p
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << uint(7-i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// Table is a compiled trie mapped from a file. It is safe for concurrent
+// use until Close is called.
+type Table struct {
+	data    []byte
+	entries int
+	v4      []byte
+	v6      []byte
+	index   []byte
+	blob    []byte
+}
+
+// Open maps a file written by Write
+func Open(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < headerLen {
+		return nil, fmt.Errorf("%s: not a compiled trie", path)
+	}
+	data, err := mapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("map %s: %v", path, err)
+	}
+
+	tb, err := parseTable(data)
+	if err != nil {
+		unmapFile(data)
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return tb, nil
+}
+
+// parseTable checks the header and slices data into its sections
+func parseTable(data []byte) (*Table, error) {
+	if string(data[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not a compiled trie")
+	}
+	if v := binary.LittleEndian.Uint32(data[8:]); v != version {
+		return nil, fmt.Errorf("unsupported version %d", v)
+	}
+
+	entries := int(binary.LittleEndian.Uint32(data[12:]))
+	n4 := int(binary.LittleEndian.Uint32(data[16:]))
+	n6 := int(binary.LittleEndian.Uint32(data[20:]))
+	size := headerLen + n4*v4RangeLen + n6*v6RangeLen + entries*indexLen
+	if n4 == 0 || n6 == 0 || size > len(data) {
+		return nil, fmt.Errorf("truncated compiled trie")
+	}
+
+	tb := &Table{data: data, entries: entries}
+	off := headerLen
+	tb.v4, off = data[off:off+n4*v4RangeLen], off+n4*v4RangeLen
+	tb.v6, off = data[off:off+n6*v6RangeLen], off+n6*v6RangeLen
+	tb.index, off = data[off:off+entries*indexLen], off+entries*indexLen
+	tb.blob = data[off:]
+	return tb, nil
+}
+
+// Len returns the number of stored prefixes
+func (tb *Table) Len() int {
+	return tb.entries
+}
+
+// Find returns the most specific prefix containing ip and its metadata,
+// like IPTrie.Find
+func (tb *Table) Find(ip string) (string, map[string]interface{}, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", nil, fmt.Errorf("invalid IP address")
+	}
+
+	ranges, width := tb.v6, v6RangeLen
+	addr := parsed.To16()
+	if ip4 := parsed.To4(); ip4 != nil {
+		ranges, width, addr = tb.v4, v4RangeLen, ip4
+	}
+
+	n := len(ranges) / width
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(ranges[i*width:i*width+len(addr)], addr) > 0
+	}) - 1
+	e := int32(binary.LittleEndian.Uint32(ranges[i*width+len(addr):]))
+	if e < 0 {
+		return "", nil, fmt.Errorf("no matching CIDR found")
+	}
+	return tb.entry(int(e))
+}
+
+// entry decodes the stored prefix at index i
+func (tb *Table) entry(i int) (string, map[string]interface{}, error) {
+	if i >= tb.entries {
+		return "", nil, fmt.Errorf("corrupt compiled trie: entry %d out of range", i)
+	}
+	off := binary.LittleEndian.Uint32(tb.index[i*indexLen:])
+	size := binary.LittleEndian.Uint32(tb.index[i*indexLen+4:])
+	if uint64(off)+uint64(size) > uint64(len(tb.blob)) {
+		return "", nil, fmt.Errorf("corrupt compiled trie: entry %d out of range", i)
+	}
+
+	var e entry
+	if err := json.Unmarshal(tb.blob[off:off+size], &e); err != nil {
+		return "", nil, fmt.Errorf("corrupt compiled trie: %v", err)
+	}
+	if e.Metadata == nil {
+		e.Metadata = map[string]interface{}{}
+	}
+	return e.CIDR, e.Metadata, nil
+}
+
+// Close unmaps the file. The Table must not be used afterwards.
+func (tb *Table) Close() error {
+	if tb.data == nil {
+		return nil
+	}
+	err := unmapFile(tb.data)
+	tb.data, tb.v4, tb.v6, tb.index, tb.blob = nil, nil, nil, nil, nil
+	return err
+}
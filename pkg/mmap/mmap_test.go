@@ -0,0 +1,175 @@
+package mmap
+
+import (
+	"bytes"
+	"math/rand"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func testTrie(t *testing.T) *trie.IPTrie {
+	tr := trie.NewIPTrie()
+	entries := map[string]map[string]interface{}{
+		"0.0.0.0/0":        {"name": "default"},
+		"10.0.0.0/8":       {"name": "ten", "vlan": 10},
+		"10.1.0.0/16":      {"name": "ten-one"},
+		"10.1.2.0/24":      {"name": "ten-one-two"},
+		"10.1.2.3/32":      {"name": "host"},
+		"192.168.0.0/16":   {"name": "private"},
+		"255.255.255.0/24": {"name": "top"},
+		"2001:db8::/32":    {"name": "doc"},
+		"2001:db8:1::/48":  {"name": "doc-one"},
+		"2001:db8::1/128":  {"name": "doc-host"},
+		"ffff::/16":        {"name": "v6-top"},
+	}
+	for cidr, md := range entries {
+		if err := tr.Insert(cidr, md); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", cidr, err)
+		}
+	}
+	return tr
+}
+
+func compile(t *testing.T, tr *trie.IPTrie) string {
+	path := filepath.Join(t.TempDir(), "test.trie")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(f, tr); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	f.Close()
+	return path
+}
+
+func TestTableMatchesTrie(t *testing.T) {
+	tr := testTrie(t)
+	tb, err := Open(compile(t, tr))
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer tb.Close()
+
+	if tb.Len() != tr.Len() {
+		t.Errorf("Expected %d entries, got %d", tr.Len(), tb.Len())
+	}
+
+	ips := []string{
+		"0.0.0.0", "9.255.255.255", "10.0.0.0", "10.1.2.2", "10.1.2.3", "10.1.2.4", "10.1.3.0",
+		"10.255.255.255", "11.0.0.0", "192.168.1.1", "255.255.255.255", "::ffff:10.1.2.3",
+		"::", "2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8:1::5", "2001:db9::", "ffff:ffff::1",
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		var a4 [4]byte
+		rng.Read(a4[:])
+		ips = append(ips, netip.AddrFrom4(a4).String())
+		var a16 [16]byte
+		rng.Read(a16[:])
+		a16[0], a16[1] = 0x20, 0x01
+		if i%2 == 0 {
+			a16[2], a16[3] = 0x0d, 0xb8
+		}
+		ips = append(ips, netip.AddrFrom16(a16).String())
+	}
+
+	for _, ip := range ips {
+		wantCIDR, wantMD, wantErr := tr.Find(ip)
+		gotCIDR, gotMD, gotErr := tb.Find(ip)
+		if (wantErr == nil) != (gotErr == nil) || gotCIDR != wantCIDR || (wantErr == nil && gotMD["name"] != wantMD["name"]) {
+			t.Fatalf("Find(%s) = %s %v (%v), trie says %s %v (%v)", ip, gotCIDR, gotMD, gotErr, wantCIDR, wantMD, wantErr)
+		}
+	}
+
+	// Numbers come back the way encoding/json decodes them
+	if _, md, _ := tb.Find("10.9.9.9"); md["vlan"] != float64(10) {
+		t.Errorf("Expected vlan 10, got %#v", md["vlan"])
+	}
+	if _, _, err := tb.Find("bogus"); err == nil {
+		t.Errorf("Expected error for invalid IP")
+	}
+}
+
+func TestEmptyTable(t *testing.T) {
+	tb, err := Open(compile(t, trie.NewIPTrie()))
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer tb.Close()
+	if _, _, err := tb.Find("10.0.0.1"); err == nil {
+		t.Errorf("Expected no match in an empty table")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	Write(&buf, testTrie(t))
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"too short", []byte("TRIE")},
+		{"wrong magic", append([]byte("NOTATRIE"), valid[8:]...)},
+		{"truncated", valid[:headerLen+4]},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		os.WriteFile(path, tt.data, 0o644)
+		if tb, err := Open(path); err == nil {
+			tb.Close()
+			t.Errorf("%s: expected Open to fail", tt.name)
+		}
+	}
+}
+
+func TestShared(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenShared(dir); err == nil {
+		t.Errorf("Expected error before anything is published")
+	}
+
+	first := testTrie(t)
+	if _, err := Publish(dir, first); err != nil {
+		t.Fatalf("Publish returned error: %v", err)
+	}
+	s, err := OpenShared(dir)
+	if err != nil {
+		t.Fatalf("OpenShared returned error: %v", err)
+	}
+	defer s.Close()
+
+	if _, md, err := s.Find("10.1.2.9"); err != nil || md["name"] != "ten-one-two" {
+		t.Errorf("Unexpected first lookup %v (%v)", md, err)
+	}
+	if changed, err := s.Refresh(); changed || err != nil {
+		t.Errorf("Expected no change, got %v (%v)", changed, err)
+	}
+
+	second := trie.NewIPTrie()
+	second.Insert("10.0.0.0/8", map[string]interface{}{"name": "replaced"})
+	for i := 0; i < 2; i++ {
+		if _, err := Publish(dir, second); err != nil {
+			t.Fatalf("Publish returned error: %v", err)
+		}
+	}
+	if changed, err := s.Refresh(); !changed || err != nil {
+		t.Errorf("Expected a change, got %v (%v)", changed, err)
+	}
+	if _, md, err := s.Find("10.1.2.9"); err != nil || md["name"] != "replaced" {
+		t.Errorf("Unexpected second lookup %v (%v)", md, err)
+	}
+
+	// Only the current dataset and the one it replaced are kept
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+datasetExt))
+	if len(files) != 2 {
+		t.Errorf("Expected 2 dataset files, got %v", files)
+	}
+}
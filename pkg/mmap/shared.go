@@ -0,0 +1,170 @@
+package mmap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// currentFile names the dataset file readers should map. It is replaced
+// atomically by rename, so readers always see a complete name.
+const currentFile = "CURRENT"
+
+// datasetExt is the extension of compiled dataset files in a directory
+const datasetExt = ".trie"
+
+// Publish compiles t into a new file in dir and points CURRENT at it.
+// Processes using Shared pick it up on their next Refresh. Older dataset
+// files other than the one just replaced are deleted; processes still
+// mapping them keep their mapping until they move on.
+func Publish(dir string, t *trie.IPTrie) (string, error) {
+	previous, _ := readCurrent(dir)
+	name := fmt.Sprintf("dataset-%d%s", time.Now().UnixNano(), datasetExt)
+
+	if err := writeAtomic(filepath.Join(dir, name), func(f *os.File) error {
+		return Write(f, t)
+	}); err != nil {
+		return "", err
+	}
+	if err := writeAtomic(filepath.Join(dir, currentFile), func(f *os.File) error {
+		_, err := f.WriteString(name + "\n")
+		return err
+	}); err != nil {
+		return "", err
+	}
+
+	old, _ := filepath.Glob(filepath.Join(dir, "*"+datasetExt))
+	for _, path := range old {
+		if base := filepath.Base(path); base != name && base != previous {
+			os.Remove(path)
+		}
+	}
+	return name, nil
+}
+
+// writeAtomic writes path through a temporary file and rename
+func writeAtomic(path string, write func(*os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %v", filepath.Base(path), err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// readCurrent returns the dataset file name CURRENT points at
+func readCurrent(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, currentFile))
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(string(data))
+	if name == "" || name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid %s file", currentFile)
+	}
+	return name, nil
+}
+
+// Shared is the dataset currently published in a directory. It is safe for
+// concurrent use.
+type Shared struct {
+	dir string
+
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	name      string
+	table     *Table
+}
+
+// OpenShared maps the dataset currently published in dir
+func OpenShared(dir string) (*Shared, error) {
+	s := &Shared{dir: dir}
+	if _, err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Refresh maps the published dataset if it changed since the last call and
+// reports whether it did. Lookups in progress finish on the old dataset.
+func (s *Shared) Refresh() (bool, error) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	name, err := readCurrent(s.dir)
+	if err != nil {
+		return false, err
+	}
+	if name == s.name {
+		return false, nil
+	}
+	table, err := Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	old := s.table
+	s.name, s.table = name, table
+	s.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return true, nil
+}
+
+// Watch calls Refresh every interval until stop is closed, reporting
+// errors to onError if it is not nil
+func (s *Shared) Watch(interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := s.Refresh(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Name returns the file name of the mapped dataset
+func (s *Shared) Name() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.name
+}
+
+// Find looks ip up in the mapped dataset
+func (s *Shared) Find(ip string) (string, map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.table.Find(ip)
+}
+
+// Close unmaps the dataset
+func (s *Shared) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.table.Close()
+}
@@ -0,0 +1,487 @@
+// Package mrt reads BGP routing table dumps in the MRT format (RFC 6396), as
+// published by RouteViews and RIPE RIS, and loads them into a trie with each
+// prefix's AS path and origin as metadata.
+//
+// Dumps are read one record at a time, so multi-gigabyte files can be
+// processed without holding more than the resulting trie in memory.
+// Compressed dumps must be decompressed first, e.g. with compress/bzip2 or by
+// fetching them through pkg/feed.
+package mrt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// MRT record types and TABLE_DUMP_V2 subtypes
+const (
+	typeTableDumpV2 = 13
+
+	subtypePeerIndexTable     = 1
+	subtypeRIBIPv4Unicast     = 2
+	subtypeRIBIPv6Unicast     = 4
+	subtypeRIBIPv4UnicastPath = 8
+	subtypeRIBIPv6UnicastPath = 10
+)
+
+// BGP path attribute type codes and AS path segment types
+const (
+	attrOrigin  = 1
+	attrASPath  = 2
+	attrNextHop = 3
+	attrMPReach = 14
+
+	segmentSet = 1
+	segmentSeq = 2
+
+	// extendedLen is the attribute flag for a two byte length
+	extendedLen = 0x10
+)
+
+const (
+	headerLen = 12
+	// maxRecordLen guards against allocating huge buffers for corrupt input
+	maxRecordLen = 1 << 24
+)
+
+// Peer is a BGP neighbor of the collector that produced the dump
+type Peer struct {
+	BGPID netip.Addr
+	Addr  netip.Addr
+	AS    uint32
+}
+
+// Segment is one AS_SEQUENCE or AS_SET of an AS path
+type Segment struct {
+	Set  bool
+	ASNs []uint32
+}
+
+// Route is one peer's path to a prefix
+type Route struct {
+	Prefix     netip.Prefix
+	Peer       Peer
+	Originated time.Time
+	// Origin is "igp", "egp" or "incomplete"
+	Origin  string
+	ASPath  []Segment
+	NextHop netip.Addr
+}
+
+// PathLen returns the AS path length as BGP counts it, with each AS_SET
+// counting as one hop
+func (r Route) PathLen() int {
+	n := 0
+	for _, seg := range r.ASPath {
+		if seg.Set {
+			n++
+		} else {
+			n += len(seg.ASNs)
+		}
+	}
+	return n
+}
+
+// OriginAS returns the last AS of the path. It is not known when the path
+// is empty, as for the collector's own routes, or ends in an AS_SET.
+func (r Route) OriginAS() (uint32, bool) {
+	if len(r.ASPath) == 0 {
+		return 0, false
+	}
+	last := r.ASPath[len(r.ASPath)-1]
+	if last.Set || len(last.ASNs) == 0 {
+		return 0, false
+	}
+	return last.ASNs[len(last.ASNs)-1], true
+}
+
+// PathString formats the AS path the way routers display it, with AS_SETs
+// in braces, e.g. "3356 1299 {64500 64501}"
+func (r Route) PathString() string {
+	var sb strings.Builder
+	for i, seg := range r.ASPath {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if seg.Set {
+			sb.WriteByte('{')
+		}
+		for j, asn := range seg.ASNs {
+			if j > 0 {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(strconv.FormatUint(uint64(asn), 10))
+		}
+		if seg.Set {
+			sb.WriteByte('}')
+		}
+	}
+	return sb.String()
+}
+
+// Reader streams the routes of an MRT dump. Only TABLE_DUMP_V2 unicast RIB
+// records are decoded; other record types are skipped.
+type Reader struct {
+	r       *bufio.Reader
+	buf     []byte
+	record  int
+	peers   []Peer
+	pending []Route
+}
+
+// NewReader returns a Reader for an uncompressed MRT dump
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 1<<16)}
+}
+
+// Next returns the next route in the dump, or io.EOF once it is exhausted.
+// Routes for the same prefix are returned consecutively, in the order of the
+// peers that carry them.
+func (r *Reader) Next() (Route, error) {
+	for len(r.pending) == 0 {
+		if err := r.readRecord(); err != nil {
+			return Route{}, err
+		}
+	}
+	rt := r.pending[0]
+	r.pending = r.pending[1:]
+	return rt, nil
+}
+
+// Peers returns the collector's peers, known once the peer index table at
+// the start of the dump has been read
+func (r *Reader) Peers() []Peer {
+	return r.peers
+}
+
+// readRecord reads one MRT record and queues the routes it holds
+func (r *Reader) readRecord() error {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("record %d: truncated header", r.record+1)
+		}
+		return err
+	}
+	r.record++
+
+	typ := binary.BigEndian.Uint16(hdr[4:6])
+	subtype := binary.BigEndian.Uint16(hdr[6:8])
+	length := binary.BigEndian.Uint32(hdr[8:12])
+	if length > maxRecordLen {
+		return fmt.Errorf("record %d: length %d too large", r.record, length)
+	}
+
+	if typ != typeTableDumpV2 {
+		if _, err := r.r.Discard(int(length)); err != nil {
+			return fmt.Errorf("record %d: truncated body", r.record)
+		}
+		return nil
+	}
+
+	if cap(r.buf) < int(length) {
+		r.buf = make([]byte, length)
+	}
+	body := r.buf[:length]
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return fmt.Errorf("record %d: truncated body", r.record)
+	}
+
+	var err error
+	switch subtype {
+	case subtypePeerIndexTable:
+		r.peers, err = parsePeerIndex(body)
+	case subtypeRIBIPv4Unicast:
+		err = r.parseRIB(body, 4, false)
+	case subtypeRIBIPv6Unicast:
+		err = r.parseRIB(body, 16, false)
+	case subtypeRIBIPv4UnicastPath:
+		err = r.parseRIB(body, 4, true)
+	case subtypeRIBIPv6UnicastPath:
+		err = r.parseRIB(body, 16, true)
+	}
+	if err != nil {
+		return fmt.Errorf("record %d: %v", r.record, err)
+	}
+	return nil
+}
+
+// decoder reads big endian fields from a record body, remembering the first
+// overrun so callers can check once at the end
+type decoder struct {
+	b   []byte
+	bad bool
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.bad || n > len(d.b) {
+		d.bad = true
+		return make([]byte, n)
+	}
+	out := d.b[:n]
+	d.b = d.b[n:]
+	return out
+}
+
+func (d *decoder) u8() uint8   { return d.bytes(1)[0] }
+func (d *decoder) u16() uint16 { return binary.BigEndian.Uint16(d.bytes(2)) }
+func (d *decoder) u32() uint32 { return binary.BigEndian.Uint32(d.bytes(4)) }
+
+// addr reads an IPv4 or IPv6 address of the given byte length
+func (d *decoder) addr(n int) netip.Addr {
+	a, _ := netip.AddrFromSlice(d.bytes(n))
+	return a
+}
+
+// parsePeerIndex decodes the PEER_INDEX_TABLE that RIB entries refer to
+func parsePeerIndex(body []byte) ([]Peer, error) {
+	d := &decoder{b: body}
+	d.u32() // collector BGP ID
+	d.bytes(int(d.u16()))
+	peers := make([]Peer, d.u16())
+	for i := range peers {
+		typ := d.u8()
+		peers[i].BGPID = d.addr(4)
+		if typ&1 != 0 {
+			peers[i].Addr = d.addr(16)
+		} else {
+			peers[i].Addr = d.addr(4)
+		}
+		if typ&2 != 0 {
+			peers[i].AS = d.u32()
+		} else {
+			peers[i].AS = uint32(d.u16())
+		}
+	}
+	if d.bad {
+		return nil, fmt.Errorf("truncated peer index table")
+	}
+	return peers, nil
+}
+
+// parseRIB decodes a unicast RIB record and queues one route per entry
+func (r *Reader) parseRIB(body []byte, addrLen int, addPath bool) error {
+	if r.peers == nil {
+		return fmt.Errorf("RIB entry before peer index table")
+	}
+
+	d := &decoder{b: body}
+	d.u32() // sequence number
+	bits := int(d.u8())
+	if bits > addrLen*8 {
+		return fmt.Errorf("invalid prefix length %d", bits)
+	}
+	raw := make([]byte, addrLen)
+	copy(raw, d.bytes((bits+7)/8))
+	addr, _ := netip.AddrFromSlice(raw)
+	prefix := netip.PrefixFrom(addr, bits).Masked()
+
+	count := int(d.u16())
+	r.pending = r.pending[:0]
+	for i := 0; i < count; i++ {
+		peerIndex := int(d.u16())
+		originated := d.u32()
+		if addPath {
+			d.u32() // path identifier
+		}
+		attrs := d.bytes(int(d.u16()))
+		if d.bad {
+			break
+		}
+		if peerIndex >= len(r.peers) {
+			return fmt.Errorf("unknown peer index %d", peerIndex)
+		}
+
+		rt := Route{
+			Prefix:     prefix,
+			Peer:       r.peers[peerIndex],
+			Originated: time.Unix(int64(originated), 0).UTC(),
+		}
+		if err := parseAttributes(attrs, &rt); err != nil {
+			return fmt.Errorf("%s: %v", prefix, err)
+		}
+		r.pending = append(r.pending, rt)
+	}
+	if d.bad {
+		return fmt.Errorf("truncated RIB entry for %s", prefix)
+	}
+	return nil
+}
+
+// parseAttributes fills in the route fields carried in BGP path attributes
+func parseAttributes(b []byte, rt *Route) error {
+	d := &decoder{b: b}
+	for len(d.b) > 0 && !d.bad {
+		flags := d.u8()
+		code := d.u8()
+		var n int
+		if flags&extendedLen != 0 {
+			n = int(d.u16())
+		} else {
+			n = int(d.u8())
+		}
+		val := d.bytes(n)
+		if d.bad {
+			break
+		}
+
+		switch code {
+		case attrOrigin:
+			if n > 0 {
+				switch val[0] {
+				case 0:
+					rt.Origin = "igp"
+				case 1:
+					rt.Origin = "egp"
+				default:
+					rt.Origin = "incomplete"
+				}
+			}
+		case attrASPath:
+			path, err := parseASPath(val)
+			if err != nil {
+				return err
+			}
+			rt.ASPath = path
+		case attrNextHop:
+			if n == 4 {
+				rt.NextHop, _ = netip.AddrFromSlice(val)
+			}
+		case attrMPReach:
+			rt.NextHop = mpNextHop(val)
+		}
+	}
+	if d.bad {
+		return fmt.Errorf("truncated path attributes")
+	}
+	return nil
+}
+
+// parseASPath decodes an AS_PATH, which TABLE_DUMP_V2 always writes with
+// four byte AS numbers. Confederation segments are local to the peer's
+// network and are left out.
+func parseASPath(b []byte) ([]Segment, error) {
+	d := &decoder{b: b}
+	var path []Segment
+	for len(d.b) > 0 && !d.bad {
+		typ := d.u8()
+		asns := make([]uint32, d.u8())
+		for i := range asns {
+			asns[i] = d.u32()
+		}
+		if typ == segmentSet || typ == segmentSeq {
+			path = append(path, Segment{Set: typ == segmentSet, ASNs: asns})
+		}
+	}
+	if d.bad {
+		return nil, fmt.Errorf("truncated AS path")
+	}
+	return path, nil
+}
+
+// mpNextHop returns the next hop of an MP_REACH_NLRI attribute. RIB entries
+// normally carry only the next hop length and address, but some collectors
+// write the full attribute with AFI and SAFI in front.
+func mpNextHop(b []byte) netip.Addr {
+	if len(b) > 3 && int(b[0]) != len(b)-1 {
+		b = b[3:]
+	}
+	if len(b) == 0 || int(b[0]) > len(b)-1 {
+		return netip.Addr{}
+	}
+	n := int(b[0])
+	if n == 32 {
+		// A global address followed by a link local one
+		n = 16
+	}
+	addr, _ := netip.AddrFromSlice(b[1 : 1+n])
+	return addr
+}
+
+// Load reads a whole dump into a new trie. Each prefix gets one entry built
+// from its best route, the one with the shortest AS path, ties going to the
+// peer listed first:
+//
+//	as_path    the path as text, e.g. "3356 1299 13335"
+//	origin_as  the originating AS, if known
+//	origin     "igp", "egp" or "incomplete"
+//	next_hop   the best route's next hop, if any
+//	peers      how many peers carried the prefix
+func Load(r io.Reader) (*trie.IPTrie, error) {
+	t := trie.NewIPTrie()
+	mr := NewReader(r)
+
+	var best Route
+	peers := 0
+	flush := func() error {
+		if peers == 0 {
+			return nil
+		}
+		return t.Insert(best.Prefix.String(), metadata(best, peers))
+	}
+
+	for {
+		rt, err := mr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if peers > 0 && rt.Prefix != best.Prefix {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			peers = 0
+		}
+		if peers == 0 || rt.PathLen() < best.PathLen() {
+			best = rt
+		}
+		peers++
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// metadata returns the trie metadata for a prefix's best route
+func metadata(rt Route, peers int) map[string]interface{} {
+	md := map[string]interface{}{
+		"as_path": rt.PathString(),
+		"peers":   peers,
+	}
+	if asn, ok := rt.OriginAS(); ok {
+		md["origin_as"] = int(asn)
+	}
+	if rt.Origin != "" {
+		md["origin"] = rt.Origin
+	}
+	if rt.NextHop.IsValid() {
+		md["next_hop"] = rt.NextHop.String()
+	}
+	return md
+}
+
+// Importer returns a pkg/feed importer that loads each fetched dump and
+// swaps it into holder, so lookups keep using the previous table until the
+// new one is complete
+func Importer(holder *trie.TrieHolder) func(r io.Reader) error {
+	return func(r io.Reader) error {
+		t, err := Load(r)
+		if err != nil {
+			return err
+		}
+		holder.Store(t)
+		return nil
+	}
+}
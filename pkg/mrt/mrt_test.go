@@ -0,0 +1,239 @@
+package mrt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// dumpBuilder writes MRT records for tests
+type dumpBuilder struct {
+	bytes.Buffer
+}
+
+func (b *dumpBuilder) record(typ, subtype uint16, body []byte) {
+	var hdr [headerLen]byte
+	binary.BigEndian.PutUint32(hdr[0:], 1700000000)
+	binary.BigEndian.PutUint16(hdr[4:], typ)
+	binary.BigEndian.PutUint16(hdr[6:], subtype)
+	binary.BigEndian.PutUint32(hdr[8:], uint32(len(body)))
+	b.Write(hdr[:])
+	b.Write(body)
+}
+
+// peerIndex writes a PEER_INDEX_TABLE with four byte AS numbers
+func (b *dumpBuilder) peerIndex(peers ...Peer) {
+	var body []byte
+	body = append(body, 192, 0, 2, 1)
+	body = binary.BigEndian.AppendUint16(body, 4)
+	body = append(body, "test"...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(peers)))
+	for _, p := range peers {
+		typ := byte(2)
+		if p.Addr.Is6() {
+			typ |= 1
+		}
+		body = append(body, typ)
+		body = append(body, p.BGPID.AsSlice()...)
+		body = append(body, p.Addr.AsSlice()...)
+		body = binary.BigEndian.AppendUint32(body, p.AS)
+	}
+	b.record(typeTableDumpV2, subtypePeerIndexTable, body)
+}
+
+// ribEntry is one peer's route in a RIB record
+type ribEntry struct {
+	peer    uint16
+	origin  byte
+	path    []Segment
+	nextHop netip.Addr
+}
+
+// rib writes a unicast RIB record for prefix
+func (b *dumpBuilder) rib(prefix string, entries ...ribEntry) {
+	p := netip.MustParsePrefix(prefix)
+	subtype := uint16(subtypeRIBIPv4Unicast)
+	if p.Addr().Is6() {
+		subtype = subtypeRIBIPv6Unicast
+	}
+
+	var body []byte
+	body = binary.BigEndian.AppendUint32(body, 7)
+	body = append(body, byte(p.Bits()))
+	body = append(body, p.Addr().AsSlice()[:(p.Bits()+7)/8]...)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(entries)))
+	for _, e := range entries {
+		var attrs []byte
+		attrs = append(attrs, 0x40, attrOrigin, 1, e.origin)
+
+		var path []byte
+		for _, seg := range e.path {
+			typ := byte(segmentSeq)
+			if seg.Set {
+				typ = segmentSet
+			}
+			path = append(path, typ, byte(len(seg.ASNs)))
+			for _, asn := range seg.ASNs {
+				path = binary.BigEndian.AppendUint32(path, asn)
+			}
+		}
+		attrs = append(attrs, 0x50, attrASPath)
+		attrs = binary.BigEndian.AppendUint16(attrs, uint16(len(path)))
+		attrs = append(attrs, path...)
+
+		if e.nextHop.Is4() {
+			attrs = append(attrs, 0x40, attrNextHop, 4)
+			attrs = append(attrs, e.nextHop.AsSlice()...)
+		} else if e.nextHop.Is6() {
+			attrs = append(attrs, 0x80, attrMPReach, 17, 16)
+			attrs = append(attrs, e.nextHop.AsSlice()...)
+		}
+
+		body = binary.BigEndian.AppendUint16(body, e.peer)
+		body = binary.BigEndian.AppendUint32(body, 1690000000)
+		body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+		body = append(body, attrs...)
+	}
+	b.record(typeTableDumpV2, subtype, body)
+}
+
+func seq(asns ...uint32) Segment {
+	return Segment{ASNs: asns}
+}
+
+func testDump() []byte {
+	var b dumpBuilder
+	b.peerIndex(
+		Peer{BGPID: netip.MustParseAddr("10.0.0.1"), Addr: netip.MustParseAddr("198.51.100.1"), AS: 3356},
+		Peer{BGPID: netip.MustParseAddr("10.0.0.2"), Addr: netip.MustParseAddr("2001:db8::2"), AS: 4200000000},
+	)
+	// An unrelated BGP4MP record, which is skipped
+	b.record(16, 4, []byte{1, 2, 3})
+	b.rib("1.1.1.0/24",
+		ribEntry{peer: 0, path: []Segment{seq(3356, 1299, 13335)}, nextHop: netip.MustParseAddr("198.51.100.1")},
+		ribEntry{peer: 1, path: []Segment{seq(4200000000, 13335)}, nextHop: netip.MustParseAddr("198.51.100.2")},
+	)
+	b.rib("10.0.0.0/8",
+		ribEntry{peer: 0, origin: 2, path: []Segment{seq(3356), {Set: true, ASNs: []uint32{64500, 64501}}}},
+	)
+	b.rib("2001:db8::/32",
+		ribEntry{peer: 1, path: []Segment{seq(4200000000, 64496)}, nextHop: netip.MustParseAddr("2001:db8::2")},
+	)
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	r := NewReader(bytes.NewReader(testDump()))
+
+	var routes []Route
+	for {
+		rt, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next returned error: %v", err)
+		}
+		routes = append(routes, rt)
+	}
+
+	if len(r.Peers()) != 2 || r.Peers()[1].AS != 4200000000 || !r.Peers()[1].Addr.Is6() {
+		t.Errorf("Unexpected peers %+v", r.Peers())
+	}
+	if len(routes) != 4 {
+		t.Fatalf("Expected 4 routes, got %d", len(routes))
+	}
+
+	tests := []struct {
+		route   Route
+		prefix  string
+		path    string
+		pathLen int
+		origin  string
+		nextHop string
+	}{
+		{routes[0], "1.1.1.0/24", "3356 1299 13335", 3, "igp", "198.51.100.1"},
+		{routes[1], "1.1.1.0/24", "4200000000 13335", 2, "igp", "198.51.100.2"},
+		{routes[2], "10.0.0.0/8", "3356 {64500 64501}", 2, "incomplete", "invalid IP"},
+		{routes[3], "2001:db8::/32", "4200000000 64496", 2, "igp", "2001:db8::2"},
+	}
+	for _, tt := range tests {
+		rt := tt.route
+		if rt.Prefix.String() != tt.prefix || rt.PathString() != tt.path || rt.PathLen() != tt.pathLen ||
+			rt.Origin != tt.origin || rt.NextHop.String() != tt.nextHop {
+			t.Errorf("Unexpected route %s %q len %d %s %s", rt.Prefix, rt.PathString(), rt.PathLen(), rt.Origin, rt.NextHop)
+		}
+	}
+
+	if routes[1].Peer.AS != 4200000000 || routes[0].Originated.Unix() != 1690000000 {
+		t.Errorf("Unexpected peer or time %+v %v", routes[1].Peer, routes[0].Originated)
+	}
+	if _, ok := routes[2].OriginAS(); ok {
+		t.Errorf("Expected no origin AS for a path ending in an AS_SET")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tr, err := Load(bytes.NewReader(testDump()))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	tests := []struct {
+		ip       string
+		cidr     string
+		path     string
+		originAS interface{}
+		peers    int
+	}{
+		// The second peer's path is shorter
+		{"1.1.1.1", "1.1.1.0/24", "4200000000 13335", 13335, 2},
+		{"10.1.2.3", "10.0.0.0/8", "3356 {64500 64501}", nil, 1},
+		{"2001:db8::1", "2001:db8::/32", "4200000000 64496", 64496, 1},
+	}
+	for _, tt := range tests {
+		cidr, md, err := tr.Find(tt.ip)
+		if err != nil {
+			t.Errorf("Find(%s) returned error: %v", tt.ip, err)
+			continue
+		}
+		if cidr != tt.cidr || md["as_path"] != tt.path || md["origin_as"] != tt.originAS || md["peers"] != tt.peers {
+			t.Errorf("Find(%s) = %s %v", tt.ip, cidr, md)
+		}
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	dump := testDump()
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"truncated header", dump[:5], "record 1: truncated header"},
+		{"truncated body", dump[:20], "record 1: truncated body"},
+		{"RIB before peers", func() []byte {
+			var b dumpBuilder
+			b.rib("1.1.1.0/24", ribEntry{path: []Segment{seq(1)}})
+			return b.Bytes()
+		}(), "record 1: RIB entry before peer index table"},
+		{"unknown peer", func() []byte {
+			var b dumpBuilder
+			b.peerIndex()
+			b.rib("1.1.1.0/24", ribEntry{peer: 3, path: []Segment{seq(1)}})
+			return b.Bytes()
+		}(), "record 2: unknown peer index 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(bytes.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Package policy loads static prefix classifications from declarative YAML
+// or JSON files, so network teams can keep them in git next to the rest of
+// their configuration.
+//
+// A file lists prefixes with their metadata and may include other files.
+// YAML anchors, aliases and merge keys can be used to share metadata:
+//
+//	include:
+//	  - common.yaml
+//	  - sites/*.yaml
+//	prefixes:
+//	  - cidr: 10.0.0.0/8
+//	    metadata: &corp
+//	      owner: netops
+//	  - cidr: 10.20.0.0/16
+//	    metadata:
+//	      <<: *corp
+//	      site: ams1
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// file is the layout of one policy file
+type file struct {
+	// Include lists files, or glob patterns, relative to this file
+	Include  []string    `yaml:"include"`
+	Prefixes []yaml.Node `yaml:"prefixes"`
+}
+
+// entry is one prefix of a policy file
+type entry struct {
+	CIDR     string                 `yaml:"cidr"`
+	Metadata map[string]interface{} `yaml:"metadata"`
+}
+
+// LoadConfig builds a trie from a policy file and everything it includes.
+// Included files are loaded before the prefixes of the including file, so
+// a file can override what it includes. Unknown fields are rejected to
+// catch typos.
+func LoadConfig(path string, opts ...trie.Option) (*trie.IPTrie, error) {
+	t := trie.NewIPTrie(opts...)
+	if err := load(t, path, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// load inserts the prefixes of path and its includes into t. active holds
+// the files being loaded further up, to detect include cycles.
+func load(t *trie.IPTrie, path string, active map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if active[abs] {
+		return fmt.Errorf("%s: include cycle", path)
+	}
+	active[abs] = true
+	defer delete(active, abs)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f file
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, pattern := range f.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: include %s: %v", path, pattern, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: include %s: no such file", path, pattern)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			if err := load(t, m, active); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, node := range f.Prefixes {
+		if err := checkFields(&node); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, node.Line, err)
+		}
+		var e entry
+		if err := node.Decode(&e); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, node.Line, err)
+		}
+		if e.CIDR == "" {
+			return fmt.Errorf("%s:%d: prefix without cidr", path, node.Line)
+		}
+		if e.Metadata == nil {
+			e.Metadata = map[string]interface{}{}
+		}
+		if err := t.Insert(e.CIDR, e.Metadata); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, node.Line, err)
+		}
+	}
+	return nil
+}
+
+// checkFields rejects unknown keys in a prefix entry. Node.Decode does not
+// apply the decoder's KnownFields setting, so they are checked by hand.
+func checkFields(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		switch key := node.Content[i].Value; key {
+		case "cidr", "metadata", "<<":
+		default:
+			return fmt.Errorf("unknown field %q", key)
+		}
+	}
+	return nil
+}
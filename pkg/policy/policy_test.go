@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// writeFiles creates files under a new temporary directory and returns it
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.yaml": `
+include:
+  - common.json
+  - sites/*.yaml
+prefixes:
+  - cidr: 10.0.0.0/8
+    metadata: &corp
+      owner: netops
+      tags: [internal, corp]
+  - cidr: 10.20.0.0/16
+    metadata:
+      <<: *corp
+      site: ams1
+  # Overrides the included definition
+  - cidr: 192.0.2.0/24
+    metadata: {owner: docs-team}
+`,
+		"common.json": `{"prefixes": [
+  {"cidr": "192.0.2.0/24", "metadata": {"owner": "docs"}},
+  {"cidr": "2001:db8::/32", "metadata": {"owner": "docs", "vlan": 12}}
+]}`,
+		"sites/b.yaml": "prefixes:\n  - cidr: 10.30.0.0/16\n    metadata: {site: fra1}\n",
+		"sites/a.yaml": "prefixes:\n  - cidr: 10.40.0.0/16\n",
+	})
+
+	tr, err := LoadConfig(filepath.Join(dir, "main.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	if tr.Len() != 6 {
+		t.Errorf("Expected 6 prefixes, got %d", tr.Len())
+	}
+
+	tests := []struct {
+		ip   string
+		cidr string
+		key  string
+		want interface{}
+	}{
+		{"10.20.1.1", "10.20.0.0/16", "owner", "netops"},
+		{"10.20.1.1", "10.20.0.0/16", "site", "ams1"},
+		{"10.30.1.1", "10.30.0.0/16", "site", "fra1"},
+		{"10.40.1.1", "10.40.0.0/16", "site", nil},
+		{"192.0.2.1", "192.0.2.0/24", "owner", "docs-team"},
+		{"2001:db8::1", "2001:db8::/32", "vlan", 12},
+	}
+	for _, tt := range tests {
+		cidr, md, err := tr.Find(tt.ip)
+		if err != nil || cidr != tt.cidr || md[tt.key] != tt.want {
+			t.Errorf("Find(%s) = %s %v (%v), expected %s=%v", tt.ip, cidr, md, err, tt.key, tt.want)
+		}
+	}
+
+	_, md, _ := tr.Find("10.20.1.1")
+	if tags, ok := md["tags"].([]interface{}); !ok || len(tags) != 2 {
+		t.Errorf("Expected tags merged from the anchor, got %#v", md["tags"])
+	}
+}
+
+func TestLoadConfigOptions(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.yaml": "prefixes:\n  - cidr: 10.0.0.0/8\n    metadata: {Owner: netops}\n",
+	})
+	tr, err := LoadConfig(filepath.Join(dir, "main.yaml"), trie.WithKeyNormalizer(trie.LowerKeys))
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	if _, md, _ := tr.Find("10.0.0.1"); md["owner"] != "netops" {
+		t.Errorf("Expected normalized key, got %v", md)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"cycle", map[string]string{
+			"main.yaml":  "include: [other.yaml]\n",
+			"other.yaml": "include: [main.yaml]\n",
+		}, "main.yaml: include cycle"},
+		{"missing include", map[string]string{
+			"main.yaml": "include: [nope.yaml]\n",
+		}, "nope.yaml: no such file"},
+		{"unknown top level field", map[string]string{
+			"main.yaml": "prefix:\n  - cidr: 10.0.0.0/8\n",
+		}, "field prefix not found"},
+		{"unknown entry field", map[string]string{
+			"main.yaml": "prefixes:\n  - cidr: 10.0.0.0/8\n    metdata: {}\n",
+		}, `main.yaml:2: unknown field "metdata"`},
+		{"missing cidr", map[string]string{
+			"main.yaml": "prefixes:\n  - metadata: {a: b}\n",
+		}, "main.yaml:2: prefix without cidr"},
+		{"bad cidr", map[string]string{
+			"main.yaml": "prefixes:\n  - cidr: 10.0.0.0/8\n  - cidr: 10.0.0.0/40\n",
+		}, "main.yaml:3: invalid CIDR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeFiles(t, tt.files)
+			_, err := LoadConfig(filepath.Join(dir, "main.yaml"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+// Package proxyproto accepts connections that start with a PROXY protocol
+// header (version 1 or 2), as sent by HAProxy, AWS NLBs and other layer 4
+// load balancers, and reports the original client as the connection's
+// remote address. HTTP and gRPC servers using the listener, and the
+// middleware classifying their clients, then see the real client address
+// instead of the load balancer's.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// v2Signature starts every version 2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1Prefix starts every version 1 header
+var v1Prefix = []byte("PROXY ")
+
+const (
+	// v1MaxLen is the longest valid version 1 header, CRLF included
+	v1MaxLen = 107
+	// defaultTimeout bounds how long a connection may take to send its header
+	defaultTimeout = 5 * time.Second
+)
+
+// Listener wraps a listener whose connections come through PROXY protocol
+// speaking load balancers
+type Listener struct {
+	net.Listener
+	// Trusted are the load balancers allowed to send a header. Headers from
+	// anyone else are not parsed and reach the server as ordinary data, so
+	// clients cannot claim arbitrary addresses. Without any, no connection
+	// is trusted.
+	Trusted []netip.Prefix
+	// TrustAll parses headers from every connection, for listeners only the
+	// load balancers can reach
+	TrustAll bool
+	// Timeout bounds reading the header, 5 seconds if zero
+	Timeout time.Duration
+}
+
+// Accept waits for the next connection. Its header is read on the first
+// Read or RemoteAddr call, so a slow client does not hold up the accept
+// loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &Conn{Conn: c, r: bufio.NewReader(c), timeout: timeout}, nil
+}
+
+// trusted reports whether addr may send a header
+func (l *Listener) trusted(addr net.Addr) bool {
+	if l.TrustAll {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, p := range l.Trusted {
+		if p.Contains(ap.Addr().Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection whose remote address comes from its PROXY header.
+// Connections without a header keep their own addresses.
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+}
+
+// Read reads data following the header
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address from the header
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to, from the header
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader consumes the header, if there is one, and records its addresses
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	src, dst, err := readHeader(c.r)
+	if err != nil {
+		c.err = fmt.Errorf("PROXY header from %s: %v", c.Conn.RemoteAddr(), err)
+		c.Conn.Close()
+		return
+	}
+	if src.IsValid() {
+		c.remote = net.TCPAddrFromAddrPort(src)
+		c.local = net.TCPAddrFromAddrPort(dst)
+	}
+}
+
+// readHeader reads a version 1 or 2 header from r. It returns invalid
+// addresses when there is no header or it carries none, as for health checks
+// sent by the load balancer itself.
+func readHeader(r *bufio.Reader) (src, dst netip.AddrPort, err error) {
+	peek, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		if err == io.EOF {
+			// Closed before sending anything
+			return src, dst, nil
+		}
+		return src, dst, err
+	}
+	if bytes.Equal(peek, v1Prefix) {
+		return readV1(r)
+	}
+	if peek, err := r.Peek(len(v2Signature)); err == nil && bytes.Equal(peek, v2Signature) {
+		return readV2(r)
+	}
+	return src, dst, nil
+}
+
+// readV1 parses a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readV1(r *bufio.Reader) (src, dst netip.AddrPort, err error) {
+	var line []byte
+	for len(line) < v1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return src, dst, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return src, dst, fmt.Errorf("version 1 header not terminated")
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return src, dst, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return src, dst, fmt.Errorf("invalid version 1 header %q", line)
+	}
+	if src, err = parseAddrPort(fields[2], fields[4]); err != nil {
+		return src, dst, err
+	}
+	if dst, err = parseAddrPort(fields[3], fields[5]); err != nil {
+		return src, dst, err
+	}
+	if src.Addr().Is4() != (fields[1] == "TCP4") {
+		return src, dst, fmt.Errorf("address %s does not match %s", src.Addr(), fields[1])
+	}
+	return src, dst, nil
+}
+
+// parseAddrPort parses the address and port fields of a version 1 header
+func parseAddrPort(addr, port string) (netip.AddrPort, error) {
+	a, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", addr)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port %q", port)
+	}
+	return netip.AddrPortFrom(a, uint16(p)), nil
+}
+
+// readV2 parses a binary header. Only the addresses of TCP and UDP over
+// IPv4 or IPv6 are used; TLVs and other families are skipped.
+func readV2(r *bufio.Reader) (src, dst netip.AddrPort, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return src, dst, err
+	}
+	verCmd, family := hdr[12], hdr[13]
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return src, dst, err
+	}
+
+	if verCmd>>4 != 2 {
+		return src, dst, fmt.Errorf("unsupported version %d", verCmd>>4)
+	}
+	switch verCmd & 0xf {
+	case 0:
+		// LOCAL, e.g. a health check from the load balancer itself
+		return src, dst, nil
+	case 1:
+		// PROXY
+	default:
+		return src, dst, fmt.Errorf("unsupported command %d", verCmd&0xf)
+	}
+
+	var size int
+	switch family >> 4 {
+	case 1:
+		size = 4
+	case 2:
+		size = 16
+	default:
+		return src, dst, nil
+	}
+	if len(body) < 2*size+4 {
+		return src, dst, fmt.Errorf("address block too short")
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:size])
+	dstIP, _ := netip.AddrFromSlice(body[size : 2*size])
+	src = netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(body[2*size:]))
+	dst = netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(body[2*size+2:]))
+	return src, dst, nil
+}
@@ -0,0 +1,129 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v2Header builds a version 2 PROXY header for TCP
+func v2Header(src, dst netip.AddrPort, tlvs []byte) []byte {
+	family := byte(0x11)
+	if src.Addr().Is6() {
+		family = 0x21
+	}
+	var body []byte
+	body = append(body, src.Addr().AsSlice()...)
+	body = append(body, dst.Addr().AsSlice()...)
+	body = binary.BigEndian.AppendUint16(body, src.Port())
+	body = binary.BigEndian.AppendUint16(body, dst.Port())
+	body = append(body, tlvs...)
+
+	hdr := append([]byte{}, v2Signature...)
+	hdr = append(hdr, 0x21, family)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(body)))
+	return append(hdr, body...)
+}
+
+func TestReadHeader(t *testing.T) {
+	v4src := netip.MustParseAddrPort("192.0.2.1:56324")
+	v4dst := netip.MustParseAddrPort("198.51.100.1:443")
+	v6src := netip.MustParseAddrPort("[2001:db8::1]:56324")
+	v6dst := netip.MustParseAddrPort("[2001:db8::2]:443")
+	local := append(append([]byte{}, v2Signature...), 0x20, 0x00, 0, 0)
+
+	tests := []struct {
+		name    string
+		input   string
+		src     string
+		rest    string
+		wantErr bool
+	}{
+		{name: "v1 TCP4", input: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET /", src: "192.0.2.1:56324", rest: "GET /"},
+		{name: "v1 TCP6", input: "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET /", src: "[2001:db8::1]:56324", rest: "GET /"},
+		{name: "v1 unknown", input: "PROXY UNKNOWN\r\nGET /", src: "invalid AddrPort", rest: "GET /"},
+		{name: "v1 family mismatch", input: "PROXY TCP4 2001:db8::1 2001:db8::2 1 2\r\n", wantErr: true},
+		{name: "v1 bad port", input: "PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n", wantErr: true},
+		{name: "v1 unterminated", input: "PROXY TCP4 " + strings.Repeat("1", 200), wantErr: true},
+		{name: "v2 IPv4", input: string(v2Header(v4src, v4dst, nil)) + "GET /", src: "192.0.2.1:56324", rest: "GET /"},
+		{name: "v2 IPv6 with TLVs", input: string(v2Header(v6src, v6dst, []byte{0x04, 0, 1, 'x'})) + "GET /", src: "[2001:db8::1]:56324", rest: "GET /"},
+		{name: "v2 local", input: string(local) + "GET /", src: "invalid AddrPort", rest: "GET /"},
+		{name: "v2 truncated", input: string(v2Header(v4src, v4dst, nil)[:20]), wantErr: true},
+		{name: "no header", input: "GET / HTTP/1.1\r\n", src: "invalid AddrPort", rest: "GET / HTTP/1.1\r\n"},
+		{name: "short input", input: "hi", src: "invalid AddrPort", rest: "hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			src, _, err := readHeader(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got source %s", src)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readHeader returned error: %v", err)
+			}
+			rest, _ := io.ReadAll(r)
+			if src.String() != tt.src || string(rest) != tt.rest {
+				t.Errorf("Expected %s and %q, got %s and %q", tt.src, tt.rest, src, rest)
+			}
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		trusted []netip.Prefix
+		all     bool
+		want    string
+		data    string
+	}{
+		{name: "trusted", trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, want: "203.0.113.9:1234", data: "hello"},
+		{name: "untrusted", trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, want: "127.0.0.1", data: "PROXY TCP4 203.0.113.9 127.0.0.1 1234 80\r\nhello"},
+		{name: "none trusted", want: "127.0.0.1", data: "PROXY TCP4 203.0.113.9 127.0.0.1 1234 80\r\nhello"},
+		{name: "trust all", all: true, want: "203.0.113.9:1234", data: "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{Listener: inner, Trusted: tt.trusted, TrustAll: tt.all, Timeout: time.Second}
+
+			go func() {
+				c, err := net.Dial("tcp", inner.Addr().String())
+				if err != nil {
+					return
+				}
+				defer c.Close()
+				io.WriteString(c, "PROXY TCP4 203.0.113.9 127.0.0.1 1234 80\r\nhello")
+			}()
+
+			c, err := l.Accept()
+			if err != nil {
+				t.Fatalf("Accept returned error: %v", err)
+			}
+			defer c.Close()
+
+			if got := c.RemoteAddr().String(); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Expected remote address %s, got %s", tt.want, got)
+			}
+			data, _ := io.ReadAll(c)
+			if string(data) != tt.data {
+				t.Errorf("Expected data %q, got %q", tt.data, data)
+			}
+		})
+	}
+	inner.Close()
+}
@@ -0,0 +1,262 @@
+// Package redismirror shares one prefix dataset between service instances
+// through Redis. Each instance answers lookups from its own in-memory trie;
+// writes go to a Redis hash and are announced on a pub/sub channel, on
+// which every instance re-reads the announced prefix from the hash. After
+// subscribing, and again whenever the subscription reconnects, an instance
+// rebuilds its trie from the whole hash, so changes announced while it was
+// away are not lost.
+//
+// For a dataset named "prefixes" the hash is "prefixes:data", keyed by the
+// masked prefix, and the channel is "prefixes:changes". Metadata is stored
+// as JSON, so numbers come back as float64 and lists as []interface{}, on
+// the writing instance as well as the others.
+package redismirror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// scanCount is the number of hash fields requested per HSCAN call
+const scanCount = 1000
+
+// entry is the stored value of one prefix
+type entry struct {
+	CIDR     string                 `json:"cidr"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Mirror is one instance's view of a shared dataset. It is safe for
+// concurrent use.
+type Mirror struct {
+	client  redis.UniversalClient
+	hash    string
+	channel string
+	opts    []trie.Option
+
+	mu   sync.RWMutex
+	trie *trie.IPTrie
+}
+
+// New returns a mirror of the dataset called name, with an empty trie
+// configured by opts. Call Rebuild or Run to load the data.
+func New(client redis.UniversalClient, name string, opts ...trie.Option) *Mirror {
+	return &Mirror{
+		client:  client,
+		hash:    name + ":data",
+		channel: name + ":changes",
+		opts:    opts,
+		trie:    trie.NewIPTrie(opts...),
+	}
+}
+
+// field returns the hash field of a CIDR: the masked prefix, so that CIDRs
+// written with host bits set still name one prefix
+func field(cidr string) (string, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR: %v", err)
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked().String(), nil
+}
+
+// Insert stores a CIDR with metadata in Redis, applies it locally and
+// announces it to the other instances. The change is checked against the
+// trie options before anything is written.
+func (m *Mirror) Insert(ctx context.Context, cidr string, metadata map[string]interface{}) error {
+	f, err := field(cidr)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(entry{CIDR: cidr, Metadata: metadata})
+	if err != nil {
+		return fmt.Errorf("encode %s: %v", cidr, err)
+	}
+	e, err := decode(value)
+	if err != nil {
+		return err
+	}
+	if err := trie.NewIPTrie(m.opts...).Insert(e.CIDR, e.Metadata); err != nil {
+		return err
+	}
+
+	if err := m.publish(ctx, f, func(pipe redis.Pipeliner) {
+		pipe.HSet(ctx, m.hash, f, value)
+	}); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.trie.Insert(e.CIDR, e.Metadata)
+}
+
+// Delete removes a CIDR from Redis and locally and announces it. Deleting
+// a CIDR that is not stored is not an error.
+func (m *Mirror) Delete(ctx context.Context, cidr string) error {
+	f, err := field(cidr)
+	if err != nil {
+		return err
+	}
+	if err := m.publish(ctx, f, func(pipe redis.Pipeliner) {
+		pipe.HDel(ctx, m.hash, f)
+	}); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _, err = m.trie.Remove(f)
+	return err
+}
+
+// publish runs write and announces the change to f in one transaction
+func (m *Mirror) publish(ctx context.Context, f string, write func(redis.Pipeliner)) error {
+	_, err := m.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		write(pipe)
+		pipe.Publish(ctx, m.channel, f)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis: %v", err)
+	}
+	return nil
+}
+
+// decode unmarshals a stored value
+func decode(value []byte) (entry, error) {
+	var e entry
+	if err := json.Unmarshal(value, &e); err != nil {
+		return entry{}, fmt.Errorf("corrupt entry: %v", err)
+	}
+	return e, nil
+}
+
+// Rebuild replaces the local trie with the whole dataset read from Redis.
+// Lookups keep using the previous trie until the new one is complete.
+func (m *Mirror) Rebuild(ctx context.Context) error {
+	next := trie.NewIPTrie(m.opts...)
+	iter := m.client.HScan(ctx, m.hash, 0, "", scanCount).Iterator()
+	for iter.Next(ctx) {
+		f := iter.Val()
+		if !iter.Next(ctx) {
+			break
+		}
+		e, err := decode([]byte(iter.Val()))
+		if err != nil {
+			return fmt.Errorf("%s: %v", f, err)
+		}
+		if err := next.Insert(e.CIDR, e.Metadata); err != nil {
+			return fmt.Errorf("%s: %v", f, err)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("redis: %v", err)
+	}
+
+	m.mu.Lock()
+	m.trie = next
+	m.mu.Unlock()
+	return nil
+}
+
+// refresh re-reads one announced prefix from Redis. Instances refresh
+// their own changes too, which is cheap and keeps a write racing with
+// Rebuild from being lost.
+func (m *Mirror) refresh(ctx context.Context, f string) error {
+	value, err := m.client.HGet(ctx, m.hash, f).Bytes()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("redis: %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == redis.Nil {
+		_, _, err = m.trie.Remove(f)
+		return err
+	}
+	e, err := decode(value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", f, err)
+	}
+	return m.trie.Insert(e.CIDR, e.Metadata)
+}
+
+// Run subscribes to changes and keeps the local trie in step until ctx is
+// done. It rebuilds the trie each time the subscription is established.
+// Errors that do not stop it, such as a lost connection or an entry that
+// fails to load, are passed to onError, which may be nil.
+func (m *Mirror) Run(ctx context.Context, onError func(error)) error {
+	report := func(err error) {
+		if onError != nil {
+			onError(err)
+		}
+	}
+
+	sub := m.client.Subscribe(ctx, m.channel)
+	defer sub.Close()
+	// Receive does not return on cancellation while it waits for a message
+	stop := context.AfterFunc(ctx, func() { sub.Close() })
+	defer stop()
+	for {
+		msg, err := sub.Receive(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// The next Receive reconnects and resubscribes
+			report(fmt.Errorf("redis: %v", err))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				if err := m.Rebuild(ctx); err != nil {
+					report(err)
+				}
+			}
+		case *redis.Message:
+			if err := m.refresh(ctx, msg.Payload); err != nil {
+				report(err)
+			}
+		}
+	}
+}
+
+// Find returns the most specific prefix containing ip in the local trie
+func (m *Mirror) Find(ip string) (string, map[string]interface{}, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.trie.Find(ip)
+}
+
+// FindAll returns every prefix containing ip in the local trie
+func (m *Mirror) FindAll(ip string) ([]trie.Match, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.trie.FindAll(ip)
+}
+
+// Len returns the number of prefixes in the local trie
+func (m *Mirror) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.trie.Len()
+}
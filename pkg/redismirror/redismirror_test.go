@@ -0,0 +1,126 @@
+package redismirror
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func newClient(t *testing.T, mr *miniredis.Miniredis) *redis.Client {
+	c := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// eventually polls cond until it holds or a second has passed
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// start runs m until the test ends
+func start(t *testing.T, m *Mirror) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Run(ctx, func(err error) { t.Logf("Run: %v", err) })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestMirrorSharesChanges(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	// Data written before an instance starts is loaded by Run
+	a := New(newClient(t, mr), "prefixes")
+	if err := a.Insert(ctx, "10.0.0.0/8", map[string]interface{}{"owner": "netops", "vlan": 10}); err != nil {
+		t.Fatalf("Insert returned error: %v", err)
+	}
+	if _, md, err := a.Find("10.1.2.3"); err != nil || md["vlan"] != float64(10) {
+		t.Errorf("Expected the writer to see the JSON form of its metadata, got %v %v", md, err)
+	}
+
+	b := New(newClient(t, mr), "prefixes")
+	start(t, a)
+	start(t, b)
+	eventually(t, "b to load 10.0.0.0/8", func() bool {
+		cidr, _, _ := b.Find("10.1.2.3")
+		return cidr == "10.0.0.0/8"
+	})
+
+	// Changes by one instance reach the other
+	if err := a.Insert(ctx, "10.1.0.0/16", map[string]interface{}{"owner": "dev"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "b to see 10.1.0.0/16", func() bool {
+		cidr, _, _ := b.Find("10.1.2.3")
+		return cidr == "10.1.0.0/16"
+	})
+	if err := b.Insert(ctx, "10.1.2.3/16", map[string]interface{}{"owner": "qa"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "a to see the update", func() bool {
+		_, md, _ := a.Find("10.1.2.3")
+		return md["owner"] == "qa"
+	})
+	if a.Len() != 2 {
+		t.Errorf("Expected the update to replace 10.1.0.0/16, got %d prefixes", a.Len())
+	}
+	if err := b.Delete(ctx, "10.1.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "a to see the delete", func() bool {
+		cidr, _, _ := a.Find("10.1.2.3")
+		return cidr == "10.0.0.0/8"
+	})
+
+	matches, err := b.FindAll("10.1.2.3")
+	if err != nil || len(matches) != 1 || matches[0].CIDR != "10.0.0.0/8" {
+		t.Errorf("Unexpected FindAll result %v, %v", matches, err)
+	}
+}
+
+func TestMirrorValidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	m := New(newClient(t, mr), "prefixes", trie.WithCIDRMode(trie.CIDRStrict))
+
+	if err := m.Insert(ctx, "10.1.1.1/8", nil); err == nil {
+		t.Errorf("Expected the trie options to reject a CIDR with host bits set")
+	}
+	if err := m.Insert(ctx, "bogus", nil); err == nil {
+		t.Errorf("Expected error for an invalid CIDR")
+	}
+	if err := m.Insert(ctx, "10.0.0.0/8", map[string]interface{}{"bad": make(chan int)}); err == nil {
+		t.Errorf("Expected error for unencodable metadata")
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("Expected nothing written to Redis, got keys %v", keys)
+	}
+
+	// A corrupt entry fails the rebuild and keeps the current trie
+	_ = m.Insert(ctx, "10.0.0.0/8", nil)
+	mr.HSet("prefixes:data", "192.168.0.0/16", "not json")
+	if err := m.Rebuild(ctx); err == nil {
+		t.Errorf("Expected error rebuilding from a corrupt entry")
+	}
+	if m.Len() != 1 {
+		t.Errorf("Expected the trie to be kept, got %d prefixes", m.Len())
+	}
+}
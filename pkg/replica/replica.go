@@ -0,0 +1,419 @@
+// Package replica keeps follower tries in step with a leader over TCP, for
+// fleets of lookup servers that must keep answering when any one of them
+// is down. A follower that connects receives a snapshot of the leader's
+// trie followed by every later change, each numbered with a sequence
+// number; it rebuilds from a fresh snapshot whenever it reconnects, so
+// followers are eventually consistent with the leader.
+//
+// Messages are JSON objects, one per line. Each carries the sequence
+// number of the last change it reflects and at most one of a snapshot or
+// an event; a message with neither is a heartbeat. Metadata travels as
+// JSON, so numbers come back as float64 and lists as []interface{} on the
+// followers, as with IPTrie.UnmarshalJSON.
+package replica
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Defaults for zero Config and FollowerConfig fields
+const (
+	defaultBuffer    = 1024
+	defaultHeartbeat = 5 * time.Second
+	defaultTimeout   = 15 * time.Second
+	defaultRetry     = time.Second
+)
+
+// message is one line of the stream
+type message struct {
+	Seq      uint64          `json:"seq"`
+	Snapshot json.RawMessage `json:"snapshot,omitempty"`
+	Event    *trie.Event     `json:"event,omitempty"`
+}
+
+// Config tunes a Leader
+type Config struct {
+	// Buffer is the number of changes queued for each follower. A follower
+	// that falls further behind is disconnected and resyncs from a
+	// snapshot when it reconnects. Defaults to 1024.
+	Buffer int
+	// Heartbeat is how often an idle follower is sent a heartbeat.
+	// Defaults to 5s.
+	Heartbeat time.Duration
+	// Timeout bounds each write to a follower. Defaults to 15s.
+	Timeout time.Duration
+}
+
+// Leader owns the trie that followers replicate. It is safe for concurrent
+// use.
+type Leader struct {
+	cfg Config
+
+	mu        sync.RWMutex
+	trie      *trie.IPTrie
+	seq       uint64
+	followers map[*follower]struct{}
+	closed    bool
+}
+
+// follower is the leader's side of one connection
+type follower struct {
+	// changes is closed when the follower falls behind or the leader closes
+	changes chan message
+}
+
+// NewLeader returns a leader replicating t. The leader takes ownership of
+// t: from now on it must only be changed through the leader, which
+// serializes changes with the snapshots sent to new followers.
+func NewLeader(t *trie.IPTrie, cfg Config) *Leader {
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = defaultBuffer
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = defaultHeartbeat
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	l := &Leader{cfg: cfg, trie: t, followers: map[*follower]struct{}{}}
+	t.OnChange(l.publish)
+	return l
+}
+
+// publish is the trie's change hook. It runs inside Insert and Delete,
+// with l.mu held.
+func (l *Leader) publish(e trie.Event) {
+	e.Previous = nil
+	if e.Type == trie.EventDelete {
+		e.Metadata = nil
+	}
+	l.seq++
+	msg := message{Seq: l.seq, Event: &e}
+	for f := range l.followers {
+		select {
+		case f.changes <- msg:
+		default:
+			close(f.changes)
+			delete(l.followers, f)
+		}
+	}
+}
+
+// Insert stores a CIDR with metadata and sends the change to the followers
+func (l *Leader) Insert(cidr string, metadata map[string]interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.trie.Insert(cidr, metadata)
+}
+
+// InsertSource stores one source's metadata for a CIDR, like
+// IPTrie.InsertSource, and sends the change to the followers
+func (l *Leader) InsertSource(cidr, source string, metadata map[string]interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.trie.InsertSource(cidr, source, metadata)
+}
+
+// Delete removes a CIDR and sends the change to the followers
+func (l *Leader) Delete(cidr string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.trie.Delete(cidr)
+}
+
+// Find returns the most specific prefix containing ip
+func (l *Leader) Find(ip string) (string, map[string]interface{}, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.trie.Find(ip)
+}
+
+// FindAll returns every prefix containing ip
+func (l *Leader) FindAll(ip string) ([]trie.Match, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.trie.FindAll(ip)
+}
+
+// Len returns the number of stored prefixes
+func (l *Leader) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.trie.Len()
+}
+
+// Seq returns the sequence number of the last change
+func (l *Leader) Seq() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.seq
+}
+
+// Followers returns the number of connected followers
+func (l *Leader) Followers() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.followers)
+}
+
+// Serve accepts followers on ln until it fails, serving each on its own
+// goroutine. Closing ln stops Serve but leaves connected followers be;
+// Close disconnects them.
+func (l *Leader) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go l.serveConn(conn)
+	}
+}
+
+// Close disconnects every follower and refuses new ones. The trie can still
+// be changed and queried.
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for f := range l.followers {
+		close(f.changes)
+		delete(l.followers, f)
+	}
+	return nil
+}
+
+// serveConn sends one follower a snapshot and then every change
+func (l *Leader) serveConn(conn net.Conn) error {
+	defer conn.Close()
+
+	// The snapshot and the registration happen under one lock, so the
+	// follower is queued exactly the changes after the snapshot
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return errors.New("replica: leader closed")
+	}
+	snap, err := l.trie.MarshalJSON()
+	if err != nil {
+		l.mu.Unlock()
+		return fmt.Errorf("replica: snapshot: %v", err)
+	}
+	f := &follower{changes: make(chan message, l.cfg.Buffer)}
+	l.followers[f] = struct{}{}
+	seq := l.seq
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		if _, ok := l.followers[f]; ok {
+			close(f.changes)
+			delete(l.followers, f)
+		}
+		l.mu.Unlock()
+	}()
+
+	w := bufio.NewWriter(conn)
+	enc := json.NewEncoder(w)
+	send := func(msg message) error {
+		conn.SetWriteDeadline(time.Now().Add(l.cfg.Timeout))
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+		// Flush once the queue is drained, so bursts are written together
+		if len(f.changes) == 0 {
+			return w.Flush()
+		}
+		return nil
+	}
+
+	if err := send(message{Seq: seq, Snapshot: snap}); err != nil {
+		return err
+	}
+	heartbeat := time.NewTicker(l.cfg.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case msg, ok := <-f.changes:
+			if !ok {
+				return errors.New("replica: follower fell behind or leader closed")
+			}
+			seq = msg.Seq
+			if err := send(msg); err != nil {
+				return err
+			}
+			heartbeat.Reset(l.cfg.Heartbeat)
+		case <-heartbeat.C:
+			if err := send(message{Seq: seq}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// FollowerConfig tunes a Follower
+type FollowerConfig struct {
+	// Timeout is how long the follower waits for a message, heartbeats
+	// included, before reconnecting. It should be a few times the leader's
+	// Heartbeat. Defaults to 15s.
+	Timeout time.Duration
+	// Retry is the delay before reconnecting after an error. Defaults to
+	// 1s.
+	Retry time.Duration
+}
+
+// Follower is a read-only copy of a leader's trie. It is safe for
+// concurrent use.
+type Follower struct {
+	addr string
+	cfg  FollowerConfig
+	opts []trie.Option
+
+	mu     sync.RWMutex
+	trie   *trie.IPTrie
+	seq    uint64
+	synced bool
+}
+
+// NewFollower returns a follower of the leader at addr, with an empty trie
+// configured by opts, which should match the leader's. Call Run to
+// connect.
+func NewFollower(addr string, cfg FollowerConfig, opts ...trie.Option) *Follower {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Retry <= 0 {
+		cfg.Retry = defaultRetry
+	}
+	return &Follower{addr: addr, cfg: cfg, opts: opts, trie: trie.NewIPTrie(opts...)}
+}
+
+// Run follows the leader until ctx is done, reconnecting after errors.
+// Lookups are answered from the last state received while disconnected.
+// Errors that do not stop it, such as a lost connection or a change that
+// fails to apply, are passed to onError, which may be nil.
+func (f *Follower) Run(ctx context.Context, onError func(error)) error {
+	for {
+		err := f.follow(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		f.mu.Lock()
+		f.synced = false
+		f.mu.Unlock()
+		if onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(f.cfg.Retry):
+		}
+	}
+}
+
+// follow runs one connection to the leader until it fails
+func (f *Follower) follow(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", f.addr)
+	if err != nil {
+		return fmt.Errorf("replica: %v", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	first := true
+	for {
+		conn.SetReadDeadline(time.Now().Add(f.cfg.Timeout))
+		var msg message
+		if err := dec.Decode(&msg); err != nil {
+			return fmt.Errorf("replica: %v", err)
+		}
+		if first != (msg.Snapshot != nil) {
+			return errors.New("replica: expected a snapshot first and only first")
+		}
+		first = false
+		if err := f.apply(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// apply brings the trie up to date with one message
+func (f *Follower) apply(msg message) error {
+	if msg.Snapshot != nil {
+		next := trie.NewIPTrie(f.opts...)
+		if err := next.UnmarshalJSON(msg.Snapshot); err != nil {
+			return fmt.Errorf("replica: %v", err)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.trie, f.seq, f.synced = next, msg.Seq, true
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if msg.Event == nil {
+		if msg.Seq != f.seq {
+			return fmt.Errorf("replica: heartbeat at change %d, have %d", msg.Seq, f.seq)
+		}
+		return nil
+	}
+	if msg.Seq != f.seq+1 {
+		return fmt.Errorf("replica: got change %d after %d", msg.Seq, f.seq)
+	}
+	// The leader prepared the metadata before sending it
+	if err := f.trie.Apply(*msg.Event); err != nil {
+		// The trie no longer matches the leader; reconnecting resyncs it
+		return fmt.Errorf("replica: apply change %d: %v", msg.Seq, err)
+	}
+	f.seq = msg.Seq
+	return nil
+}
+
+// Seq returns the sequence number of the last change applied
+func (f *Follower) Seq() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.seq
+}
+
+// Synced reports whether the follower is connected and has loaded a
+// snapshot, so its answers lag the leader by no more than the changes in
+// flight
+func (f *Follower) Synced() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.synced
+}
+
+// Find returns the most specific prefix containing ip
+func (f *Follower) Find(ip string) (string, map[string]interface{}, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.trie.Find(ip)
+}
+
+// FindAll returns every prefix containing ip
+func (f *Follower) FindAll(ip string) ([]trie.Match, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.trie.FindAll(ip)
+}
+
+// Len returns the number of prefixes in the local trie
+func (f *Follower) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.trie.Len()
+}
@@ -0,0 +1,238 @@
+package replica
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// eventually polls cond until it holds or a second has passed
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// serve runs l on a local listener until the test ends and returns its
+// address
+func serve(t *testing.T, l *Leader) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go l.Serve(ln)
+	t.Cleanup(func() {
+		ln.Close()
+		l.Close()
+	})
+	return ln.Addr().String()
+}
+
+// start runs f until the test ends
+func start(t *testing.T, f *Follower) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.Run(ctx, func(err error) { t.Logf("Run: %v", err) })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestReplication(t *testing.T) {
+	tr := trie.NewIPTrie()
+	if err := tr.Insert("10.0.0.0/8", map[string]interface{}{"owner": "netops", "vlan": 10}); err != nil {
+		t.Fatal(err)
+	}
+	l := NewLeader(tr, Config{Heartbeat: 20 * time.Millisecond})
+	addr := serve(t, l)
+
+	f := NewFollower(addr, FollowerConfig{Timeout: 200 * time.Millisecond, Retry: 10 * time.Millisecond})
+	start(t, f)
+	eventually(t, "the snapshot", f.Synced)
+	if _, md, err := f.Find("10.1.2.3"); err != nil || md["vlan"] != float64(10) {
+		t.Errorf("Expected the snapshot's metadata in JSON form, got %v %v", md, err)
+	}
+
+	// Inserts, updates and deletes follow in order
+	steps := []struct {
+		change func() error
+		ip     string
+		want   string
+		owner  string
+	}{
+		{func() error { return l.Insert("10.1.0.0/16", map[string]interface{}{"owner": "dev"}) }, "10.1.2.3", "10.1.0.0/16", "dev"},
+		{func() error { return l.Insert("10.1.0.0/16", map[string]interface{}{"owner": "qa"}) }, "10.1.2.3", "10.1.0.0/16", "qa"},
+		{func() error { return l.Insert("2001:db8::/32", map[string]interface{}{"owner": "v6"}) }, "2001:db8::1", "2001:db8::/32", "v6"},
+		{func() error { return l.Delete("10.1.0.0/16") }, "10.1.2.3", "10.0.0.0/8", "netops"},
+	}
+	for i, s := range steps {
+		if err := s.change(); err != nil {
+			t.Fatalf("Step %d failed: %v", i, err)
+		}
+		eventually(t, s.want+" for "+s.ip, func() bool {
+			cidr, md, _ := f.Find(s.ip)
+			return cidr == s.want && md["owner"] == s.owner
+		})
+	}
+	if f.Seq() != l.Seq() || l.Seq() != 4 {
+		t.Errorf("Expected both at change 4, leader at %d and follower at %d", l.Seq(), f.Seq())
+	}
+	if f.Len() != l.Len() {
+		t.Errorf("Expected %d prefixes, got %d", l.Len(), f.Len())
+	}
+	matches, err := f.FindAll("10.1.2.3")
+	if err != nil || len(matches) != 1 || matches[0].CIDR != "10.0.0.0/8" {
+		t.Errorf("Unexpected FindAll result %v, %v", matches, err)
+	}
+
+	// Heartbeats keep an idle follower connected
+	time.Sleep(300 * time.Millisecond)
+	if !f.Synced() || l.Followers() != 1 {
+		t.Errorf("Expected the idle follower to stay connected")
+	}
+
+	// A follower that loses its connection resyncs, keeping its answers
+	// while it is away
+	l.Close()
+	eventually(t, "the follower to notice", func() bool { return !f.Synced() })
+	if cidr, _, _ := f.Find("2001:db8::1"); cidr != "2001:db8::/32" {
+		t.Errorf("Expected answers from the last state while disconnected, got %q", cidr)
+	}
+	l.mu.Lock()
+	l.closed = false
+	l.mu.Unlock()
+	if err := l.Insert("192.168.0.0/16", nil); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the resync", func() bool {
+		cidr, _, _ := f.Find("192.168.1.1")
+		return f.Synced() && cidr == "192.168.0.0/16"
+	})
+}
+
+func TestReplicationPreparedMetadata(t *testing.T) {
+	opts := []trie.Option{trie.WithReservedKeys(), trie.WithKeyNormalizer(trie.SnakeCaseKeys)}
+	l := NewLeader(trie.NewIPTrie(opts...), Config{Heartbeat: 20 * time.Millisecond})
+	addr := serve(t, l)
+	f := NewFollower(addr, FollowerConfig{Timeout: 200 * time.Millisecond, Retry: 10 * time.Millisecond}, opts...)
+
+	errs := make(chan error, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.Run(ctx, func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	eventually(t, "the snapshot", f.Synced)
+
+	// Source metadata lives under the reserved _sys key, which the
+	// follower must take from the leader rather than reject
+	if err := l.InsertSource("10.0.0.0/8", "ipam", map[string]interface{}{"ownerTeam": "netops"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.InsertSource("10.0.0.0/8", "dns", map[string]interface{}{"zone": "corp"}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "both sources", func() bool {
+		_, md, _ := f.Find("10.1.2.3")
+		return f.Seq() == 2 && md["zone"] == "corp"
+	})
+	_, md, _ := f.Find("10.1.2.3")
+	if md["owner_team"] != "netops" || trie.SysMetadata(md) == nil {
+		t.Errorf("Expected the leader's metadata, got %v", md)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("Expected the changes to apply without a resync, got %v", err)
+	default:
+	}
+}
+
+func TestSlowFollowerIsDropped(t *testing.T) {
+	l := NewLeader(trie.NewIPTrie(), Config{Buffer: 2})
+	addr := serve(t, l)
+
+	// A raw connection that reads the snapshot and then stalls
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var snap message
+	if line, err := r.ReadBytes('\n'); err != nil || json.Unmarshal(line, &snap) != nil || snap.Snapshot == nil {
+		t.Fatalf("Expected a snapshot first, got %q (%v)", line, err)
+	}
+	eventually(t, "the follower to register", func() bool { return l.Followers() == 1 })
+
+	// Changes pile up once the socket buffers are full
+	big := map[string]interface{}{"blob": strings.Repeat("x", 64<<10)}
+	for i := 0; i < 256; i++ {
+		if err := l.Insert(fmt.Sprintf("10.%d.0.0/16", i), big); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eventually(t, "the follower to be dropped", func() bool { return l.Followers() == 0 })
+}
+
+func TestFollowerRejectsGaps(t *testing.T) {
+	tr := trie.NewIPTrie()
+	_ = tr.Insert("10.0.0.0/8", nil)
+	snap, err := tr.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFollower("", FollowerConfig{})
+	if err := f.apply(message{Seq: 5, Snapshot: snap}); err != nil {
+		t.Fatalf("Snapshot failed to apply: %v", err)
+	}
+	if f.Len() != 1 || f.Seq() != 5 {
+		t.Errorf("Expected 1 prefix at change 5, got %d at %d", f.Len(), f.Seq())
+	}
+
+	tests := []struct {
+		name    string
+		msg     message
+		wantErr bool
+	}{
+		{"heartbeat", message{Seq: 5}, false},
+		{"stale heartbeat", message{Seq: 4}, true},
+		{"gap", message{Seq: 7, Event: &trie.Event{Type: trie.EventInsert, CIDR: "10.1.0.0/16"}}, true},
+		{"next", message{Seq: 6, Event: &trie.Event{Type: trie.EventInsert, CIDR: "10.1.0.0/16"}}, false},
+		{"delete missing", message{Seq: 7, Event: &trie.Event{Type: trie.EventDelete, CIDR: "10.2.0.0/16"}}, true},
+		{"unknown type", message{Seq: 7, Event: &trie.Event{Type: "rename", CIDR: "10.1.0.0/16"}}, true},
+	}
+	for _, tt := range tests {
+		if err := f.apply(tt.msg); (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+	if f.Seq() != 6 || f.Len() != 2 {
+		t.Errorf("Expected 2 prefixes at change 6, got %d at %d", f.Len(), f.Seq())
+	}
+}
@@ -0,0 +1,178 @@
+// Package rir reads the delegated statistics files the Regional Internet
+// Registries publish daily (delegated-<registry>-extended-latest and the
+// older non-extended form), mapping every IPv4 and IPv6 block to its
+// country and allocation status.
+package rir
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Record is one IPv4 or IPv6 line of a delegated statistics file. IPv4
+// lines give an address count that need not be a power of two, so one line
+// can produce several records.
+type Record struct {
+	Registry string
+	// Country is the ISO 3166 code, empty for unallocated space
+	Country string
+	Prefix  netip.Prefix
+	// Date is when the block was allocated or assigned, zero if not given
+	Date time.Time
+	// Status is "allocated", "assigned", "available" or "reserved"
+	Status string
+	// OpaqueID identifies the holder across records, extended files only
+	OpaqueID string
+	Line     int
+}
+
+// Parse reads a delegated statistics file and calls fn for each IPv4 and
+// IPv6 record, stopping at the first error fn returns. The version header,
+// summary lines, comments and ASN records are skipped.
+func Parse(r io.Reader, fn func(Record) error) error {
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// Summary lines have a "*" country and only 6 fields; the version
+		// line has a date where the type should be
+		fields := strings.Split(text, "|")
+		if len(fields) < 7 || fields[1] == "*" {
+			continue
+		}
+		if fields[2] != "ipv4" && fields[2] != "ipv6" {
+			continue
+		}
+
+		prefixes, err := blocks(fields[2], fields[3], fields[4])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		rec := Record{
+			Registry: fields[0],
+			Country:  strings.ToUpper(fields[1]),
+			Status:   fields[6],
+			Line:     line,
+		}
+		if rec.Country == "ZZ" {
+			rec.Country = ""
+		}
+		if d, err := time.Parse("20060102", fields[5]); err == nil {
+			rec.Date = d
+		}
+		if len(fields) > 7 {
+			rec.OpaqueID = fields[7]
+		}
+
+		for _, p := range prefixes {
+			rec.Prefix = p
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return sc.Err()
+}
+
+// blocks returns the prefixes covering a record's start and value, which is
+// an address count for IPv4 and a prefix length for IPv6
+func blocks(family, start, value string) ([]netip.Prefix, error) {
+	addr, err := netip.ParseAddr(start)
+	if err != nil || addr.Is4() != (family == "ipv4") {
+		return nil, fmt.Errorf("invalid %s start %q", family, start)
+	}
+
+	if family == "ipv6" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > 128 {
+			return nil, fmt.Errorf("invalid prefix length %q", value)
+		}
+		return []netip.Prefix{netip.PrefixFrom(addr, n).Masked()}, nil
+	}
+
+	count, err := strconv.ParseUint(value, 10, 64)
+	a4 := addr.As4()
+	first := uint64(binary.BigEndian.Uint32(a4[:]))
+	if err != nil || count == 0 || first+count > 1<<32 {
+		return nil, fmt.Errorf("invalid address count %q", value)
+	}
+	return rangePrefixes(first, count), nil
+}
+
+// rangePrefixes splits count IPv4 addresses starting at first into the
+// fewest aligned prefixes
+func rangePrefixes(first, count uint64) []netip.Prefix {
+	var out []netip.Prefix
+	for count > 0 {
+		// The largest block aligned at first that does not overshoot
+		size := 63 - bits.LeadingZeros64(count)
+		if first != 0 {
+			size = min(size, bits.TrailingZeros64(first))
+		}
+		size = min(size, 32)
+
+		var a4 [4]byte
+		binary.BigEndian.PutUint32(a4[:], uint32(first))
+		out = append(out, netip.PrefixFrom(netip.AddrFrom4(a4), 32-size))
+
+		first += 1 << size
+		count -= 1 << size
+	}
+	return out
+}
+
+// Load builds one trie from any number of delegated statistics files, e.g.
+// those of all five registries. Each prefix gets:
+//
+//	country   the ISO 3166 code, if the space is delegated
+//	registry  "arin", "ripencc", "apnic", "lacnic" or "afrinic"
+//	status    "allocated", "assigned", "available" or "reserved"
+//	date      the allocation date as YYYY-MM-DD, if known
+//	opaque_id the holder's identifier, if the file is extended
+func Load(files ...io.Reader) (*trie.IPTrie, error) {
+	t := trie.NewIPTrie()
+	for i, f := range files {
+		err := Parse(f, func(rec Record) error {
+			return t.Insert(rec.Prefix.String(), metadata(rec))
+		})
+		if err != nil {
+			if len(files) > 1 {
+				return nil, fmt.Errorf("file %d: %v", i+1, err)
+			}
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// metadata returns the trie metadata for a record
+func metadata(rec Record) map[string]interface{} {
+	md := map[string]interface{}{
+		"registry": rec.Registry,
+		"status":   rec.Status,
+	}
+	if rec.Country != "" {
+		md["country"] = rec.Country
+	}
+	if !rec.Date.IsZero() {
+		md["date"] = rec.Date.Format("2006-01-02")
+	}
+	if rec.OpaqueID != "" {
+		md["opaque_id"] = rec.OpaqueID
+	}
+	return md
+}
@@ -0,0 +1,129 @@
+package rir
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const ripeFile = `2|ripencc|20240101|5|19830705|20240101|+0100
+# comment
+ripencc|*|ipv4|*|3|summary
+ripencc|*|ipv6|*|1|summary
+ripencc|*|asn|*|1|summary
+ripencc|FR|ipv4|2.0.0.0|1048576|20100712|allocated|a1b2c3
+ripencc|DE|ipv4|5.10.0.0|768|20120301|assigned|d4e5f6
+ripencc|EU|asn|3333|1|19930901|allocated|a1b2c3
+ripencc|JP|ipv6|2001:200::|35|19990813|allocated|g7h8
+ripencc||ipv4|5.11.0.0|256||available
+`
+
+const arinFile = `arin|US|ipv4|3.0.0.0|16777216|19880223|allocated
+`
+
+func TestParse(t *testing.T) {
+	var got []string
+	err := Parse(strings.NewReader(ripeFile), func(rec Record) error {
+		got = append(got, rec.Prefix.String()+" "+rec.Country+" "+rec.Status+" "+rec.OpaqueID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	want := []string{
+		"2.0.0.0/12 FR allocated a1b2c3",
+		"5.10.0.0/23 DE assigned d4e5f6",
+		"5.10.2.0/24 DE assigned d4e5f6",
+		"2001:200::/35 JP allocated g7h8",
+		"5.11.0.0/24  available ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected records\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestRangePrefixes(t *testing.T) {
+	tests := []struct {
+		first uint64
+		count uint64
+		want  string
+	}{
+		{first: 0x0a000000, count: 256, want: "10.0.0.0/24"},
+		{first: 0x0a000080, count: 384, want: "10.0.0.128/25 10.0.1.0/24"},
+		{first: 0, count: 1 << 32, want: "0.0.0.0/0"},
+		{first: 0xffffffff, count: 1, want: "255.255.255.255/32"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range rangePrefixes(tt.first, tt.count) {
+			got = append(got, p.String())
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("rangePrefixes(%x, %d) = %v, expected %s", tt.first, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tr, err := Load(strings.NewReader(ripeFile), strings.NewReader(arinFile))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	tests := []struct {
+		ip       string
+		cidr     string
+		country  interface{}
+		registry string
+		date     interface{}
+	}{
+		{"2.1.2.3", "2.0.0.0/12", "FR", "ripencc", "2010-07-12"},
+		{"5.10.2.9", "5.10.2.0/24", "DE", "ripencc", "2012-03-01"},
+		{"2001:200::1", "2001:200::/35", "JP", "ripencc", "1999-08-13"},
+		{"5.11.0.1", "5.11.0.0/24", nil, "ripencc", nil},
+		{"3.3.3.3", "3.0.0.0/8", "US", "arin", "1988-02-23"},
+	}
+	for _, tt := range tests {
+		cidr, md, err := tr.Find(tt.ip)
+		if err != nil {
+			t.Errorf("Find(%s) returned error: %v", tt.ip, err)
+			continue
+		}
+		if cidr != tt.cidr || md["country"] != tt.country || md["registry"] != tt.registry || md["date"] != tt.date {
+			t.Errorf("Find(%s) = %s %v", tt.ip, cidr, md)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"bad start", "arin|US|ipv4|3.0.0|256|19880223|allocated", "line 1: invalid ipv4 start"},
+		{"family mismatch", "arin|US|ipv6|3.0.0.0|32|19880223|allocated", "line 1: invalid ipv6 start"},
+		{"bad count", "arin|US|ipv4|3.0.0.0|0|19880223|allocated", "line 1: invalid address count"},
+		{"past the end", "arin|US|ipv4|255.255.255.0|512|19880223|allocated", "line 1: invalid address count"},
+		{"bad length", "arin|US|ipv6|2001:db8::|129|19880223|allocated", "line 1: invalid prefix length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Parse(strings.NewReader(tt.input), func(Record) error { return nil })
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("Expected error %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// The prefix is parsed in full
+	var p netip.Prefix
+	Parse(strings.NewReader("apnic|AU|ipv6|2001:db8:1::|48|20000101|allocated"), func(rec Record) error {
+		p = rec.Prefix
+		return nil
+	})
+	if p.String() != "2001:db8:1::/48" {
+		t.Errorf("Unexpected prefix %s", p)
+	}
+}
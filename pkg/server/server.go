@@ -0,0 +1,269 @@
+// Package server exposes a trie over a JSON REST API, so services not
+// written in Go can query and maintain classifications:
+//
+//	GET    /lookup/{ip}      most specific prefix containing ip
+//	GET    /prefixes         stored prefixes, paged with limit and token
+//	GET    /prefix/{cidr}    one stored prefix
+//	PUT    /prefix/{cidr}    store a prefix, the body being its metadata
+//	DELETE /prefix/{cidr}    delete a prefix
+//	GET    /info             manifests of the bundles being served
+//	GET    /admin/check      structural self-check of the trie
+//
+// Errors are returned as {"error": "..."} with a matching status code.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/metajar/trie-network/pkg/bundle"
+	"github.com/metajar/trie-network/pkg/computed"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// defaultPageSize is the number of prefixes GET /prefixes returns when the
+// request and Config do not say otherwise
+const defaultPageSize = 1000
+
+// maxBodyBytes bounds the metadata accepted by PUT
+const maxBodyBytes = 1 << 20
+
+// Config controls the API
+type Config struct {
+	// ReadOnly rejects PUT and DELETE with 405 Method Not Allowed
+	ReadOnly bool
+	// PageSize is the default and maximum page size of GET /prefixes
+	PageSize int
+	// Manifests are returned by GET /info
+	Manifests []bundle.Manifest
+	// Computed fields are added to the metadata of GET /lookup results.
+	// Fields that fail for a match are left out.
+	Computed *computed.Fields
+}
+
+// Entry is the JSON form of a stored prefix
+type Entry struct {
+	CIDR      string                 `json:"cidr"`
+	PrefixLen int                    `json:"prefix_len"`
+	Host      bool                   `json:"host,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata"`
+}
+
+// LookupResult is the response of GET /lookup/{ip}
+type LookupResult struct {
+	IP string `json:"ip"`
+	Entry
+}
+
+// Page is the response of GET /prefixes. Next, when set, is passed back as
+// the token parameter to fetch the following page.
+type Page struct {
+	Prefixes []Entry `json:"prefixes"`
+	Next     string  `json:"next,omitempty"`
+	Omitted  int     `json:"omitted,omitempty"`
+}
+
+// Server serves the trie held by a TrieHolder. Reads use the current trie
+// without locking. Writes are serialized and copy the trie, then Store the
+// modified copy, so they cost time proportional to its size and suit
+// occasional changes rather than bulk loads.
+type Server struct {
+	holder *trie.TrieHolder
+	cfg    Config
+	mux    *http.ServeMux
+	mu     sync.Mutex // serializes writes
+}
+
+// New returns a Server for the trie in holder
+func New(holder *trie.TrieHolder, cfg Config) *Server {
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = defaultPageSize
+	}
+	if cfg.Manifests == nil {
+		cfg.Manifests = []bundle.Manifest{}
+	}
+	s := &Server{holder: holder, cfg: cfg, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /lookup/{ip}", s.lookup)
+	s.mux.HandleFunc("GET /prefixes", s.prefixes)
+	s.mux.HandleFunc("GET /prefix/{cidr...}", s.getPrefix)
+	s.mux.HandleFunc("PUT /prefix/{cidr...}", s.putPrefix)
+	s.mux.HandleFunc("DELETE /prefix/{cidr...}", s.deletePrefix)
+	s.mux.HandleFunc("GET /info", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.cfg.Manifests)
+	})
+	s.mux.HandleFunc("GET /admin/check", s.check)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid IP address %q", ip))
+		return
+	}
+	m, found := s.holder.Load().FindMatch(addr)
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no matching CIDR found"))
+		return
+	}
+	entry := entryOf(m)
+	entry.Metadata, _ = s.cfg.Computed.Apply(ip, m)
+	writeJSON(w, http.StatusOK, LookupResult{IP: ip, Entry: entry})
+}
+
+func (s *Server) prefixes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := s.cfg.PageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+		limit = min(n, s.cfg.PageSize)
+	}
+
+	matches, trunc, err := s.holder.Load().Enumerate(q.Get("within"), limit, q.Get("token"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	page := Page{Prefixes: make([]Entry, 0, len(matches))}
+	for _, m := range matches {
+		page.Prefixes = append(page.Prefixes, entryOf(m))
+	}
+	if trunc != nil {
+		page.Next, page.Omitted = trunc.Next, trunc.Omitted
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) getPrefix(w http.ResponseWriter, r *http.Request) {
+	p, ok := parseCIDR(w, r)
+	if !ok {
+		return
+	}
+	md, found := s.holder.Load().FindExact(p.String())
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not stored", p))
+		return
+	}
+	writeJSON(w, http.StatusOK, Entry{CIDR: p.String(), PrefixLen: p.Bits(), Host: p.IsSingleIP(), Metadata: md})
+}
+
+func (s *Server) putPrefix(w http.ResponseWriter, r *http.Request) {
+	if !s.writable(w) {
+		return
+	}
+	p, ok := parseCIDR(w, r)
+	if !ok {
+		return
+	}
+	// An empty body stores the prefix without metadata
+	var md map[string]interface{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&md); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("metadata must be a JSON object: %v", err))
+		return
+	}
+	if md == nil {
+		md = map[string]interface{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.holder.Load().Clone()
+	_, existed := next.FindExact(p.String())
+	if err := next.Insert(p.String(), md); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.holder.Store(next)
+
+	status := http.StatusCreated
+	if existed {
+		status = http.StatusOK
+	}
+	stored, _ := next.FindExact(p.String())
+	writeJSON(w, status, Entry{CIDR: p.String(), PrefixLen: p.Bits(), Host: p.IsSingleIP(), Metadata: stored})
+}
+
+func (s *Server) deletePrefix(w http.ResponseWriter, r *http.Request) {
+	if !s.writable(w) {
+		return
+	}
+	p, ok := parseCIDR(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.holder.Load().FindExact(p.String()); !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not stored", p))
+		return
+	}
+	next := s.holder.Load().Clone()
+	if err := next.Delete(p.String()); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.holder.Store(next)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// check runs CheckStructure, answering 500 if it finds problems so probes
+// and soak tests can alert on it
+func (s *Server) check(w http.ResponseWriter, r *http.Request) {
+	h := s.holder.Load().CheckStructure()
+	status := http.StatusOK
+	if !h.OK() {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, h)
+}
+
+// writable reports whether writes are allowed, answering the request if not
+func (s *Server) writable(w http.ResponseWriter) bool {
+	if s.cfg.ReadOnly {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("server is read-only"))
+	}
+	return !s.cfg.ReadOnly
+}
+
+// parseCIDR reads the canonical prefix from the request path, answering the
+// request if it is invalid
+func parseCIDR(w http.ResponseWriter, r *http.Request) (netip.Prefix, bool) {
+	cidr := r.PathValue("cidr")
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil || p.Addr().Is4In6() {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid CIDR %q", cidr))
+		return netip.Prefix{}, false
+	}
+	return p.Masked(), true
+}
+
+func entryOf(m trie.Match) Entry {
+	return Entry{CIDR: m.CIDR, PrefixLen: m.PrefixLen, Host: m.Host, Metadata: m.Metadata}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
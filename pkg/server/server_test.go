@@ -0,0 +1,269 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/bundle"
+	"github.com/metajar/trie-network/pkg/computed"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func testServer(t *testing.T, cfg Config) (*Server, *trie.TrieHolder) {
+	h := trie.NewTrieHolder()
+	tr := trie.NewIPTrie()
+	entries := map[string]map[string]interface{}{
+		"10.0.0.0/8":    {"owner": "netops"},
+		"10.1.0.0/16":   {"owner": "dev"},
+		"192.0.2.0/24":  {"owner": "docs"},
+		"2001:db8::/32": {"owner": "v6"},
+	}
+	for cidr, md := range entries {
+		if err := tr.Insert(cidr, md); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", cidr, err)
+		}
+	}
+	h.Store(tr)
+	return New(h, cfg), h
+}
+
+// do sends a request and decodes the JSON response into v, if not nil
+func do(t *testing.T, s *Server, method, path, body string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if v != nil {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: invalid response: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestLookup(t *testing.T) {
+	s, _ := testServer(t, Config{})
+
+	tests := []struct {
+		ip     string
+		status int
+		cidr   string
+	}{
+		{"10.1.2.3", http.StatusOK, "10.1.0.0/16"},
+		{"10.2.0.1", http.StatusOK, "10.0.0.0/8"},
+		{"2001:db8::1", http.StatusOK, "2001:db8::/32"},
+		{"198.51.100.1", http.StatusNotFound, ""},
+		{"bogus", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		var res struct {
+			LookupResult
+			Error string `json:"error"`
+		}
+		status := do(t, s, "GET", "/lookup/"+tt.ip, "", &res)
+		if status != tt.status || res.CIDR != tt.cidr {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.ip, tt.status, tt.cidr, status, res.CIDR)
+		}
+		if status == http.StatusOK && (res.IP != tt.ip || res.Metadata["owner"] == nil) {
+			t.Errorf("%s: unexpected result %+v", tt.ip, res.LookupResult)
+		}
+		if status != http.StatusOK && res.Error == "" {
+			t.Errorf("%s: expected an error message", tt.ip)
+		}
+	}
+}
+
+func TestLookupMatchOrder(t *testing.T) {
+	h := trie.NewTrieHolder(trie.WithMatchOrder(trie.LeastSpecificFirst))
+	h.ReplaceAll([]trie.Match{{CIDR: "0.0.0.0/0"}, {CIDR: "10.0.0.0/8"}, {CIDR: "10.1.0.0/16"}})
+	s := New(h, Config{})
+
+	// Lookups answer with the longest match whatever FindAll's order
+	var res LookupResult
+	if status := do(t, s, "GET", "/lookup/10.1.2.3", "", &res); status != http.StatusOK || res.CIDR != "10.1.0.0/16" || res.PrefixLen != 16 {
+		t.Errorf("Expected 10.1.0.0/16, got %d %+v", status, res)
+	}
+	if status := do(t, s, "GET", "/lookup/fe80::1%25eth0", "", nil); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a zoned address, got %d", status)
+	}
+}
+
+func TestWritesKeepHooks(t *testing.T) {
+	s, h := testServer(t, Config{})
+	var events []string
+	h.Load().OnChange(func(e trie.Event) {
+		events = append(events, string(e.Type)+" "+e.CIDR)
+	})
+
+	// Every write swaps in a clone, which must keep notifying the hook
+	do(t, s, "PUT", "/prefix/10.2.0.0/16", `{"owner":"lab"}`, nil)
+	do(t, s, "PUT", "/prefix/10.2.0.0/16", `{"owner":"ops"}`, nil)
+	do(t, s, "DELETE", "/prefix/10.2.0.0/16", "", nil)
+	want := []string{"insert 10.2.0.0/16", "update 10.2.0.0/16", "delete 10.2.0.0/16"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("Expected events %v, got %v", want, events)
+	}
+}
+
+func TestLookupComputed(t *testing.T) {
+	fields, err := computed.Compile(map[string]string{
+		"host":  "hostOffset(ip, cidr)",
+		"team":  "metadata.team",
+		"owner": "metadata.owner.upperAscii()",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, h := testServer(t, Config{Computed: fields})
+
+	var res LookupResult
+	if status := do(t, s, "GET", "/lookup/10.1.2.3", "", &res); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	// team is not stored, so it is left out
+	md := res.Metadata
+	if md["host"] != float64(515) || md["owner"] != "DEV" || md["team"] != nil || len(md) != 2 {
+		t.Errorf("Unexpected metadata %v", md)
+	}
+	if stored, _ := h.Load().FindExact("10.1.0.0/16"); stored["owner"] != "dev" || len(stored) != 1 {
+		t.Errorf("Expected the stored metadata to be unchanged, got %v", stored)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	s, _ := testServer(t, Config{PageSize: 3})
+
+	var page Page
+	if status := do(t, s, "GET", "/prefixes", "", &page); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if len(page.Prefixes) != 3 || page.Next == "" || page.Omitted != 1 {
+		t.Fatalf("Expected 3 prefixes and 1 omitted, got %+v", page)
+	}
+
+	var rest Page
+	do(t, s, "GET", "/prefixes?token="+page.Next, "", &rest)
+	if len(rest.Prefixes) != 1 || rest.Next != "" {
+		t.Errorf("Expected the last prefix, got %+v", rest)
+	}
+
+	var within Page
+	do(t, s, "GET", "/prefixes?within=10.0.0.0/8&limit=10", "", &within)
+	if len(within.Prefixes) != 2 {
+		t.Errorf("Expected 2 prefixes within 10.0.0.0/8, got %+v", within)
+	}
+
+	for _, q := range []string{"limit=0", "limit=x", "token=bogus", "within=bogus"} {
+		if status := do(t, s, "GET", "/prefixes?"+q, "", nil); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, status)
+		}
+	}
+}
+
+func TestPutDelete(t *testing.T) {
+	s, h := testServer(t, Config{})
+	before := h.Load()
+
+	var e Entry
+	if status := do(t, s, "PUT", "/prefix/10.2.3.4/16", `{"owner":"lab"}`, &e); status != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", status)
+	}
+	if e.CIDR != "10.2.0.0/16" || e.PrefixLen != 16 || e.Host || e.Metadata["owner"] != "lab" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	var host Entry
+	if status := do(t, s, "PUT", "/prefix/10.2.3.4/32", `{}`, &host); status != http.StatusCreated || !host.Host || host.PrefixLen != 32 {
+		t.Errorf("Expected a host route, got %d %+v", status, host)
+	}
+	var res LookupResult
+	if status := do(t, s, "GET", "/lookup/10.2.3.4", "", &res); status != http.StatusOK || !res.Host || res.CIDR != "10.2.3.4/32" {
+		t.Errorf("Expected lookup of a host route, got %d %+v", status, res)
+	}
+	if _, found := before.FindExact("10.2.0.0/16"); found {
+		t.Errorf("Expected the previously loaded trie to be left unchanged")
+	}
+
+	if status := do(t, s, "PUT", "/prefix/10.2.0.0/16", `{"owner":"ops"}`, nil); status != http.StatusOK {
+		t.Errorf("Expected 200 replacing a prefix, got %d", status)
+	}
+	if status := do(t, s, "PUT", "/prefix/2001:db8:1::/48", "", nil); status != http.StatusCreated {
+		t.Errorf("Expected 201 with an empty body, got %d", status)
+	}
+	do(t, s, "GET", "/prefix/10.2.0.0/16", "", &e)
+	if e.Metadata["owner"] != "ops" {
+		t.Errorf("Expected replaced metadata, got %+v", e)
+	}
+	if cidr, _, _ := h.Load().Find("10.2.9.9"); cidr != "10.2.0.0/16" {
+		t.Errorf("Expected lookup to see the new prefix, got %q", cidr)
+	}
+
+	if status := do(t, s, "DELETE", "/prefix/10.2.0.0/16", "", nil); status != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", status)
+	}
+	if status := do(t, s, "DELETE", "/prefix/10.2.0.0/16", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting a missing prefix, got %d", status)
+	}
+	if status := do(t, s, "GET", "/prefix/10.2.0.0/16", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", status)
+	}
+
+	for _, tt := range []struct{ path, body string }{
+		{"/prefix/10.0.0.0/33", "{}"},
+		{"/prefix/bogus", "{}"},
+		{"/prefix/::ffff:10.0.0.0/104", "{}"},
+		{"/prefix/10.3.0.0/16", "[1,2]"},
+	} {
+		if status := do(t, s, "PUT", tt.path, tt.body, nil); status != http.StatusBadRequest {
+			t.Errorf("PUT %s %s: expected 400, got %d", tt.path, tt.body, status)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	s, h := testServer(t, Config{ReadOnly: true})
+	if status := do(t, s, "PUT", "/prefix/10.2.0.0/16", "{}", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for PUT, got %d", status)
+	}
+	if status := do(t, s, "DELETE", "/prefix/10.0.0.0/8", "", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for DELETE, got %d", status)
+	}
+	if h.Load().Len() != 4 {
+		t.Errorf("Expected trie to be unchanged, got %d prefixes", h.Load().Len())
+	}
+}
+
+func TestInfo(t *testing.T) {
+	s, _ := testServer(t, Config{})
+	var got []bundle.Manifest
+	do(t, s, "GET", "/info", "", &got)
+	if got == nil || len(got) != 0 {
+		t.Errorf("Expected an empty list, got %v", got)
+	}
+
+	s, _ = testServer(t, Config{Manifests: []bundle.Manifest{{Name: "corp", Version: "3"}}})
+	do(t, s, "GET", "/info", "", &got)
+	if len(got) != 1 || got[0].Name != "corp" {
+		t.Errorf("Unexpected manifests %+v", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s, h := testServer(t, Config{})
+	for i := 0; i < 100; i++ {
+		do(t, s, "PUT", fmt.Sprintf("/prefix/10.%d.%d.0/24", i%5, i), "{}", nil)
+	}
+	for i := 0; i < 100; i += 2 {
+		do(t, s, "DELETE", fmt.Sprintf("/prefix/10.%d.%d.0/24", i%5, i), "", nil)
+	}
+
+	var health trie.Health
+	if status := do(t, s, "GET", "/admin/check", "", &health); status != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %+v", status, health)
+	}
+	if health.Prefixes != h.Load().Len() || health.Prefixes != 54 {
+		t.Errorf("Expected 54 prefixes, got %+v", health)
+	}
+}
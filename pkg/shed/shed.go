@@ -0,0 +1,127 @@
+// Package shed sheds load by client class. Prefixes in the trie are tagged
+// with a class, such as "scraper" or "low", and while the server is
+// overloaded requests from those classes are turned away first so that
+// everyone else keeps being served.
+package shed
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/httpmw"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// Config sets which classes are shed and when
+type Config struct {
+	// Key is the metadata key holding a prefix's class, "priority" if empty
+	Key string
+	// Thresholds maps classes to the load, from 0 to 1, at or above which
+	// their requests are shed, e.g. {"scraper": 0.5, "low": 0.8}. Classes
+	// not listed, and clients matching no prefix, are never shed.
+	Thresholds map[string]float64
+	// Load reports the current load from 0 (idle) to 1 (saturated). If nil,
+	// requests in flight through Acquire divided by MaxInFlight is used.
+	Load func() float64
+	// MaxInFlight is the request count treated as full load
+	MaxInFlight int
+	// RetryAfter is suggested to shed HTTP clients, 1 second if zero
+	RetryAfter time.Duration
+}
+
+// Shedder makes admission decisions for incoming requests
+type Shedder struct {
+	holder   *trie.TrieHolder
+	cfg      Config
+	inFlight atomic.Int64
+	shed     atomic.Uint64
+}
+
+// New returns a Shedder classifying clients with the holder's current trie
+func New(holder *trie.TrieHolder, cfg Config) *Shedder {
+	if cfg.Key == "" {
+		cfg.Key = "priority"
+	}
+	if cfg.RetryAfter == 0 {
+		cfg.RetryAfter = time.Second
+	}
+	return &Shedder{holder: holder, cfg: cfg}
+}
+
+// Class returns the class of the most specific prefix containing ip that
+// has one, or "" if none does. Servers can use it to deprioritize rather
+// than shed, e.g. by picking a work queue.
+func (s *Shedder) Class(ip netip.Addr) string {
+	_, md, err := s.holder.Load().FindValue(ip.String(), s.cfg.Key)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprint(md[s.cfg.Key])
+}
+
+// Load returns the current load from 0 to 1
+func (s *Shedder) Load() float64 {
+	if s.cfg.Load != nil {
+		return s.cfg.Load()
+	}
+	if s.cfg.MaxInFlight <= 0 {
+		return 0
+	}
+	return float64(s.inFlight.Load()) / float64(s.cfg.MaxInFlight)
+}
+
+// Admit reports whether a request from ip should be served at the current
+// load, along with the client's class
+func (s *Shedder) Admit(ip netip.Addr) (string, bool) {
+	class := s.Class(ip)
+	threshold, ok := s.cfg.Thresholds[class]
+	if !ok || s.Load() < threshold {
+		return class, true
+	}
+	s.shed.Add(1)
+	return class, false
+}
+
+// Acquire is Admit for servers that let the Shedder measure load itself.
+// When the request is admitted it counts as in flight until release is
+// called.
+func (s *Shedder) Acquire(ip netip.Addr) (release func(), ok bool) {
+	if _, ok := s.Admit(ip); !ok {
+		return nil, false
+	}
+	s.inFlight.Add(1)
+	return func() { s.inFlight.Add(-1) }, true
+}
+
+// Shed returns the number of requests turned away so far
+func (s *Shedder) Shed() uint64 {
+	return s.shed.Load()
+}
+
+// Middleware answers requests that are shed with 503 Service Unavailable
+// and a Retry-After header. Client addresses are determined as by
+// httpmw.Middleware; requests without one are always served.
+func (s *Shedder) Middleware(clients httpmw.Config) func(http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int((s.cfg.RetryAfter + time.Second - 1) / time.Second))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, ok := clients.ClientIP(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			release, ok := s.Acquire(ip)
+			if !ok {
+				w.Header().Set("Retry-After", retryAfter)
+				http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			defer release()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package shed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/metajar/trie-network/pkg/httpmw"
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func testHolder() *trie.TrieHolder {
+	holder := trie.NewTrieHolder()
+	holder.ReplaceAll([]trie.Match{
+		{CIDR: "198.51.100.0/24", Metadata: map[string]interface{}{"priority": "scraper"}},
+		{CIDR: "203.0.113.0/24", Metadata: map[string]interface{}{"priority": "low"}},
+		// Inherits the class of the /24
+		{CIDR: "203.0.113.128/25", Metadata: map[string]interface{}{"owner": "acme"}},
+		{CIDR: "192.0.2.0/24", Metadata: map[string]interface{}{"priority": "critical"}},
+	})
+	return holder
+}
+
+func TestAdmit(t *testing.T) {
+	load := 0.0
+	s := New(testHolder(), Config{
+		Thresholds: map[string]float64{"scraper": 0.5, "low": 0.8},
+		Load:       func() float64 { return load },
+	})
+
+	tests := []struct {
+		ip    string
+		load  float64
+		class string
+		admit bool
+	}{
+		{"198.51.100.1", 0.2, "scraper", true},
+		{"198.51.100.1", 0.5, "scraper", false},
+		{"203.0.113.200", 0.7, "low", true},
+		{"203.0.113.200", 0.9, "low", false},
+		{"192.0.2.1", 1.0, "critical", true},
+		{"10.0.0.1", 1.0, "", true},
+	}
+	for _, tt := range tests {
+		load = tt.load
+		class, ok := s.Admit(netip.MustParseAddr(tt.ip))
+		if class != tt.class || ok != tt.admit {
+			t.Errorf("Admit(%s) at load %v = %q, %v; expected %q, %v", tt.ip, tt.load, class, ok, tt.class, tt.admit)
+		}
+	}
+	if s.Shed() != 2 {
+		t.Errorf("Expected 2 shed requests, got %d", s.Shed())
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	s := New(testHolder(), Config{
+		Thresholds:  map[string]float64{"scraper": 0.5},
+		MaxInFlight: 4,
+	})
+	scraper := netip.MustParseAddr("198.51.100.1")
+	other := netip.MustParseAddr("10.0.0.1")
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, ok := s.Acquire(other)
+		if !ok {
+			t.Fatalf("Expected request %d to be admitted", i)
+		}
+		releases = append(releases, release)
+	}
+	if s.Load() != 0.5 {
+		t.Errorf("Expected load 0.5, got %v", s.Load())
+	}
+	if _, ok := s.Acquire(scraper); ok {
+		t.Errorf("Expected scraper to be shed at half load")
+	}
+
+	releases[0]()
+	release, ok := s.Acquire(scraper)
+	if !ok {
+		t.Fatalf("Expected scraper to be admitted once load dropped")
+	}
+	release()
+	releases[1]()
+	if s.Load() != 0 {
+		t.Errorf("Expected load 0, got %v", s.Load())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	s := New(testHolder(), Config{
+		Thresholds: map[string]float64{"scraper": 0.5},
+		Load:       func() float64 { return 0.6 },
+		RetryAfter: 1500 * time.Millisecond,
+	})
+	handler := s.Middleware(httpmw.Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		remote string
+		code   int
+	}{
+		{"198.51.100.1:1234", http.StatusServiceUnavailable},
+		{"192.0.2.1:1234", http.StatusNoContent},
+		{"@", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.remote, tt.code, w.Code)
+		}
+		if tt.code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "2" {
+			t.Errorf("Expected Retry-After 2, got %q", w.Header().Get("Retry-After"))
+		}
+	}
+}
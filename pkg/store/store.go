@@ -0,0 +1,392 @@
+// Package store keeps prefixes in an embedded bbolt database instead of in
+// memory, for datasets with tens of millions of prefixes or large metadata
+// that do not fit in RAM. An IPTrieStore offers the lookup and update
+// methods of IPTrie, each reading only the records it needs from disk.
+//
+// Each prefix is one key: the address family, the masked address and the
+// prefix length, so keys sort in the same order IPTrie.Walk visits
+// prefixes. Find probes one key per prefix length in use, longest first,
+// using a count of stored prefixes per length. Metadata is stored as JSON,
+// so numbers come back as float64 and lists as []interface{}, as with
+// IPTrie.UnmarshalJSON.
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+var (
+	prefixesBucket = []byte("prefixes")
+	lengthsBucket  = []byte("lengths")
+)
+
+// importBatch is the number of prefixes Import writes per transaction
+const importBatch = 10000
+
+// ErrNotFound is returned by Delete for a CIDR that is not stored
+var ErrNotFound = errors.New("CIDR not found")
+
+// entry is the stored value of one prefix
+type entry struct {
+	CIDR     string                 `json:"cidr"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// IPTrieStore is a prefix table kept in a bbolt database file. It is safe
+// for concurrent use. Only one process can open a database at a time.
+type IPTrieStore struct {
+	db *bolt.DB
+
+	// lengths counts the stored prefixes of each length, IPv4 in [0] and
+	// IPv6 in [1], mirroring the lengths bucket
+	mu      sync.RWMutex
+	lengths [2][129]uint64
+}
+
+// Open opens or creates the database at path. It fails after a second if
+// another process has the database open.
+func Open(path string) (*IPTrieStore, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+
+	s := &IPTrieStore{db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(prefixesBucket); err != nil {
+			return err
+		}
+		lengths, err := tx.CreateBucketIfNotExists(lengthsBucket)
+		if err != nil {
+			return err
+		}
+		return lengths.ForEach(func(k, v []byte) error {
+			if len(k) != 2 || k[0] > 1 || k[1] > 128 || len(v) != 8 {
+				return fmt.Errorf("corrupt length record %x", k)
+			}
+			s.lengths[k[0]][k[1]] = binary.BigEndian.Uint64(v)
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Close closes the database. The store must not be used afterwards.
+func (s *IPTrieStore) Close() error {
+	return s.db.Close()
+}
+
+// parseCIDR parses a CIDR into its masked form, unmapping IPv4-mapped IPv6
+// prefixes the way the trie stores them
+func parseCIDR(cidr string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR: %v", err)
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked(), nil
+}
+
+// family returns the lengths index of addr's family
+func family(addr netip.Addr) int {
+	if addr.Is4() {
+		return 0
+	}
+	return 1
+}
+
+// key returns the database key of a masked prefix
+func key(p netip.Prefix) []byte {
+	k := make([]byte, 0, 18)
+	k = append(k, byte(family(p.Addr())))
+	k = append(k, p.Addr().AsSlice()...)
+	return append(k, byte(p.Bits()))
+}
+
+// decode unmarshals a stored value
+func decode(v []byte) (entry, error) {
+	var e entry
+	if err := json.Unmarshal(v, &e); err != nil {
+		return entry{}, fmt.Errorf("corrupt store entry: %v", err)
+	}
+	if e.Metadata == nil {
+		e.Metadata = map[string]interface{}{}
+	}
+	return e, nil
+}
+
+// lengthDelta is a change to the count of prefixes of one length
+type lengthDelta struct {
+	fam, bits int
+	delta     int64
+}
+
+// update runs fn in a write transaction and applies the length count
+// changes it records once the transaction has committed
+func (s *IPTrieStore) update(fn func(tx *bolt.Tx, deltas *[]lengthDelta) error) error {
+	var deltas []lengthDelta
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		deltas = deltas[:0]
+		if err := fn(tx, &deltas); err != nil {
+			return err
+		}
+		return writeLengths(tx, deltas)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range deltas {
+		s.lengths[d.fam][d.bits] = uint64(int64(s.lengths[d.fam][d.bits]) + d.delta)
+	}
+	return nil
+}
+
+// writeLengths adds deltas to the counts in the lengths bucket
+func writeLengths(tx *bolt.Tx, deltas []lengthDelta) error {
+	b := tx.Bucket(lengthsBucket)
+	for _, d := range deltas {
+		k := []byte{byte(d.fam), byte(d.bits)}
+		var n uint64
+		if v := b.Get(k); len(v) == 8 {
+			n = binary.BigEndian.Uint64(v)
+		}
+		n = uint64(int64(n) + d.delta)
+		if n == 0 {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			continue
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, n)
+		if err := b.Put(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put stores one prefix, recording a new length count if it is new
+func put(tx *bolt.Tx, cidr string, metadata map[string]interface{}, deltas *[]lengthDelta) error {
+	p, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(entry{CIDR: cidr, Metadata: metadata})
+	if err != nil {
+		return fmt.Errorf("encode %s: %v", cidr, err)
+	}
+	b := tx.Bucket(prefixesBucket)
+	k := key(p)
+	if b.Get(k) == nil {
+		*deltas = append(*deltas, lengthDelta{family(p.Addr()), p.Bits(), 1})
+	}
+	return b.Put(k, v)
+}
+
+// Insert adds a CIDR with metadata, replacing the metadata of a CIDR that
+// is already stored
+func (s *IPTrieStore) Insert(cidr string, metadata map[string]interface{}) error {
+	return s.update(func(tx *bolt.Tx, deltas *[]lengthDelta) error {
+		return put(tx, cidr, metadata, deltas)
+	})
+}
+
+// Import copies every prefix of t into the store, a batch of prefixes per
+// transaction. If it fails, the batches written so far remain.
+func (s *IPTrieStore) Import(t *trie.IPTrie) error {
+	var batch []trie.Match
+	flush := func() error {
+		err := s.update(func(tx *bolt.Tx, deltas *[]lengthDelta) error {
+			for _, m := range batch {
+				if err := put(tx, m.CIDR, m.Metadata, deltas); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	var err error
+	t.Walk(func(prefix string, md map[string]interface{}) bool {
+		batch = append(batch, trie.Match{CIDR: prefix, Metadata: md})
+		if len(batch) == importBatch {
+			err = flush()
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// Delete removes a CIDR, failing with ErrNotFound if it is not stored
+func (s *IPTrieStore) Delete(cidr string) error {
+	p, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx, deltas *[]lengthDelta) error {
+		b := tx.Bucket(prefixesBucket)
+		k := key(p)
+		if b.Get(k) == nil {
+			return ErrNotFound
+		}
+		*deltas = append(*deltas, lengthDelta{family(p.Addr()), p.Bits(), -1})
+		return b.Delete(k)
+	})
+}
+
+// Len returns the number of stored prefixes
+func (s *IPTrieStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var n uint64
+	for _, fam := range s.lengths {
+		for _, c := range fam {
+			n += c
+		}
+	}
+	return int(n)
+}
+
+// usedLengths returns the prefix lengths in use for addr's family, longest
+// first
+func (s *IPTrieStore) usedLengths(addr netip.Addr) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []int
+	counts := s.lengths[family(addr)]
+	for bits := addr.BitLen(); bits >= 0; bits-- {
+		if counts[bits] > 0 {
+			out = append(out, bits)
+		}
+	}
+	return out
+}
+
+// parseIP parses a lookup address the way IPTrie.Find does
+func parseIP(ip string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("invalid IP address")
+	}
+	return addr.Unmap(), nil
+}
+
+// Find returns the most specific stored prefix containing ip and its
+// metadata, like IPTrie.Find
+func (s *IPTrieStore) Find(ip string) (string, map[string]interface{}, error) {
+	addr, err := parseIP(ip)
+	if err != nil {
+		return "", nil, err
+	}
+	var found *entry
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(prefixesBucket)
+		for _, bits := range s.usedLengths(addr) {
+			if v := b.Get(key(netip.PrefixFrom(addr, bits).Masked())); v != nil {
+				e, err := decode(v)
+				found = &e
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if found == nil {
+		return "", nil, fmt.Errorf("no matching CIDR found")
+	}
+	return found.CIDR, found.Metadata, nil
+}
+
+// FindAll returns every stored prefix containing ip, most specific first
+func (s *IPTrieStore) FindAll(ip string) ([]trie.Match, error) {
+	addr, err := parseIP(ip)
+	if err != nil {
+		return nil, err
+	}
+	var matches []trie.Match
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(prefixesBucket)
+		for _, bits := range s.usedLengths(addr) {
+			v := b.Get(key(netip.PrefixFrom(addr, bits).Masked()))
+			if v == nil {
+				continue
+			}
+			e, err := decode(v)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, trie.Match{
+				CIDR:      e.CIDR,
+				PrefixLen: bits,
+				Host:      bits == addr.BitLen(),
+				Metadata:  e.Metadata,
+			})
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// FindExact returns the metadata stored for exactly the given CIDR
+func (s *IPTrieStore) FindExact(cidr string) (map[string]interface{}, bool) {
+	p, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, false
+	}
+	var md map[string]interface{}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(prefixesBucket).Get(key(p))
+		if v == nil {
+			return ErrNotFound
+		}
+		e, err := decode(v)
+		md = e.Metadata
+		return err
+	})
+	return md, err == nil
+}
+
+// Walk calls fn for every stored prefix in the order of IPTrie.Walk until
+// fn returns false. The store must not be modified from fn.
+func (s *IPTrieStore) Walk(fn func(prefix string, md map[string]interface{}) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(prefixesBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			e, err := decode(v)
+			if err != nil {
+				return err
+			}
+			if !fn(e.CIDR, e.Metadata) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
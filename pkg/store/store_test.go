@@ -0,0 +1,164 @@
+package store
+
+import (
+	"errors"
+	"math/rand"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func testTrie(t *testing.T) *trie.IPTrie {
+	tr := trie.NewIPTrie()
+	entries := map[string]map[string]interface{}{
+		"0.0.0.0/0":       {"name": "default"},
+		"10.0.0.0/8":      {"name": "ten", "vlan": 10},
+		"10.1.0.0/16":     {"name": "ten-one"},
+		"10.1.2.0/24":     {"name": "ten-one-two"},
+		"10.1.2.3/32":     {"name": "host"},
+		"192.168.0.0/16":  {"name": "private"},
+		"2001:db8::/32":   {"name": "doc"},
+		"2001:db8:1::/48": {"name": "doc-one"},
+		"2001:db8::1/128": {"name": "doc-host"},
+		"ffff::/16":       {"name": "v6-top"},
+	}
+	for cidr, md := range entries {
+		if err := tr.Insert(cidr, md); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", cidr, err)
+		}
+	}
+	return tr
+}
+
+func openStore(t *testing.T) (*IPTrieStore, string) {
+	path := filepath.Join(t.TempDir(), "prefixes.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func TestStoreMatchesTrie(t *testing.T) {
+	tr := testTrie(t)
+	s, _ := openStore(t)
+	if err := s.Import(tr); err != nil {
+		t.Fatalf("Import returned error: %v", err)
+	}
+	if s.Len() != tr.Len() {
+		t.Errorf("Expected %d entries, got %d", tr.Len(), s.Len())
+	}
+
+	ips := []string{
+		"0.0.0.0", "9.255.255.255", "10.0.0.0", "10.1.2.2", "10.1.2.3", "10.1.2.4", "10.1.3.0",
+		"11.0.0.0", "192.168.1.1", "255.255.255.255", "::ffff:10.1.2.3",
+		"::", "2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8:1::5", "2001:db9::", "ffff:ffff::1",
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		var a4 [4]byte
+		rng.Read(a4[:])
+		ips = append(ips, netip.AddrFrom4(a4).String())
+		var a16 [16]byte
+		rng.Read(a16[:])
+		a16[0], a16[1], a16[2], a16[3] = 0x20, 0x01, 0x0d, 0xb8
+		ips = append(ips, netip.AddrFrom16(a16).String())
+	}
+
+	for _, ip := range ips {
+		wantCIDR, wantMD, wantErr := tr.Find(ip)
+		gotCIDR, gotMD, gotErr := s.Find(ip)
+		if (wantErr == nil) != (gotErr == nil) || gotCIDR != wantCIDR || (wantErr == nil && gotMD["name"] != wantMD["name"]) {
+			t.Fatalf("Find(%s) = %s %v (%v), trie says %s %v (%v)", ip, gotCIDR, gotMD, gotErr, wantCIDR, wantMD, wantErr)
+		}
+		want, _ := tr.FindAll(ip)
+		got, err := s.FindAll(ip)
+		if err != nil || len(got) != len(want) {
+			t.Fatalf("FindAll(%s) = %v (%v), trie says %v", ip, got, err, want)
+		}
+		for i := range want {
+			if got[i].CIDR != want[i].CIDR || got[i].PrefixLen != want[i].PrefixLen || got[i].Host != want[i].Host {
+				t.Fatalf("FindAll(%s) = %v, trie says %v", ip, got, want)
+			}
+		}
+	}
+
+	// Walk visits prefixes in the trie's order
+	var want, got []string
+	tr.Walk(func(prefix string, md map[string]interface{}) bool {
+		want = append(want, prefix)
+		return true
+	})
+	err := s.Walk(func(prefix string, md map[string]interface{}) bool {
+		got = append(got, prefix)
+		return true
+	})
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("Walk returned %v (%v), expected %v", got, err, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Walk returned %v, expected %v", got, want)
+			break
+		}
+	}
+
+	// Numbers come back the way encoding/json decodes them
+	if _, md, _ := s.Find("10.9.9.9"); md["vlan"] != float64(10) {
+		t.Errorf("Expected vlan 10, got %#v", md["vlan"])
+	}
+	if _, _, err := s.Find("bogus"); err == nil {
+		t.Errorf("Expected error for invalid IP")
+	}
+}
+
+func TestStoreUpdates(t *testing.T) {
+	s, path := openStore(t)
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.2.0.0/16"} {
+		if err := s.Insert(cidr, map[string]interface{}{"name": cidr}); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", cidr, err)
+		}
+	}
+	if err := s.Insert("10.1.0.0/16", map[string]interface{}{"name": "replaced"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("10.2.0.0/16"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	if err := s.Delete("10.2.0.0/16"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := s.Insert("bogus", nil); err == nil {
+		t.Errorf("Expected error for an invalid CIDR")
+	}
+
+	if s.Len() != 2 {
+		t.Errorf("Expected 2 prefixes, got %d", s.Len())
+	}
+	if md, ok := s.FindExact("10.1.0.0/16"); !ok || md["name"] != "replaced" {
+		t.Errorf("Expected replaced metadata, got %v", md)
+	}
+	if _, ok := s.FindExact("10.2.0.0/16"); ok {
+		t.Errorf("Expected 10.2.0.0/16 to be gone")
+	}
+	if cidr, _, _ := s.Find("10.2.3.4"); cidr != "10.0.0.0/8" {
+		t.Errorf("Expected 10.0.0.0/8, got %s", cidr)
+	}
+
+	// Reopening keeps the data and the per-length counts
+	s.Close()
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	defer s.Close()
+	if s.Len() != 2 {
+		t.Errorf("Expected 2 prefixes after reopening, got %d", s.Len())
+	}
+	if cidr, md, err := s.Find("10.1.2.3"); err != nil || cidr != "10.1.0.0/16" || md["name"] != "replaced" {
+		t.Errorf("Unexpected Find after reopening: %s %v %v", cidr, md, err)
+	}
+}
@@ -0,0 +1,146 @@
+package trie
+
+import "time"
+
+// AlertKind names the measurement that crossed a threshold
+type AlertKind string
+
+const (
+	// AlertRequestsPerMinute fires when a prefix sees more observations in
+	// one minute than the threshold allows
+	AlertRequestsPerMinute AlertKind = "requests_per_minute"
+	// AlertDistinctIPs fires when the estimated number of distinct clients
+	// of a prefix exceeds the threshold
+	AlertDistinctIPs AlertKind = "distinct_ips"
+)
+
+// Threshold selects prefixes and the activity limits that trigger alerts for
+// them. A zero limit disables that check.
+type Threshold struct {
+	// CIDR restricts the threshold to one stored prefix
+	CIDR string
+	// Tag restricts the threshold to prefixes whose "tags" metadata
+	// contains this value
+	Tag string
+
+	RequestsPerMinute uint64
+	DistinctIPs       uint64
+}
+
+// Alert describes a threshold being exceeded during Observe
+type Alert struct {
+	Kind      AlertKind              `json:"kind"`
+	CIDR      string                 `json:"cidr"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Value     uint64                 `json:"value"`
+	Limit     uint64                 `json:"limit"`
+	Time      time.Time              `json:"time"`
+	Threshold Threshold              `json:"-"`
+}
+
+// alertHook pairs a threshold with its callback
+type alertHook struct {
+	threshold Threshold
+	fn        func(Alert)
+}
+
+// alertKey identifies one hook and kind in a node's fired set
+type alertKey struct {
+	hook int
+	kind AlertKind
+}
+
+// OnThreshold registers fn to be called from Observe when a matching prefix
+// exceeds one of the threshold's limits. Requests-per-minute alerts fire at
+// most once per prefix per minute; distinct-IP alerts fire once until the
+// distinct counts are reset.
+func (t *IPTrie) OnThreshold(th Threshold, fn func(Alert)) {
+	t.alertHooks = append(t.alertHooks, alertHook{threshold: th, fn: fn})
+}
+
+// applies reports whether the threshold selects the given prefix
+func (th Threshold) applies(n *Node) bool {
+	if th.CIDR != "" && th.CIDR != n.cidr {
+		return false
+	}
+	if th.Tag != "" && !hasTag(n.metadata, th.Tag) {
+		return false
+	}
+	return true
+}
+
+// hasTag reports whether the "tags" metadata entry contains tag
+func hasTag(md map[string]interface{}, tag string) bool {
+	switch tags := md["tags"].(type) {
+	case []string:
+		for _, v := range tags {
+			if v == tag {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range tags {
+			if v == tag {
+				return true
+			}
+		}
+	case string:
+		return tags == tag
+	}
+	return false
+}
+
+// checkAlerts evaluates the registered thresholds against a node that has
+// just been observed
+func (t *IPTrie) checkAlerts(n *Node, minute int64, sketchChanged bool, now time.Time) {
+	for i, hook := range t.alertHooks {
+		th := hook.threshold
+		if !th.applies(n) {
+			continue
+		}
+
+		if th.RequestsPerMinute > 0 {
+			count := n.activity.buckets[minute%activityWindow]
+			key := alertKey{hook: i, kind: AlertRequestsPerMinute}
+			if fired, ok := n.alerted[key]; count > th.RequestsPerMinute && (!ok || fired != minute) {
+				n.markAlerted(key, minute)
+				hook.fn(Alert{
+					Kind:      AlertRequestsPerMinute,
+					CIDR:      n.cidr,
+					Metadata:  n.metadata,
+					Value:     count,
+					Limit:     th.RequestsPerMinute,
+					Time:      now,
+					Threshold: th,
+				})
+			}
+		}
+
+		if th.DistinctIPs > 0 && sketchChanged {
+			key := alertKey{hook: i, kind: AlertDistinctIPs}
+			if _, ok := n.alerted[key]; ok {
+				continue
+			}
+			if estimate := n.distinct.estimate(); estimate > th.DistinctIPs {
+				n.markAlerted(key, minute)
+				hook.fn(Alert{
+					Kind:      AlertDistinctIPs,
+					CIDR:      n.cidr,
+					Metadata:  n.metadata,
+					Value:     estimate,
+					Limit:     th.DistinctIPs,
+					Time:      now,
+					Threshold: th,
+				})
+			}
+		}
+	}
+}
+
+// markAlerted remembers that an alert fired in the given minute
+func (n *Node) markAlerted(key alertKey, minute int64) {
+	if n.alerted == nil {
+		n.alerted = make(map[alertKey]int64)
+	}
+	n.alerted[key] = minute
+}
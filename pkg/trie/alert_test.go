@@ -0,0 +1,71 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRequestsPerMinuteAlert(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"tags": []string{"edge"}})
+	_ = trie.Insert("10.1.0.0/16", map[string]interface{}{"tags": []string{"core"}})
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	trie.now = func() time.Time { return now }
+
+	var alerts []Alert
+	trie.OnThreshold(Threshold{Tag: "edge", RequestsPerMinute: 3}, func(a Alert) {
+		alerts = append(alerts, a)
+	})
+
+	for i := 0; i < 10; i++ {
+		_ = trie.Observe("10.1.0.1")
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("Expected exactly one alert in the first minute, got %d", len(alerts))
+	}
+	if alerts[0].CIDR != "10.0.0.0/8" || alerts[0].Kind != AlertRequestsPerMinute || alerts[0].Value != 4 {
+		t.Errorf("Unexpected alert: %+v", alerts[0])
+	}
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		_ = trie.Observe("10.1.0.1")
+	}
+	if len(alerts) != 1 {
+		t.Errorf("Expected no alert while at the threshold, got %d alerts", len(alerts))
+	}
+	_ = trie.Observe("10.1.0.1")
+	if len(alerts) != 2 {
+		t.Errorf("Expected a second alert in the next minute, got %d alerts", len(alerts))
+	}
+}
+
+func TestDistinctIPsAlert(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("192.168.0.0/16", nil)
+
+	fired := 0
+	trie.OnThreshold(Threshold{CIDR: "192.168.0.0/16", DistinctIPs: 50}, func(a Alert) {
+		fired++
+		if a.Kind != AlertDistinctIPs || a.Value <= 50 {
+			t.Errorf("Unexpected alert: %+v", a)
+		}
+	})
+
+	for i := 0; i < 200; i++ {
+		_ = trie.Observe(fmt.Sprintf("192.168.0.%d", i))
+	}
+	if fired != 1 {
+		t.Fatalf("Expected one distinct-IP alert, got %d", fired)
+	}
+
+	trie.ResetDistinctCounts()
+	for i := 0; i < 200; i++ {
+		_ = trie.Observe(fmt.Sprintf("192.168.1.%d", i))
+	}
+	if fired != 2 {
+		t.Errorf("Expected alert to re-arm after reset, got %d alerts", fired)
+	}
+}
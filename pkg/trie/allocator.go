@@ -0,0 +1,132 @@
+package trie
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+)
+
+// AllocStrategy chooses which free subnet an Allocator hands out
+type AllocStrategy int
+
+const (
+	// FirstFit allocates the lowest free subnet
+	FirstFit AllocStrategy = iota
+	// BestFit allocates from the smallest free block that fits, keeping
+	// larger blocks intact for later, larger requests
+	BestFit
+	// RandomFit allocates a random subnet from a random free block that
+	// fits, making allocations hard to predict
+	RandomFit
+)
+
+// Allocator hands out non-overlapping subnets of parent prefixes stored in
+// a trie. The trie is the only record of allocations: every stored prefix
+// more specific than a parent counts as allocated, whether or not the
+// Allocator created it. Like the trie, an Allocator is not safe for
+// concurrent use.
+type Allocator struct {
+	trie     *IPTrie
+	strategy AllocStrategy
+}
+
+// NewAllocator returns an Allocator over t using strategy
+func NewAllocator(t *IPTrie, strategy AllocStrategy) *Allocator {
+	return &Allocator{trie: t, strategy: strategy}
+}
+
+// Allocate finds a free subnet of length prefixLen within parent, stores it
+// with md and returns it. ErrNoFreeSpace is returned when parent is full.
+func (a *Allocator) Allocate(parent string, prefixLen int, md map[string]interface{}) (netip.Prefix, error) {
+	var (
+		subnet netip.Prefix
+		err    error
+	)
+	if a.strategy == FirstFit {
+		subnet, err = a.trie.FreeSpace(parent, prefixLen)
+	} else {
+		subnet, err = a.pick(parent, prefixLen)
+	}
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	if err := a.trie.Insert(subnet.String(), md); err != nil {
+		return netip.Prefix{}, err
+	}
+	return subnet, nil
+}
+
+// pick chooses a subnet from the free blocks of parent for the best-fit and
+// random strategies
+func (a *Allocator) pick(parent string, prefixLen int) (netip.Prefix, error) {
+	free, err := a.trie.freeBlocks(parent, prefixLen)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	var fits []netip.Prefix
+	for _, block := range free {
+		if block.Bits() <= prefixLen {
+			fits = append(fits, block)
+		}
+	}
+	if len(fits) == 0 {
+		return netip.Prefix{}, ErrNoFreeSpace
+	}
+
+	switch a.strategy {
+	case BestFit:
+		best := fits[0]
+		for _, block := range fits[1:] {
+			if block.Bits() > best.Bits() {
+				best = block
+			}
+		}
+		return netip.PrefixFrom(best.Addr(), prefixLen), nil
+	case RandomFit:
+		block := fits[rand.IntN(len(fits))]
+		addr := block.Addr().AsSlice()
+		for i := block.Bits(); i < prefixLen; i++ {
+			if rand.IntN(2) == 1 {
+				addr[i/8] |= 1 << uint(7-i%8)
+			}
+		}
+		start, _ := netip.AddrFromSlice(addr)
+		return netip.PrefixFrom(start, prefixLen), nil
+	}
+	return netip.Prefix{}, fmt.Errorf("unknown allocation strategy %d", a.strategy)
+}
+
+// Release removes an allocated subnet, returning it to the free space of
+// its parent
+func (a *Allocator) Release(prefix string) error {
+	_, ok, err := a.trie.Remove(prefix)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("CIDR not found")
+	}
+	return nil
+}
+
+// Allocations returns the subnets currently allocated within parent
+func (a *Allocator) Allocations(parent string) ([]Match, error) {
+	pool, err := netip.ParsePrefix(unmapCIDR(parent))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR: %v", err)
+	}
+	matches, err := a.trie.CoveredBy(pool.Masked().String())
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Match
+	for _, m := range matches {
+		if p, ok := storedPrefix(m.CIDR); ok && p.Bits() > pool.Bits() {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
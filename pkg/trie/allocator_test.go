@@ -0,0 +1,101 @@
+package trie
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestAllocatorStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy AllocStrategy
+		want     string
+	}{
+		// 10.0.0.0/26 is taken, leaving a /26 hole before the free /25
+		{name: "first fit", strategy: FirstFit, want: "10.0.0.64/27"},
+		{name: "best fit", strategy: BestFit, want: "10.0.0.224/27"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trie := NewIPTrie()
+			trie.Insert("10.0.0.0/24", nil)
+			trie.Insert("10.0.0.0/26", nil)
+			trie.Insert("10.0.0.128/26", nil)
+			trie.Insert("10.0.0.192/27", nil)
+
+			alloc := NewAllocator(trie, tt.strategy)
+			got, err := alloc.Allocate("10.0.0.0/24", 27, map[string]interface{}{"owner": "x"})
+			if err != nil {
+				t.Fatalf("Allocate returned error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+			if md, ok := trie.FindExact(got.String()); !ok || md["owner"] != "x" {
+				t.Errorf("Expected allocation to be stored with metadata, got %v %v", md, ok)
+			}
+		})
+	}
+}
+
+func TestAllocatorExhaustion(t *testing.T) {
+	for _, strategy := range []AllocStrategy{FirstFit, BestFit, RandomFit} {
+		trie := NewIPTrie()
+		trie.Insert("192.168.0.0/24", nil)
+		alloc := NewAllocator(trie, strategy)
+
+		seen := make(map[netip.Prefix]bool)
+		for i := 0; i < 16; i++ {
+			p, err := alloc.Allocate("192.168.0.0/24", 28, nil)
+			if err != nil {
+				t.Fatalf("strategy %d: Allocate %d returned error: %v", strategy, i, err)
+			}
+			if seen[p] || !netip.MustParsePrefix("192.168.0.0/24").Contains(p.Addr()) {
+				t.Fatalf("strategy %d: unexpected allocation %s", strategy, p)
+			}
+			seen[p] = true
+		}
+
+		if _, err := alloc.Allocate("192.168.0.0/24", 28, nil); !errors.Is(err, ErrNoFreeSpace) {
+			t.Errorf("strategy %d: Expected ErrNoFreeSpace, got %v", strategy, err)
+		}
+
+		// Releasing one subnet makes exactly that space available again
+		if err := alloc.Release("192.168.0.32/28"); err != nil {
+			t.Fatalf("Release returned error: %v", err)
+		}
+		p, err := alloc.Allocate("192.168.0.0/24", 28, nil)
+		if err != nil || p.String() != "192.168.0.32/28" {
+			t.Errorf("strategy %d: Expected released subnet back, got %s (%v)", strategy, p, err)
+		}
+	}
+}
+
+func TestAllocatorReleaseAndList(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("2001:db8::/32", nil)
+	alloc := NewAllocator(trie, FirstFit)
+
+	a, _ := alloc.Allocate("2001:db8::/32", 48, nil)
+	b, _ := alloc.Allocate("2001:db8::/32", 48, nil)
+	if a.String() != "2001:db8::/48" || b.String() != "2001:db8:1::/48" {
+		t.Errorf("Expected consecutive /48s, got %s and %s", a, b)
+	}
+
+	list, err := alloc.Allocations("2001:db8::/32")
+	if err != nil || len(list) != 2 {
+		t.Fatalf("Expected 2 allocations, got %v (%v)", list, err)
+	}
+
+	if err := alloc.Release(a.String()); err != nil {
+		t.Errorf("Release returned error: %v", err)
+	}
+	if err := alloc.Release(a.String()); err == nil {
+		t.Errorf("Expected error releasing an unallocated subnet")
+	}
+	if _, err := alloc.Allocate("2001:db8::/32", 16, nil); err == nil {
+		t.Errorf("Expected error for a prefix shorter than the parent")
+	}
+}
@@ -0,0 +1,164 @@
+package trie
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// batchTrie returns a trie with random IPv4 and IPv6 prefixes and host
+// routes, and addresses to look up in it
+func batchTrie(t testing.TB, n int) (*IPTrie, []netip.Addr) {
+	trie := NewIPTrie()
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		var cidr string
+		switch i % 3 {
+		case 0:
+			cidr = fmt.Sprintf("10.%d.%d.0/%d", r.Intn(256), r.Intn(256), 8+r.Intn(17))
+		case 1:
+			cidr = fmt.Sprintf("10.%d.%d.%d/32", r.Intn(4), r.Intn(256), r.Intn(256))
+		default:
+			cidr = fmt.Sprintf("2001:db8:%x::/%d", r.Intn(65536), 32+r.Intn(33))
+		}
+		if err := trie.Insert(cidr, map[string]interface{}{"i": i}); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", cidr, err)
+		}
+	}
+
+	ips := make([]netip.Addr, 0, 4*n)
+	for i := 0; i < 4*n; i++ {
+		switch i % 4 {
+		case 0:
+			ips = append(ips, netip.AddrFrom4([4]byte{10, byte(r.Intn(4)), byte(r.Intn(256)), byte(r.Intn(256))}))
+		case 1:
+			ips = append(ips, netip.AddrFrom4([4]byte{10, byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))}))
+		case 2:
+			ips = append(ips, netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, byte(r.Intn(256)), byte(r.Intn(256)), 15: 1}))
+		default:
+			// IPv4-mapped, which must match IPv4 prefixes
+			ips = append(ips, netip.AddrFrom16([16]byte{10: 0xff, 11: 0xff, 12: 10, 13: byte(r.Intn(4)), 14: byte(r.Intn(256)), 15: byte(r.Intn(256))}))
+		}
+	}
+	return trie, ips
+}
+
+func TestFindBatchMatchesFind(t *testing.T) {
+	trie, ips := batchTrie(t, 3000)
+	ips = append(ips, netip.Addr{}, netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("fe80::1%eth0"))
+
+	got := trie.FindBatch(ips)
+	if len(got) != len(ips) {
+		t.Fatalf("Expected %d results, got %d", len(ips), len(got))
+	}
+	matched := 0
+	for i, addr := range ips {
+		cidr, _, err := trie.Find(addr.WithZone("").String())
+		if err != nil {
+			cidr = ""
+		}
+		if got[i].CIDR != cidr {
+			t.Fatalf("%s: FindBatch returned %q, Find returned %q", addr, got[i].CIDR, cidr)
+		}
+		if cidr != "" {
+			matched++
+			if got[i].PrefixLen != prefixLen(cidr) || got[i].Metadata == nil {
+				t.Errorf("%s: unexpected match %+v", addr, got[i])
+			}
+		}
+	}
+	if matched == 0 {
+		t.Errorf("Expected some addresses to match")
+	}
+}
+
+func TestFindBatchParallel(t *testing.T) {
+	trie, ips := batchTrie(t, 3000)
+	want := trie.FindBatch(ips)
+
+	for _, workers := range []int{0, 1, 3, 64} {
+		got := trie.FindBatchParallel(ips, workers)
+		if len(got) != len(want) {
+			t.Fatalf("workers %d: expected %d results, got %d", workers, len(want), len(got))
+		}
+		for i := range want {
+			if got[i].CIDR != want[i].CIDR {
+				t.Fatalf("workers %d: %s: expected %q, got %q", workers, ips[i], want[i].CIDR, got[i].CIDR)
+			}
+		}
+	}
+
+	if got := trie.FindBatchParallel(nil, 4); len(got) != 0 {
+		t.Errorf("Expected no results for an empty batch, got %d", len(got))
+	}
+}
+
+func TestFindBatchHits(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	trie := NewIPTrie(WithHitTracking())
+	trie.now = func() time.Time { return now }
+	trie.Insert("10.0.0.0/8", nil)
+	trie.Insert("10.1.0.0/16", nil)
+
+	ips := []netip.Addr{
+		netip.MustParseAddr("10.1.2.3"),
+		netip.MustParseAddr("10.2.0.1"),
+		netip.MustParseAddr("::ffff:10.1.0.1"),
+		netip.MustParseAddr("192.0.2.1"),
+	}
+	trie.FindBatch(ips)
+	if u, _ := trie.Hits("10.1.0.0/16"); u.Hits != 2 || !u.LastHit.Equal(now) {
+		t.Errorf("Expected 2 hits on 10.1.0.0/16, got %+v", u)
+	}
+	if u, _ := trie.Hits("10.0.0.0/8"); u.Hits != 1 {
+		t.Errorf("Expected 1 hit on 10.0.0.0/8, got %+v", u)
+	}
+
+	// Parallel batches count every lookup too; run with -race to check
+	many := make([]netip.Addr, 4*minParallelChunk)
+	for i := range many {
+		many[i] = ips[0]
+	}
+	trie.FindBatchParallel(many, 4)
+	if u, _ := trie.Hits("10.1.0.0/16"); u.Hits != uint64(2+len(many)) {
+		t.Errorf("Expected %d hits on 10.1.0.0/16, got %+v", 2+len(many), u)
+	}
+}
+
+func TestFindBatchAllocs(t *testing.T) {
+	trie, ips := batchTrie(t, 1000)
+	allocs := testing.AllocsPerRun(10, func() {
+		trie.FindBatch(ips)
+	})
+	if allocs > 1 {
+		t.Errorf("Expected only the result slice to be allocated, got %v allocations", allocs)
+	}
+}
+
+func BenchmarkFindBatch(b *testing.B) {
+	trie, ips := batchTrie(b, 10000)
+	strs := make([]string, len(ips))
+	for i, addr := range ips {
+		strs[i] = addr.String()
+	}
+
+	b.Run("Find", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, ip := range strs {
+				_, _, _ = trie.Find(ip)
+			}
+		}
+	})
+	b.Run("FindBatch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.FindBatch(ips)
+		}
+	})
+	b.Run("FindBatchParallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.FindBatchParallel(ips, 0)
+		}
+	})
+}
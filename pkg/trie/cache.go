@@ -0,0 +1,224 @@
+package trie
+
+import (
+	"container/list"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// CacheConfig sizes a LookupCache
+type CacheConfig struct {
+	// Size is the maximum number of cached results. Defaults to 65536.
+	Size int
+	// BucketV4 and BucketV6 share one cached result between every address
+	// of an IPv4 or IPv6 prefix of this length, such as 24 or 64. A bucket
+	// is only used while no stored prefix is longer than it and falls
+	// inside it, so results are never wrong. Zero caches each address on
+	// its own.
+	BucketV4 int
+	BucketV6 int
+}
+
+// CacheStats counts the lookups a LookupCache answered
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Len is the number of cached results
+	Len int
+}
+
+// defaultCacheSize is the Size of a zero CacheConfig
+const defaultCacheSize = 65536
+
+// cacheEntry is one cached result
+type cacheEntry struct {
+	key     netip.Prefix
+	matches []Match // in the trie's match order
+	// hits is the counter of the most specific match when the trie tracks
+	// hits, so that Find counts cached lookups as IPTrie.Find would
+	hits *hitCounter
+}
+
+// LookupCache answers lookups from a least recently used cache in front of
+// a trie, for workloads where the same addresses repeat heavily. Cached
+// results overlapping a changed prefix are dropped as the trie changes.
+//
+// The cache is safe for concurrent lookups, but like the trie it needs
+// external locking against writers.
+type LookupCache struct {
+	t   *IPTrie
+	cfg CacheConfig
+
+	mu      sync.Mutex
+	lru     *list.List // front is most recently used
+	entries map[netip.Prefix]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+// NewLookupCache returns a cache of lookups in t. Changes made through
+// UnmarshalJSON or UnmarshalBinary emit no events, so call Purge after
+// them.
+func NewLookupCache(t *IPTrie, cfg CacheConfig) *LookupCache {
+	if cfg.Size <= 0 {
+		cfg.Size = defaultCacheSize
+	}
+	c := &LookupCache{
+		t:       t,
+		cfg:     cfg,
+		lru:     list.New(),
+		entries: make(map[netip.Prefix]*list.Element),
+	}
+	t.OnChange(c.invalidate)
+	return c
+}
+
+// Find returns the most specific prefix containing ip, like IPTrie.Find,
+// and counts the hit when the trie tracks hits
+func (c *LookupCache) Find(ip string) (string, map[string]interface{}, error) {
+	matches, hits, err := c.lookup(ip)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(matches) == 0 {
+		return "", nil, errNoMatch
+	}
+	if hits != nil {
+		hits.record(c.t.clock())
+	}
+	best := matches[0]
+	if c.t.matchOrder != MostSpecificFirst {
+		best = matches[len(matches)-1]
+	}
+	return best.CIDR, best.Metadata, nil
+}
+
+// FindAll returns every prefix containing ip, like IPTrie.FindAll
+func (c *LookupCache) FindAll(ip string) ([]Match, error) {
+	matches, _, err := c.lookup(ip)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Clone(matches), nil
+}
+
+// lookup returns the cached matches for ip and the hit counter of the
+// most specific one, looking them up on a miss
+func (c *LookupCache) lookup(ip string) ([]Match, *hitCounter, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		return nil, nil, errInvalidIP
+	}
+	addr = addr.Unmap()
+	host := netip.PrefixFrom(addr, addr.BitLen())
+	bucket := host
+	if bits := c.bucketBits(addr); bits > 0 && bits < addr.BitLen() {
+		bucket = netip.PrefixFrom(addr, bits).Masked()
+	}
+
+	c.mu.Lock()
+	for _, key := range []netip.Prefix{bucket, host} {
+		if el, ok := c.entries[key]; ok {
+			c.lru.MoveToFront(el)
+			c.hits++
+			entry := el.Value.(*cacheEntry)
+			matches, hits := entry.matches, entry.hits
+			c.mu.Unlock()
+			return matches, hits, nil
+		}
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	matches, err := c.t.FindAll(addr.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	var hits *hitCounter
+	if c.t.trackHits {
+		if n := c.t.lookupAddr(addr); n != nil {
+			hits = n.hits
+		}
+	}
+	key := host
+	if bucket != host && c.bucketUniform(bucket) {
+		key = bucket
+	}
+	c.add(key, matches, hits)
+	return matches, hits, nil
+}
+
+// bucketBits returns the configured bucket length for addr's family
+func (c *LookupCache) bucketBits(addr netip.Addr) int {
+	if addr.Is4() {
+		return c.cfg.BucketV4
+	}
+	return c.cfg.BucketV6
+}
+
+// bucketUniform reports whether every address in bucket has the same
+// matches, which holds when no stored prefix inside it is longer than it
+func (c *LookupCache) bucketUniform(bucket netip.Prefix) bool {
+	uniform := true
+	c.t.walkCovered(bucket.String(), func(n *Node) bool {
+		uniform = n.match().PrefixLen <= bucket.Bits()
+		return uniform
+	})
+	return uniform
+}
+
+// add caches matches under key, evicting the least recently used result
+// when the cache is full
+func (c *LookupCache) add(key netip.Prefix, matches []Match, hits *hitCounter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.matches, entry.hits = matches, hits
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, matches: matches, hits: hits})
+	for c.lru.Len() > c.cfg.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate is the trie's change hook. It drops every cached result for
+// addresses inside or around the changed prefix, which takes time
+// proportional to the number of cached results.
+func (c *LookupCache) invalidate(e Event) {
+	changed, ok := storedPrefix(e.CIDR)
+	if !ok {
+		c.Purge()
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if key := el.Value.(*cacheEntry).key; key.Overlaps(changed) {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+		}
+		el = next
+	}
+}
+
+// Purge empties the cache
+func (c *LookupCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	clear(c.entries)
+}
+
+// Stats returns the hit and miss counts and the number of cached results
+func (c *LookupCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Len: c.lru.Len()}
+}
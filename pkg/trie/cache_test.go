@@ -0,0 +1,195 @@
+package trie
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLookupCache(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"name": "ten"})
+	trie.Insert("10.1.0.0/16", map[string]interface{}{"name": "ten-one"})
+	c := NewLookupCache(trie, CacheConfig{})
+
+	for i := 0; i < 3; i++ {
+		cidr, md, err := c.Find("10.1.2.3")
+		if err != nil || cidr != "10.1.0.0/16" || md["name"] != "ten-one" {
+			t.Fatalf("Expected 10.1.0.0/16, got %s %v %v", cidr, md, err)
+		}
+	}
+	if st := c.Stats(); st.Hits != 2 || st.Misses != 1 || st.Len != 1 {
+		t.Errorf("Expected 2 hits, 1 miss and 1 entry, got %+v", st)
+	}
+
+	// Inserts, updates and deletes overlapping a cached address drop it
+	steps := []struct {
+		change func() error
+		want   string
+		name   string
+	}{
+		{func() error { return trie.Insert("10.1.2.0/24", map[string]interface{}{"name": "ten-one-two"}) }, "10.1.2.0/24", "ten-one-two"},
+		{func() error { return trie.Insert("10.1.2.0/24", map[string]interface{}{"name": "renamed"}) }, "10.1.2.0/24", "renamed"},
+		{func() error { return trie.Delete("10.1.2.0/24") }, "10.1.0.0/16", "ten-one"},
+		{func() error { return trie.Insert("10.1.2.3/32", map[string]interface{}{"name": "host"}) }, "10.1.2.3/32", "host"},
+	}
+	for i, s := range steps {
+		if err := s.change(); err != nil {
+			t.Fatalf("Step %d failed: %v", i, err)
+		}
+		if cidr, md, _ := c.Find("10.1.2.3"); cidr != s.want || md["name"] != s.name {
+			t.Errorf("Step %d: expected %s %s, got %s %v", i, s.want, s.name, cidr, md)
+		}
+	}
+
+	// Changes elsewhere keep the entry
+	c.Find("10.1.2.3")
+	trie.Insert("192.168.0.0/16", map[string]interface{}{})
+	before := c.Stats().Hits
+	c.Find("10.1.2.3")
+	if c.Stats().Hits != before+1 {
+		t.Errorf("Expected an unrelated change to keep the cached result")
+	}
+
+	if _, _, err := c.Find("11.0.0.1"); err == nil {
+		t.Errorf("Expected error for an unmatched address")
+	}
+	if _, _, err := c.Find("11.0.0.1"); err == nil {
+		t.Errorf("Expected the cached miss to still be an error")
+	}
+	if _, err := c.FindAll("bogus"); err == nil {
+		t.Errorf("Expected error for an invalid IP")
+	}
+	matches, err := c.FindAll("::ffff:10.1.2.3")
+	if err != nil || len(matches) != 3 || matches[0].CIDR != "10.1.2.3/32" {
+		t.Errorf("Expected 3 matches most specific first, got %v %v", matches, err)
+	}
+
+	c.Purge()
+	if st := c.Stats(); st.Len != 0 {
+		t.Errorf("Expected an empty cache after Purge, got %+v", st)
+	}
+}
+
+func TestLookupCacheBuckets(t *testing.T) {
+	trie := NewIPTrie(WithMatchOrder(LeastSpecificFirst))
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"name": "ten"})
+	trie.Insert("10.2.3.128/25", map[string]interface{}{"name": "half"})
+	trie.Insert("2001:db8::/32", map[string]interface{}{"name": "doc"})
+	c := NewLookupCache(trie, CacheConfig{BucketV4: 24, BucketV6: 64})
+
+	// Addresses of a /24 share one entry
+	for i := 1; i <= 10; i++ {
+		if cidr, _, _ := c.Find(fmt.Sprintf("10.1.1.%d", i)); cidr != "10.0.0.0/8" {
+			t.Fatalf("Expected 10.0.0.0/8, got %s", cidr)
+		}
+	}
+	for i := 1; i <= 10; i++ {
+		c.Find(fmt.Sprintf("2001:db8::%x", i))
+	}
+	if st := c.Stats(); st.Misses != 2 || st.Len != 2 {
+		t.Errorf("Expected one miss per bucket, got %+v", st)
+	}
+
+	// A /24 holding a longer prefix is cached per address
+	if cidr, _, _ := c.Find("10.2.3.1"); cidr != "10.0.0.0/8" {
+		t.Errorf("Expected 10.0.0.0/8, got %s", cidr)
+	}
+	if cidr, _, _ := c.Find("10.2.3.200"); cidr != "10.2.3.128/25" {
+		t.Errorf("Expected 10.2.3.128/25, got %s", cidr)
+	}
+
+	// Inserting a longer prefix into a cached bucket drops it
+	trie.Insert("10.1.1.8/29", map[string]interface{}{"name": "small"})
+	if cidr, _, _ := c.Find("10.1.1.9"); cidr != "10.1.1.8/29" {
+		t.Errorf("Expected 10.1.1.8/29, got %s", cidr)
+	}
+	if cidr, _, _ := c.Find("10.1.1.1"); cidr != "10.0.0.0/8" {
+		t.Errorf("Expected 10.0.0.0/8, got %s", cidr)
+	}
+}
+
+func TestLookupCacheEviction(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.0.0.0/8", map[string]interface{}{})
+	c := NewLookupCache(trie, CacheConfig{Size: 2})
+
+	c.Find("10.0.0.1")
+	c.Find("10.0.0.2")
+	c.Find("10.0.0.1") // 10.0.0.2 is now least recently used
+	c.Find("10.0.0.3")
+	if st := c.Stats(); st.Len != 2 {
+		t.Errorf("Expected 2 entries, got %+v", st)
+	}
+	misses := c.Stats().Misses
+	c.Find("10.0.0.1")
+	if c.Stats().Misses != misses {
+		t.Errorf("Expected 10.0.0.1 to still be cached")
+	}
+	c.Find("10.0.0.2")
+	if c.Stats().Misses != misses+1 {
+		t.Errorf("Expected 10.0.0.2 to have been evicted")
+	}
+}
+
+func TestLookupCacheHitTracking(t *testing.T) {
+	trie := NewIPTrie(WithHitTracking())
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	trie.now = func() time.Time { return now }
+	trie.Insert("10.0.0.0/8", map[string]interface{}{})
+	trie.Insert("10.1.0.0/16", map[string]interface{}{})
+	c := NewLookupCache(trie, CacheConfig{BucketV4: 24})
+
+	for i := 0; i < 3; i++ {
+		c.Find("10.1.2.3")
+		c.Find("10.2.0.1")
+	}
+	c.FindAll("10.1.2.3") // a cache hit, but like IPTrie.FindAll not counted
+	c.Find("11.0.0.1")
+	if st := c.Stats(); st.Hits != 5 || st.Misses != 3 {
+		t.Errorf("Expected 5 cache hits and 3 misses, got %+v", st)
+	}
+	for cidr, want := range map[string]uint64{"10.0.0.0/8": 3, "10.1.0.0/16": 3} {
+		if u, _ := trie.Hits(cidr); u.Hits != want || !u.LastHit.Equal(now) {
+			t.Errorf("Expected %s to have %d hits, got %+v", cidr, want, u)
+		}
+	}
+
+	// An update keeps the counter, so its cached replacement does too
+	trie.Insert("10.1.0.0/16", map[string]interface{}{"owner": "dev"})
+	c.Find("10.1.2.3")
+	c.Find("10.1.2.3")
+	if u, _ := trie.Hits("10.1.0.0/16"); u.Hits != 5 {
+		t.Errorf("Expected 5 hits after the update, got %d", u.Hits)
+	}
+}
+
+func TestLookupCacheConcurrentMisses(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.0.0.0/8", map[string]interface{}{})
+	trie.Insert("2001:db8::/32", map[string]interface{}{})
+	for i := 0; i < 64; i++ {
+		trie.Insert(fmt.Sprintf("10.0.%d.1/32", i), map[string]interface{}{})
+		trie.Insert(fmt.Sprintf("2001:db8:0:%x::1/128", i), map[string]interface{}{})
+	}
+	// Buckets are checked against the host routes on every miss
+	c := NewLookupCache(trie, CacheConfig{Size: 16, BucketV4: 24, BucketV6: 64})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 256; i++ {
+				want := "10.0.0.0/8"
+				if cidr, _, err := c.Find(fmt.Sprintf("10.0.%d.2", i%128)); err != nil || cidr != want {
+					t.Errorf("Expected %s, got %s %v", want, cidr, err)
+					return
+				}
+				c.Find(fmt.Sprintf("2001:db8:0:%x::2", i%128))
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,141 @@
+package trie
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// maxProblems bounds the problems listed in a Health report. Counts keep
+// going past it.
+const maxProblems = 20
+
+// Health is the result of CheckStructure
+type Health struct {
+	// Prefixes is the number of stored prefixes found by walking the
+	// structure, which should equal Len
+	Prefixes int `json:"prefixes"`
+	// Nodes counts trie nodes, including both family roots, as Stats does
+	Nodes int `json:"nodes"`
+	// Orphans counts nodes with no stored prefix at or below them. Delete
+	// prunes these, so any left behind are leaked memory.
+	Orphans int `json:"orphans"`
+	// Problems describes each inconsistency found, up to a limit
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether the check found nothing wrong
+func (h Health) OK() bool {
+	return h.Orphans == 0 && len(h.Problems) == 0
+}
+
+func (h *Health) problem(format string, args ...interface{}) {
+	if len(h.Problems) < maxProblems {
+		h.Problems = append(h.Problems, fmt.Sprintf(format, args...))
+	}
+}
+
+// CheckStructure walks the whole trie and verifies its invariants: every
+// branch leads to a stored prefix, stored prefixes sit at the node their
+// CIDR names, cleared nodes hold no leftover data and the counters behind
+// Len agree with what is stored. It is meant for soak tests and debugging
+// after heavy churn, and takes time proportional to the number of nodes.
+func (t *IPTrie) CheckStructure() Health {
+	var h Health
+	var path [16]byte
+	v4 := t.checkNode(t.root4, &path, 0, net.IPv4len, &h)
+	path = [16]byte{}
+	v6 := t.checkNode(t.root6, &path, 0, net.IPv6len, &h)
+
+	for addr, host := range t.hosts {
+		switch {
+		case !host.isEnd:
+			h.problem("host route %s is not marked as stored", addr)
+		case host.cidr == "":
+			h.problem("host route %s has no CIDR", addr)
+		default:
+			if p, ok := storedPrefix(host.cidr); !ok || p.Addr() != addr || p.Bits() != addr.BitLen() {
+				h.problem("host route %s holds CIDR %s", addr, host.cidr)
+			}
+		}
+		if addr.Is4() {
+			v4++
+		} else {
+			v6++
+		}
+	}
+
+	h.Prefixes = v4 + v6
+	if v4 != t.len4 || v6 != t.len6 {
+		h.problem("counted %d IPv4 and %d IPv6 prefixes, Len reports %d and %d", v4, v6, t.len4, t.len6)
+	}
+	return h
+}
+
+// checkNode checks node, whose path of depth bits is held in path, and its
+// descendants. It returns the number of prefixes stored at or below node.
+func (t *IPTrie) checkNode(node *Node, path *[16]byte, depth, size int, h *Health) int {
+	h.Nodes++
+
+	stored := 0
+	if node.isEnd {
+		stored++
+		addr, _ := netip.AddrFromSlice(path[:size])
+		want := netip.PrefixFrom(addr, depth)
+		if p, ok := storedPrefix(node.cidr); !ok || p != want {
+			h.problem("node %s holds CIDR %q", want, node.cidr)
+		}
+	} else if node.cidr != "" || len(node.metadata) > 0 || node.distinct != nil || node.activity != nil {
+		addr, _ := netip.AddrFromSlice(path[:size])
+		h.problem("node %s is not stored but holds data of %q", netip.PrefixFrom(addr, depth), node.cidr)
+	}
+
+	// Orphaned children are reported here unless node turns out to hold
+	// no prefixes either, in which case its parent reports node instead
+	var orphans []netip.Prefix
+	for bit, child := range node.children {
+		if bit > 1 || depth >= size*8 {
+			addr, _ := netip.AddrFromSlice(path[:size])
+			h.problem("node %s has an invalid child %d", netip.PrefixFrom(addr, depth), bit)
+			continue
+		}
+		if bit == 1 {
+			path[depth/8] |= 1 << (7 - depth%8)
+		}
+		below := t.checkNode(child, path, depth+1, size, h)
+		if below == 0 {
+			h.Orphans++
+			addr, _ := netip.AddrFromSlice(path[:size])
+			orphans = append(orphans, netip.PrefixFrom(addr, depth+1))
+		}
+		path[depth/8] &^= 1 << (7 - depth%8)
+		stored += below
+	}
+	if stored > 0 || depth == 0 {
+		for _, p := range orphans {
+			h.problem("branch %s holds no prefixes", p)
+		}
+	}
+	return stored
+}
+
+// WithSelfCheck runs CheckStructure after every n-th successful Remove or
+// Delete and passes the result to fn, e.g. to export it as metrics or to
+// fail a soak test. fn runs synchronously and must not modify the trie.
+func WithSelfCheck(n int, fn func(Health)) Option {
+	return func(t *IPTrie) {
+		t.selfCheckEvery = n
+		t.selfCheckFn = fn
+	}
+}
+
+// removed counts a removal and runs the self-check when one is due
+func (t *IPTrie) removed() {
+	if t.selfCheckEvery <= 0 || t.selfCheckFn == nil {
+		return
+	}
+	t.removals++
+	if t.removals%t.selfCheckEvery == 0 {
+		t.selfCheckFn(t.CheckStructure())
+	}
+}
@@ -0,0 +1,154 @@
+package trie
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestCheckStructureAfterChurn(t *testing.T) {
+	var checks []Health
+	trie := NewIPTrie(WithSelfCheck(1000, func(h Health) {
+		checks = append(checks, h)
+	}))
+
+	// Prefix lengths around byte boundaries are the ones most likely to
+	// leave branches behind
+	r := rand.New(rand.NewSource(1))
+	var cidrs []string
+	for i := 0; i < 5000; i++ {
+		var cidr string
+		if r.Intn(2) == 0 {
+			cidr = fmt.Sprintf("%d.%d.%d.%d/%d", r.Intn(4), r.Intn(256), r.Intn(256), r.Intn(256), 1+r.Intn(32))
+		} else {
+			cidr = fmt.Sprintf("2001:db8:%x::%x/%d", r.Intn(16), r.Intn(65536), 1+r.Intn(128))
+		}
+		if err := trie.Insert(cidr, map[string]interface{}{"i": i}); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", cidr, err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+
+	r.Shuffle(len(cidrs), func(i, j int) { cidrs[i], cidrs[j] = cidrs[j], cidrs[i] })
+	for i, cidr := range cidrs {
+		trie.Remove(cidr)
+		if i == len(cidrs)/2 {
+			if h := trie.CheckStructure(); !h.OK() || h.Prefixes != trie.Len() {
+				t.Errorf("Unhealthy halfway through deletes: %+v", h)
+			}
+		}
+	}
+
+	h := trie.CheckStructure()
+	if !h.OK() || h.Prefixes != 0 || h.Nodes != 2 {
+		t.Errorf("Expected an empty trie with only its roots, got %+v", h)
+	}
+	if len(checks) == 0 {
+		t.Fatalf("Expected the self-check to run")
+	}
+	for _, c := range checks {
+		if !c.OK() {
+			t.Errorf("Self-check failed: %+v", c)
+		}
+	}
+}
+
+func TestCheckStructureFindsProblems(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(trie *IPTrie)
+		orphans int
+		problem string
+	}{
+		{
+			name: "orphaned branch",
+			corrupt: func(trie *IPTrie) {
+				// 128.0.0.0/1 -> 192.0.0.0/2, neither stored
+				leaf := &Node{children: map[byte]*Node{}}
+				trie.root4.children[1] = &Node{children: map[byte]*Node{1: leaf}}
+			},
+			orphans: 2,
+			problem: "branch 128.0.0.0/1 holds no prefixes",
+		},
+		{
+			name: "stale data",
+			corrupt: func(trie *IPTrie) {
+				trie.root4.children[0].cidr = "0.0.0.0/1"
+			},
+			problem: "node 0.0.0.0/1 is not stored",
+		},
+		{
+			name: "misplaced CIDR",
+			corrupt: func(trie *IPTrie) {
+				n, _ := trie.lookupExact("10.0.0.0/8")
+				n.cidr = "11.0.0.0/8"
+			},
+			problem: `node 10.0.0.0/8 holds CIDR "11.0.0.0/8"`,
+		},
+		{
+			name: "wrong count",
+			corrupt: func(trie *IPTrie) {
+				trie.len6++
+			},
+			problem: "counted 3 IPv4 and 1 IPv6 prefixes, Len reports 3 and 2",
+		},
+		{
+			name: "bad host route",
+			corrupt: func(trie *IPTrie) {
+				for _, host := range trie.hosts {
+					host.cidr = "10.0.0.2/32"
+				}
+			},
+			problem: "host route 10.0.0.1 holds CIDR 10.0.0.2/32",
+		},
+	}
+
+	for _, tt := range tests {
+		trie := NewIPTrie()
+		_ = trie.Insert("10.0.0.0/8", nil)
+		_ = trie.Insert("10.20.0.0/16", nil)
+		_ = trie.Insert("10.0.0.1/32", nil)
+		_ = trie.Insert("2001:db8::/32", nil)
+		if h := trie.CheckStructure(); !h.OK() || h.Prefixes != 4 {
+			t.Fatalf("%s: expected a healthy trie before corrupting it, got %+v", tt.name, h)
+		}
+
+		tt.corrupt(trie)
+		h := trie.CheckStructure()
+		if h.OK() {
+			t.Errorf("%s: expected the check to fail", tt.name)
+		}
+		if h.Orphans != tt.orphans {
+			t.Errorf("%s: expected %d orphans, got %d", tt.name, tt.orphans, h.Orphans)
+		}
+		if len(h.Problems) != 1 || !strings.HasPrefix(h.Problems[0], tt.problem) {
+			t.Errorf("%s: expected problem %q, got %q", tt.name, tt.problem, h.Problems)
+		}
+	}
+}
+
+func TestSelfCheckInterval(t *testing.T) {
+	runs := 0
+	trie := NewIPTrie(WithSelfCheck(2, func(Health) { runs++ }))
+	for i := 0; i < 5; i++ {
+		_ = trie.Insert(fmt.Sprintf("10.%d.0.0/16", i), nil)
+	}
+	_ = trie.Insert("10.0.0.1/32", nil)
+
+	_ = trie.Delete("10.0.0.0/16")
+	_ = trie.Delete("10.9.0.0/16") // not stored, does not count
+	_ = trie.Delete("10.0.0.1/32")
+	_ = trie.Delete("10.1.0.0/16")
+	if runs != 1 {
+		t.Errorf("Expected 1 self-check after 3 removals, got %d", runs)
+	}
+
+	// Clones keep the option
+	c := trie.Clone()
+	_ = c.Delete("10.2.0.0/16")
+	_ = c.Delete("10.3.0.0/16")
+	if runs != 2 {
+		t.Errorf("Expected the clone to run the self-check, got %d runs", runs)
+	}
+}
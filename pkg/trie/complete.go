@@ -0,0 +1,76 @@
+package trie
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// completion is one entry of the autocomplete index
+type completion struct {
+	key  string // lowercased CIDR for case-insensitive IPv6 matching
+	node *Node
+}
+
+// completionCache holds the autocomplete index. Writes drop it; the first
+// Complete call after them builds it again under mu, so concurrent readers
+// sort the prefixes once between changes rather than on every keystroke.
+type completionCache struct {
+	mu    sync.Mutex
+	index []completion
+}
+
+// completionIndex returns the stored prefixes sorted by their CIDR text,
+// building and caching them if a change dropped the index
+func (t *IPTrie) completionIndex() []completion {
+	t.completions.mu.Lock()
+	defer t.completions.mu.Unlock()
+	if t.completions.index == nil && t.Len() > 0 {
+		t.completions.index = t.buildCompletions()
+	}
+	return t.completions.index
+}
+
+// dropCompletions invalidates the autocomplete index after a change
+func (t *IPTrie) dropCompletions() {
+	t.completions.mu.Lock()
+	t.completions.index = nil
+	t.completions.mu.Unlock()
+}
+
+// buildCompletions sorts the stored prefixes by their CIDR text
+func (t *IPTrie) buildCompletions() []completion {
+	index := make([]completion, 0, t.Len())
+	t.walkNodes(func(n *Node) bool {
+		index = append(index, completion{key: strings.ToLower(n.cidr), node: n})
+		return true
+	})
+	sort.Slice(index, func(i, j int) bool {
+		return index[i].key < index[j].key
+	})
+	return index
+}
+
+// Complete returns up to limit stored prefixes whose CIDR text starts with
+// partial, e.g. "10.20." or "2001:db8:", in lexical order, for interactive
+// autocompletion. A limit of zero or less returns every candidate.
+//
+// Complete searches a sorted index in O(log n). BuildIndexes builds it, as
+// TrieHolder.Store does; after a change the first Complete call sorts the
+// prefixes in O(n log n) to build it again, and later calls reuse it.
+func (t *IPTrie) Complete(partial string, limit int) []Match {
+	index := t.completionIndex()
+	partial = strings.ToLower(partial)
+	i := sort.Search(len(index), func(i int) bool {
+		return index[i].key >= partial
+	})
+
+	var matches []Match
+	for ; i < len(index) && strings.HasPrefix(index[i].key, partial); i++ {
+		if limit > 0 && len(matches) == limit {
+			break
+		}
+		matches = append(matches, index[i].node.match())
+	}
+	return matches
+}
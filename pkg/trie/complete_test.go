@@ -0,0 +1,113 @@
+package trie
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.20.0.0/16", "10.20.20.0/24", "10.20.100.0/24", "10.2.0.0/16", "10.200.0.0/16", "2001:DB8::/32", "2001:db8:1::/48", "10.20.30.4/32"} {
+		trie.Insert(cidr, nil)
+	}
+
+	tests := []struct {
+		name    string
+		partial string
+		limit   int
+		want    []string
+	}{
+		{name: "dotted prefix", partial: "10.20.", want: []string{"10.20.0.0/16", "10.20.100.0/24", "10.20.20.0/24", "10.20.30.4/32"}},
+		{name: "limited", partial: "10.20.", limit: 2, want: []string{"10.20.0.0/16", "10.20.100.0/24"}},
+		{name: "partial octet", partial: "10.2", limit: 0, want: []string{"10.2.0.0/16", "10.20.0.0/16", "10.20.100.0/24", "10.20.20.0/24", "10.20.30.4/32", "10.200.0.0/16"}},
+		{name: "IPv6 any case", partial: "2001:db8", want: []string{"2001:db8:1::/48", "2001:DB8::/32"}},
+		{name: "no candidates", partial: "192.", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, m := range trie.Complete(tt.partial, tt.limit) {
+				got = append(got, m.CIDR)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCompleteTracksChanges(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.1.0.0/16", nil)
+	if got := trie.Complete("10.", 0); len(got) != 1 {
+		t.Fatalf("Expected 1 candidate, got %v", got)
+	}
+
+	trie.Insert("10.2.0.0/16", nil)
+	trie.Delete("10.1.0.0/16")
+	trie.Insert("10.3.0.9/16", nil)
+	trie.Insert("10.3.0.0/16", map[string]interface{}{"v": 2})
+
+	got := trie.Complete("10.", 0)
+	if len(got) != 2 || got[0].CIDR != "10.2.0.0/16" || got[1].CIDR != "10.3.0.0/16" || got[1].Metadata["v"] != 2 {
+		t.Errorf("Expected index to follow inserts, deletes and renames, got %v", got)
+	}
+}
+
+func TestCompleteConcurrentReaders(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.1.0.0/16", "10.2.0.0/16", "2001:db8::/32"} {
+		trie.Insert(cidr, nil)
+	}
+
+	// Readers racing to rebuild a dropped index must not conflict, whether
+	// or not BuildIndexes has run; run with -race to check
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			trie.BuildIndexes()
+		}
+		var wg sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if got := trie.Complete("10.", 0); len(got) != 2 {
+					t.Errorf("Expected 2 candidates, got %v", got)
+				}
+			}()
+		}
+		wg.Wait()
+		trie.Insert("10.9.0.0/16", nil)
+		trie.Delete("10.9.0.0/16")
+	}
+	trie.Insert("10.3.0.0/16", nil)
+	if got := trie.Complete("10.", 0); len(got) != 3 {
+		t.Errorf("Expected a change to drop the built index, got %v", got)
+	}
+}
+
+func TestCompleteCachesIndex(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.1.0.0/16", nil)
+	if trie.completions.index != nil {
+		t.Errorf("Expected inserts not to build the index")
+	}
+
+	// The first call after a change builds the index and later calls
+	// reuse it
+	trie.Complete("10.", 0)
+	index := trie.completions.index
+	if len(index) != 1 {
+		t.Fatalf("Expected Complete to cache the index, got %v", index)
+	}
+	trie.Complete("10.1", 0)
+	if &trie.completions.index[0] != &index[0] {
+		t.Errorf("Expected Complete to reuse the cached index")
+	}
+	trie.Delete("10.1.0.0/16")
+	if trie.completions.index != nil {
+		t.Errorf("Expected a delete to drop the index")
+	}
+}
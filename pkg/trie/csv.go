@@ -0,0 +1,185 @@
+package trie
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CSVOptions describes the layout of a CSV or TSV file for LoadCSV
+type CSVOptions struct {
+	// Comma is the field separator, ',' if zero. Use '\t' for TSV.
+	Comma rune
+	// Header names the columns of files without a header row. When empty
+	// the first row is the header.
+	Header []string
+	// CIDRColumn is the column holding the prefix, "cidr" if empty
+	CIDRColumn string
+	// Columns maps the columns to keep to their metadata keys, e.g.
+	// {"Site Name": "site"}. When nil every other column is kept under its
+	// own name.
+	Columns map[string]string
+}
+
+// LoadCSV builds a trie from a CSV or TSV file with one prefix per row, so
+// inventory spreadsheets can be loaded without custom code. Metadata values
+// are the cell strings; empty cells are left out. Rows whose prefix cell is
+// empty are skipped, and a later row for the same prefix replaces an earlier
+// one.
+func LoadCSV(r io.Reader, opts CSVOptions, trieOpts ...Option) (*IPTrie, error) {
+	rows := csv.NewReader(r)
+	rows.FieldsPerRecord = -1
+	rows.TrimLeadingSpace = true
+	if opts.Comma != 0 {
+		rows.Comma = opts.Comma
+	}
+
+	header := opts.Header
+	if len(header) == 0 {
+		var err error
+		if header, err = rows.Read(); err != nil {
+			return nil, fmt.Errorf("read CSV header: %v", err)
+		}
+		header = append([]string(nil), header...)
+	}
+
+	cidrColumn := opts.CIDRColumn
+	if cidrColumn == "" {
+		cidrColumn = "cidr"
+	}
+	cidrIndex := -1
+	keys := make([]string, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == cidrColumn:
+			cidrIndex = i
+		case opts.Columns == nil:
+			keys[i] = name
+		default:
+			keys[i] = opts.Columns[name]
+		}
+	}
+	if cidrIndex < 0 {
+		return nil, fmt.Errorf("CSV has no %q column", cidrColumn)
+	}
+	for col := range opts.Columns {
+		if !hasColumn(header, col) {
+			return nil, fmt.Errorf("CSV has no %q column", col)
+		}
+	}
+
+	t := NewIPTrie(trieOpts...)
+	for {
+		rec, err := rows.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := rows.FieldPos(0)
+		if cidrIndex >= len(rec) || strings.TrimSpace(rec[cidrIndex]) == "" {
+			continue
+		}
+
+		md := make(map[string]interface{})
+		for i, value := range rec {
+			if i < len(keys) && keys[i] != "" && value != "" {
+				md[keys[i]] = value
+			}
+		}
+		if err := t.Insert(strings.TrimSpace(rec[cidrIndex]), md); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+}
+
+// hasColumn reports whether names holds name, ignoring surrounding spaces
+func hasColumn(names []string, name string) bool {
+	for _, n := range names {
+		if strings.TrimSpace(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportCSV writes every stored prefix in sorted order as CSV, with a cidr
+// column followed by one column per metadata key used anywhere in the trie,
+// sorted by name. String values are written as is and other values as
+// JSON; keys a prefix does not have are left empty. References under
+// RefsKey are written as "type:id url" separated by "; ", with URLs filled
+// in from their types. The output loads back with LoadCSV, with every
+// value as a string.
+func (t *IPTrie) ExportCSV(w io.Writer) error {
+	seen := make(map[string]bool)
+	t.walkNodes(func(n *Node) bool {
+		for k := range n.metadata {
+			seen[k] = true
+		}
+		return true
+	})
+	keys := make([]string, 0, len(seen))
+	for k := range seen {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append([]string{"cidr"}, keys...)); err != nil {
+		return err
+	}
+
+	var err error
+	row := make([]string, len(keys)+1)
+	t.walkNodes(func(n *Node) bool {
+		row[0] = n.cidr
+		for i, k := range keys {
+			if refs := Refs(n.metadata); k == RefsKey && refs != nil {
+				row[i+1] = refsCSV(t.ResolveRefs(refs))
+				continue
+			}
+			row[i+1], err = csvValue(n.metadata, k)
+			if err != nil {
+				err = fmt.Errorf("%s: %v", n.cidr, err)
+				return false
+			}
+		}
+		err = cw.Write(row)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvValue formats one metadata value for ExportCSV
+func csvValue(md map[string]interface{}, key string) (string, error) {
+	v, ok := md[key]
+	if !ok {
+		return "", nil
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("encode %s: %v", key, err)
+	}
+	return string(data), nil
+}
+
+// refsCSV formats references for ExportCSV
+func refsCSV(refs []Ref) string {
+	parts := make([]string, len(refs))
+	for i, r := range refs {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,110 @@
+package trie
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  CSVOptions
+		ip    string
+		cidr  string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "all columns",
+			input: "cidr,site,owner\n10.0.0.0/8,ams1,netops\n10.1.0.0/16,,dev\n",
+			ip:    "10.1.2.3",
+			cidr:  "10.1.0.0/16",
+			want:  map[string]interface{}{"owner": "dev"},
+		},
+		{
+			name:  "mapped columns",
+			input: "Network, Site Name, Notes\n10.0.0.0/8, ams1, \"old, do not use\"\n",
+			opts:  CSVOptions{CIDRColumn: "Network", Columns: map[string]string{"Site Name": "site"}},
+			ip:    "10.0.0.1",
+			cidr:  "10.0.0.0/8",
+			want:  map[string]interface{}{"site": "ams1"},
+		},
+		{
+			name:  "TSV without header",
+			input: "2001:db8::/32\tlab\n\t\n",
+			opts:  CSVOptions{Comma: '\t', Header: []string{"prefix", "env"}, CIDRColumn: "prefix"},
+			ip:    "2001:db8::1",
+			cidr:  "2001:db8::/32",
+			want:  map[string]interface{}{"env": "lab"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trie, err := LoadCSV(strings.NewReader(tt.input), tt.opts)
+			if err != nil {
+				t.Fatalf("LoadCSV returned error: %v", err)
+			}
+			cidr, md, err := trie.Find(tt.ip)
+			if err != nil || cidr != tt.cidr || len(md) != len(tt.want) {
+				t.Fatalf("Find(%s) = %s %v (%v)", tt.ip, cidr, md, err)
+			}
+			for k, v := range tt.want {
+				if md[k] != v {
+					t.Errorf("Expected %s=%v, got %v", k, v, md[k])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadCSVErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		opts  CSVOptions
+		want  string
+	}{
+		{"empty", "", CSVOptions{}, "read CSV header: EOF"},
+		{"no CIDR column", "prefix,site\n", CSVOptions{}, `CSV has no "cidr" column`},
+		{"missing mapped column", "cidr,site\n", CSVOptions{Columns: map[string]string{"owner": "owner"}}, `CSV has no "owner" column`},
+		{"bad CIDR", "cidr\n10.0.0.0/8\n10.0.0.0/33\n", CSVOptions{}, "line 3: invalid CIDR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadCSV(strings.NewReader(tt.input), tt.opts)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("Expected error %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.1.0.0/16", map[string]interface{}{"site": "ams1", "vlan": 12})
+	trie.Insert("2001:db8::/32", map[string]interface{}{"tags": []string{"lab", "v6"}})
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"site": "core, east"})
+
+	var buf strings.Builder
+	if err := trie.ExportCSV(&buf); err != nil {
+		t.Fatalf("ExportCSV returned error: %v", err)
+	}
+	want := `cidr,site,tags,vlan
+10.0.0.0/8,"core, east",,
+10.1.0.0/16,ams1,,12
+2001:db8::/32,,"[""lab"",""v6""]",
+`
+	if buf.String() != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, buf.String())
+	}
+
+	// Loads back with the values as strings
+	loaded, err := LoadCSV(strings.NewReader(buf.String()), CSVOptions{})
+	if err != nil {
+		t.Fatalf("LoadCSV returned error: %v", err)
+	}
+	if _, md, _ := loaded.Find("10.1.2.3"); md["vlan"] != "12" || md["site"] != "ams1" || len(md) != 2 {
+		t.Errorf("Unexpected reloaded metadata %v", md)
+	}
+}
@@ -0,0 +1,215 @@
+package trie
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+)
+
+// backend is the surface the differential harness drives. Any storage
+// engine that claims IPTrie semantics can be compared against another.
+type backend interface {
+	Insert(cidr string, metadata map[string]interface{}) error
+	Delete(cidr string) error
+	Find(ip string) (string, map[string]interface{}, error)
+	FindAll(ip string) ([]Match, error)
+	FindExact(cidr string) (map[string]interface{}, bool)
+	Len() int
+}
+
+// trieBackend adapts IPTrie to backend
+type trieBackend struct{ *IPTrie }
+
+// referenceBackend is a deliberately naive linear scan used as the oracle
+type referenceBackend struct {
+	entries []referenceEntry
+}
+
+type referenceEntry struct {
+	prefix   netip.Prefix
+	cidr     string
+	metadata map[string]interface{}
+}
+
+func (r *referenceBackend) index(p netip.Prefix) int {
+	for i, e := range r.entries {
+		if e.prefix == p {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *referenceBackend) Insert(cidr string, metadata map[string]interface{}) error {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+	p = p.Masked()
+	if i := r.index(p); i >= 0 {
+		r.entries[i] = referenceEntry{prefix: p, cidr: cidr, metadata: metadata}
+		return nil
+	}
+	r.entries = append(r.entries, referenceEntry{prefix: p, cidr: cidr, metadata: metadata})
+	return nil
+}
+
+func (r *referenceBackend) Delete(cidr string) error {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+	i := r.index(p.Masked())
+	if i < 0 {
+		return fmt.Errorf("CIDR not found")
+	}
+	r.entries = append(r.entries[:i], r.entries[i+1:]...)
+	return nil
+}
+
+func (r *referenceBackend) FindAll(ip string) ([]Match, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	var hits []referenceEntry
+	for _, e := range r.entries {
+		if e.prefix.Contains(addr) {
+			hits = append(hits, e)
+		}
+	}
+	// Most specific first, as the trie reports them
+	for i := 1; i < len(hits); i++ {
+		for j := i; j > 0 && hits[j].prefix.Bits() > hits[j-1].prefix.Bits(); j-- {
+			hits[j], hits[j-1] = hits[j-1], hits[j]
+		}
+	}
+	matches := make([]Match, len(hits))
+	for i, e := range hits {
+		matches[i] = Match{CIDR: e.cidr, PrefixLen: e.prefix.Bits(), Host: e.prefix.IsSingleIP(), Metadata: e.metadata}
+	}
+	return matches, nil
+}
+
+func (r *referenceBackend) Find(ip string) (string, map[string]interface{}, error) {
+	matches, err := r.FindAll(ip)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(matches) == 0 {
+		return "", nil, fmt.Errorf("no matching CIDR found")
+	}
+	m := matches[0]
+	return m.CIDR, m.Metadata, nil
+}
+
+func (r *referenceBackend) FindExact(cidr string) (map[string]interface{}, bool) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, false
+	}
+	if i := r.index(p.Masked()); i >= 0 {
+		return r.entries[i].metadata, true
+	}
+	return nil, false
+}
+
+func (r *referenceBackend) Len() int {
+	return len(r.entries)
+}
+
+// opGenerator produces addresses and prefixes clustered in a small space so
+// random sequences hit overlaps, host routes and default routes often
+type opGenerator struct {
+	rng *rand.Rand
+}
+
+func (g opGenerator) addr() netip.Addr {
+	if g.rng.Intn(2) == 0 {
+		return netip.AddrFrom4([4]byte{10, byte(g.rng.Intn(4)), byte(g.rng.Intn(4)), byte(g.rng.Intn(256))})
+	}
+	a := netip.MustParseAddr("2001:db8::").As16()
+	a[6], a[14], a[15] = byte(g.rng.Intn(4)), byte(g.rng.Intn(4)), byte(g.rng.Intn(256))
+	return netip.AddrFrom16(a)
+}
+
+func (g opGenerator) prefix() string {
+	addr := g.addr()
+	bits := g.rng.Intn(addr.BitLen() + 1)
+	// Favour host routes and lengths near the clustered bits
+	switch g.rng.Intn(4) {
+	case 0:
+		bits = addr.BitLen()
+	case 1:
+		bits = addr.BitLen() - g.rng.Intn(24)
+	}
+	return netip.PrefixFrom(addr, bits).String()
+}
+
+// runDifferential applies the same random operation sequence to a and b and
+// fails on the first divergence
+func runDifferential(t *testing.T, seed int64, steps int, a, b backend) {
+	t.Helper()
+	g := opGenerator{rng: rand.New(rand.NewSource(seed))}
+
+	for step := 0; step < steps; step++ {
+		switch op := g.rng.Intn(10); {
+		case op < 4:
+			cidr := g.prefix()
+			md := map[string]interface{}{"step": step}
+			errA, errB := a.Insert(cidr, md), b.Insert(cidr, md)
+			if (errA == nil) != (errB == nil) {
+				t.Fatalf("seed %d step %d: Insert(%s) errors diverge: %v vs %v", seed, step, cidr, errA, errB)
+			}
+		case op < 6:
+			cidr := g.prefix()
+			errA, errB := a.Delete(cidr), b.Delete(cidr)
+			if (errA == nil) != (errB == nil) {
+				t.Fatalf("seed %d step %d: Delete(%s) errors diverge: %v vs %v", seed, step, cidr, errA, errB)
+			}
+		case op < 8:
+			ip := g.addr().String()
+			cidrA, mdA, errA := a.Find(ip)
+			cidrB, mdB, errB := b.Find(ip)
+			if cidrA != cidrB || fmt.Sprint(mdA) != fmt.Sprint(mdB) || (errA == nil) != (errB == nil) {
+				t.Fatalf("seed %d step %d: Find(%s) diverges: %q %v vs %q %v", seed, step, ip, cidrA, mdA, cidrB, mdB)
+			}
+			allA, _ := a.FindAll(ip)
+			allB, _ := b.FindAll(ip)
+			if fmt.Sprint(allA) != fmt.Sprint(allB) {
+				t.Fatalf("seed %d step %d: FindAll(%s) diverges: %v vs %v", seed, step, ip, allA, allB)
+			}
+		default:
+			cidr := g.prefix()
+			mdA, okA := a.FindExact(cidr)
+			mdB, okB := b.FindExact(cidr)
+			if okA != okB || fmt.Sprint(mdA) != fmt.Sprint(mdB) {
+				t.Fatalf("seed %d step %d: FindExact(%s) diverges: %v %v vs %v %v", seed, step, cidr, mdA, okA, mdB, okB)
+			}
+		}
+
+		if a.Len() != b.Len() {
+			t.Fatalf("seed %d step %d: Len diverges: %d vs %d", seed, step, a.Len(), b.Len())
+		}
+	}
+}
+
+func TestDifferentialAgainstReference(t *testing.T) {
+	steps := 2000
+	if testing.Short() {
+		steps = 200
+	}
+	for seed := int64(1); seed <= 20; seed++ {
+		runDifferential(t, seed, steps, trieBackend{NewIPTrie()}, &referenceBackend{})
+	}
+}
+
+func FuzzDifferential(f *testing.F) {
+	for _, seed := range []int64{1, 42, 1 << 40} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		runDifferential(t, seed, 500, trieBackend{NewIPTrie()}, &referenceBackend{})
+	})
+}
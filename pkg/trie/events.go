@@ -0,0 +1,64 @@
+package trie
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType is the kind of change an Event reports
+type EventType string
+
+const (
+	// EventInsert reports a CIDR stored for the first time
+	EventInsert EventType = "insert"
+	// EventUpdate reports new metadata for a CIDR already stored
+	EventUpdate EventType = "update"
+	// EventDelete reports a CIDR being removed
+	EventDelete EventType = "delete"
+)
+
+// Event describes one change to the stored prefixes
+type Event struct {
+	Type EventType `json:"type"`
+	CIDR string    `json:"cidr"`
+	// Metadata is the new metadata, or for deletes the removed metadata
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// Previous is the metadata an update replaced
+	Previous map[string]interface{} `json:"previous,omitempty"`
+	Time     time.Time              `json:"time"`
+}
+
+// OnChange registers fn to be called after every insert, update and
+// delete, so caches, metrics and replicas can follow the trie. fn runs
+// synchronously inside the mutating call and must not modify the trie.
+// Wholesale replacement through UnmarshalJSON or UnmarshalBinary does not
+// emit events.
+func (t *IPTrie) OnChange(fn func(Event)) {
+	t.changeHooks = append(t.changeHooks, fn)
+}
+
+// Apply replays a change reported by another trie's OnChange hook, e.g.
+// from a write-ahead log or a replication stream. The metadata of an event
+// already went through the source trie's key options, so it is stored as
+// is: reserved keys, key normalization and reference validation are not
+// applied a second time.
+func (t *IPTrie) Apply(e Event) error {
+	switch e.Type {
+	case EventInsert, EventUpdate:
+		return t.insert(e.CIDR, e.Metadata, false)
+	case EventDelete:
+		return t.Delete(e.CIDR)
+	}
+	return fmt.Errorf("unknown change type %q", e.Type)
+}
+
+// emit notifies the change hooks
+func (t *IPTrie) emit(typ EventType, cidr string, md, previous map[string]interface{}) {
+	if len(t.changeHooks) == 0 {
+		return
+	}
+	e := Event{Type: typ, CIDR: cidr, Metadata: md, Previous: previous, Time: t.clock()}
+	for _, fn := range t.changeHooks {
+		fn(e)
+	}
+}
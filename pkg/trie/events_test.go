@@ -0,0 +1,76 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOnChange(t *testing.T) {
+	trie := NewIPTrie()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	trie.now = func() time.Time { return now }
+
+	var events []Event
+	trie.OnChange(func(e Event) {
+		// Hooks see the trie after the change
+		_, stored := trie.FindExact(e.CIDR)
+		if stored == (e.Type == EventDelete) {
+			t.Errorf("%s %s: hook ran before the change was applied", e.Type, e.CIDR)
+		}
+		events = append(events, e)
+	})
+
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"v": 1})
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"v": 2})
+	trie.Upsert("10.0.0.0/8", map[string]interface{}{"w": 3}, MergeMaps)
+	trie.Insert("192.0.2.1/32", nil)
+	trie.Delete("192.0.2.1/32")
+	trie.Delete("10.0.0.0/8")
+	trie.Delete("10.0.0.0/8")
+
+	var got []string
+	for _, e := range events {
+		got = append(got, fmt.Sprintf("%s %s %v %v", e.Type, e.CIDR, e.Metadata, e.Previous))
+		if !e.Time.Equal(now) {
+			t.Errorf("Expected event time %v, got %v", now, e.Time)
+		}
+	}
+	want := []string{
+		"insert 10.0.0.0/8 map[v:1] map[]",
+		"update 10.0.0.0/8 map[v:2] map[v:1]",
+		"update 10.0.0.0/8 map[v:2 w:3] map[v:2]",
+		"insert 192.0.2.1/32 map[] map[]",
+		"delete 192.0.2.1/32 map[] map[]",
+		"delete 10.0.0.0/8 map[v:2 w:3] map[]",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected events\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestApply(t *testing.T) {
+	source := NewIPTrie(WithReservedKeys(), WithKeyNormalizer(LowerKeys))
+	follower := NewIPTrie(WithReservedKeys(), WithKeyNormalizer(SnakeCaseKeys))
+	source.OnChange(func(e Event) {
+		if err := follower.Apply(e); err != nil {
+			t.Errorf("Apply %s %s failed: %v", e.Type, e.CIDR, err)
+		}
+	})
+
+	source.InsertSource("10.0.0.0/8", "ipam", map[string]interface{}{"OwnerTeam": "netops"})
+	source.Insert("10.1.0.0/16", map[string]interface{}{"Site": "ams"})
+	source.Delete("10.1.0.0/16")
+
+	// Metadata is stored as the source prepared it
+	md, ok := follower.FindExact("10.0.0.0/8")
+	if !ok || md["ownerteam"] != "netops" || SysMetadata(md) == nil {
+		t.Errorf("Expected the source's metadata, got %v", md)
+	}
+	if follower.Len() != 1 {
+		t.Errorf("Expected the delete to be applied, got %d prefixes", follower.Len())
+	}
+	if err := follower.Apply(Event{Type: "bogus", CIDR: "10.0.0.0/8"}); err == nil {
+		t.Errorf("Expected error for an unknown change type")
+	}
+}
@@ -0,0 +1,105 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrNoFreeSpace is returned when a parent prefix has no unallocated subnet
+// of the requested size left
+var ErrNoFreeSpace = errors.New("no free space")
+
+// FreeSpace returns the lowest subnet of length wantLen within parent that
+// overlaps no stored prefix more specific than parent. The parent itself and
+// any prefixes containing it, such as the pool it was inserted as, do not
+// count as allocations.
+func (t *IPTrie) FreeSpace(parent string, wantLen int) (netip.Prefix, error) {
+	pool, used, err := t.allocated(parent, wantLen)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	// Descend to the pool, then search its subtree lowest address first
+	node := used.root(pool.Addr())
+	addr := pool.Addr().AsSlice()
+	for i := 0; i < pool.Bits() && node != nil; i++ {
+		node = node.children[(addr[i/8]>>uint(7-i%8))&1]
+	}
+	if !firstFree(node, addr, pool.Bits(), wantLen) {
+		return netip.Prefix{}, ErrNoFreeSpace
+	}
+
+	free, _ := netip.AddrFromSlice(addr)
+	return netip.PrefixFrom(free, wantLen), nil
+}
+
+// freeBlocks returns the unallocated space of parent as a minimal list of
+// prefixes, lowest address first
+func (t *IPTrie) freeBlocks(parent string, wantLen int) ([]netip.Prefix, error) {
+	pool, used, err := t.allocated(parent, wantLen)
+	if err != nil {
+		return nil, err
+	}
+
+	whole := newAddressSpace()
+	whole.add(pool)
+	size := pool.Addr().BitLen() / 8
+
+	var free []netip.Prefix
+	addr := make([]byte, size)
+	keep := func(inPool, inUse bool) bool { return inPool && !inUse }
+	combine(whole.root(pool.Addr()), used.root(pool.Addr()), false, false, addr, 0, keep, func(b []byte, bits int) {
+		start, _ := netip.AddrFromSlice(b)
+		free = append(free, netip.PrefixFrom(start, bits))
+	})
+	return Aggregate(free), nil
+}
+
+// allocated validates a FreeSpace request and returns the masked parent
+// together with the space taken by prefixes stored inside it
+func (t *IPTrie) allocated(parent string, wantLen int) (netip.Prefix, addressSpace, error) {
+	used := newAddressSpace()
+	pool, err := netip.ParsePrefix(unmapCIDR(parent))
+	if err != nil {
+		return netip.Prefix{}, used, fmt.Errorf("invalid CIDR: %v", err)
+	}
+	pool = pool.Masked()
+	if wantLen < pool.Bits() || wantLen > pool.Addr().BitLen() {
+		return netip.Prefix{}, used, fmt.Errorf("invalid prefix length %d for %s", wantLen, pool)
+	}
+
+	matches, err := t.CoveredBy(pool.String())
+	if err != nil {
+		return netip.Prefix{}, used, err
+	}
+	for _, m := range matches {
+		if p, ok := storedPrefix(m.CIDR); ok && p.Bits() > pool.Bits() {
+			used.add(p)
+		}
+	}
+	return pool, used, nil
+}
+
+// firstFree sets the bits of addr from depth down to wantLen to the lowest
+// block with nothing allocated in it, reporting whether there is one
+func firstFree(node *spaceNode, addr []byte, depth, wantLen int) bool {
+	if node == nil {
+		return true
+	}
+	if node.full || depth == wantLen {
+		return false
+	}
+
+	mask := byte(1) << uint(7-depth%8)
+	addr[depth/8] &^= mask
+	if firstFree(node.children[0], addr, depth+1, wantLen) {
+		return true
+	}
+	addr[depth/8] |= mask
+	if firstFree(node.children[1], addr, depth+1, wantLen) {
+		return true
+	}
+	addr[depth/8] &^= mask
+	return false
+}
@@ -0,0 +1,65 @@
+package trie
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFreeSpace(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/16", "10.0.0.0/24", "10.0.1.0/25", "10.0.2.0/23", "10.0.1.200/32", "2001:db8::/32", "2001:db8::/48"} {
+		if err := trie.Insert(cidr, nil); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", cidr, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		parent  string
+		wantLen int
+		want    string
+		wantErr error
+	}{
+		{name: "first gap", parent: "10.0.0.0/16", wantLen: 25, want: "10.0.4.0/25"},
+		{name: "skips host route", parent: "10.0.0.0/16", wantLen: 26, want: "10.0.1.128/26"},
+		{name: "larger block", parent: "10.0.0.0/16", wantLen: 24, want: "10.0.4.0/24"},
+		{name: "whole parent", parent: "10.1.0.0/16", wantLen: 16, want: "10.1.0.0/16"},
+		{name: "parent itself not allocated", parent: "10.0.0.0/24", wantLen: 24, want: "10.0.0.0/24"},
+		{name: "full parent", parent: "10.0.1.0/24", wantLen: 25, wantErr: ErrNoFreeSpace},
+		{name: "IPv6", parent: "2001:db8::/32", wantLen: 48, want: "2001:db8:1::/48"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := trie.FreeSpace(tt.parent, tt.wantLen)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v (%v)", tt.wantErr, err, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FreeSpace returned error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFreeSpaceInvalid(t *testing.T) {
+	trie := NewIPTrie()
+	for _, tt := range []struct {
+		parent  string
+		wantLen int
+	}{
+		{"bogus", 24},
+		{"10.0.0.0/16", 8},
+		{"10.0.0.0/16", 33},
+	} {
+		if _, err := trie.FreeSpace(tt.parent, tt.wantLen); err == nil {
+			t.Errorf("Expected error for %s /%d", tt.parent, tt.wantLen)
+		}
+	}
+}
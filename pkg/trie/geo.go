@@ -0,0 +1,162 @@
+package trie
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// GeoView is a precomputed aggregation of a trie by a location metadata key
+// such as "country" or "continent". It is a snapshot: build a new one after
+// reloading the trie.
+type GeoView struct {
+	key      string
+	prefixes map[string][]Match
+	cidrs    map[string][]netip.Prefix
+}
+
+// Coverage is the address space a GeoView attributes to one code
+type Coverage struct {
+	Code string
+	// Prefixes counts the stored prefixes tagged with Code
+	Prefixes int
+	IPv4     uint64
+	IPv6     *big.Int
+}
+
+// geoNode is one bit of the tree used to resolve which code each address
+// effectively has
+type geoNode struct {
+	children [2]*geoNode
+	code     string
+	tagged   bool
+}
+
+// GeoView groups the stored prefixes by the value of key. Codes are
+// compared case-insensitively and reported in upper case. Prefixes without
+// the key inherit the code of the closest containing prefix that has one,
+// the same way FindValue resolves it, so every address counts towards
+// exactly one code.
+func (t *IPTrie) GeoView(key string) *GeoView {
+	v := &GeoView{
+		key:      key,
+		prefixes: make(map[string][]Match),
+		cidrs:    make(map[string][]netip.Prefix),
+	}
+	roots := map[bool]*geoNode{true: {}, false: {}}
+
+	t.walkNodes(func(n *Node) bool {
+		value, ok := n.metadata[key]
+		if !ok {
+			return true
+		}
+		code := strings.ToUpper(fmt.Sprint(value))
+		v.prefixes[code] = append(v.prefixes[code], n.match())
+
+		if p, ok := storedPrefix(n.cidr); ok {
+			node := roots[p.Addr().Is4()]
+			b := p.Addr().AsSlice()
+			for i := 0; i < p.Bits(); i++ {
+				bit := (b[i/8] >> uint(7-i%8)) & 1
+				if node.children[bit] == nil {
+					node.children[bit] = &geoNode{}
+				}
+				node = node.children[bit]
+			}
+			node.code, node.tagged = code, true
+		}
+		return true
+	})
+
+	emit := func(code string, addr []byte, bits int) {
+		a, _ := netip.AddrFromSlice(addr)
+		v.cidrs[code] = append(v.cidrs[code], netip.PrefixFrom(a, bits))
+	}
+	resolveGeo(roots[true], "", make([]byte, 4), 0, emit)
+	resolveGeo(roots[false], "", make([]byte, 16), 0, emit)
+	for code, cidrs := range v.cidrs {
+		v.cidrs[code] = Aggregate(cidrs)
+	}
+	return v
+}
+
+// resolveGeo emits the parts of node's range whose effective code is known,
+// code being the one inherited from above
+func resolveGeo(node *geoNode, code string, addr []byte, depth int, emit func(string, []byte, int)) {
+	if node.tagged {
+		code = node.code
+	}
+	if node.children[0] == nil && node.children[1] == nil {
+		if code != "" {
+			emit(code, addr, depth)
+		}
+		return
+	}
+	for bit := byte(0); bit <= 1; bit++ {
+		if bit == 1 {
+			addr[depth/8] |= 1 << uint(7-depth%8)
+		}
+		if child := node.children[bit]; child != nil {
+			resolveGeo(child, code, addr, depth+1, emit)
+		} else if code != "" {
+			emit(code, addr, depth+1)
+		}
+		if bit == 1 {
+			addr[depth/8] &^= 1 << uint(7-depth%8)
+		}
+	}
+}
+
+// Key returns the metadata key the view was built from
+func (v *GeoView) Key() string {
+	return v.key
+}
+
+// Codes returns every code that tags at least one prefix, sorted
+func (v *GeoView) Codes() []string {
+	codes := make([]string, 0, len(v.prefixes))
+	for code := range v.prefixes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// PrefixesByCountry returns the stored prefixes tagged with code, in sorted
+// order. Despite the name it works for any key the view was built from.
+func (v *GeoView) PrefixesByCountry(code string) []Match {
+	return v.prefixes[strings.ToUpper(code)]
+}
+
+// CIDRs returns the minimal set of prefixes covering exactly the addresses
+// whose effective code is code, with more specific prefixes tagged with
+// other codes carved out. It is suited to building geo-blocking rules.
+func (v *GeoView) CIDRs(code string) []netip.Prefix {
+	return v.cidrs[strings.ToUpper(code)]
+}
+
+// Coverage returns the number of addresses attributed to code
+func (v *GeoView) Coverage(code string) Coverage {
+	code = strings.ToUpper(code)
+	c := Coverage{Code: code, Prefixes: len(v.prefixes[code]), IPv6: new(big.Int)}
+	for _, p := range v.cidrs[code] {
+		if p.Addr().Is4() {
+			c.IPv4 += 1 << uint(32-p.Bits())
+		} else {
+			c.IPv6.Add(c.IPv6, new(big.Int).Lsh(big.NewInt(1), uint(128-p.Bits())))
+		}
+	}
+	return c
+}
+
+// Coverages returns the Coverage of every code, sorted by code
+func (v *GeoView) Coverages() []Coverage {
+	codes := v.Codes()
+	out := make([]Coverage, len(codes))
+	for i, code := range codes {
+		out[i] = v.Coverage(code)
+	}
+	return out
+}
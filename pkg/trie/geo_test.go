@@ -0,0 +1,69 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGeoView(t *testing.T) {
+	trie := NewIPTrie()
+	entries := []struct {
+		cidr string
+		md   map[string]interface{}
+	}{
+		{"10.0.0.0/8", map[string]interface{}{"country": "DE", "continent": "EU"}},
+		// A French block carved out of the German one
+		{"10.1.0.0/16", map[string]interface{}{"country": "fr", "continent": "EU"}},
+		// Back to German inside the French block
+		{"10.1.2.0/24", map[string]interface{}{"country": "DE", "continent": "EU"}},
+		// No country, so it stays French
+		{"10.1.3.0/24", map[string]interface{}{"owner": "acme"}},
+		{"192.0.2.1/32", map[string]interface{}{"country": "US", "continent": "NA"}},
+		{"2001:db8::/32", map[string]interface{}{"country": "US", "continent": "NA"}},
+		{"172.16.0.0/12", map[string]interface{}{"owner": "lab"}},
+	}
+	for _, e := range entries {
+		if err := trie.Insert(e.cidr, e.md); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", e.cidr, err)
+		}
+	}
+
+	view := trie.GeoView("country")
+	if fmt.Sprint(view.Codes()) != "[DE FR US]" {
+		t.Errorf("Unexpected codes %v", view.Codes())
+	}
+
+	var de []string
+	for _, m := range view.PrefixesByCountry("de") {
+		de = append(de, m.CIDR)
+	}
+	if fmt.Sprint(de) != "[10.0.0.0/8 10.1.2.0/24]" {
+		t.Errorf("Unexpected DE prefixes %v", de)
+	}
+
+	tests := []struct {
+		code  string
+		cidrs string
+		ipv4  uint64
+		ipv6  string
+	}{
+		{"DE", "[10.0.0.0/16 10.1.2.0/24 10.2.0.0/15 10.4.0.0/14 10.8.0.0/13 10.16.0.0/12 10.32.0.0/11 10.64.0.0/10 10.128.0.0/9]", 1<<24 - 1<<16 + 256, "0"},
+		{"FR", "[10.1.0.0/23 10.1.3.0/24 10.1.4.0/22 10.1.8.0/21 10.1.16.0/20 10.1.32.0/19 10.1.64.0/18 10.1.128.0/17]", 1<<16 - 256, "0"},
+		{"US", "[192.0.2.1/32 2001:db8::/32]", 1, "79228162514264337593543950336"},
+		{"JP", "[]", 0, "0"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(view.CIDRs(tt.code)); got != tt.cidrs {
+			t.Errorf("CIDRs(%s) = %s, expected %s", tt.code, got, tt.cidrs)
+		}
+		c := view.Coverage(tt.code)
+		if c.IPv4 != tt.ipv4 || c.IPv6.String() != tt.ipv6 {
+			t.Errorf("Coverage(%s) = %d, %s; expected %d, %s", tt.code, c.IPv4, c.IPv6, tt.ipv4, tt.ipv6)
+		}
+	}
+
+	continents := trie.GeoView("continent").Coverages()
+	if len(continents) != 2 || continents[0].Code != "EU" || continents[0].IPv4 != 1<<24 || continents[0].Prefixes != 3 {
+		t.Errorf("Unexpected continent coverage %+v", continents)
+	}
+}
@@ -0,0 +1,122 @@
+package trie
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// hitCounter counts the lookups a stored prefix answered. It is updated
+// atomically, so concurrent readers can share a trie.
+type hitCounter struct {
+	count atomic.Uint64
+	last  atomic.Int64 // unix nanoseconds of the latest hit, 0 if none
+}
+
+// record counts one hit at now
+func (h *hitCounter) record(now time.Time) {
+	h.count.Add(1)
+	h.last.Store(now.UnixNano())
+}
+
+// clone returns a new counter starting at h's values, or nil if h is nil
+func (h *hitCounter) clone() *hitCounter {
+	if h == nil {
+		return nil
+	}
+	c := &hitCounter{}
+	c.count.Store(h.count.Load())
+	c.last.Store(h.last.Load())
+	return c
+}
+
+// PrefixUsage is how often a stored prefix answered Find and when it last
+// did
+type PrefixUsage struct {
+	CIDR string `json:"cidr"`
+	Hits uint64 `json:"hits"`
+	// LastHit is the zero time for a prefix that was never hit
+	LastHit time.Time `json:"last_hit"`
+}
+
+// WithHitTracking counts, for every stored prefix, the Find, FindAddr,
+// FindMatch and FindBatch lookups it answered and the time of the latest, e.g. to find firewall or
+// ACL entries that no longer match any traffic. Each stored prefix gets a
+// counter when it is inserted and loses it when deleted; updating its
+// metadata keeps it. Lookups stay allocation free and safe for concurrent
+// readers.
+func WithHitTracking() Option {
+	return func(t *IPTrie) {
+		t.trackHits = true
+	}
+}
+
+// errHitsDisabled is returned by the hit APIs of a trie without tracking
+var errHitsDisabled = errors.New("hit tracking is not enabled")
+
+// usage returns the recorded usage of a stored node
+func (n *Node) usage() PrefixUsage {
+	u := PrefixUsage{CIDR: n.cidr}
+	if n.hits == nil {
+		return u
+	}
+	u.Hits = n.hits.count.Load()
+	if last := n.hits.last.Load(); last != 0 {
+		u.LastHit = time.Unix(0, last)
+	}
+	return u
+}
+
+// Hits returns the usage of exactly the given stored CIDR
+func (t *IPTrie) Hits(cidr string) (PrefixUsage, error) {
+	if !t.trackHits {
+		return PrefixUsage{}, errHitsDisabled
+	}
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return PrefixUsage{}, err
+	}
+	return node.usage(), nil
+}
+
+// HitCounts returns the usage of every stored prefix in sorted order,
+// including those never hit
+func (t *IPTrie) HitCounts() ([]PrefixUsage, error) {
+	if !t.trackHits {
+		return nil, errHitsDisabled
+	}
+	out := make([]PrefixUsage, 0, t.Len())
+	t.walkNodes(func(n *Node) bool {
+		out = append(out, n.usage())
+		return true
+	})
+	return out, nil
+}
+
+// Stale returns the stored prefixes not hit since the given time, in sorted
+// order. Prefixes never hit are included.
+func (t *IPTrie) Stale(since time.Time) ([]PrefixUsage, error) {
+	all, err := t.HitCounts()
+	if err != nil {
+		return nil, err
+	}
+	var out []PrefixUsage
+	for _, u := range all {
+		if u.LastHit.Before(since) {
+			out = append(out, u)
+		}
+	}
+	return out, nil
+}
+
+// ResetHits zeroes the hit counts and last hit times of every stored
+// prefix, e.g. at the start of an audit period
+func (t *IPTrie) ResetHits() {
+	t.walkNodes(func(n *Node) bool {
+		if n.hits != nil {
+			n.hits.count.Store(0)
+			n.hits.last.Store(0)
+		}
+		return true
+	})
+}
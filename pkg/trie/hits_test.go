@@ -0,0 +1,142 @@
+package trie
+
+import (
+	"encoding/json"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHitTracking(t *testing.T) {
+	trie := NewIPTrie(WithHitTracking())
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	trie.now = func() time.Time { return now }
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "2001:db8::/32"} {
+		trie.Insert(cidr, map[string]interface{}{})
+	}
+
+	trie.Find("10.1.2.3")
+	trie.Find("10.1.9.9")
+	now = now.Add(time.Hour)
+	trie.Find("10.1.9.9")
+	trie.FindAddr(netip.MustParseAddr("2001:db8::1"))
+	trie.FindAll("10.2.0.1") // only Find and FindAddr count
+	trie.Find("192.168.0.1")
+
+	tests := []struct {
+		cidr    string
+		hits    uint64
+		lastHit time.Time
+	}{
+		{"10.0.0.0/8", 0, time.Time{}},
+		{"10.1.0.0/16", 2, now},
+		{"10.1.2.3/32", 1, now.Add(-time.Hour)},
+		{"2001:db8::/32", 1, now},
+	}
+	for _, tt := range tests {
+		u, err := trie.Hits(tt.cidr)
+		if err != nil || u.CIDR != tt.cidr || u.Hits != tt.hits || !u.LastHit.Equal(tt.lastHit) {
+			t.Errorf("Expected %s to have %d hits, last at %v, got %+v %v", tt.cidr, tt.hits, tt.lastHit, u, err)
+		}
+	}
+	if _, err := trie.Hits("172.16.0.0/12"); err == nil {
+		t.Errorf("Expected error for a CIDR that is not stored")
+	}
+
+	all, err := trie.HitCounts()
+	if err != nil || len(all) != 4 || all[0].CIDR != "10.0.0.0/8" {
+		t.Errorf("Expected usage of all 4 prefixes in order, got %v %v", all, err)
+	}
+	stale, err := trie.Stale(now.Add(-time.Minute))
+	if err != nil || len(stale) != 2 || stale[0].CIDR != "10.0.0.0/8" || stale[1].CIDR != "10.1.2.3/32" {
+		t.Errorf("Expected 10.0.0.0/8 and 10.1.2.3/32 to be stale, got %v %v", stale, err)
+	}
+
+	// Updates keep the counter; deleting and reinserting starts over
+	trie.Insert("10.1.0.0/16", map[string]interface{}{"owner": "dev"})
+	if u, _ := trie.Hits("10.1.0.0/16"); u.Hits != 2 {
+		t.Errorf("Expected an update to keep 2 hits, got %d", u.Hits)
+	}
+	trie.Delete("10.1.2.3/32")
+	trie.Insert("10.1.2.3/32", map[string]interface{}{})
+	if u, _ := trie.Hits("10.1.2.3/32"); u.Hits != 0 {
+		t.Errorf("Expected a reinserted prefix to start at 0 hits, got %d", u.Hits)
+	}
+
+	trie.ResetHits()
+	if u, _ := trie.Hits("10.1.0.0/16"); u.Hits != 0 || !u.LastHit.IsZero() {
+		t.Errorf("Expected ResetHits to clear the counter, got %+v", u)
+	}
+}
+
+func TestHitTrackingCopies(t *testing.T) {
+	trie := NewIPTrie(WithHitTracking())
+	trie.Insert("10.0.0.0/8", map[string]interface{}{})
+	trie.Find("10.1.1.1")
+
+	// A clone starts from the source's counts, then counts on its own
+	c := trie.Clone()
+	c.Find("10.1.1.1")
+	c.Find("10.1.1.1")
+	if u, _ := c.Hits("10.0.0.0/8"); u.Hits != 3 {
+		t.Errorf("Expected the clone to continue from the source's count, got %d hits", u.Hits)
+	}
+	if u, _ := trie.Hits("10.0.0.0/8"); u.Hits != 1 {
+		t.Errorf("Expected the clone's hits to leave the source unchanged, got %d hits", u.Hits)
+	}
+	c.ResetHits()
+	if u, _ := trie.Hits("10.0.0.0/8"); u.Hits != 1 || u.LastHit.IsZero() {
+		t.Errorf("Expected ResetHits on the clone to leave the source unchanged, got %+v", u)
+	}
+	trie.Find("10.1.1.1")
+	if u, _ := c.Hits("10.0.0.0/8"); u.Hits != 0 {
+		t.Errorf("Expected the source's hits to leave the clone unchanged, got %d hits", u.Hits)
+	}
+	c.Insert("192.168.0.0/16", map[string]interface{}{})
+	if _, err := c.Hits("192.168.0.0/16"); err != nil {
+		t.Errorf("Expected the clone to track new prefixes, got %v", err)
+	}
+
+	data, err := json.Marshal(trie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIPTrie(WithHitTracking())
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatal(err)
+	}
+	restored.Find("10.1.1.1")
+	if u, _ := restored.Hits("10.0.0.0/8"); u.Hits != 1 {
+		t.Errorf("Expected a restored trie to track hits from 0, got %d", u.Hits)
+	}
+
+	if _, err := NewIPTrie().HitCounts(); err == nil {
+		t.Errorf("Expected error without hit tracking")
+	}
+}
+
+func TestHitTrackingConcurrentReaders(t *testing.T) {
+	trie := NewIPTrie(WithHitTracking())
+	trie.Insert("10.0.0.0/8", map[string]interface{}{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				trie.Find("10.1.2.3")
+			}
+		}()
+	}
+	wg.Wait()
+	if u, _ := trie.Hits("10.0.0.0/8"); u.Hits != 8000 {
+		t.Errorf("Expected 8000 hits, got %d", u.Hits)
+	}
+
+	addr := netip.MustParseAddr("10.1.2.3")
+	if allocs := testing.AllocsPerRun(100, func() { trie.FindAddr(addr) }); allocs != 0 {
+		t.Errorf("Expected tracked lookups not to allocate, got %v allocs", allocs)
+	}
+}
@@ -0,0 +1,45 @@
+package trie
+
+import "sync/atomic"
+
+// TrieHolder publishes a trie to concurrent readers and lets a writer swap
+// in a replacement atomically. Readers call Load and query the returned
+// trie without locks; a stored trie must not be mutated afterwards, so
+// writers build or Clone a new one and Store it.
+type TrieHolder struct {
+	current atomic.Pointer[IPTrie]
+	opts    []Option
+}
+
+// NewTrieHolder returns a holder containing an empty trie. opts are used for
+// that trie and for every trie built by ReplaceAll.
+func NewTrieHolder(opts ...Option) *TrieHolder {
+	h := &TrieHolder{opts: opts}
+	h.current.Store(NewIPTrie(opts...))
+	return h
+}
+
+// Load returns the current trie
+func (h *TrieHolder) Load() *IPTrie {
+	return h.current.Load()
+}
+
+// Store makes t the current trie for all subsequent Loads, building its
+// read indexes first with BuildIndexes
+func (h *TrieHolder) Store(t *IPTrie) {
+	t.BuildIndexes()
+	h.current.Store(t)
+}
+
+// ReplaceAll builds a new trie from entries and swaps it in. If any entry
+// fails to insert, the current trie is left in place.
+func (h *TrieHolder) ReplaceAll(entries []Match) error {
+	next := NewIPTrie(h.opts...)
+	for _, e := range entries {
+		if err := next.Insert(e.CIDR, e.Metadata); err != nil {
+			return err
+		}
+	}
+	h.Store(next)
+	return nil
+}
@@ -0,0 +1,76 @@
+package trie
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTrieHolder(t *testing.T) {
+	h := NewTrieHolder(WithCIDRMode(CIDRStrict))
+	if h.Load() == nil || h.Load().Len() != 0 {
+		t.Fatalf("Expected holder to start with an empty trie")
+	}
+
+	err := h.ReplaceAll([]Match{
+		{CIDR: "10.0.0.0/8", Metadata: map[string]interface{}{"gen": 1}},
+		{CIDR: "192.168.0.0/16", Metadata: map[string]interface{}{"gen": 1}},
+	})
+	if err != nil {
+		t.Fatalf("ReplaceAll returned error: %v", err)
+	}
+	first := h.Load()
+	if first.Len() != 2 {
+		t.Errorf("Expected 2 prefixes, got %d", first.Len())
+	}
+
+	// A bad entry keeps the current table in place
+	err = h.ReplaceAll([]Match{{CIDR: "10.0.0.0/8"}, {CIDR: "10.1.1.1/8"}})
+	if err == nil {
+		t.Fatalf("Expected ReplaceAll to reject a CIDR with host bits set")
+	}
+	if h.Load() != first {
+		t.Errorf("Expected failed ReplaceAll to leave the current trie")
+	}
+
+	next := first.Clone()
+	_ = next.Insert("172.16.0.0/12", nil)
+	h.Store(next)
+	if h.Load().Len() != 3 || first.Len() != 2 {
+		t.Errorf("Expected Store to publish the new trie without touching the old one")
+	}
+}
+
+func TestTrieHolderConcurrentReaders(t *testing.T) {
+	h := NewTrieHolder()
+	_ = h.ReplaceAll([]Match{{CIDR: "10.0.0.0/8", Metadata: map[string]interface{}{"gen": 0}}})
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, _, err := h.Load().Find("10.1.2.3"); err != nil {
+					t.Errorf("Reader saw an inconsistent table: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for gen := 1; gen <= 100; gen++ {
+		_ = h.ReplaceAll([]Match{{CIDR: "10.0.0.0/8", Metadata: map[string]interface{}{"gen": gen}}})
+	}
+	close(stop)
+	wg.Wait()
+
+	if _, md, _ := h.Load().Find("10.1.2.3"); md["gen"] != 100 {
+		t.Errorf("Expected the last generation to win, got %v", md["gen"])
+	}
+}
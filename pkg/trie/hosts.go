@@ -0,0 +1,192 @@
+package trie
+
+import (
+	"bytes"
+	"net/netip"
+	"sort"
+)
+
+// Host routes (/32 and /128) are kept in a hash map rather than as trie
+// paths. They are always the most specific match for their address, so a
+// single map probe answers Find for them, and they avoid a chain of up to
+// 128 mostly unshared nodes each.
+
+// hostKey returns the map key for the trie bytes of a full length address
+func hostKey(ipBytes []byte) netip.Addr {
+	addr, _ := netip.AddrFromSlice(ipBytes)
+	return addr
+}
+
+// hostRoute returns the stored host route for an address, or nil
+func (t *IPTrie) hostRoute(ipBytes []byte) *Node {
+	if len(t.hosts) == 0 {
+		return nil
+	}
+	return t.hosts[hostKey(ipBytes)]
+}
+
+// insertHost stores a host route, reusing the node if it already exists
+func (t *IPTrie) insertHost(ipBytes []byte) *Node {
+	key := hostKey(ipBytes)
+	node := t.hosts[key]
+	if node == nil {
+		node = &Node{}
+		t.hosts[key] = node
+		t.hostOrder = nil
+	}
+	return node
+}
+
+// removeHost deletes a host route and returns its node, or nil
+func (t *IPTrie) removeHost(ipBytes []byte) *Node {
+	key := hostKey(ipBytes)
+	node := t.hosts[key]
+	if node != nil {
+		delete(t.hosts, key)
+		t.hostOrder = nil
+	}
+	return node
+}
+
+// trieOrder returns the address left aligned in 16 bytes, the order in
+// which the trie visits prefixes
+func trieOrder(addr netip.Addr) [16]byte {
+	var out [16]byte
+	if addr.Is4() {
+		a4 := addr.As4()
+		copy(out[:], a4[:])
+		return out
+	}
+	return addr.As16()
+}
+
+// sortedHosts returns the host route addresses in trie order, IPv4 before
+// IPv6. It runs on read paths, which must not modify the trie, so once a
+// host insert or removal has dropped the cached order it sorts a fresh copy
+// for each call until BuildIndexes caches one again.
+func (t *IPTrie) sortedHosts() []netip.Addr {
+	if t.hostOrder != nil || len(t.hosts) == 0 {
+		return t.hostOrder
+	}
+	return t.buildHostOrder()
+}
+
+// buildHostOrder sorts the host route addresses in trie order
+func (t *IPTrie) buildHostOrder() []netip.Addr {
+	order := make([]netip.Addr, 0, len(t.hosts))
+	for addr := range t.hosts {
+		order = append(order, addr)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return order[i].Less(order[j])
+	})
+	return order
+}
+
+// BuildIndexes builds the sorted host route order that walks use and the
+// index behind Complete, so readers sharing the trie find them ready
+// instead of sorting for every call. It is a write: call it after the last
+// change and before readers start, as TrieHolder.Store does.
+func (t *IPTrie) BuildIndexes() {
+	if t.hostOrder == nil && len(t.hosts) > 0 {
+		t.hostOrder = t.buildHostOrder()
+	}
+	t.completionIndex()
+}
+
+// hostMerger interleaves host routes into a trie walk so callers see one
+// sorted sequence of stored prefixes
+type hostMerger struct {
+	t     *IPTrie
+	hosts []netip.Addr
+	next  int
+	fn    func(*Node) bool
+}
+
+// flushBefore emits the pending host routes ordered before addr
+func (m *hostMerger) flushBefore(addr [16]byte) bool {
+	for m.next < len(m.hosts) {
+		h := trieOrder(m.hosts[m.next])
+		if bytes.Compare(h[:], addr[:]) >= 0 {
+			break
+		}
+		if !m.fn(m.t.hosts[m.hosts[m.next]]) {
+			return false
+		}
+		m.next++
+	}
+	return true
+}
+
+// flush emits every remaining host route
+func (m *hostMerger) flush() bool {
+	for ; m.next < len(m.hosts); m.next++ {
+		if !m.fn(m.t.hosts[m.hosts[m.next]]) {
+			return false
+		}
+	}
+	return true
+}
+
+// walk visits the prefixes at or below node, whose path so far is held in
+// addr, emitting host routes as their position in the order comes up
+func (m *hostMerger) walk(node *Node, addr *[16]byte, depth int) bool {
+	if node.isEnd {
+		if !m.flushBefore(*addr) || !m.fn(node) {
+			return false
+		}
+	}
+	for bit := byte(0); bit <= 1; bit++ {
+		child := node.children[bit]
+		if child == nil {
+			continue
+		}
+		if bit == 1 {
+			addr[depth/8] |= 1 << uint(7-depth%8)
+		}
+		ok := m.walk(child, addr, depth+1)
+		if bit == 1 {
+			addr[depth/8] &^= 1 << uint(7-depth%8)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// walkNodes visits every stored prefix, host routes included, in sorted
+// order with IPv4 before IPv6. It stops early and returns false once fn
+// returns false.
+func (t *IPTrie) walkNodes(fn func(*Node) bool) bool {
+	hosts := t.sortedHosts()
+	split := sort.Search(len(hosts), func(i int) bool { return !hosts[i].Is4() })
+
+	var addr [16]byte
+	v4 := &hostMerger{t: t, hosts: hosts[:split], fn: fn}
+	if !v4.walk(t.root4, &addr, 0) || !v4.flush() {
+		return false
+	}
+	v6 := &hostMerger{t: t, hosts: hosts[split:], fn: fn}
+	return v6.walk(t.root6, &addr, 0) && v6.flush()
+}
+
+// walkWithin visits every stored prefix inside prefix in sorted order. node
+// is the trie node at the end of prefix's path, or nil if there is none.
+func (t *IPTrie) walkWithin(node *Node, prefix netip.Prefix, fn func(*Node) bool) bool {
+	var hosts []netip.Addr
+	for _, h := range t.sortedHosts() {
+		if prefix.Contains(h) {
+			hosts = append(hosts, h)
+		}
+	}
+
+	m := &hostMerger{t: t, hosts: hosts, fn: fn}
+	if node != nil {
+		addr := trieOrder(prefix.Masked().Addr())
+		if !m.walk(node, &addr, prefix.Bits()) {
+			return false
+		}
+	}
+	return m.flush()
+}
@@ -0,0 +1,142 @@
+package trie
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestHostRoutes(t *testing.T) {
+	trie := NewIPTrie()
+	entries := []string{
+		"10.0.0.0/8",
+		"10.0.0.0/32",
+		"10.0.0.1/32",
+		"10.1.0.0/16",
+		"10.1.0.5/32",
+		"11.0.0.1/32",
+		"2001:db8::/32",
+		"2001:db8::1/128",
+	}
+	for _, cidr := range entries {
+		if err := trie.Insert(cidr, map[string]interface{}{"cidr": cidr}); err != nil {
+			t.Fatalf("Failed to insert CIDR: %v", err)
+		}
+	}
+
+	t.Run("find prefers host route", func(t *testing.T) {
+		cidr, md, err := trie.Find("10.1.0.5")
+		if err != nil || cidr != "10.1.0.5/32" || md["cidr"] != "10.1.0.5/32" {
+			t.Errorf("Expected host route 10.1.0.5/32, got %q (%v)", cidr, err)
+		}
+		cidr, _, _ = trie.Find("10.1.0.6")
+		if cidr != "10.1.0.0/16" {
+			t.Errorf("Expected 10.1.0.6 to fall back to 10.1.0.0/16, got %q", cidr)
+		}
+		cidr, _, _ = trie.Find("2001:db8::1")
+		if cidr != "2001:db8::1/128" {
+			t.Errorf("Expected IPv6 host route, got %q", cidr)
+		}
+	})
+
+	t.Run("find all starts with host route", func(t *testing.T) {
+		matches, _ := trie.FindAll("10.1.0.5")
+		if len(matches) != 3 || matches[0].CIDR != "10.1.0.5/32" {
+			t.Errorf("Expected /8, /16 and host route, got %v", matches)
+		}
+		if !matches[0].Host || matches[1].Host || matches[0].PrefixLen != 32 {
+			t.Errorf("Expected only the host route flagged as one, got %+v", matches)
+		}
+		matches, _ = trie.FindAll("2001:db8::1")
+		if len(matches) != 2 || !matches[0].Host || matches[0].PrefixLen != 128 || matches[1].Host {
+			t.Errorf("Expected the IPv6 host route flagged as one, got %+v", matches)
+		}
+	})
+
+	t.Run("walk interleaves host routes", func(t *testing.T) {
+		var got []string
+		trie.Walk(func(prefix string, md map[string]interface{}) bool {
+			got = append(got, prefix)
+			return true
+		})
+		if fmt.Sprint(got) != fmt.Sprint(entries) {
+			t.Errorf("Expected walk order %v, got %v", entries, got)
+		}
+	})
+
+	t.Run("covered by includes host routes", func(t *testing.T) {
+		matches, _ := trie.CoveredBy("10.0.0.0/15")
+		var got []string
+		for _, m := range matches {
+			got = append(got, m.CIDR)
+		}
+		want := []string{"10.0.0.0/32", "10.0.0.1/32", "10.1.0.0/16", "10.1.0.5/32"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+
+		matches, _ = trie.CoveredBy("11.0.0.1/32")
+		if len(matches) != 1 || matches[0].CIDR != "11.0.0.1/32" {
+			t.Errorf("Expected the host route itself, got %v", matches)
+		}
+	})
+
+	t.Run("exact and covers", func(t *testing.T) {
+		if _, ok := trie.FindExact("11.0.0.1/32"); !ok {
+			t.Errorf("Expected FindExact to see the host route")
+		}
+		if ok, _ := trie.Covers("11.0.0.1/32"); !ok {
+			t.Errorf("Expected host route to cover itself")
+		}
+		if ok, _ := trie.Covers("11.0.0.2/32"); ok {
+			t.Errorf("Expected neighbouring host to be uncovered")
+		}
+	})
+
+	t.Run("remove host route", func(t *testing.T) {
+		md, removed, err := trie.Remove("10.1.0.5/32")
+		if err != nil || !removed || md["cidr"] != "10.1.0.5/32" {
+			t.Fatalf("Expected host route to be removed, got removed=%v err=%v", removed, err)
+		}
+		cidr, _, _ := trie.Find("10.1.0.5")
+		if cidr != "10.1.0.0/16" {
+			t.Errorf("Expected lookup to fall back to 10.1.0.0/16, got %q", cidr)
+		}
+		if err := trie.Delete("10.1.0.5/32"); err == nil {
+			t.Errorf("Expected deleting a missing host route to fail")
+		}
+	})
+}
+
+func TestHostRoutesConcurrentWalks(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/8", "10.0.0.9/32", "10.0.0.1/32", "2001:db8::1/128"} {
+		trie.Insert(cidr, map[string]interface{}{})
+	}
+
+	// Walks must not cache the host order themselves, whether or not
+	// BuildIndexes has run; run with -race to check
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			trie.BuildIndexes()
+		}
+		var wg sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var got []string
+				trie.Walk(func(prefix string, md map[string]interface{}) bool {
+					got = append(got, prefix)
+					return true
+				})
+				if len(got) != 4 || got[1] != "10.0.0.1/32" {
+					t.Errorf("Expected sorted host routes, got %v", got)
+				}
+				trie.CoveredBy("10.0.0.0/8")
+				trie.Enumerate("", 10, "")
+			}()
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,89 @@
+package trie
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// PrefixHits is a stored prefix and the observations that fell inside it
+type PrefixHits struct {
+	CIDR string `json:"cidr"`
+	Hits uint64 `json:"hits"`
+}
+
+// Branch is one root-to-leaf path of the trie
+type Branch struct {
+	// Prefix is the path to the leaf node
+	Prefix netip.Prefix `json:"prefix"`
+	// Depth is the number of nodes below the root, the cost of walking it
+	Depth int `json:"depth"`
+	// Prefixes counts the stored prefixes along the path, leaf included
+	Prefixes int `json:"prefixes"`
+}
+
+// TopActive returns the n stored prefixes with the most observations over
+// the last hour, busiest first, as counted by Observe. Prefixes without
+// observations are left out.
+func (t *IPTrie) TopActive(n int) []PrefixHits {
+	minute := t.clock().Unix() / 60
+	var out []PrefixHits
+	t.walkNodes(func(node *Node) bool {
+		if node.activity == nil {
+			return true
+		}
+		var hits uint64
+		for _, c := range node.activity.counts(minute) {
+			hits += c
+		}
+		if hits > 0 {
+			out = append(out, PrefixHits{CIDR: node.cidr, Hits: hits})
+		}
+		return true
+	})
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Hits > out[j].Hits })
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// DeepestBranches returns the n longest paths from a family root to a leaf,
+// deepest first, showing where lookups walk furthest. Host routes are kept
+// outside the trie and are not counted.
+func (t *IPTrie) DeepestBranches(n int) []Branch {
+	var out []Branch
+	var addr [16]byte
+	var walk func(node *Node, depth, stored, size int)
+	walk = func(node *Node, depth, stored, size int) {
+		if node.isEnd {
+			stored++
+		}
+		if len(node.children) == 0 {
+			if depth > 0 {
+				a, _ := netip.AddrFromSlice(addr[:size])
+				out = append(out, Branch{Prefix: netip.PrefixFrom(a, depth), Depth: depth, Prefixes: stored})
+			}
+			return
+		}
+		for bit := byte(0); bit <= 1; bit++ {
+			child := node.children[bit]
+			if child == nil {
+				continue
+			}
+			mask := byte(1) << (7 - depth%8)
+			if bit == 1 {
+				addr[depth/8] |= mask
+			}
+			walk(child, depth+1, stored, size)
+			addr[depth/8] &^= mask
+		}
+	}
+	walk(t.root4, 0, 0, 4)
+	walk(t.root6, 0, 0, 16)
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Depth > out[j].Depth })
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package trie
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopActive(t *testing.T) {
+	trie := NewIPTrie()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	trie.now = func() time.Time { return now }
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/16", "2001:db8::/32"} {
+		trie.Insert(cidr, map[string]interface{}{})
+	}
+
+	for _, ip := range []string{"10.1.2.3", "10.1.2.4", "10.2.0.1", "2001:db8::1"} {
+		trie.Observe(ip)
+	}
+	// Observations older than an hour no longer count
+	now = now.Add(-2 * time.Hour)
+	for i := 0; i < 10; i++ {
+		trie.Observe("2001:db8::1")
+	}
+	now = now.Add(2 * time.Hour)
+
+	top := trie.TopActive(10)
+	expected := []PrefixHits{{"10.0.0.0/8", 3}, {"10.1.0.0/16", 2}, {"2001:db8::/32", 1}}
+	if len(top) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, top)
+	}
+	for i := range expected {
+		if top[i] != expected[i] {
+			t.Errorf("Expected %v at %d, got %v", expected[i], i, top[i])
+		}
+	}
+	if top := trie.TopActive(1); len(top) != 1 || top[0].CIDR != "10.0.0.0/8" {
+		t.Errorf("Expected only the busiest prefix, got %v", top)
+	}
+}
+
+func TestDeepestBranches(t *testing.T) {
+	trie := NewIPTrie()
+	if got := trie.DeepestBranches(5); len(got) != 0 {
+		t.Errorf("Expected no branches in an empty trie, got %v", got)
+	}
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "192.168.0.0/16", "2001:db8::/32", "10.1.2.3/32"} {
+		trie.Insert(cidr, map[string]interface{}{})
+	}
+
+	tests := []struct {
+		prefix   string
+		depth    int
+		prefixes int
+	}{
+		{"2001:db8::/32", 32, 1},
+		{"10.1.2.0/24", 24, 3},
+		{"192.168.0.0/16", 16, 1},
+	}
+	got := trie.DeepestBranches(10)
+	if len(got) != len(tests) {
+		t.Fatalf("Expected %d branches, got %v", len(tests), got)
+	}
+	for i, tt := range tests {
+		if got[i].Prefix.String() != tt.prefix || got[i].Depth != tt.depth || got[i].Prefixes != tt.prefixes {
+			t.Errorf("Expected branch %s depth %d with %d prefixes, got %+v", tt.prefix, tt.depth, tt.prefixes, got[i])
+		}
+	}
+	if got := trie.DeepestBranches(1); len(got) != 1 || got[0].Depth != 32 {
+		t.Errorf("Expected only the deepest branch, got %v", got)
+	}
+}
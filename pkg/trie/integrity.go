@@ -0,0 +1,92 @@
+package trie
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrIntegrity is returned by Verify when a loaded trie does not match its
+// manifest or fails a canary lookup
+var ErrIntegrity = errors.New("integrity check failed")
+
+// Manifest records what a saved trie is expected to contain, so a loader
+// can tell a complete copy from a truncated or corrupted one
+type Manifest struct {
+	Prefixes   int `json:"prefixes"`
+	PrefixesV4 int `json:"prefixes_v4"`
+	PrefixesV6 int `json:"prefixes_v6"`
+	// Checksum is the SHA-256 of the trie's ExportJSONL output, in hex
+	Checksum string `json:"sha256"`
+}
+
+// Canary is a lookup with a known answer, checked by Verify. An empty CIDR
+// means IP must not match anything.
+type Canary struct {
+	IP   string `json:"ip"`
+	CIDR string `json:"cidr"`
+}
+
+// Manifest computes the manifest of the trie's current contents. Save it
+// next to a snapshot and check it with Verify after loading.
+func (t *IPTrie) Manifest() (Manifest, error) {
+	h := sha256.New()
+	if err := t.ExportJSONL(h); err != nil {
+		return Manifest{}, err
+	}
+	return Manifest{
+		Prefixes:   t.Len(),
+		PrefixesV4: t.LenV4(),
+		PrefixesV6: t.LenV6(),
+		Checksum:   hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// Verify checks the trie against a manifest and then runs the canary
+// lookups, returning an error wrapping ErrIntegrity at the first mismatch.
+// An empty Checksum in m skips the checksum, which is the slowest check.
+func (t *IPTrie) Verify(m Manifest, canaries ...Canary) error {
+	if t.Len() != m.Prefixes || t.LenV4() != m.PrefixesV4 || t.LenV6() != m.PrefixesV6 {
+		return fmt.Errorf("%w: expected %d prefixes (%d IPv4, %d IPv6), loaded %d (%d IPv4, %d IPv6)",
+			ErrIntegrity, m.Prefixes, m.PrefixesV4, m.PrefixesV6, t.Len(), t.LenV4(), t.LenV6())
+	}
+
+	if m.Checksum != "" {
+		got, err := t.Manifest()
+		if err != nil {
+			return err
+		}
+		if got.Checksum != m.Checksum {
+			return fmt.Errorf("%w: checksum %s, expected %s", ErrIntegrity, got.Checksum, m.Checksum)
+		}
+	}
+
+	for _, c := range canaries {
+		cidr, _, err := t.Find(c.IP)
+		if err != nil {
+			cidr = ""
+		}
+		if cidr != c.CIDR {
+			want := c.CIDR
+			if want == "" {
+				want = "no match"
+			}
+			if cidr == "" {
+				cidr = "no match"
+			}
+			return fmt.Errorf("%w: canary %s: got %s, expected %s", ErrIntegrity, c.IP, cidr, want)
+		}
+	}
+	return nil
+}
+
+// StoreVerified makes t the current trie only if it passes Verify, so a
+// bad load never replaces good data being served
+func (h *TrieHolder) StoreVerified(t *IPTrie, m Manifest, canaries ...Canary) error {
+	if err := t.Verify(m, canaries...); err != nil {
+		return err
+	}
+	h.Store(t)
+	return nil
+}
@@ -0,0 +1,96 @@
+package trie
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func integrityTrie() *IPTrie {
+	trie := NewIPTrie()
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"owner": "netops"})
+	trie.Insert("10.1.0.0/16", map[string]interface{}{"owner": "dev"})
+	trie.Insert("2001:db8::/32", map[string]interface{}{"owner": "lab"})
+	return trie
+}
+
+func TestManifest(t *testing.T) {
+	m, err := integrityTrie().Manifest()
+	if err != nil {
+		t.Fatalf("Manifest returned error: %v", err)
+	}
+	if m.Prefixes != 3 || m.PrefixesV4 != 2 || m.PrefixesV6 != 1 || len(m.Checksum) != 64 {
+		t.Errorf("Unexpected manifest %+v", m)
+	}
+
+	// The checksum survives a JSON round trip
+	data, _ := integrityTrie().MarshalJSON()
+	loaded := NewIPTrie()
+	loaded.UnmarshalJSON(data)
+	if err := loaded.Verify(m); err != nil {
+		t.Errorf("Verify after round trip returned error: %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m, _ := integrityTrie().Manifest()
+
+	changed := integrityTrie()
+	changed.Insert("10.1.0.0/16", map[string]interface{}{"owner": "qa"})
+	truncated := integrityTrie()
+	truncated.Remove("2001:db8::/32")
+
+	tests := []struct {
+		name     string
+		trie     *IPTrie
+		manifest Manifest
+		canaries []Canary
+		want     string
+	}{
+		{name: "intact", trie: integrityTrie(), manifest: m, canaries: []Canary{
+			{IP: "10.1.2.3", CIDR: "10.1.0.0/16"},
+			{IP: "192.0.2.1"},
+		}},
+		{name: "truncated", trie: truncated, manifest: m, want: "expected 3 prefixes (2 IPv4, 1 IPv6), loaded 2 (2 IPv4, 0 IPv6)"},
+		{name: "changed", trie: changed, manifest: m, want: "checksum"},
+		{name: "checksum skipped", trie: changed, manifest: Manifest{Prefixes: 3, PrefixesV4: 2, PrefixesV6: 1}},
+		{name: "wrong canary", trie: integrityTrie(), manifest: m, canaries: []Canary{{IP: "10.2.0.1", CIDR: "10.1.0.0/16"}}, want: "canary 10.2.0.1: got 10.0.0.0/8, expected 10.1.0.0/16"},
+		{name: "missing canary", trie: integrityTrie(), manifest: m, canaries: []Canary{{IP: "192.0.2.1", CIDR: "192.0.2.0/24"}}, want: "canary 192.0.2.1: got no match, expected 192.0.2.0/24"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.trie.Verify(tt.manifest, tt.canaries...)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Verify returned error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrIntegrity) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected integrity error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestStoreVerified(t *testing.T) {
+	holder := NewTrieHolder()
+	good := integrityTrie()
+	m, _ := good.Manifest()
+
+	bad := integrityTrie()
+	bad.Remove("10.0.0.0/8")
+	if err := holder.StoreVerified(bad, m); err == nil {
+		t.Errorf("Expected StoreVerified to reject a truncated trie")
+	}
+	if holder.Load().Len() != 0 {
+		t.Errorf("Expected the rejected trie not to be stored")
+	}
+
+	if err := holder.StoreVerified(good, m); err != nil {
+		t.Errorf("StoreVerified returned error: %v", err)
+	}
+	if holder.Load() != good {
+		t.Errorf("Expected the verified trie to be stored")
+	}
+}
@@ -0,0 +1,77 @@
+package trie
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// LabelOther is the label value for metadata values outside the
+	// allowlist
+	LabelOther = "other"
+	// LabelNone is the label value when the metadata key is absent, or
+	// there was no match at all
+	LabelNone = "none"
+)
+
+// LabelSet turns match metadata into metric labels with a bounded number of
+// values: each label passes through only allowlisted values and folds every
+// other value into LabelOther, so trie results can be used as Prometheus
+// labels without a cardinality explosion. A LabelSet is safe for
+// concurrent use.
+type LabelSet struct {
+	names   []string
+	allowed []map[string]bool
+}
+
+// NewLabelSet returns a LabelSet with one label per metadata key in allow,
+// each accepting only the listed values
+func NewLabelSet(allow map[string][]string) *LabelSet {
+	s := &LabelSet{}
+	for name := range allow {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+
+	for _, name := range s.names {
+		values := make(map[string]bool, len(allow[name]))
+		for _, v := range allow[name] {
+			values[v] = true
+		}
+		s.allowed = append(s.allowed, values)
+	}
+	return s
+}
+
+// Names returns the label names in sorted order, the order Values uses
+func (s *LabelSet) Names() []string {
+	return append([]string(nil), s.names...)
+}
+
+// Values returns the label values for md in Names order, ready for a
+// Prometheus WithLabelValues call. md may be nil when nothing matched.
+func (s *LabelSet) Values(md map[string]interface{}) []string {
+	out := make([]string, len(s.names))
+	for i, name := range s.names {
+		v, ok := md[name]
+		switch {
+		case !ok || v == nil:
+			out[i] = LabelNone
+		case s.allowed[i][fmt.Sprint(v)]:
+			out[i] = fmt.Sprint(v)
+		default:
+			out[i] = LabelOther
+		}
+	}
+	return out
+}
+
+// Labels returns the label values for md keyed by label name
+func (s *LabelSet) Labels(md map[string]interface{}) map[string]string {
+	values := s.Values(md)
+	out := make(map[string]string, len(values))
+	for i, name := range s.names {
+		out[name] = values[i]
+	}
+	return out
+}
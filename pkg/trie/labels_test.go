@@ -0,0 +1,41 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLabelSet(t *testing.T) {
+	labels := NewLabelSet(map[string][]string{
+		"region": {"us-east-1", "eu-west-1"},
+		"tier":   {"1", "2"},
+	})
+
+	if got := fmt.Sprint(labels.Names()); got != "[region tier]" {
+		t.Fatalf("Expected sorted names, got %s", got)
+	}
+
+	tests := []struct {
+		name string
+		md   map[string]interface{}
+		want []string
+	}{
+		{name: "allowed", md: map[string]interface{}{"region": "eu-west-1", "tier": "2"}, want: []string{"eu-west-1", "2"}},
+		{name: "numbers as text", md: map[string]interface{}{"region": "us-east-1", "tier": 1}, want: []string{"us-east-1", "1"}},
+		{name: "unlisted values fold", md: map[string]interface{}{"region": "customer-42", "tier": "9"}, want: []string{"other", "other"}},
+		{name: "missing keys", md: map[string]interface{}{"owner": "x", "tier": nil}, want: []string{"none", "none"}},
+		{name: "no match", md: nil, want: []string{"none", "none"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := labels.Values(tt.md); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	got := labels.Labels(map[string]interface{}{"region": "us-east-1"})
+	if got["region"] != "us-east-1" || got["tier"] != "none" || len(got) != 2 {
+		t.Errorf("Unexpected labels %v", got)
+	}
+}
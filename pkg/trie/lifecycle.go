@@ -0,0 +1,199 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// PrefixState is the allocation lifecycle stage of a stored prefix.
+// Prefixes are active unless inserted or moved into another state.
+type PrefixState uint8
+
+const (
+	// StateActive prefixes are in use
+	StateActive PrefixState = iota
+	// StatePlanned prefixes are reserved but not yet in use
+	StatePlanned
+	// StateDeprecated prefixes are still in use but being phased out
+	StateDeprecated
+	// StateRetired prefixes are no longer in use and kept for the record
+	StateRetired
+)
+
+// ErrInvalidTransition is returned by SetState for a move the lifecycle
+// does not allow
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+var stateNames = map[PrefixState]string{
+	StateActive:     "active",
+	StatePlanned:    "planned",
+	StateDeprecated: "deprecated",
+	StateRetired:    "retired",
+}
+
+// transitions lists the states each state may move to. Planned prefixes can
+// be cancelled straight to retired, and deprecation can be undone.
+var transitions = map[PrefixState][]PrefixState{
+	StatePlanned:    {StateActive, StateRetired},
+	StateActive:     {StateDeprecated},
+	StateDeprecated: {StateActive, StateRetired},
+}
+
+// String returns the lowercase name of the state
+func (s PrefixState) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("PrefixState(%d)", s)
+}
+
+// ParseState returns the state with the given name
+func ParseState(name string) (PrefixState, error) {
+	for s, n := range stateNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown prefix state %q", name)
+}
+
+// CanTransition reports whether the lifecycle allows moving from one state
+// to another
+func CanTransition(from, to PrefixState) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateChange describes a prefix moving between lifecycle states
+type StateChange struct {
+	CIDR     string
+	From     PrefixState
+	To       PrefixState
+	Metadata map[string]interface{}
+	Time     time.Time
+}
+
+// OnStateChange registers fn to be called after every successful SetState
+func (t *IPTrie) OnStateChange(fn func(StateChange)) {
+	t.stateHooks = append(t.stateHooks, fn)
+}
+
+// InsertWithState adds a CIDR like Insert and places it in the given state
+// without a transition check, e.g. to record a planned allocation or import
+// an existing inventory. Replacing a stored CIDR also replaces its state.
+func (t *IPTrie) InsertWithState(cidr string, state PrefixState, metadata map[string]interface{}) error {
+	if _, ok := stateNames[state]; !ok {
+		return fmt.Errorf("unknown prefix state %d", state)
+	}
+	if err := t.Insert(cidr, metadata); err != nil {
+		return err
+	}
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return err
+	}
+	node.state = state
+	return nil
+}
+
+// State returns the lifecycle state of a stored prefix
+func (t *IPTrie) State(cidr string) (PrefixState, error) {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return 0, err
+	}
+	return node.state, nil
+}
+
+// SetState moves a stored prefix to a new lifecycle state, returning
+// ErrInvalidTransition if the lifecycle does not allow it. Setting the
+// current state again is a no-op and fires no event.
+func (t *IPTrie) SetState(cidr string, state PrefixState) error {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return err
+	}
+	if node.state == state {
+		return nil
+	}
+	if !CanTransition(node.state, state) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, node.state, state)
+	}
+
+	change := StateChange{
+		CIDR:     node.cidr,
+		From:     node.state,
+		To:       state,
+		Metadata: node.metadata,
+		Time:     t.clock(),
+	}
+	node.state = state
+	for _, fn := range t.stateHooks {
+		fn(change)
+	}
+	return nil
+}
+
+// FindActive is Find restricted to prefixes in StateActive
+func (t *IPTrie) FindActive(ip string) (string, map[string]interface{}, error) {
+	return t.FindInState(ip, StateActive)
+}
+
+// FindInState returns the most specific prefix containing ip whose state is
+// one of states, skipping prefixes in any other state
+func (t *IPTrie) FindInState(ip string, states ...PrefixState) (string, map[string]interface{}, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return "", nil, fmt.Errorf("invalid IP address")
+	}
+
+	wanted := func(n *Node) bool {
+		for _, s := range states {
+			if n.state == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	ipBytes := ipToBytes(parsedIP)
+	if host := t.hostRoute(ipBytes); host != nil && wanted(host) {
+		return host.cidr, host.metadata, nil
+	}
+
+	node := t.rootFor(ipBytes)
+	var lastMatch *Node
+	totalBits := len(ipBytes) * 8
+
+	for i := 0; i < totalBits; i++ {
+		if node.isEnd && wanted(node) {
+			lastMatch = node
+		}
+
+		byteIndex := i / 8
+		bitIndex := 7 - (i % 8)
+		bit := (ipBytes[byteIndex] >> uint(bitIndex)) & 1
+
+		node = node.children[bit]
+		if node == nil {
+			break
+		}
+	}
+
+	// Check the last node in case it's an exact match
+	if node != nil && node.isEnd && wanted(node) {
+		lastMatch = node
+	}
+
+	if lastMatch == nil {
+		return "", nil, fmt.Errorf("no matching CIDR found")
+	}
+
+	return lastMatch.cidr, lastMatch.metadata, nil
+}
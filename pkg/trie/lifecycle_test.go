@@ -0,0 +1,130 @@
+package trie
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestLifecycleTransitions(t *testing.T) {
+	trie := NewIPTrie()
+	if err := trie.InsertWithState("10.1.0.0/16", StatePlanned, map[string]interface{}{"site": "fra1"}); err != nil {
+		t.Fatalf("InsertWithState returned error: %v", err)
+	}
+
+	var changes []StateChange
+	trie.OnStateChange(func(c StateChange) {
+		changes = append(changes, c)
+	})
+
+	steps := []struct {
+		to      PrefixState
+		wantErr bool
+	}{
+		{to: StateDeprecated, wantErr: true},
+		{to: StateActive},
+		{to: StateActive},
+		{to: StatePlanned, wantErr: true},
+		{to: StateDeprecated},
+		{to: StateRetired},
+		{to: StateActive, wantErr: true},
+	}
+	for _, step := range steps {
+		err := trie.SetState("10.1.0.0/16", step.to)
+		if step.wantErr != (err != nil) {
+			t.Fatalf("SetState(%s): expected error=%v, got %v", step.to, step.wantErr, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("Expected ErrInvalidTransition, got %v", err)
+		}
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("Expected 3 state change events, got %d", len(changes))
+	}
+	if c := changes[0]; c.From != StatePlanned || c.To != StateActive || c.CIDR != "10.1.0.0/16" || c.Metadata["site"] != "fra1" {
+		t.Errorf("Unexpected first event %+v", c)
+	}
+	if state, _ := trie.State("10.1.0.0/16"); state != StateRetired {
+		t.Errorf("Expected retired, got %s", state)
+	}
+}
+
+func TestFindActive(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.0.0.0/8", nil)
+	trie.InsertWithState("10.1.0.0/16", StateDeprecated, nil)
+	trie.InsertWithState("10.1.1.0/24", StatePlanned, nil)
+	trie.InsertWithState("10.1.1.1/32", StateRetired, nil)
+
+	tests := []struct {
+		name   string
+		states []PrefixState
+		want   string
+	}{
+		{name: "active", states: []PrefixState{StateActive}, want: "10.0.0.0/8"},
+		{name: "in service", states: []PrefixState{StateActive, StateDeprecated}, want: "10.1.0.0/16"},
+		{name: "planned", states: []PrefixState{StatePlanned}, want: "10.1.1.0/24"},
+		{name: "retired host", states: []PrefixState{StateRetired}, want: "10.1.1.1/32"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := trie.FindInState("10.1.1.1", tt.states...)
+			if err != nil || got != tt.want {
+				t.Errorf("Expected %s, got %s (%v)", tt.want, got, err)
+			}
+		})
+	}
+
+	if got, _, _ := trie.FindActive("10.1.1.1"); got != "10.0.0.0/8" {
+		t.Errorf("Expected FindActive to skip inactive prefixes, got %s", got)
+	}
+	if _, _, err := trie.FindActive("192.0.2.1"); err == nil {
+		t.Errorf("Expected error for an address with no active prefix")
+	}
+}
+
+func TestLifecyclePersistence(t *testing.T) {
+	trie := NewIPTrie()
+	trie.InsertWithState("10.1.0.0/16", StatePlanned, nil)
+	trie.Insert("10.2.0.0/16", nil)
+
+	// Re-inserting keeps the state, removal forgets it
+	trie.Insert("10.1.0.0/16", map[string]interface{}{"v": 2})
+	if state, _ := trie.State("10.1.0.0/16"); state != StatePlanned {
+		t.Errorf("Expected planned after update, got %s", state)
+	}
+
+	if state, _ := trie.Clone().State("10.1.0.0/16"); state != StatePlanned {
+		t.Errorf("Expected clone to keep state, got %s", state)
+	}
+
+	data, _ := json.Marshal(trie)
+	restored := NewIPTrie()
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("UnmarshalJSON returned error: %v", err)
+	}
+	if state, _ := restored.State("10.1.0.0/16"); state != StatePlanned {
+		t.Errorf("Expected planned after JSON round trip, got %s", state)
+	}
+	if state, _ := restored.State("10.2.0.0/16"); state != StateActive {
+		t.Errorf("Expected active after JSON round trip, got %s", state)
+	}
+
+	trie.Delete("10.1.0.0/16")
+	trie.Insert("10.1.0.0/16", nil)
+	if state, _ := trie.State("10.1.0.0/16"); state != StateActive {
+		t.Errorf("Expected re-inserted prefix to be active, got %s", state)
+	}
+}
+
+func TestParseState(t *testing.T) {
+	for _, s := range []PrefixState{StateActive, StatePlanned, StateDeprecated, StateRetired} {
+		if got, err := ParseState(s.String()); err != nil || got != s {
+			t.Errorf("Expected %s, got %s (%v)", s, got, err)
+		}
+	}
+	if _, err := ParseState("gone"); err == nil {
+		t.Errorf("Expected error for unknown state")
+	}
+}
@@ -0,0 +1,101 @@
+package trie
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+// hostRouteCount returns how many /128s the memory tests insert. Set
+// TRIE_MEMORY_ROUTES to reproduce the multi-million route worst case.
+func hostRouteCount(t *testing.T) int {
+	if v := os.Getenv("TRIE_MEMORY_ROUTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			t.Fatalf("Invalid TRIE_MEMORY_ROUTES: %v", err)
+		}
+		return n
+	}
+	return 20000
+}
+
+// heapBytesPerRoute inserts n /128s produced by gen and returns the retained
+// heap per route
+func heapBytesPerRoute(t *testing.T, n int, gen func(i int, ip net.IP)) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	trie := NewIPTrie()
+	ip := make(net.IP, net.IPv6len)
+	for i := 0; i < n; i++ {
+		copy(ip, net.ParseIP("2001:db8::"))
+		gen(i, ip)
+		if err := trie.Insert(ip.String()+"/128", nil); err != nil {
+			t.Fatalf("Failed to insert route: %v", err)
+		}
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	// Spot check that the routes are all still reachable
+	for i := 0; i < n; i += n/100 + 1 {
+		copy(ip, net.ParseIP("2001:db8::"))
+		gen(i, ip)
+		if _, _, err := trie.Find(ip.String()); err != nil {
+			t.Fatalf("Route %s not found after insert", ip)
+		}
+	}
+	runtime.KeepAlive(trie)
+
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0
+	}
+	return (after.HeapAlloc - before.HeapAlloc) / uint64(n)
+}
+
+func TestIPv6HostRouteMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping memory measurement in short mode")
+	}
+
+	n := hostRouteCount(t)
+	tests := []struct {
+		name     string
+		gen      func(i int, ip net.IP)
+		maxBytes uint64
+	}{
+		{
+			name: "dense /128s",
+			gen: func(i int, ip net.IP) {
+				ip[12], ip[13], ip[14], ip[15] = byte(i>>24), byte(i>>16), byte(i>>8), byte(i)
+			},
+			maxBytes: 512,
+		},
+		{
+			// Random interface IDs would need a chain of ~64 unshared
+			// nodes each if host routes were stored in the trie
+			name: "sparse /128s",
+			gen: func(i int, ip net.IP) {
+				x := uint64(i+1) * 0x9e3779b97f4a7c15
+				x ^= x >> 31
+				binary.BigEndian.PutUint64(ip[8:], x*0xbf58476d1ce4e5b9)
+			},
+			maxBytes: 512,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perRoute := heapBytesPerRoute(t, n, tt.gen)
+			t.Logf("%d routes, %d bytes per route", n, perRoute)
+			if perRoute > tt.maxBytes {
+				t.Errorf("Expected at most %d bytes per route, got %d", tt.maxBytes, perRoute)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package trie
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// SysKey is the metadata key under which the package keeps its own data,
+// such as timestamps and provenance, as a nested map
+const SysKey = "_sys"
+
+// ErrReservedKey is returned when user metadata sets SysKey on a trie
+// created with WithReservedKeys
+var ErrReservedKey = errors.New("metadata key " + SysKey + " is reserved")
+
+// LowerKeys is a key normalizer that lowercases keys
+func LowerKeys(key string) string {
+	return strings.ToLower(key)
+}
+
+// SnakeCaseKeys is a key normalizer that converts camelCase, PascalCase,
+// kebab-case and space separated keys to snake_case
+func SnakeCaseKeys(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	var last rune
+	write := func(r rune) {
+		b.WriteRune(r)
+		last = r
+	}
+
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == ' ' || r == '.' || r == '_':
+			if last != 0 && last != '_' {
+				write('_')
+			}
+		case unicode.IsUpper(r):
+			// Start a new word after a lowercase letter or digit, or at the
+			// last capital of an acronym followed by lowercase ("HTTPServer")
+			if i > 0 && last != '_' {
+				prev := runes[i-1]
+				nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+				if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+					write('_')
+				}
+			}
+			write(unicode.ToLower(r))
+		default:
+			write(r)
+		}
+	}
+	return b.String()
+}
+
+// SysMetadata returns the package-maintained values stored under SysKey,
+// or nil if there are none
+func SysMetadata(md map[string]interface{}) map[string]interface{} {
+	sys, _ := md[SysKey].(map[string]interface{})
+	return sys
+}
+
+// prepareMetadata applies the trie's key options to metadata on its way in.
+// The caller's map is never modified.
+func (t *IPTrie) prepareMetadata(md map[string]interface{}) (map[string]interface{}, error) {
+	if t.protectSys {
+		if _, ok := md[SysKey]; ok {
+			return nil, ErrReservedKey
+		}
+	}
+	if t.normalizeKey != nil && md != nil {
+		out := make(map[string]interface{}, len(md))
+		for k, v := range md {
+			if k != SysKey {
+				k = t.normalizeKey(k)
+			}
+			out[k] = v
+		}
+		md = out
+	}
+	if err := t.validateRefs(md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
@@ -0,0 +1,76 @@
+package trie
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSnakeCaseKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "region", want: "region"},
+		{in: "regionName", want: "region_name"},
+		{in: "RegionName", want: "region_name"},
+		{in: "region-name", want: "region_name"},
+		{in: "Region Name", want: "region_name"},
+		{in: "HTTPServer", want: "http_server"},
+		{in: "vlan10Id", want: "vlan10_id"},
+		{in: "already_snake", want: "already_snake"},
+		{in: "double--dash", want: "double_dash"},
+	}
+
+	for _, tt := range tests {
+		if got := SnakeCaseKeys(tt.in); got != tt.want {
+			t.Errorf("SnakeCaseKeys(%q) = %q, expected %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestKeyNormalization(t *testing.T) {
+	trie := NewIPTrie(WithKeyNormalizer(SnakeCaseKeys))
+
+	input := map[string]interface{}{"Region": "us-west", "ownerTeam": "neteng"}
+	if err := trie.Insert("10.0.0.0/8", input); err != nil {
+		t.Fatalf("Failed to insert CIDR: %v", err)
+	}
+	if _, ok := input["owner_team"]; ok {
+		t.Errorf("Expected caller's metadata map to be left untouched")
+	}
+
+	_, md, _ := trie.Find("10.1.1.1")
+	if md["region"] != "us-west" || md["owner_team"] != "neteng" {
+		t.Errorf("Expected normalized keys, got %v", md)
+	}
+
+	_ = trie.Upsert("10.0.0.0/8", map[string]interface{}{"OwnerTeam": "sre"}, MergeMaps)
+	_, md, _ = trie.Find("10.1.1.1")
+	if md["owner_team"] != "sre" || len(md) != 2 {
+		t.Errorf("Expected Upsert to merge onto the normalized key, got %v", md)
+	}
+}
+
+func TestReservedKeys(t *testing.T) {
+	sys := map[string]interface{}{SysKey: map[string]interface{}{"source": "spoofed"}}
+
+	open := NewIPTrie()
+	if err := open.Insert("10.0.0.0/8", sys); err != nil {
+		t.Errorf("Expected unprotected trie to accept %s, got %v", SysKey, err)
+	}
+
+	protected := NewIPTrie(WithReservedKeys(), WithKeyNormalizer(LowerKeys))
+	if err := protected.Insert("10.0.0.0/8", sys); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey from Insert, got %v", err)
+	}
+
+	_ = protected.Insert("10.0.0.0/8", map[string]interface{}{"Owner": "neteng"})
+	if err := protected.Upsert("10.0.0.0/8", sys, MergeMaps); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey from Upsert, got %v", err)
+	}
+
+	md, _ := protected.FindExact("10.0.0.0/8")
+	if SysMetadata(md) != nil || md["owner"] != "neteng" {
+		t.Errorf("Expected rejected Upsert to leave metadata unchanged, got %v", md)
+	}
+}
@@ -0,0 +1,227 @@
+package trie
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"net"
+	"time"
+)
+
+// hllPrecision is the number of hash bits used to pick a register. 2^12
+// registers give a standard error of about 1.6% in 4KB per prefix.
+const hllPrecision = 12
+
+// hllSketch is a HyperLogLog estimator of distinct addresses
+type hllSketch struct {
+	registers [1 << hllPrecision]uint8
+}
+
+// hashIP returns a well mixed 64-bit hash of an address
+func hashIP(ip []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(ip)
+	x := h.Sum64()
+
+	// FNV alone leaves the high bits poorly distributed for short inputs,
+	// so finish with the splitmix64 mixer.
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// add records one address in the sketch and reports whether the estimate
+// may have changed
+func (s *hllSketch) add(ip []byte) bool {
+	x := hashIP(ip)
+	idx := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > s.registers[idx] {
+		s.registers[idx] = rank
+		return true
+	}
+	return false
+}
+
+// estimate returns the approximate number of distinct addresses added
+func (s *hllSketch) estimate() uint64 {
+	m := float64(len(s.registers))
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	sum := 0.0
+	zeros := 0
+	for _, r := range s.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate while many registers are empty
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// activityWindow is the number of one-minute buckets kept per prefix
+const activityWindow = 60
+
+// activityRing counts observations per minute over the last hour
+type activityRing struct {
+	buckets [activityWindow]uint64
+	last    int64 // unix minute of the newest bucket
+}
+
+// advance moves the ring forward to minute, clearing skipped buckets
+func (r *activityRing) advance(minute int64) {
+	if minute <= r.last {
+		return
+	}
+	if minute-r.last >= activityWindow {
+		r.buckets = [activityWindow]uint64{}
+	} else {
+		for m := r.last + 1; m <= minute; m++ {
+			r.buckets[m%activityWindow] = 0
+		}
+	}
+	r.last = minute
+}
+
+// add counts one observation in the given minute
+func (r *activityRing) add(minute int64) {
+	r.advance(minute)
+	if minute > r.last-activityWindow {
+		r.buckets[minute%activityWindow]++
+	}
+}
+
+// counts returns the per-minute counts for the hour ending at minute,
+// oldest first
+func (r *activityRing) counts(minute int64) []uint64 {
+	out := make([]uint64, activityWindow)
+	for i := range out {
+		m := minute - activityWindow + 1 + int64(i)
+		if m <= r.last && m > r.last-activityWindow {
+			out[i] = r.buckets[m%activityWindow]
+		}
+	}
+	return out
+}
+
+// Observe records a client address against every stored prefix that
+// contains it, feeding both the distinct client sketch and the per-minute
+// activity counters. Both are allocated on a prefix's first observation, so
+// tries that never call Observe pay nothing.
+func (t *IPTrie) Observe(ip string) error {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return fmt.Errorf("invalid IP address")
+	}
+
+	now := t.clock()
+	minute := now.Unix() / 60
+	ipBytes := ipToBytes(parsedIP)
+	node := t.rootFor(ipBytes)
+	totalBits := len(ipBytes) * 8
+
+	for i := 0; i < totalBits; i++ {
+		if node.isEnd {
+			t.observeNode(node, ipBytes, now, minute)
+		}
+
+		byteIndex := i / 8
+		bitIndex := 7 - (i % 8)
+		bit := (ipBytes[byteIndex] >> uint(bitIndex)) & 1
+
+		node = node.children[bit]
+		if node == nil {
+			break
+		}
+	}
+
+	// Check the last node in case it's an exact match
+	if node != nil && node.isEnd {
+		t.observeNode(node, ipBytes, now, minute)
+	}
+
+	if host := t.hostRoute(ipBytes); host != nil {
+		t.observeNode(host, ipBytes, now, minute)
+	}
+
+	return nil
+}
+
+// observeNode updates the per-prefix counters for one matched address and
+// evaluates any alert thresholds against them
+func (t *IPTrie) observeNode(n *Node, ip []byte, now time.Time, minute int64) {
+	if n.distinct == nil {
+		n.distinct = &hllSketch{}
+	}
+	changed := n.distinct.add(ip)
+
+	if n.activity == nil {
+		n.activity = &activityRing{last: minute}
+	}
+	n.activity.add(minute)
+
+	if len(t.alertHooks) > 0 {
+		t.checkAlerts(n, minute, changed, now)
+	}
+}
+
+// clock returns the current time, overridable in tests
+func (t *IPTrie) clock() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// DistinctCount returns the approximate number of distinct addresses passed
+// to Observe that fell inside the given stored prefix
+func (t *IPTrie) DistinctCount(cidr string) (uint64, error) {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return 0, err
+	}
+	if node.distinct == nil {
+		return 0, nil
+	}
+	return node.distinct.estimate(), nil
+}
+
+// ResetDistinctCounts discards the distinct address sketches of every
+// prefix, e.g. at the start of a new reporting day, and re-arms distinct
+// client alerts
+func (t *IPTrie) ResetDistinctCounts() {
+	t.walkNodes(func(n *Node) bool {
+		n.distinct = nil
+		for key := range n.alerted {
+			if key.kind == AlertDistinctIPs {
+				delete(n.alerted, key)
+			}
+		}
+		return true
+	})
+}
+
+// Activity returns the number of observations that fell inside the given
+// stored prefix for each of the last 60 minutes, oldest first. The final
+// element is the current, still filling, minute.
+func (t *IPTrie) Activity(cidr string) ([]uint64, error) {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return nil, err
+	}
+	minute := t.clock().Unix() / 60
+	if node.activity == nil {
+		return make([]uint64, activityWindow), nil
+	}
+	return node.activity.counts(minute), nil
+}
@@ -0,0 +1,136 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDistinctCount(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"scope": "wide"})
+	_ = trie.Insert("10.1.0.0/16", map[string]interface{}{"scope": "narrow"})
+
+	// 5000 distinct clients, each seen three times
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 5000; i++ {
+			ip := fmt.Sprintf("10.1.%d.%d", i/256, i%256)
+			if err := trie.Observe(ip); err != nil {
+				t.Fatalf("Observe(%s) returned error: %v", ip, err)
+			}
+		}
+	}
+	for i := 0; i < 100; i++ {
+		_ = trie.Observe(fmt.Sprintf("10.200.0.%d", i))
+	}
+
+	tests := []struct {
+		cidr string
+		want float64
+	}{
+		{cidr: "10.0.0.0/8", want: 5100},
+		{cidr: "10.1.0.0/16", want: 5000},
+	}
+
+	for _, tt := range tests {
+		got, err := trie.DistinctCount(tt.cidr)
+		if err != nil {
+			t.Fatalf("DistinctCount(%s) returned error: %v", tt.cidr, err)
+		}
+		if diff := float64(got) - tt.want; diff > tt.want*0.05 || diff < -tt.want*0.05 {
+			t.Errorf("Expected about %.0f distinct clients in %s, got %d", tt.want, tt.cidr, got)
+		}
+	}
+
+	if _, err := trie.DistinctCount("172.16.0.0/12"); err == nil {
+		t.Errorf("Expected error for prefix that was never inserted")
+	}
+	if err := trie.Observe("not-an-ip"); err == nil {
+		t.Errorf("Expected error for invalid IP")
+	}
+
+	trie.ResetDistinctCounts()
+	if got, _ := trie.DistinctCount("10.0.0.0/8"); got != 0 {
+		t.Errorf("Expected 0 after reset, got %d", got)
+	}
+}
+
+func TestDistinctCountSmall(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("2001:db8::/32", nil)
+
+	for i := 0; i < 10; i++ {
+		_ = trie.Observe(fmt.Sprintf("2001:db8::%x", i))
+		_ = trie.Observe(fmt.Sprintf("2001:db8::%x", i))
+	}
+
+	got, err := trie.DistinctCount("2001:db8::/32")
+	if err != nil {
+		t.Fatalf("DistinctCount returned error: %v", err)
+	}
+	if got != 10 {
+		t.Errorf("Expected 10 distinct clients, got %d", got)
+	}
+}
+
+func TestActivity(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("10.0.0.0/8", nil)
+	_ = trie.Insert("10.1.0.0/16", nil)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	trie.now = func() time.Time { return now }
+
+	// 3 hits at 12:00, 1 at 12:05 (outside the /16), 2 at 12:30
+	for i := 0; i < 3; i++ {
+		_ = trie.Observe("10.1.0.1")
+	}
+	now = now.Add(5 * time.Minute)
+	_ = trie.Observe("10.9.0.1")
+	now = now.Add(25 * time.Minute)
+	_ = trie.Observe("10.1.0.1")
+	_ = trie.Observe("10.1.0.2")
+
+	wide, err := trie.Activity("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("Activity returned error: %v", err)
+	}
+	if len(wide) != 60 {
+		t.Fatalf("Expected 60 buckets, got %d", len(wide))
+	}
+	if wide[59] != 2 || wide[34] != 1 || wide[29] != 3 {
+		t.Errorf("Unexpected /8 activity: 12:00=%d 12:05=%d 12:30=%d", wide[29], wide[34], wide[59])
+	}
+
+	narrow, _ := trie.Activity("10.1.0.0/16")
+	if narrow[59] != 2 || narrow[34] != 0 || narrow[29] != 3 {
+		t.Errorf("Unexpected /16 activity: 12:00=%d 12:05=%d 12:30=%d", narrow[29], narrow[34], narrow[59])
+	}
+
+	// Once 12:00 slides out of the window only the later hits remain
+	now = now.Add(45 * time.Minute)
+	narrow, _ = trie.Activity("10.1.0.0/16")
+	var total uint64
+	for _, c := range narrow {
+		total += c
+	}
+	if total != 2 || narrow[14] != 2 {
+		t.Errorf("Expected only the 12:30 hits to remain, got total %d", total)
+	}
+
+	// Observing after a long idle period clears stale buckets
+	now = now.Add(3 * time.Hour)
+	_ = trie.Observe("10.1.0.1")
+	narrow, _ = trie.Activity("10.1.0.0/16")
+	total = 0
+	for _, c := range narrow {
+		total += c
+	}
+	if total != 1 || narrow[59] != 1 {
+		t.Errorf("Expected a single fresh hit after idling, got total %d", total)
+	}
+
+	if _, err := trie.Activity("172.16.0.0/12"); err == nil {
+		t.Errorf("Expected error for prefix that was never inserted")
+	}
+}
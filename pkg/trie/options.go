@@ -0,0 +1,62 @@
+package trie
+
+// Option configures an IPTrie at construction time
+type Option func(*IPTrie)
+
+// CIDRMode controls how Insert treats CIDRs with host bits set, such as
+// 10.20.20.5/24
+type CIDRMode int
+
+const (
+	// CIDRAsGiven stores the network but reports the CIDR exactly as it was
+	// inserted. This is the default.
+	CIDRAsGiven CIDRMode = iota
+	// CIDRLenient stores and reports the canonical network, 10.20.20.0/24,
+	// for every inserted CIDR
+	CIDRLenient
+	// CIDRStrict rejects CIDRs with host bits set
+	CIDRStrict
+)
+
+// WithCIDRMode sets how Insert handles non-canonical CIDRs
+func WithCIDRMode(mode CIDRMode) Option {
+	return func(t *IPTrie) {
+		t.cidrMode = mode
+	}
+}
+
+// MatchOrder sets the order of the matches returned by FindAll
+type MatchOrder int
+
+const (
+	// MostSpecificFirst puts the longest prefix, the one Find returns,
+	// first. This is the default.
+	MostSpecificFirst MatchOrder = iota
+	// LeastSpecificFirst puts the shortest prefix, such as a default
+	// route, first
+	LeastSpecificFirst
+)
+
+// WithMatchOrder sets the order of the matches returned by FindAll
+func WithMatchOrder(order MatchOrder) Option {
+	return func(t *IPTrie) {
+		t.matchOrder = order
+	}
+}
+
+// WithKeyNormalizer rewrites every top-level metadata key passed to Insert
+// and Upsert, e.g. with LowerKeys or SnakeCaseKeys, so that data from
+// different sources agrees on spelling. The reserved SysKey is left as is.
+func WithKeyNormalizer(fn func(string) string) Option {
+	return func(t *IPTrie) {
+		t.normalizeKey = fn
+	}
+}
+
+// WithReservedKeys rejects metadata that sets SysKey directly, so values
+// written by this package can never be spoofed or clobbered by user data
+func WithReservedKeys() Option {
+	return func(t *IPTrie) {
+		t.protectSys = true
+	}
+}
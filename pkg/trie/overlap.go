@@ -0,0 +1,81 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrOverlap is matched by the errors InsertStrict returns for prefixes
+// that overlap a stored prefix
+var ErrOverlap = errors.New("CIDR overlaps an existing prefix")
+
+// OverlapError reports the stored prefix that blocked an InsertStrict
+type OverlapError struct {
+	CIDR     string
+	Conflict string
+}
+
+func (e *OverlapError) Error() string {
+	return fmt.Sprintf("%s overlaps existing prefix %s", e.CIDR, e.Conflict)
+}
+
+// Unwrap lets errors.Is match ErrOverlap
+func (e *OverlapError) Unwrap() error {
+	return ErrOverlap
+}
+
+// InsertStrict inserts a CIDR like Insert, unless it contains or is
+// contained by a different stored prefix, in which case it returns an
+// *OverlapError naming the closest conflicting prefix. Re-inserting a stored
+// CIDR updates it. Use it to keep IPAM pools from overlapping.
+func (t *IPTrie) InsertStrict(cidr string, metadata map[string]interface{}) error {
+	p, err := netip.ParsePrefix(unmapCIDR(cidr))
+	if err != nil {
+		return fmt.Errorf("invalid CIDR: %v", err)
+	}
+	if conflict, ok := t.overlapping(p.Masked()); ok {
+		return &OverlapError{CIDR: cidr, Conflict: conflict}
+	}
+	return t.Insert(cidr, metadata)
+}
+
+// overlapping returns the stored prefix nearest to p that contains or is
+// contained by it, other than p itself
+func (t *IPTrie) overlapping(p netip.Prefix) (string, bool) {
+	for _, m := range t.Supernets(p.String()) {
+		if s, ok := storedPrefix(m.CIDR); ok && s != p {
+			return m.CIDR, true
+		}
+	}
+	covered, _ := t.CoveredBy(p.String())
+	for _, m := range covered {
+		if s, ok := storedPrefix(m.CIDR); ok && s != p {
+			return m.CIDR, true
+		}
+	}
+	return "", false
+}
+
+// Overlaps returns every stored prefix that overlaps the given prefix:
+// those containing it, the prefix itself if stored, and those it contains,
+// in Walk order. Policy engines can use it to find shadowed rules. An
+// invalid CIDR yields no matches.
+func (t *IPTrie) Overlaps(cidr string) []Match {
+	p, err := netip.ParsePrefix(unmapCIDR(cidr))
+	if err != nil {
+		return nil
+	}
+	p = p.Masked()
+
+	var matches []Match
+	supernets := t.Supernets(p.String())
+	for i := len(supernets) - 1; i >= 0; i-- {
+		// CoveredBy below reports the prefix itself
+		if s, ok := storedPrefix(supernets[i].CIDR); ok && s != p {
+			matches = append(matches, supernets[i])
+		}
+	}
+	covered, _ := t.CoveredBy(p.String())
+	return append(matches, covered...)
+}
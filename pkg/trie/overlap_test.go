@@ -0,0 +1,90 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestInsertStrict(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/16", "10.2.0.0/16", "10.2.3.4/32", "2001:db8::/32"} {
+		if err := trie.Insert(cidr, nil); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", cidr, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		cidr     string
+		conflict string
+	}{
+		{name: "disjoint", cidr: "10.1.0.0/16"},
+		{name: "update in place", cidr: "10.0.0.0/16"},
+		{name: "inside existing", cidr: "10.0.5.0/24", conflict: "10.0.0.0/16"},
+		{name: "covers existing", cidr: "10.0.0.0/8", conflict: "10.0.0.0/16"},
+		{name: "nearest supernet reported", cidr: "10.2.3.0/24", conflict: "10.2.0.0/16"},
+		{name: "stored prefix with nested entries", cidr: "10.2.0.0/16", conflict: "10.2.3.4/32"},
+		{name: "other family untouched", cidr: "::/0", conflict: "2001:db8::/32"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := trie.Len()
+			err := trie.InsertStrict(tt.cidr, nil)
+			if tt.conflict == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+
+			var overlap *OverlapError
+			if !errors.As(err, &overlap) || !errors.Is(err, ErrOverlap) {
+				t.Fatalf("Expected an OverlapError, got %v", err)
+			}
+			if overlap.Conflict != tt.conflict {
+				t.Errorf("Expected conflict with %s, got %s", tt.conflict, overlap.Conflict)
+			}
+			if trie.Len() != before {
+				t.Errorf("Expected %s not to be inserted", tt.cidr)
+			}
+		})
+	}
+
+	if err := trie.InsertStrict("bogus", nil); err == nil {
+		t.Errorf("Expected error for invalid CIDR")
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32", "10.2.0.0/16", "2001:db8::/32"} {
+		trie.Insert(cidr, nil)
+	}
+
+	tests := []struct {
+		name string
+		cidr string
+		want []string
+	}{
+		{name: "supernets, self and subnets", cidr: "10.1.0.0/16", want: []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32"}},
+		{name: "not stored", cidr: "10.1.0.0/17", want: []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32"}},
+		{name: "host route", cidr: "10.1.2.3/32", want: []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32"}},
+		{name: "disjoint sibling", cidr: "10.3.0.0/16", want: []string{"0.0.0.0/0", "10.0.0.0/8"}},
+		{name: "IPv6 only", cidr: "2001:db8:1::/48", want: []string{"2001:db8::/32"}},
+		{name: "invalid", cidr: "bogus", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, m := range trie.Overlaps(tt.cidr) {
+				got = append(got, m.CIDR)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
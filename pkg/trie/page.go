@@ -0,0 +1,71 @@
+package trie
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Truncation describes the entries an Enumerate call left out
+type Truncation struct {
+	// Omitted is the number of matching entries after the returned page
+	Omitted int
+	// Next is the continuation token that resumes after the returned page
+	Next string
+}
+
+// Enumerate returns up to limit stored prefixes within the given prefix, or
+// within the whole trie if within is empty, in Walk order. When more remain
+// it also returns a Truncation, whose Next token passed back as token
+// resumes right after the last returned prefix. Tokens stay valid across
+// inserts and deletes; entries added before the resume point are skipped.
+// A limit of zero or less means no limit.
+func (t *IPTrie) Enumerate(within string, limit int, token string) ([]Match, *Truncation, error) {
+	var after netip.Prefix
+	if token != "" {
+		var ok bool
+		if after, ok = storedPrefix(token); !ok {
+			return nil, nil, fmt.Errorf("invalid continuation token %q", token)
+		}
+	}
+
+	var (
+		matches []Match
+		trunc   *Truncation
+	)
+	visit := func(n *Node) bool {
+		if token != "" && !walksAfter(n.cidr, after) {
+			return true
+		}
+		if limit <= 0 || len(matches) < limit {
+			matches = append(matches, n.match())
+			return true
+		}
+		if trunc == nil {
+			trunc = &Truncation{Next: matches[len(matches)-1].CIDR}
+		}
+		trunc.Omitted++
+		return true
+	}
+
+	if within == "" {
+		t.walkNodes(visit)
+		return matches, trunc, nil
+	}
+	if err := t.walkCovered(within, visit); err != nil {
+		return nil, nil, err
+	}
+	return matches, trunc, nil
+}
+
+// walksAfter reports whether Walk visits the stored cidr after p. Walk
+// order is address order, with shorter prefixes first on a tie.
+func walksAfter(cidr string, p netip.Prefix) bool {
+	s, ok := storedPrefix(cidr)
+	if !ok {
+		return false
+	}
+	if c := s.Addr().Compare(p.Addr()); c != 0 {
+		return c > 0
+	}
+	return s.Bits() > p.Bits()
+}
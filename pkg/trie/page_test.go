@@ -0,0 +1,76 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEnumeratePages(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"0.0.0.0/0", "10.0.0.0/8", "10.0.0.0/16", "10.0.0.1/32", "10.1.0.0/16", "192.0.2.0/24", "::/0", "2001:db8::/32", "2001:db8::1/128"} {
+		trie.Insert(cidr, nil)
+	}
+
+	var want []string
+	trie.Walk(func(prefix string, md map[string]interface{}) bool {
+		want = append(want, prefix)
+		return true
+	})
+
+	for _, limit := range []int{1, 2, 4, 100} {
+		var got []string
+		token := ""
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatalf("limit %d: pagination did not terminate", limit)
+			}
+			page, trunc, err := trie.Enumerate("", limit, token)
+			if err != nil {
+				t.Fatalf("Enumerate returned error: %v", err)
+			}
+			if len(page) > limit {
+				t.Fatalf("limit %d: got %d entries", limit, len(page))
+			}
+			for _, m := range page {
+				got = append(got, m.CIDR)
+			}
+			if trunc == nil {
+				break
+			}
+			if trunc.Omitted != len(want)-len(got) {
+				t.Errorf("limit %d: Expected %d omitted, got %d", limit, len(want)-len(got), trunc.Omitted)
+			}
+			token = trunc.Next
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("limit %d: Expected %v, got %v", limit, want, got)
+		}
+	}
+}
+
+func TestEnumerateWithin(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.9/32", "10.2.0.0/16"} {
+		trie.Insert(cidr, nil)
+	}
+
+	page, trunc, err := trie.Enumerate("10.1.0.0/16", 2, "")
+	if err != nil || len(page) != 2 || trunc == nil || trunc.Omitted != 1 || trunc.Next != "10.1.2.0/24" {
+		t.Fatalf("Unexpected first page %v %+v (%v)", page, trunc, err)
+	}
+
+	// Entries removed or added behind the token do not disturb the rest
+	trie.Delete("10.1.2.0/24")
+	trie.Insert("10.1.0.0/20", nil)
+	page, trunc, _ = trie.Enumerate("10.1.0.0/16", 2, trunc.Next)
+	if len(page) != 1 || page[0].CIDR != "10.1.2.9/32" || trunc != nil {
+		t.Errorf("Expected only the host route on the second page, got %v %+v", page, trunc)
+	}
+
+	if _, _, err := trie.Enumerate("bogus", 1, ""); err == nil {
+		t.Errorf("Expected error for invalid CIDR")
+	}
+	if _, _, err := trie.Enumerate("", 1, "bogus"); err == nil {
+		t.Errorf("Expected error for invalid token")
+	}
+}
@@ -0,0 +1,91 @@
+package trie
+
+import (
+	"fmt"
+	"net"
+)
+
+// Pair links an IPv4 prefix with the IPv6 prefix allocated to the same
+// service or site. Both must already be stored. A prefix has at most one
+// counterpart, so pairing replaces any earlier link of either side. Links
+// are dropped when either prefix is removed.
+func (t *IPTrie) Pair(cidr4, cidr6 string) error {
+	n4, err := t.lookupExact(cidr4)
+	if err != nil {
+		return err
+	}
+	n6, err := t.lookupExact(cidr6)
+	if err != nil {
+		return err
+	}
+	if !isFamily(n4.cidr, net.IPv4len) {
+		return fmt.Errorf("not an IPv4 CIDR: %s", cidr4)
+	}
+	if !isFamily(n6.cidr, net.IPv6len) {
+		return fmt.Errorf("not an IPv6 CIDR: %s", cidr6)
+	}
+
+	t.unpair(n4.cidr)
+	t.unpair(n6.cidr)
+	if t.pairs == nil {
+		t.pairs = make(map[string]string)
+	}
+	t.pairs[n4.cidr] = n6.cidr
+	t.pairs[n6.cidr] = n4.cidr
+	return nil
+}
+
+// Unpair removes the link between a stored prefix and its counterpart
+func (t *IPTrie) Unpair(cidr string) error {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return err
+	}
+	t.unpair(node.cidr)
+	return nil
+}
+
+// Counterpart returns the prefix of the other address family paired with
+// the given stored prefix, and whether there is one
+func (t *IPTrie) Counterpart(cidr string) (Match, bool) {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return Match{}, false
+	}
+	other, ok := t.pairs[node.cidr]
+	if !ok {
+		return Match{}, false
+	}
+	counterpart, err := t.lookupExact(other)
+	if err != nil {
+		return Match{}, false
+	}
+	return counterpart.match(), true
+}
+
+// unpair drops the links of a stored CIDR in both directions
+func (t *IPTrie) unpair(cidr string) {
+	if other, ok := t.pairs[cidr]; ok {
+		delete(t.pairs, cidr)
+		delete(t.pairs, other)
+	}
+}
+
+// renamePair keeps links pointing at a prefix whose stored spelling
+// changed, e.g. when re-inserted with different host bits
+func (t *IPTrie) renamePair(from, to string) {
+	other, ok := t.pairs[from]
+	if !ok || from == to {
+		return
+	}
+	delete(t.pairs, from)
+	t.pairs[to] = other
+	t.pairs[other] = to
+}
+
+// isFamily reports whether a stored CIDR belongs to the address family
+// with addresses of size bytes
+func isFamily(cidr string, size int) bool {
+	_, _, ipnet, err := parseCIDR(cidr)
+	return err == nil && len(ipToBytes(ipnet.IP)) == size
+}
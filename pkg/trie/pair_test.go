@@ -0,0 +1,98 @@
+package trie
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPair(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("192.0.2.0/24", map[string]interface{}{"site": "ams1"})
+	trie.Insert("2001:db8:1::/48", map[string]interface{}{"site": "ams1"})
+	trie.Insert("198.51.100.0/24", nil)
+
+	if err := trie.Pair("192.0.2.0/24", "2001:db8:1::/48"); err != nil {
+		t.Fatalf("Pair returned error: %v", err)
+	}
+
+	m, ok := trie.Counterpart("192.0.2.0/24")
+	if !ok || m.CIDR != "2001:db8:1::/48" || m.Metadata["site"] != "ams1" {
+		t.Errorf("Expected 2001:db8:1::/48 as counterpart, got %v %v", m, ok)
+	}
+	m, ok = trie.Counterpart("2001:db8:1::/48")
+	if !ok || m.CIDR != "192.0.2.0/24" {
+		t.Errorf("Expected 192.0.2.0/24 as counterpart, got %v %v", m, ok)
+	}
+
+	// Re-pairing the IPv6 side releases the old IPv4 partner
+	if err := trie.Pair("198.51.100.0/24", "2001:db8:1::/48"); err != nil {
+		t.Fatalf("Pair returned error: %v", err)
+	}
+	if _, ok := trie.Counterpart("192.0.2.0/24"); ok {
+		t.Errorf("Expected old partner to be unpaired")
+	}
+
+	// Removing one side unpairs the other
+	trie.Delete("2001:db8:1::/48")
+	if _, ok := trie.Counterpart("198.51.100.0/24"); ok {
+		t.Errorf("Expected counterpart to be dropped on delete")
+	}
+	trie.Insert("2001:db8:1::/48", nil)
+	if _, ok := trie.Counterpart("2001:db8:1::/48"); ok {
+		t.Errorf("Expected re-inserted prefix to start unpaired")
+	}
+}
+
+func TestPairErrors(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("192.0.2.0/24", nil)
+	trie.Insert("10.0.0.0/8", nil)
+	trie.Insert("2001:db8::/32", nil)
+
+	tests := []struct {
+		name         string
+		cidr4, cidr6 string
+	}{
+		{name: "missing IPv4", cidr4: "203.0.113.0/24", cidr6: "2001:db8::/32"},
+		{name: "missing IPv6", cidr4: "192.0.2.0/24", cidr6: "2001:db9::/32"},
+		{name: "both IPv4", cidr4: "192.0.2.0/24", cidr6: "10.0.0.0/8"},
+		{name: "swapped", cidr4: "2001:db8::/32", cidr6: "192.0.2.0/24"},
+		{name: "invalid", cidr4: "bogus", cidr6: "2001:db8::/32"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := trie.Pair(tt.cidr4, tt.cidr6); err == nil {
+				t.Errorf("Expected error")
+			}
+		})
+	}
+}
+
+func TestPairPersistence(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("192.0.2.0/24", nil)
+	trie.Insert("2001:db8::1/128", nil)
+	trie.Pair("192.0.2.0/24", "2001:db8::1/128")
+
+	// Host bits in the re-inserted spelling keep the link
+	trie.Insert("192.0.2.7/24", nil)
+	if m, ok := trie.Counterpart("2001:db8::1/128"); !ok || m.CIDR != "192.0.2.7/24" {
+		t.Errorf("Expected link to follow the new spelling, got %v %v", m, ok)
+	}
+
+	if m, ok := trie.Clone().Counterpart("192.0.2.0/24"); !ok || m.CIDR != "2001:db8::1/128" {
+		t.Errorf("Expected clone to keep pairs, got %v %v", m, ok)
+	}
+
+	data, err := json.Marshal(trie)
+	if err != nil {
+		t.Fatalf("MarshalJSON returned error: %v", err)
+	}
+	restored := NewIPTrie()
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("UnmarshalJSON returned error: %v", err)
+	}
+	if m, ok := restored.Counterpart("2001:db8::1/128"); !ok || m.CIDR != "192.0.2.7/24" {
+		t.Errorf("Expected pairs to survive JSON, got %v %v", m, ok)
+	}
+}
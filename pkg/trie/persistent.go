@@ -0,0 +1,309 @@
+package trie
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// PersistentTrie is an immutable trie. Insert and Delete leave the receiver
+// unchanged and return a new trie that shares every node off the modified
+// path, so a change costs O(prefix length) rather than a full Clone. Any
+// number of goroutines may query a PersistentTrie without locks, and old
+// versions stay valid for as long as they are referenced, e.g. to roll a
+// configuration back.
+//
+// Host routes are kept on trie paths like other prefixes. Lifecycle states,
+// dual-stack pairs, observation counters and hooks are not supported.
+type PersistentTrie struct {
+	root4 *pnode
+	root6 *pnode
+	size  int
+	// opts holds the options the trie was created with, on an otherwise
+	// unused IPTrie
+	opts *IPTrie
+}
+
+// pnode is a node of a PersistentTrie. It is never modified once reachable
+// from a published root.
+type pnode struct {
+	children [2]*pnode
+	isEnd    bool
+	cidr     string
+	metadata map[string]interface{}
+}
+
+// NewPersistentTrie returns an empty persistent trie configured by opts.
+// Options that install hooks have no effect.
+func NewPersistentTrie(opts ...Option) *PersistentTrie {
+	return &PersistentTrie{opts: NewIPTrie(opts...)}
+}
+
+// Freeze returns a persistent trie holding the prefixes and metadata of t,
+// with the same options
+func (t *IPTrie) Freeze() *PersistentTrie {
+	p := &PersistentTrie{opts: NewIPTrie()}
+	copyOptions(p.opts, t)
+	t.walkNodes(func(n *Node) bool {
+		p, _ = p.insert(n.cidr, n.metadata, false)
+		return true
+	})
+	return p
+}
+
+// Thaw returns a new mutable trie holding the prefixes and metadata of p,
+// with the same options
+func (p *PersistentTrie) Thaw() *IPTrie {
+	t := NewIPTrie()
+	copyOptions(t, p.opts)
+	p.Walk(func(prefix string, md map[string]interface{}) bool {
+		_ = t.insert(prefix, md, false)
+		return true
+	})
+	return t
+}
+
+// Len returns the number of stored prefixes
+func (p *PersistentTrie) Len() int {
+	return p.size
+}
+
+// Insert returns a trie with cidr added, or its metadata replaced if it is
+// already stored
+func (p *PersistentTrie) Insert(cidr string, metadata map[string]interface{}) (*PersistentTrie, error) {
+	return p.insert(cidr, metadata, true)
+}
+
+// insert is Insert, passing metadata through the key options first unless
+// the caller already did
+func (p *PersistentTrie) insert(cidr string, metadata map[string]interface{}, prepare bool) (*PersistentTrie, error) {
+	cidr, ip, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	cidr, err = p.opts.canonicalCIDR(cidr, ip, ipnet)
+	if err != nil {
+		return nil, err
+	}
+	if prepare {
+		if metadata, err = p.opts.prepareMetadata(metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	ipBytes := ipToBytes(ipnet.IP)
+	ones, _ := ipnet.Mask.Size()
+	next := *p
+	root, added := p.root(ipBytes).insert(ipBytes, 0, ones, cidr, metadata)
+	next.setRoot(ipBytes, root)
+	if added {
+		next.size++
+	}
+	return &next, nil
+}
+
+// Delete returns a trie without cidr. It fails if cidr is not stored.
+func (p *PersistentTrie) Delete(cidr string) (*PersistentTrie, error) {
+	_, _, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	ipBytes := ipToBytes(ipnet.IP)
+	ones, _ := ipnet.Mask.Size()
+	root, removed := p.root(ipBytes).remove(ipBytes, 0, ones)
+	if !removed {
+		return nil, fmt.Errorf("CIDR not found")
+	}
+	next := *p
+	next.setRoot(ipBytes, root)
+	next.size--
+	return &next, nil
+}
+
+// root returns the root for the address family of ipBytes
+func (p *PersistentTrie) root(ipBytes []byte) *pnode {
+	if len(ipBytes) == net.IPv4len {
+		return p.root4
+	}
+	return p.root6
+}
+
+// setRoot replaces the root for the address family of ipBytes
+func (p *PersistentTrie) setRoot(ipBytes []byte, root *pnode) {
+	if len(ipBytes) == net.IPv4len {
+		p.root4 = root
+	} else {
+		p.root6 = root
+	}
+}
+
+// insert returns a copy of the path from n down to depth ones with the end
+// node holding cidr, and whether the prefix is new. n may be nil.
+func (n *pnode) insert(ipBytes []byte, depth, ones int, cidr string, metadata map[string]interface{}) (*pnode, bool) {
+	c := &pnode{}
+	if n != nil {
+		*c = *n
+	}
+	if depth == ones {
+		added := !c.isEnd
+		c.isEnd, c.cidr, c.metadata = true, cidr, metadata
+		return c, added
+	}
+
+	bit := (ipBytes[depth/8] >> uint(7-depth%8)) & 1
+	child, added := c.children[bit].insert(ipBytes, depth+1, ones, cidr, metadata)
+	c.children[bit] = child
+	return c, added
+}
+
+// remove returns a copy of the path from n with the prefix at depth ones
+// unmarked and emptied branches pruned, and whether it was stored. When it
+// was not, n itself is returned.
+func (n *pnode) remove(ipBytes []byte, depth, ones int) (*pnode, bool) {
+	if n == nil {
+		return nil, false
+	}
+	c := *n
+	if depth == ones {
+		if !n.isEnd {
+			return n, false
+		}
+		c.isEnd, c.cidr, c.metadata = false, "", nil
+	} else {
+		bit := (ipBytes[depth/8] >> uint(7-depth%8)) & 1
+		child, removed := n.children[bit].remove(ipBytes, depth+1, ones)
+		if !removed {
+			return n, false
+		}
+		c.children[bit] = child
+	}
+
+	if !c.isEnd && c.children[0] == nil && c.children[1] == nil {
+		return nil, true
+	}
+	return &c, true
+}
+
+// Find returns the most specific stored prefix containing ip and its
+// metadata
+func (p *PersistentTrie) Find(ip string) (string, map[string]interface{}, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		return "", nil, errInvalidIP
+	}
+	cidr, md, ok := p.FindAddr(addr)
+	if !ok {
+		return "", nil, errNoMatch
+	}
+	return cidr, md, nil
+}
+
+// FindAddr is Find for a parsed address, reporting whether any prefix
+// matched. Like IPTrie.FindAddr it makes no heap allocations.
+func (p *PersistentTrie) FindAddr(addr netip.Addr) (string, map[string]interface{}, bool) {
+	var last *pnode
+	p.path(addr, func(n *pnode) {
+		last = n
+	})
+	if last == nil {
+		return "", nil, false
+	}
+	return last.cidr, last.metadata, true
+}
+
+// FindExact returns the metadata stored for exactly the given CIDR
+func (p *PersistentTrie) FindExact(cidr string) (map[string]interface{}, bool) {
+	node := p.exact(cidr)
+	if node == nil {
+		return nil, false
+	}
+	return node.metadata, true
+}
+
+// exact returns the node holding exactly the given CIDR, or nil
+func (p *PersistentTrie) exact(cidr string) *pnode {
+	_, _, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+	ipBytes := ipToBytes(ipnet.IP)
+	ones, _ := ipnet.Mask.Size()
+	node := p.root(ipBytes)
+	for i := 0; i < ones && node != nil; i++ {
+		node = node.children[(ipBytes[i/8]>>uint(7-i%8))&1]
+	}
+	if node == nil || !node.isEnd {
+		return nil
+	}
+	return node
+}
+
+// FindAll returns every stored prefix containing ip, in the trie's match
+// order
+func (p *PersistentTrie) FindAll(ip string) ([]Match, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		return nil, errInvalidIP
+	}
+	var matches []Match
+	p.path(addr, func(n *pnode) {
+		matches = append(matches, n.match())
+	})
+	if p.opts.matchOrder == MostSpecificFirst {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+	return matches, nil
+}
+
+// path calls fn for every stored node containing addr, least specific
+// first
+func (p *PersistentTrie) path(addr netip.Addr, fn func(*pnode)) {
+	if !addr.IsValid() {
+		return
+	}
+	addr = addr.Unmap().WithZone("")
+	node, bits := p.root6, 128
+	b := addr.As16()
+	if addr.Is4() {
+		node, bits = p.root4, 32
+		a4 := addr.As4()
+		copy(b[:], a4[:])
+	}
+
+	for i := 0; i < bits && node != nil; i++ {
+		if node.isEnd {
+			fn(node)
+		}
+		node = node.children[(b[i/8]>>(7-i%8))&1]
+	}
+	if node != nil && node.isEnd {
+		fn(node)
+	}
+}
+
+// match returns the Match for a stored node
+func (n *pnode) match() Match {
+	bits := prefixLen(n.cidr)
+	return Match{CIDR: n.cidr, PrefixLen: bits, Host: isHostRoute(n.cidr, bits), Metadata: n.metadata}
+}
+
+// Walk calls fn for every stored prefix in sorted order, IPv4 before IPv6
+// and shorter prefixes before the longer prefixes they contain. Returning
+// false from fn stops the walk.
+func (p *PersistentTrie) Walk(fn func(prefix string, md map[string]interface{}) bool) {
+	_ = p.root4.walk(fn) && p.root6.walk(fn)
+}
+
+// walk visits the stored prefixes at or below n in order
+func (n *pnode) walk(fn func(prefix string, md map[string]interface{}) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.isEnd && !fn(n.cidr, n.metadata) {
+		return false
+	}
+	return n.children[0].walk(fn) && n.children[1].walk(fn)
+}
@@ -0,0 +1,197 @@
+package trie
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPersistentTrie(t *testing.T) {
+	v0 := NewPersistentTrie()
+	v1, err := v0.Insert("10.0.0.0/8", map[string]interface{}{"gen": 1})
+	if err != nil {
+		t.Fatalf("Insert returned error: %v", err)
+	}
+	v2, _ := v1.Insert("10.1.0.0/16", map[string]interface{}{"gen": 2})
+	v3, _ := v2.Insert("10.1.2.3/32", map[string]interface{}{"gen": 3})
+	v4, _ := v3.Insert("10.0.0.0/8", map[string]interface{}{"gen": 4})
+	v5, err := v4.Delete("10.1.0.0/16")
+	if err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		trie    *PersistentTrie
+		ip      string
+		want    string
+		wantGen int
+		wantLen int
+	}{
+		{"empty", v0, "10.1.2.3", "", 0, 0},
+		{"first insert", v1, "10.1.2.3", "10.0.0.0/8", 1, 1},
+		{"more specific", v2, "10.1.2.3", "10.1.0.0/16", 2, 2},
+		{"host route", v3, "10.1.2.3", "10.1.2.3/32", 3, 3},
+		{"host route neighbour", v3, "10.1.2.4", "10.1.0.0/16", 2, 3},
+		{"update", v4, "10.2.0.0", "10.0.0.0/8", 4, 3},
+		{"update leaves old version", v3, "10.2.0.0", "10.0.0.0/8", 1, 3},
+		{"delete", v5, "10.1.9.9", "10.0.0.0/8", 4, 2},
+		{"delete keeps host route", v5, "10.1.2.3", "10.1.2.3/32", 3, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cidr, md, err := tt.trie.Find(tt.ip)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Expected no match, got %s", cidr)
+				}
+			} else if err != nil || cidr != tt.want || md["gen"] != tt.wantGen {
+				t.Errorf("Expected %s gen %d, got %s %v %v", tt.want, tt.wantGen, cidr, md, err)
+			}
+			if tt.trie.Len() != tt.wantLen {
+				t.Errorf("Expected Len %d, got %d", tt.wantLen, tt.trie.Len())
+			}
+		})
+	}
+
+	if _, err := v5.Delete("10.1.0.0/16"); err == nil {
+		t.Errorf("Expected error deleting a CIDR that is not stored")
+	}
+	if _, err := v0.Insert("bogus", nil); err == nil {
+		t.Errorf("Expected error for an invalid CIDR")
+	}
+	if _, _, err := v0.Find("bogus"); err == nil {
+		t.Errorf("Expected error for an invalid IP")
+	}
+}
+
+func TestPersistentTrieSharing(t *testing.T) {
+	v1, _ := NewPersistentTrie().Insert("10.0.0.0/8", nil)
+	v1, _ = v1.Insert("192.168.0.0/16", nil)
+	v1, _ = v1.Insert("2001:db8::/32", nil)
+	v2, _ := v1.Insert("10.1.0.0/16", nil)
+
+	// Only the IPv4 path down to 10.1.0.0/16 is copied
+	if v1.root6 != v2.root6 {
+		t.Errorf("Expected the IPv6 root to be shared")
+	}
+	if v1.root4 == v2.root4 || v1.root4.children[1] != v2.root4.children[1] {
+		t.Errorf("Expected a new IPv4 root sharing the 192.168.0.0/16 branch")
+	}
+
+	// Deleting the last prefix under a branch prunes it
+	v3, _ := v2.Delete("2001:db8::/32")
+	if v3.root6 != nil {
+		t.Errorf("Expected the emptied IPv6 trie to be pruned")
+	}
+	if _, ok := v2.FindExact("2001:db8::/32"); !ok {
+		t.Errorf("Expected the previous version to keep 2001:db8::/32")
+	}
+}
+
+func TestPersistentTrieQueries(t *testing.T) {
+	p := NewPersistentTrie(WithMatchOrder(LeastSpecificFirst), WithKeyNormalizer(LowerKeys))
+	for _, cidr := range []string{"2001:db8::/32", "10.1.0.0/16", "0.0.0.0/0", "10.0.0.0/8", "10.1.2.3/32"} {
+		var err error
+		if p, err = p.Insert(cidr, map[string]interface{}{"Name": cidr}); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", cidr, err)
+		}
+	}
+
+	matches, err := p.FindAll("::ffff:10.1.2.3")
+	if err != nil || len(matches) != 4 || matches[0].CIDR != "0.0.0.0/0" || !matches[3].Host {
+		t.Errorf("Unexpected FindAll result %v, %v", matches, err)
+	}
+	if md, ok := p.FindExact("10.1.0.0/16"); !ok || md["name"] != "10.1.0.0/16" {
+		t.Errorf("Expected normalized metadata for 10.1.0.0/16, got %v", md)
+	}
+	if _, ok := p.FindExact("10.1.0.0/17"); ok {
+		t.Errorf("Expected no exact match for 10.1.0.0/17")
+	}
+
+	var walked []string
+	p.Walk(func(prefix string, md map[string]interface{}) bool {
+		walked = append(walked, prefix)
+		return true
+	})
+	want := []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "2001:db8::/32"}
+	if len(walked) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, walked)
+	}
+	for i := range want {
+		if walked[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, walked)
+			break
+		}
+	}
+
+	addr := netip.MustParseAddr("10.1.9.9")
+	if allocs := testing.AllocsPerRun(100, func() { p.FindAddr(addr) }); allocs != 0 {
+		t.Errorf("Expected FindAddr not to allocate, got %v allocations", allocs)
+	}
+}
+
+func TestFreezeThaw(t *testing.T) {
+	trie := NewIPTrie(WithCIDRMode(CIDRStrict))
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"owner": "netops"})
+	_ = trie.Insert("10.1.2.3/32", nil)
+	_ = trie.Insert("2001:db8::/32", nil)
+
+	frozen := trie.Freeze()
+	_ = trie.Delete("10.0.0.0/8")
+	if frozen.Len() != 3 {
+		t.Errorf("Expected the frozen trie to keep 3 prefixes, got %d", frozen.Len())
+	}
+	if _, err := frozen.Insert("10.1.1.1/8", nil); err == nil {
+		t.Errorf("Expected the frozen trie to keep CIDRStrict")
+	}
+
+	thawed := frozen.Thaw()
+	if thawed.Len() != 3 {
+		t.Errorf("Expected 3 prefixes after Thaw, got %d", thawed.Len())
+	}
+	if cidr, md, err := thawed.Find("10.2.0.0"); err != nil || cidr != "10.0.0.0/8" || md["owner"] != "netops" {
+		t.Errorf("Unexpected Find after Thaw: %s %v %v", cidr, md, err)
+	}
+	if err := thawed.Insert("10.1.1.1/8", nil); err == nil {
+		t.Errorf("Expected the thawed trie to keep CIDRStrict")
+	}
+}
+
+func TestPersistentTrieConcurrentReaders(t *testing.T) {
+	var current atomic.Pointer[PersistentTrie]
+	first, _ := NewPersistentTrie().Insert("10.0.0.0/8", nil)
+	current.Store(first)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, _, err := current.Load().Find("10.1.2.3"); err != nil {
+					t.Errorf("Reader saw an inconsistent trie: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	p := current.Load()
+	for i := 0; i < 200; i++ {
+		p, _ = p.Insert(netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i), 0, 0}), 16).String(), nil)
+		current.Store(p)
+	}
+	close(stop)
+	wg.Wait()
+	if current.Load().Len() != 201 {
+		t.Errorf("Expected 201 prefixes, got %d", current.Load().Len())
+	}
+}
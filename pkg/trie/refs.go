@@ -0,0 +1,247 @@
+package trie
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RefsKey is the metadata key holding a prefix's external references
+const RefsKey = "refs"
+
+// Ref links a prefix to operational context kept elsewhere, such as a
+// ticket, a CMDB record or a monitoring dashboard
+type Ref struct {
+	// Type names the kind of reference, e.g. "ticket" or "cmdb"
+	Type string `json:"type"`
+	// ID identifies the record in the external system
+	ID string `json:"id,omitempty"`
+	// URL links to the record. When empty it is built from the ID with
+	// the type's URL template, if any.
+	URL   string `json:"url,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// String formats r as "type:id url", leaving out whichever is empty
+func (r Ref) String() string {
+	s := r.Type
+	if r.ID != "" {
+		s += ":" + r.ID
+	}
+	if r.URL != "" {
+		s += " " + r.URL
+	}
+	return s
+}
+
+// RefType declares a kind of reference accepted by a trie created with
+// WithRefTypes
+type RefType struct {
+	Name string
+	// Pattern, if set, must match every ID of this type. Anchor it with ^
+	// and $ to match whole IDs.
+	Pattern *regexp.Regexp
+	// URL is a template for references without their own URL, with {id}
+	// replaced by the escaped ID, e.g. "https://jira.example.com/browse/{id}"
+	URL string
+}
+
+// WithRefTypes restricts the references stored under RefsKey to the given
+// types and validates them on every insert. Without it, only AddRef
+// validates references, and then only their form.
+func WithRefTypes(types ...RefType) Option {
+	return func(t *IPTrie) {
+		t.refTypes = make(map[string]RefType, len(types))
+		for _, rt := range types {
+			t.refTypes[rt.Name] = rt
+		}
+	}
+}
+
+// AddRef adds a reference to a stored CIDR, validating it first. Adding a
+// reference with the same type and ID, or the same type and URL if it has
+// no ID, replaces the existing one.
+func (t *IPTrie) AddRef(cidr string, ref Ref) error {
+	if err := t.validateRef(ref); err != nil {
+		return err
+	}
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return err
+	}
+
+	refs := Refs(node.metadata)
+	replaced := false
+	for i, r := range refs {
+		if sameRef(r, ref) {
+			refs[i], replaced = ref, true
+		}
+	}
+	if !replaced {
+		refs = append(refs, ref)
+	}
+	return t.insert(cidr, withRefs(node.metadata, refs), false)
+}
+
+// RemoveRef removes the references of a stored CIDR with the given type
+// and ID and reports whether there were any
+func (t *IPTrie) RemoveRef(cidr, refType, id string) (bool, error) {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return false, err
+	}
+	refs := Refs(node.metadata)
+	kept := refs[:0]
+	for _, r := range refs {
+		if r.Type != refType || r.ID != id {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(refs) {
+		return false, nil
+	}
+	return true, t.insert(cidr, withRefs(node.metadata, kept), false)
+}
+
+// FindRefs is Find returning the references of the matching CIDR, with
+// URLs filled in from their types' templates
+func (t *IPTrie) FindRefs(ip string) (string, []Ref, error) {
+	cidr, md, err := t.Find(ip)
+	if err != nil {
+		return "", nil, err
+	}
+	return cidr, t.ResolveRefs(Refs(md)), nil
+}
+
+// ResolveRefs fills in the URL of references that have none from their
+// types' templates. The slice is modified and returned.
+func (t *IPTrie) ResolveRefs(refs []Ref) []Ref {
+	for i, r := range refs {
+		if rt, ok := t.refTypes[r.Type]; ok && r.URL == "" && rt.URL != "" && r.ID != "" {
+			refs[i].URL = strings.ReplaceAll(rt.URL, "{id}", url.PathEscape(r.ID))
+		}
+	}
+	return refs
+}
+
+// Refs returns the references stored in metadata, whether set with AddRef
+// or inserted as a list of objects under RefsKey, as after JSON decoding
+func Refs(md map[string]interface{}) []Ref {
+	switch v := md[RefsKey].(type) {
+	case []Ref:
+		return append([]Ref(nil), v...)
+	case []interface{}:
+		refs := make([]Ref, 0, len(v))
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				refs = append(refs, refFromMap(m))
+			}
+		}
+		return refs
+	case []map[string]interface{}:
+		refs := make([]Ref, 0, len(v))
+		for _, m := range v {
+			refs = append(refs, refFromMap(m))
+		}
+		return refs
+	}
+	return nil
+}
+
+func refFromMap(m map[string]interface{}) Ref {
+	str := func(k string) string {
+		s, _ := m[k].(string)
+		return s
+	}
+	return Ref{Type: str("type"), ID: str("id"), URL: str("url"), Title: str("title")}
+}
+
+// withRefs returns a copy of md with refs stored under RefsKey in the form
+// JSON and gob round trip unchanged
+func withRefs(md map[string]interface{}, refs []Ref) map[string]interface{} {
+	out := make(map[string]interface{}, len(md)+1)
+	for k, v := range md {
+		out[k] = v
+	}
+	if len(refs) == 0 {
+		delete(out, RefsKey)
+		return out
+	}
+	list := make([]interface{}, len(refs))
+	for i, r := range refs {
+		m := map[string]interface{}{"type": r.Type}
+		for k, v := range map[string]string{"id": r.ID, "url": r.URL, "title": r.Title} {
+			if v != "" {
+				m[k] = v
+			}
+		}
+		list[i] = m
+	}
+	out[RefsKey] = list
+	return out
+}
+
+func sameRef(a, b Ref) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.ID != "" || b.ID != "" {
+		return a.ID == b.ID
+	}
+	return a.URL == b.URL
+}
+
+// validateRef checks the form of ref and, with WithRefTypes, its type and
+// ID
+func (t *IPTrie) validateRef(ref Ref) error {
+	if ref.Type == "" {
+		return fmt.Errorf("reference has no type")
+	}
+	if ref.ID == "" && ref.URL == "" {
+		return fmt.Errorf("%s reference has neither ID nor URL", ref.Type)
+	}
+	if ref.URL != "" {
+		u, err := url.Parse(ref.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s reference URL %q is not an absolute http(s) URL", ref.Type, ref.URL)
+		}
+	}
+	if t.refTypes == nil {
+		return nil
+	}
+	rt, ok := t.refTypes[ref.Type]
+	if !ok {
+		return fmt.Errorf("unknown reference type %q", ref.Type)
+	}
+	if rt.Pattern != nil && !rt.Pattern.MatchString(ref.ID) {
+		return fmt.Errorf("%s reference ID %q does not match %s", ref.Type, ref.ID, rt.Pattern)
+	}
+	return nil
+}
+
+// validateRefs checks the references in metadata on its way in, for tries
+// created with WithRefTypes
+func (t *IPTrie) validateRefs(md map[string]interface{}) error {
+	v, ok := md[RefsKey]
+	if !ok || t.refTypes == nil {
+		return nil
+	}
+	switch list := v.(type) {
+	case []Ref, []map[string]interface{}:
+	case []interface{}:
+		for _, item := range list {
+			if _, ok := item.(map[string]interface{}); !ok {
+				return fmt.Errorf("%s must be a list of reference objects", RefsKey)
+			}
+		}
+	default:
+		return fmt.Errorf("%s must be a list of reference objects", RefsKey)
+	}
+	for _, r := range Refs(md) {
+		if err := t.validateRef(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
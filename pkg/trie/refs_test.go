@@ -0,0 +1,187 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func refTypes() Option {
+	return WithRefTypes(
+		RefType{Name: "ticket", Pattern: regexp.MustCompile(`^OPS-[0-9]+$`), URL: "https://jira.example.com/browse/{id}"},
+		RefType{Name: "cmdb", URL: "https://cmdb.example.com/ci/{id}"},
+		RefType{Name: "dashboard"},
+	)
+}
+
+func TestAddRef(t *testing.T) {
+	trie := NewIPTrie(refTypes())
+	if err := trie.Insert("10.1.0.0/16", map[string]interface{}{"owner": "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []Ref{
+		{Type: "ticket", ID: "OPS-12"},
+		{Type: "cmdb", ID: "CI 7", Title: "core switch"},
+		{Type: "dashboard", URL: "https://grafana.example.com/d/abc"},
+		{Type: "ticket", ID: "OPS-12", Title: "renumbering"},
+	} {
+		if err := trie.AddRef("10.1.0.0/16", ref); err != nil {
+			t.Fatalf("AddRef(%v) returned error: %v", ref, err)
+		}
+	}
+
+	cidr, refs, err := trie.FindRefs("10.1.2.3")
+	if err != nil || cidr != "10.1.0.0/16" {
+		t.Fatalf("FindRefs returned %q, %v", cidr, err)
+	}
+	want := []Ref{
+		{Type: "ticket", ID: "OPS-12", URL: "https://jira.example.com/browse/OPS-12", Title: "renumbering"},
+		{Type: "cmdb", ID: "CI 7", URL: "https://cmdb.example.com/ci/CI%207", Title: "core switch"},
+		{Type: "dashboard", URL: "https://grafana.example.com/d/abc"},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("Expected %d refs, got %v", len(want), refs)
+	}
+	for i := range want {
+		if refs[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], refs[i])
+		}
+	}
+
+	// Stored URLs are left empty and other metadata kept
+	md, _ := trie.FindExact("10.1.0.0/16")
+	if md["owner"] != "dev" || Refs(md)[0].URL != "" {
+		t.Errorf("Unexpected stored metadata %v", md)
+	}
+
+	if removed, err := trie.RemoveRef("10.1.0.0/16", "ticket", "OPS-12"); err != nil || !removed {
+		t.Errorf("Expected RemoveRef to remove, got %v, %v", removed, err)
+	}
+	if removed, err := trie.RemoveRef("10.1.0.0/16", "ticket", "OPS-12"); err != nil || removed {
+		t.Errorf("Expected nothing to remove, got %v, %v", removed, err)
+	}
+	if _, refs, _ := trie.FindRefs("10.1.2.3"); len(refs) != 2 {
+		t.Errorf("Expected 2 refs left, got %v", refs)
+	}
+	if err := trie.AddRef("10.2.0.0/16", Ref{Type: "cmdb", ID: "x"}); err == nil {
+		t.Errorf("Expected error adding a ref to a CIDR that is not stored")
+	}
+}
+
+func TestRefValidation(t *testing.T) {
+	typed := NewIPTrie(refTypes())
+	plain := NewIPTrie()
+	for _, tr := range []*IPTrie{typed, plain} {
+		if err := tr.Insert("10.0.0.0/8", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ref        Ref
+		typedOK    bool
+		untypedOK  bool
+		errContain string
+	}{
+		{Ref{Type: "ticket", ID: "OPS-1"}, true, true, ""},
+		{Ref{Type: "ticket", ID: "BUG-1"}, false, true, "does not match"},
+		{Ref{Type: "ticket", URL: "https://jira.example.com/browse/OPS-1"}, false, true, "does not match"},
+		{Ref{Type: "wiki", ID: "Page"}, false, true, "unknown reference type"},
+		{Ref{ID: "x"}, false, false, "no type"},
+		{Ref{Type: "cmdb"}, false, false, "neither ID nor URL"},
+		{Ref{Type: "dashboard", URL: "javascript:alert(1)"}, false, false, "absolute http(s) URL"},
+		{Ref{Type: "dashboard", URL: "/d/abc"}, false, false, "absolute http(s) URL"},
+	}
+	for _, tt := range tests {
+		err := typed.AddRef("10.0.0.0/8", tt.ref)
+		if (err == nil) != tt.typedOK || err != nil && !strings.Contains(err.Error(), tt.errContain) {
+			t.Errorf("%+v: unexpected typed result %v", tt.ref, err)
+		}
+		if err := plain.AddRef("10.0.0.0/8", tt.ref); (err == nil) != tt.untypedOK {
+			t.Errorf("%+v: unexpected untyped result %v", tt.ref, err)
+		}
+	}
+}
+
+func TestInsertValidatesRefs(t *testing.T) {
+	trie := NewIPTrie(refTypes())
+	var md map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"refs": [{"type": "ticket", "id": "OPS-3"}]}`), &md); err != nil {
+		t.Fatal(err)
+	}
+	if err := trie.Insert("10.0.0.0/8", md); err != nil {
+		t.Errorf("Expected decoded refs to be accepted, got %v", err)
+	}
+	if _, refs, _ := trie.FindRefs("10.1.1.1"); len(refs) != 1 || refs[0].URL != "https://jira.example.com/browse/OPS-3" {
+		t.Errorf("Unexpected refs %v", refs)
+	}
+
+	for _, bad := range []interface{}{
+		"OPS-3",
+		[]interface{}{"OPS-3"},
+		[]interface{}{map[string]interface{}{"type": "ticket", "id": "nope"}},
+		[]Ref{{Type: "wiki", ID: "x"}},
+	} {
+		if err := trie.Insert("10.1.0.0/16", map[string]interface{}{RefsKey: bad}); err == nil {
+			t.Errorf("Expected Insert to reject refs %v", bad)
+		}
+	}
+
+	// Without WithRefTypes the key is ordinary metadata
+	if err := NewIPTrie().Insert("10.0.0.0/8", map[string]interface{}{RefsKey: "anything"}); err != nil {
+		t.Errorf("Expected an untyped trie to accept any refs value, got %v", err)
+	}
+}
+
+func TestRefsSurviveSnapshots(t *testing.T) {
+	trie := NewIPTrie(refTypes())
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"owner": "netops"})
+	if err := trie.AddRef("10.0.0.0/8", Ref{Type: "ticket", ID: "OPS-9", Title: "audit"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewIPTrie(refTypes())
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, refs, _ := restored.FindRefs("10.0.0.1"); len(refs) != 1 || refs[0].Title != "audit" {
+		t.Errorf("Expected the ref to survive gob, got %v", refs)
+	}
+
+	data, err = trie.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, refs, _ := restored.FindRefs("10.0.0.1"); len(refs) != 1 || refs[0].ID != "OPS-9" {
+		t.Errorf("Expected the ref to survive JSON, got %v", refs)
+	}
+}
+
+func TestExportCSVRefs(t *testing.T) {
+	trie := NewIPTrie(refTypes())
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"owner": "netops"})
+	_ = trie.Insert("10.1.0.0/16", map[string]interface{}{"owner": "dev"})
+	_ = trie.AddRef("10.0.0.0/8", Ref{Type: "ticket", ID: "OPS-1"})
+	_ = trie.AddRef("10.0.0.0/8", Ref{Type: "dashboard", URL: "https://grafana.example.com/d/abc"})
+
+	var buf bytes.Buffer
+	if err := trie.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "cidr,owner,refs\n" +
+		"10.0.0.0/8,netops,ticket:OPS-1 https://jira.example.com/browse/OPS-1; dashboard https://grafana.example.com/d/abc\n" +
+		"10.1.0.0/16,dev,\n"
+	if buf.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
@@ -0,0 +1,96 @@
+package trie
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// SearchHit is one metadata value matching a Search pattern
+type SearchHit struct {
+	// Field is the path to the value, e.g. "description", "tags[1]" or
+	// "contact.email" for nested maps
+	Field string
+	// Value is the value as text
+	Value string
+	// Spans holds the [start, end) byte offsets of each match in Value,
+	// for highlighting
+	Spans [][2]int
+}
+
+// SearchResult is a stored prefix with the metadata values that matched
+type SearchResult struct {
+	Match
+	Hits []SearchHit
+}
+
+// Search returns every stored prefix with at least one metadata value,
+// at any nesting depth, matching the regular expression pattern. Results
+// are in Walk order and hits within a result are sorted by field. Use
+// regexp.QuoteMeta for a plain substring search, or a (?i) prefix to
+// ignore case.
+func (t *IPTrie) Search(pattern string) ([]SearchResult, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %v", err)
+	}
+
+	var results []SearchResult
+	t.walkNodes(func(n *Node) bool {
+		var hits []SearchHit
+		searchValue(re, "", n.metadata, &hits)
+		if len(hits) > 0 {
+			sort.Slice(hits, func(i, j int) bool { return hits[i].Field < hits[j].Field })
+			results = append(results, SearchResult{Match: n.match(), Hits: hits})
+		}
+		return true
+	})
+	return results, nil
+}
+
+// searchValue matches re against v and, for maps and slices, everything
+// nested inside it
+func searchValue(re *regexp.Regexp, field string, v interface{}, hits *[]SearchHit) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			searchValue(re, joinField(field, k), child, hits)
+		}
+	case map[string]string:
+		for k, child := range v {
+			searchValue(re, joinField(field, k), child, hits)
+		}
+	case []interface{}:
+		for i, child := range v {
+			searchValue(re, field+"["+strconv.Itoa(i)+"]", child, hits)
+		}
+	case []string:
+		for i, child := range v {
+			searchValue(re, field+"["+strconv.Itoa(i)+"]", child, hits)
+		}
+	case nil:
+	default:
+		text, ok := v.(string)
+		if !ok {
+			text = fmt.Sprint(v)
+		}
+		locs := re.FindAllStringIndex(text, -1)
+		if len(locs) == 0 {
+			return
+		}
+		spans := make([][2]int, len(locs))
+		for i, loc := range locs {
+			spans[i] = [2]int{loc[0], loc[1]}
+		}
+		*hits = append(*hits, SearchHit{Field: field, Value: text, Spans: spans})
+	}
+}
+
+// joinField appends a map key to a field path
+func joinField(field, key string) string {
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
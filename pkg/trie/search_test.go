@@ -0,0 +1,77 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"description": "corp range, see NET-1234 and NET-1250"})
+	trie.Insert("10.1.0.0/16", map[string]interface{}{
+		"tags":    []string{"prod", "net-1234"},
+		"contact": map[string]interface{}{"email": "noc@example.net"},
+		"vlan":    1234,
+	})
+	trie.Insert("192.0.2.0/24", map[string]interface{}{"description": "lab"})
+	trie.Insert("2001:db8::1/128", map[string]interface{}{"ticket": "NET-1234"})
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+	}{
+		{name: "ticket", pattern: `NET-1234`, want: []string{"10.0.0.0/8", "2001:db8::1/128"}},
+		{name: "ignore case", pattern: `(?i)net-1234`, want: []string{"10.0.0.0/8", "10.1.0.0/16", "2001:db8::1/128"}},
+		{name: "numbers as text", pattern: `^1234$`, want: []string{"10.1.0.0/16"}},
+		{name: "nested map", pattern: `@example\.net`, want: []string{"10.1.0.0/16"}},
+		{name: "no match", pattern: `NET-9999`, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := trie.Search(tt.pattern)
+			if err != nil {
+				t.Fatalf("Search returned error: %v", err)
+			}
+			got := []string{}
+			for _, r := range results {
+				got = append(got, r.CIDR)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := trie.Search(`(`); err == nil {
+		t.Errorf("Expected error for an invalid pattern")
+	}
+}
+
+func TestSearchHits(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.1.0.0/16", map[string]interface{}{
+		"description": "NET-1 then NET-2",
+		"tags":        []interface{}{"x", "NET-3"},
+		"contact":     map[string]interface{}{"name": "NET ops"},
+	})
+
+	results, _ := trie.Search(`NET`)
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+
+	var fields []string
+	for _, h := range results[0].Hits {
+		fields = append(fields, h.Field)
+	}
+	if want := []string{"contact.name", "description", "tags[1]"}; fmt.Sprint(fields) != fmt.Sprint(want) {
+		t.Errorf("Expected fields %v, got %v", want, fields)
+	}
+
+	desc := results[0].Hits[1]
+	if want := [][2]int{{0, 3}, {11, 14}}; fmt.Sprint(desc.Spans) != fmt.Sprint(want) {
+		t.Errorf("Expected spans %v, got %v", want, desc.Spans)
+	}
+}
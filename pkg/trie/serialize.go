@@ -0,0 +1,158 @@
+package trie
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+)
+
+func init() {
+	// Metadata is stored as interface{} values, so gob must know the
+	// composite types callers commonly put in it.
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+	gob.Register([]string{})
+	gob.Register([]int{})
+	gob.Register(map[string]string{})
+}
+
+// snapshotVersion is bumped whenever the serialized layout changes
+const snapshotVersion = 1
+
+// snapshot is the serialized form of a trie
+type snapshot struct {
+	Version int             `json:"version"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+// snapshotEntry is one stored prefix in a snapshot
+type snapshotEntry struct {
+	CIDR     string                 `json:"cidr"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// State is the lifecycle state, omitted for active prefixes
+	State string `json:"state,omitempty"`
+	// Pair is the dual-stack counterpart, recorded on the IPv4 side only
+	Pair string `json:"pair,omitempty"`
+}
+
+// snapshot captures every stored prefix in sorted order
+func (t *IPTrie) snapshot() snapshot {
+	s := snapshot{Version: snapshotVersion, Entries: []snapshotEntry{}}
+	t.walkNodes(func(n *Node) bool {
+		s.Entries = append(s.Entries, t.entryFor(n))
+		return true
+	})
+	return s
+}
+
+// entryFor returns the snapshot entry for a stored prefix
+func (t *IPTrie) entryFor(n *Node) snapshotEntry {
+	e := snapshotEntry{CIDR: n.cidr, Metadata: n.metadata}
+	if n.state != StateActive {
+		e.State = n.state.String()
+	}
+	if other, ok := t.pairs[n.cidr]; ok && isFamily(n.cidr, net.IPv4len) {
+		e.Pair = other
+	}
+	return e
+}
+
+// restore replaces the contents of the trie with the snapshot entries
+func (t *IPTrie) restore(s snapshot) error {
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+
+	fresh := NewIPTrie()
+	fresh.trackHits = t.trackHits
+	for _, e := range s.Entries {
+		state := StateActive
+		if e.State != "" {
+			var err error
+			if state, err = ParseState(e.State); err != nil {
+				return err
+			}
+		}
+		if err := fresh.InsertWithState(e.CIDR, state, e.Metadata); err != nil {
+			return err
+		}
+	}
+	for _, e := range s.Entries {
+		if e.Pair == "" {
+			continue
+		}
+		if err := fresh.Pair(e.CIDR, e.Pair); err != nil {
+			return fmt.Errorf("invalid pair %s: %v", e.CIDR, err)
+		}
+	}
+
+	t.root4 = fresh.root4
+	t.root6 = fresh.root6
+	t.hosts = fresh.hosts
+	t.hostOrder = nil
+	t.len4 = fresh.len4
+	t.len6 = fresh.len6
+	t.pairs = fresh.pairs
+	t.dropCompletions()
+	return nil
+}
+
+// MarshalJSON encodes every stored prefix and its metadata in sorted order
+func (t *IPTrie) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.snapshot())
+}
+
+// UnmarshalJSON replaces the contents of the trie with a previously
+// marshaled one. Metadata values come back as their JSON equivalents, so
+// numbers decode as float64 and lists as []interface{}.
+func (t *IPTrie) UnmarshalJSON(data []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid trie JSON: %v", err)
+	}
+	return t.restore(s)
+}
+
+// ExportJSONL writes every stored prefix in sorted order as JSON Lines, one
+// object per prefix in the same form as the entries of MarshalJSON, so the
+// output can be streamed into analytics tools or diffed line by line
+func (t *IPTrie) ExportJSONL(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	var err error
+	t.walkNodes(func(n *Node) bool {
+		if err = enc.Encode(t.entryFor(n)); err != nil {
+			err = fmt.Errorf("encode %s: %v", n.cidr, err)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// MarshalBinary encodes the trie with gob, which also lets tries be embedded
+// in gob encoded values and RPC messages. Metadata values of custom types
+// must be registered with gob.Register by the caller.
+func (t *IPTrie) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(t.snapshot()); err != nil {
+		return nil, fmt.Errorf("encode trie: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the contents of the trie with one produced by
+// MarshalBinary
+func (t *IPTrie) UnmarshalBinary(data []byte) error {
+	var s snapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&s); err != nil {
+		return fmt.Errorf("invalid trie encoding: %v", err)
+	}
+	return t.restore(s)
+}
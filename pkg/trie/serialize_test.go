@@ -0,0 +1,173 @@
+package trie
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	original := NewIPTrie()
+	entries := map[string]map[string]interface{}{
+		"10.0.0.0/8":          {"scope": "wide"},
+		"10.1.0.0/16":         {"scope": "narrow", "vlan": 12},
+		"192.168.1.1/32":      {"scope": "host"},
+		"2001:dead:beef::/48": {"scope": "v6"},
+	}
+	for cidr, md := range entries {
+		if err := original.Insert(cidr, md); err != nil {
+			t.Fatalf("Failed to insert CIDR: %v", err)
+		}
+	}
+
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+
+	var restored IPTrie
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("Unmarshal returned error: %v", err)
+	}
+
+	for cidr, md := range entries {
+		got, ok := restored.FindExact(cidr)
+		if !ok {
+			t.Errorf("Expected %s to survive the round trip", cidr)
+			continue
+		}
+		if got["scope"] != md["scope"] {
+			t.Errorf("Expected scope %v for %s, got %v", md["scope"], cidr, got["scope"])
+		}
+	}
+
+	if got, _ := restored.FindExact("10.1.0.0/16"); got["vlan"] != float64(12) {
+		t.Errorf("Expected numeric metadata to decode as float64, got %T", got["vlan"])
+	}
+
+	if restored.Len() != len(entries) || restored.LenV6() != 1 {
+		t.Errorf("Expected restored counters to match, got Len=%d LenV6=%d", restored.Len(), restored.LenV6())
+	}
+
+	cidr, _, err := restored.Find("10.1.2.3")
+	if err != nil || cidr != "10.1.0.0/16" {
+		t.Errorf("Expected lookup on restored trie to match 10.1.0.0/16, got %q (%v)", cidr, err)
+	}
+}
+
+func TestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "malformed", data: `{"version":`},
+		{name: "unknown version", data: `{"version":99,"entries":[]}`},
+		{name: "bad CIDR", data: `{"version":1,"entries":[{"cidr":"10.0.0.0/99"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trie := NewIPTrie()
+			_ = trie.Insert("10.0.0.0/8", nil)
+			if err := json.Unmarshal([]byte(tt.data), trie); err == nil {
+				t.Fatalf("Expected error")
+			}
+			if _, ok := trie.FindExact("10.0.0.0/8"); !ok {
+				t.Errorf("Expected failed unmarshal to leave the trie untouched")
+			}
+		})
+	}
+}
+
+func TestExportJSONL(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("2001:db8::/32", map[string]interface{}{"owner": "lab"})
+	trie.InsertWithState("10.1.0.0/16", StatePlanned, nil)
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"owner": "netops"})
+	trie.Pair("10.0.0.0/8", "2001:db8::/32")
+
+	var buf bytes.Buffer
+	if err := trie.ExportJSONL(&buf); err != nil {
+		t.Fatalf("ExportJSONL returned error: %v", err)
+	}
+	want := `{"cidr":"10.0.0.0/8","metadata":{"owner":"netops"},"pair":"2001:db8::/32"}
+{"cidr":"10.1.0.0/16","state":"planned"}
+{"cidr":"2001:db8::/32","metadata":{"owner":"lab"}}
+`
+	if buf.String() != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, buf.String())
+	}
+
+	if err := NewIPTrie().ExportJSONL(&buf); err != nil {
+		t.Errorf("ExportJSONL of an empty trie returned error: %v", err)
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	original := NewIPTrie()
+	_ = original.Insert("10.0.0.0/8", map[string]interface{}{
+		"region": "us-east",
+		"vlan":   12,
+		"tags":   []string{"edge", "public"},
+	})
+	_ = original.Insert("2001:db8::/32", map[string]interface{}{
+		"region": "eu-west",
+		"owner":  map[string]interface{}{"team": "neteng"},
+	})
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary returned error: %v", err)
+	}
+
+	restored := NewIPTrie()
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary returned error: %v", err)
+	}
+
+	md, ok := restored.FindExact("10.0.0.0/8")
+	if !ok {
+		t.Fatalf("Expected 10.0.0.0/8 to survive the round trip")
+	}
+	if md["vlan"] != 12 {
+		t.Errorf("Expected gob to keep the int type, got %T", md["vlan"])
+	}
+	if tags, ok := md["tags"].([]string); !ok || len(tags) != 2 {
+		t.Errorf("Expected []string tags, got %T", md["tags"])
+	}
+
+	md, _ = restored.FindExact("2001:db8::/32")
+	if owner, ok := md["owner"].(map[string]interface{}); !ok || owner["team"] != "neteng" {
+		t.Errorf("Expected nested map metadata, got %v", md["owner"])
+	}
+
+	if err := restored.UnmarshalBinary([]byte("garbage")); err == nil {
+		t.Errorf("Expected error for invalid encoding")
+	}
+}
+
+func TestGobEmbedding(t *testing.T) {
+	type envelope struct {
+		Name string
+		Trie *IPTrie
+	}
+
+	trie := NewIPTrie()
+	_ = trie.Insert("192.168.1.0/24", map[string]interface{}{"site": "lab"})
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(envelope{Name: "lab", Trie: trie}); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+
+	var got envelope
+	if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+
+	cidr, md, err := got.Trie.Find("192.168.1.50")
+	if err != nil || cidr != "192.168.1.0/24" || md["site"] != "lab" {
+		t.Errorf("Expected embedded trie to answer lookups, got %q %v %v", cidr, md, err)
+	}
+}
@@ -0,0 +1,169 @@
+package trie
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// spaceNode is one bit of a per-family address space tree used by the set
+// operations. full marks the whole range under the node as present.
+type spaceNode struct {
+	children [2]*spaceNode
+	full     bool
+}
+
+// addressSpace is the union of a trie's prefixes, split by family
+type addressSpace struct {
+	v4 *spaceNode
+	v6 *spaceNode
+}
+
+// storedPrefix parses a CIDR into the masked netip form of the prefix the
+// trie stores for it, unmapping it with unmapCIDR as Insert does
+func storedPrefix(cidr string) (netip.Prefix, bool) {
+	p, err := netip.ParsePrefix(unmapCIDR(cidr))
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return p.Masked(), true
+}
+
+// space builds the address space covered by the trie's prefixes
+func (t *IPTrie) space() addressSpace {
+	s := newAddressSpace()
+	t.Walk(func(prefix string, md map[string]interface{}) bool {
+		if p, ok := storedPrefix(prefix); ok {
+			s.add(p)
+		}
+		return true
+	})
+	return s
+}
+
+// newAddressSpace returns an empty address space
+func newAddressSpace() addressSpace {
+	return addressSpace{v4: &spaceNode{}, v6: &spaceNode{}}
+}
+
+// add marks every address in p as present
+func (s addressSpace) add(p netip.Prefix) {
+	node := s.root(p.Addr())
+	b := p.Addr().AsSlice()
+	for i := 0; i < p.Bits() && !node.full; i++ {
+		bit := (b[i/8] >> uint(7-i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &spaceNode{}
+		}
+		node = node.children[bit]
+	}
+	node.full = true
+}
+
+// root returns the tree for the address family of addr
+func (s addressSpace) root(addr netip.Addr) *spaceNode {
+	if addr.Is4() {
+		return s.v4
+	}
+	return s.v6
+}
+
+// combine walks two space trees in lockstep, emitting the largest prefixes
+// for which keep(inA, inB) holds throughout
+func combine(a, b *spaceNode, aFull, bFull bool, addr []byte, depth int, keep func(inA, inB bool) bool, emit func([]byte, int)) {
+	aFull = aFull || (a != nil && a.full)
+	bFull = bFull || (b != nil && b.full)
+
+	// Below this point neither side changes, so the whole range is uniform
+	aUniform := aFull || a == nil
+	bUniform := bFull || b == nil
+	if aUniform && bUniform {
+		if keep(aFull, bFull) {
+			emit(addr, depth)
+		}
+		return
+	}
+
+	for bit := byte(0); bit <= 1; bit++ {
+		var ac, bc *spaceNode
+		if a != nil && !aFull {
+			ac = a.children[bit]
+		}
+		if b != nil && !bFull {
+			bc = b.children[bit]
+		}
+		if bit == 1 {
+			addr[depth/8] |= 1 << uint(7-depth%8)
+		}
+		combine(ac, bc, aFull, bFull, addr, depth+1, keep, emit)
+		if bit == 1 {
+			addr[depth/8] &^= 1 << uint(7-depth%8)
+		}
+	}
+}
+
+// setOperation applies keep to the address spaces of t and other
+func (t *IPTrie) setOperation(other *IPTrie, keep func(inA, inB bool) bool) []netip.Prefix {
+	a, b := t.space(), other.space()
+	var out []netip.Prefix
+
+	v4 := make([]byte, 4)
+	combine(a.v4, b.v4, false, false, v4, 0, keep, func(addr []byte, bits int) {
+		out = append(out, netip.PrefixFrom(netip.AddrFrom4([4]byte(addr)), bits))
+	})
+	v6 := make([]byte, 16)
+	combine(a.v6, b.v6, false, false, v6, 0, keep, func(addr []byte, bits int) {
+		out = append(out, netip.PrefixFrom(netip.AddrFrom16([16]byte(addr)), bits))
+	})
+	return Aggregate(out)
+}
+
+// Intersect returns the minimal set of prefixes covering the address space
+// present in both t and other, regardless of how either divides it up
+func (t *IPTrie) Intersect(other *IPTrie) []netip.Prefix {
+	return t.setOperation(other, func(inA, inB bool) bool { return inA && inB })
+}
+
+// Subtract returns the minimal set of prefixes covering the address space
+// present in t but not in other
+func (t *IPTrie) Subtract(other *IPTrie) []netip.Prefix {
+	return t.setOperation(other, func(inA, inB bool) bool { return inA && !inB })
+}
+
+// Aggregate returns the minimal sorted list of prefixes covering the same
+// addresses as prefixes: duplicates and covered prefixes are dropped and
+// adjacent siblings are joined into their parent
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	ps := make([]netip.Prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		if p.IsValid() {
+			ps = append(ps, p.Masked())
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		if c := ps[i].Addr().Compare(ps[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return ps[i].Bits() < ps[j].Bits()
+	})
+
+	var out []netip.Prefix
+	for _, p := range ps {
+		if n := len(out); n > 0 && out[n-1].Contains(p.Addr()) && out[n-1].Bits() <= p.Bits() {
+			continue
+		}
+		out = append(out, p)
+		// Join with the preceding sibling for as long as possible
+		for n := len(out); n >= 2; n = len(out) {
+			prev, cur := out[n-2], out[n-1]
+			if prev.Bits() != cur.Bits() || prev.Bits() == 0 {
+				break
+			}
+			parent, _ := prev.Addr().Prefix(prev.Bits() - 1)
+			if parent.Addr() != prev.Addr() || !parent.Contains(cur.Addr()) {
+				break
+			}
+			out = append(out[:n-2], parent)
+		}
+	}
+	return out
+}
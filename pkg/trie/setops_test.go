@@ -0,0 +1,84 @@
+package trie
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func prefixStrings(ps []netip.Prefix) []string {
+	out := []string{}
+	for _, p := range ps {
+		out = append(out, p.String())
+	}
+	return out
+}
+
+func buildTrie(t *testing.T, cidrs ...string) *IPTrie {
+	t.Helper()
+	trie := NewIPTrie()
+	for _, cidr := range cidrs {
+		if err := trie.Insert(cidr, nil); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", cidr, err)
+		}
+	}
+	return trie
+}
+
+func TestIntersect(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{name: "nested", a: []string{"10.0.0.0/8"}, b: []string{"10.1.0.0/16"}, want: []string{"10.1.0.0/16"}},
+		{name: "disjoint", a: []string{"10.0.0.0/8"}, b: []string{"192.168.0.0/16"}, want: []string{}},
+		{name: "differently divided", a: []string{"10.0.0.0/24"}, b: []string{"10.0.0.0/25", "10.0.0.128/26"}, want: []string{"10.0.0.0/25", "10.0.0.128/26"}},
+		{name: "siblings aggregate", a: []string{"10.0.0.0/25", "10.0.0.128/25"}, b: []string{"10.0.0.0/16"}, want: []string{"10.0.0.0/24"}},
+		{name: "host route", a: []string{"10.0.0.5/32"}, b: []string{"10.0.0.0/24"}, want: []string{"10.0.0.5/32"}},
+		{name: "families kept apart", a: []string{"0.0.0.0/0", "2001:db8::/32"}, b: []string{"10.0.0.0/8", "2001:db8:1::/48"}, want: []string{"10.0.0.0/8", "2001:db8:1::/48"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prefixStrings(buildTrie(t, tt.a...).Intersect(buildTrie(t, tt.b...)))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{name: "hole punched", a: []string{"10.0.0.0/24"}, b: []string{"10.0.0.0/26"}, want: []string{"10.0.0.64/26", "10.0.0.128/25"}},
+		{name: "fully covered", a: []string{"10.1.0.0/16"}, b: []string{"10.0.0.0/8"}, want: []string{}},
+		{name: "disjoint", a: []string{"10.0.0.0/8"}, b: []string{"192.168.0.0/16"}, want: []string{"10.0.0.0/8"}},
+		{name: "host route removed", a: []string{"10.0.0.0/30"}, b: []string{"10.0.0.1/32"}, want: []string{"10.0.0.0/32", "10.0.0.2/31"}},
+		{name: "IPv6", a: []string{"2001:db8::/32"}, b: []string{"2001:db8:8000::/33"}, want: []string{"2001:db8::/33"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := prefixStrings(buildTrie(t, tt.a...).Subtract(buildTrie(t, tt.b...)))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	var in []netip.Prefix
+	for _, s := range []string{"10.0.0.128/25", "10.0.0.0/25", "10.0.1.0/24", "10.0.1.7/32", "10.0.0.0/25", "192.168.0.0/16"} {
+		in = append(in, netip.MustParsePrefix(s))
+	}
+	want := []string{"10.0.0.0/23", "192.168.0.0/16"}
+	if got := prefixStrings(Aggregate(in)); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,119 @@
+package trie
+
+import "sort"
+
+// sourcesKey is the SysKey entry holding per-source metadata
+const sourcesKey = "sources"
+
+// SourceEntry is the metadata one source supplied for a CIDR
+type SourceEntry struct {
+	Source   string
+	Metadata map[string]interface{}
+}
+
+// InsertSource stores metadata for a CIDR on behalf of one source, keeping
+// what other sources supplied for the same CIDR. The stored metadata is the
+// merge of all sources in source name order, so Find keeps working, with
+// every source's own copy kept under SysKey for Sources and FindSources. A
+// plain Insert of the CIDR replaces all sources.
+func (t *IPTrie) InsertSource(cidr, source string, metadata map[string]interface{}) error {
+	metadata, err := t.prepareMetadata(metadata)
+	if err != nil {
+		return err
+	}
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+
+	var existing map[string]interface{}
+	if node, err := t.lookupExact(cidr); err == nil {
+		existing = node.metadata
+	}
+	sources := sourceMap(existing)
+	sources[source] = metadata
+	return t.insert(cidr, mergeSources(existing, sources), false)
+}
+
+// DeleteSource removes one source's metadata for a CIDR and reports
+// whether it was there. The CIDR itself is removed with its last source.
+func (t *IPTrie) DeleteSource(cidr, source string) (bool, error) {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return false, err
+	}
+	sources := sourceMap(node.metadata)
+	if _, ok := sources[source]; !ok {
+		return false, nil
+	}
+	delete(sources, source)
+
+	if len(sources) == 0 {
+		_, _, err := t.Remove(cidr)
+		return err == nil, err
+	}
+	return true, t.insert(cidr, mergeSources(node.metadata, sources), false)
+}
+
+// FindSources is Find returning the metadata of every source that supplied
+// the matching CIDR. The list is empty for CIDRs stored with plain Insert.
+func (t *IPTrie) FindSources(ip string) (string, []SourceEntry, error) {
+	cidr, md, err := t.Find(ip)
+	if err != nil {
+		return "", nil, err
+	}
+	return cidr, Sources(md), nil
+}
+
+// Sources returns the per-source metadata kept in stored metadata, in
+// source name order
+func Sources(md map[string]interface{}) []SourceEntry {
+	sources := sourceMap(md)
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]SourceEntry, len(names))
+	for i, name := range names {
+		sourceMd, _ := sources[name].(map[string]interface{})
+		out[i] = SourceEntry{Source: name, Metadata: sourceMd}
+	}
+	return out
+}
+
+// sourceMap returns a copy of the per-source metadata in md
+func sourceMap(md map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	stored, _ := SysMetadata(md)[sourcesKey].(map[string]interface{})
+	for name, v := range stored {
+		out[name] = v
+	}
+	return out
+}
+
+// mergeSources builds the stored metadata for a set of sources, keeping
+// any other SysKey values of existing
+func mergeSources(existing, sources map[string]interface{}) map[string]interface{} {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	merged := make(map[string]interface{})
+	for _, name := range names {
+		sourceMd, _ := sources[name].(map[string]interface{})
+		for k, v := range sourceMd {
+			merged[k] = v
+		}
+	}
+
+	sys := make(map[string]interface{})
+	for k, v := range SysMetadata(existing) {
+		sys[k] = v
+	}
+	sys[sourcesKey] = sources
+	merged[SysKey] = sys
+	return merged
+}
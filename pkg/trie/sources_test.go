@@ -0,0 +1,87 @@
+package trie
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestInsertSource(t *testing.T) {
+	trie := NewIPTrie(WithReservedKeys())
+	if err := trie.InsertSource("10.0.0.0/8", "ipam", map[string]interface{}{"owner": "net", "site": "ams1"}); err != nil {
+		t.Fatalf("InsertSource returned error: %v", err)
+	}
+	if err := trie.InsertSource("10.0.0.0/8", "bgp", map[string]interface{}{"asn": 64500, "site": "ams2"}); err != nil {
+		t.Fatalf("InsertSource returned error: %v", err)
+	}
+	if trie.Len() != 1 {
+		t.Errorf("Expected one stored CIDR, got %d", trie.Len())
+	}
+
+	// Find sees the merge, later source names winning on conflicts
+	_, md, _ := trie.Find("10.1.1.1")
+	if md["owner"] != "net" || md["asn"] != 64500 || md["site"] != "ams1" {
+		t.Errorf("Unexpected merged metadata %v", md)
+	}
+
+	cidr, sources, err := trie.FindSources("10.1.1.1")
+	if err != nil || cidr != "10.0.0.0/8" {
+		t.Fatalf("FindSources returned %q (%v)", cidr, err)
+	}
+	var got []string
+	for _, s := range sources {
+		got = append(got, fmt.Sprintf("%s=%v", s.Source, s.Metadata))
+	}
+	want := []string{"bgp=map[asn:64500 site:ams2]", "ipam=map[owner:net site:ams1]"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if err := trie.InsertSource("10.0.0.0/8", "evil", map[string]interface{}{SysKey: "x"}); err == nil {
+		t.Errorf("Expected reserved key to be rejected")
+	}
+}
+
+func TestDeleteSource(t *testing.T) {
+	trie := NewIPTrie()
+	trie.InsertSource("192.0.2.0/24", "a", map[string]interface{}{"x": 1})
+	trie.InsertSource("192.0.2.0/24", "b", map[string]interface{}{"y": 2})
+
+	if ok, err := trie.DeleteSource("192.0.2.0/24", "c"); ok || err != nil {
+		t.Errorf("Expected unknown source to be reported absent, got %v %v", ok, err)
+	}
+	if ok, err := trie.DeleteSource("192.0.2.0/24", "a"); !ok || err != nil {
+		t.Fatalf("DeleteSource returned %v %v", ok, err)
+	}
+	md, _ := trie.FindExact("192.0.2.0/24")
+	if _, ok := md["x"]; ok || md["y"] != 2 || len(Sources(md)) != 1 {
+		t.Errorf("Expected only source b to remain, got %v", md)
+	}
+
+	if ok, _ := trie.DeleteSource("192.0.2.0/24", "b"); !ok {
+		t.Errorf("Expected last source to be deleted")
+	}
+	if trie.Len() != 0 {
+		t.Errorf("Expected the CIDR to go with its last source")
+	}
+	if _, err := trie.DeleteSource("192.0.2.0/24", "b"); err == nil {
+		t.Errorf("Expected error for a CIDR that is not stored")
+	}
+}
+
+func TestSourcesSurviveJSON(t *testing.T) {
+	trie := NewIPTrie()
+	trie.InsertSource("2001:db8::/32", "rir", map[string]interface{}{"country": "NL"})
+	trie.InsertSource("2001:db8::/32", "geo", map[string]interface{}{"city": "Amsterdam"})
+
+	data, _ := json.Marshal(trie)
+	restored := NewIPTrie()
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("UnmarshalJSON returned error: %v", err)
+	}
+	restored.InsertSource("2001:db8::/32", "ops", nil)
+	_, sources, _ := restored.FindSources("2001:db8::1")
+	if len(sources) != 3 || sources[0].Source != "geo" || sources[0].Metadata["city"] != "Amsterdam" {
+		t.Errorf("Expected sources to survive a JSON round trip, got %v", sources)
+	}
+}
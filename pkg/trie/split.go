@@ -0,0 +1,76 @@
+package trie
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// maxSplitBits caps Split at 2^20 subnets, since splitting a short prefix
+// into long ones grows exponentially (a /32 into /64s is 2^32)
+const maxSplitBits = 20
+
+// Split breaks cidr into the equal subnets of length newLen that cover it,
+// in address order. newLen must be at least the prefix's own length and at
+// most the address length.
+func Split(cidr string, newLen int) ([]netip.Prefix, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR: %v", err)
+	}
+	p = p.Masked()
+
+	if newLen < p.Bits() || newLen > p.Addr().BitLen() {
+		return nil, fmt.Errorf("cannot split /%d into /%d", p.Bits(), newLen)
+	}
+	if newLen-p.Bits() > maxSplitBits {
+		return nil, fmt.Errorf("splitting /%d into /%d yields more than %d subnets", p.Bits(), newLen, 1<<maxSplitBits)
+	}
+
+	count := 1 << (newLen - p.Bits())
+	subnets := make([]netip.Prefix, 0, count)
+	next := netip.PrefixFrom(p.Addr(), newLen)
+	for i := 0; i < count; i++ {
+		subnets = append(subnets, next)
+		next = netip.PrefixFrom(addBit(next.Addr(), newLen-1), newLen)
+	}
+	return subnets, nil
+}
+
+// addBit adds one at the given bit position, counted from the most
+// significant bit, carrying towards the front. Overflow wraps silently.
+func addBit(addr netip.Addr, bit int) netip.Addr {
+	if addr.Is4() {
+		a := addr.As4()
+		incrementAt(a[:], bit)
+		return netip.AddrFrom4(a)
+	}
+	a := addr.As16()
+	incrementAt(a[:], bit)
+	return netip.AddrFrom16(a)
+}
+
+// incrementAt adds one at bit position bit of the big-endian number b
+func incrementAt(b []byte, bit int) {
+	i := bit / 8
+	carry := uint16(1) << uint(7-bit%8)
+	for ; i >= 0 && carry > 0; i-- {
+		sum := uint16(b[i]) + carry
+		b[i] = byte(sum)
+		carry = sum >> 8
+	}
+}
+
+// InsertSplit splits cidr into subnets of length newLen and inserts each of
+// them with the given metadata, which is shared by all subnets
+func (t *IPTrie) InsertSplit(cidr string, newLen int, metadata map[string]interface{}) error {
+	subnets, err := Split(cidr, newLen)
+	if err != nil {
+		return err
+	}
+	for _, p := range subnets {
+		if err := t.Insert(p.String(), metadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidr    string
+		newLen  int
+		want    []string
+		count   int
+		wantErr bool
+	}{
+		{name: "IPv4 /24 into /26", cidr: "192.168.1.0/24", newLen: 26, want: []string{"192.168.1.0/26", "192.168.1.64/26", "192.168.1.128/26", "192.168.1.192/26"}},
+		{name: "same length", cidr: "10.0.0.0/8", newLen: 8, want: []string{"10.0.0.0/8"}},
+		{name: "host bits masked", cidr: "10.0.0.77/30", newLen: 31, want: []string{"10.0.0.76/31", "10.0.0.78/31"}},
+		{name: "carry across bytes", cidr: "10.0.255.0/23", newLen: 24, want: []string{"10.0.254.0/24", "10.0.255.0/24"}},
+		{name: "IPv6 /32 into /48", cidr: "2001:db8::/32", newLen: 48, count: 65536},
+		{name: "IPv6 hosts", cidr: "2001:db8::/127", newLen: 128, want: []string{"2001:db8::/128", "2001:db8::1/128"}},
+		{name: "shorter than parent", cidr: "10.0.0.0/8", newLen: 7, wantErr: true},
+		{name: "longer than address", cidr: "10.0.0.0/8", newLen: 33, wantErr: true},
+		{name: "too many subnets", cidr: "2001:db8::/32", newLen: 64, wantErr: true},
+		{name: "invalid CIDR", cidr: "bogus", newLen: 24, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subnets, err := Split(tt.cidr, tt.newLen)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error=%v, got %v", tt.wantErr, err)
+			}
+			if tt.count > 0 {
+				if len(subnets) != tt.count {
+					t.Fatalf("Expected %d subnets, got %d", tt.count, len(subnets))
+				}
+				if last := subnets[len(subnets)-1].String(); last != "2001:db8:ffff::/48" {
+					t.Errorf("Expected last subnet 2001:db8:ffff::/48, got %s", last)
+				}
+				return
+			}
+			var got []string
+			for _, p := range subnets {
+				got = append(got, p.String())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestInsertSplit(t *testing.T) {
+	trie := NewIPTrie()
+	md := map[string]interface{}{"owner": "tenant-a"}
+	if err := trie.InsertSplit("10.0.0.0/22", 24, md); err != nil {
+		t.Fatalf("InsertSplit returned error: %v", err)
+	}
+	if trie.Len() != 4 {
+		t.Errorf("Expected 4 subnets, got %d", trie.Len())
+	}
+
+	cidr, got, err := trie.Find("10.0.3.9")
+	if err != nil || cidr != "10.0.3.0/24" || got["owner"] != "tenant-a" {
+		t.Errorf("Expected 10.0.3.0/24 with parent metadata, got %q %v (%v)", cidr, got, err)
+	}
+
+	if err := trie.InsertSplit("10.0.0.0/22", 21, md); err == nil {
+		t.Errorf("Expected error for invalid split")
+	}
+}
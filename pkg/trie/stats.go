@@ -0,0 +1,86 @@
+package trie
+
+import (
+	"net/netip"
+	"unsafe"
+)
+
+// Approximate heap cost of the maps each node allocates, measured on 64-bit
+// platforms. They only need to be close enough for capacity planning.
+const (
+	emptyMapBytes    = 56
+	childMapBytes    = 200
+	hostEntryBytes   = 48
+	observedNodeCost = int(unsafe.Sizeof(hllSketch{}) + unsafe.Sizeof(activityRing{}))
+)
+
+// Stats describes the shape and approximate memory footprint of a trie
+type Stats struct {
+	Prefixes   int
+	PrefixesV4 int
+	PrefixesV6 int
+	// HostRoutes counts /32 and /128 entries kept in the host route map
+	HostRoutes int
+	// Nodes counts trie nodes, including both family roots
+	Nodes int
+	// DepthHistogram holds the number of trie nodes at each depth, where
+	// depth is the prefix length the node represents
+	DepthHistogram []int
+	// EstimatedBytes approximates the memory held by the trie structure.
+	// Metadata maps are supplied by callers and are not included.
+	EstimatedBytes int
+}
+
+// Stats walks the trie and reports node counts, the depth histogram and an
+// estimate of memory used
+func (t *IPTrie) Stats() Stats {
+	st := Stats{
+		Prefixes:   t.Len(),
+		PrefixesV4: t.LenV4(),
+		PrefixesV6: t.LenV6(),
+		HostRoutes: len(t.hosts),
+	}
+
+	for _, root := range []*Node{t.root4, t.root6} {
+		countNodes(root, 0, &st)
+	}
+
+	nodeBytes := int(unsafe.Sizeof(Node{}))
+	st.EstimatedBytes += len(t.hosts) * (nodeBytes + hostEntryBytes)
+	st.EstimatedBytes += len(t.hostOrder) * int(unsafe.Sizeof(netip.Addr{}))
+	for _, host := range t.hosts {
+		if host.distinct != nil {
+			st.EstimatedBytes += observedNodeCost
+		}
+	}
+	return st
+}
+
+// countNodes adds node and its descendants to st
+func countNodes(node *Node, depth int, st *Stats) {
+	st.Nodes++
+	for len(st.DepthHistogram) <= depth {
+		st.DepthHistogram = append(st.DepthHistogram, 0)
+	}
+	st.DepthHistogram[depth]++
+
+	st.EstimatedBytes += int(unsafe.Sizeof(Node{}))
+	if node.isEnd {
+		// Stored prefixes carry caller metadata instead of the empty map
+		// intermediate nodes get
+		if node.distinct != nil {
+			st.EstimatedBytes += observedNodeCost
+		}
+	} else {
+		st.EstimatedBytes += emptyMapBytes
+	}
+	if len(node.children) == 0 {
+		st.EstimatedBytes += emptyMapBytes
+	} else {
+		st.EstimatedBytes += childMapBytes
+	}
+
+	for _, child := range node.children {
+		countNodes(child, depth+1, st)
+	}
+}
@@ -0,0 +1,58 @@
+package trie
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("10.0.0.0/8", nil)
+	_ = trie.Insert("10.128.0.0/9", nil)
+	_ = trie.Insert("10.0.0.1/32", nil)
+	_ = trie.Insert("::/1", nil)
+
+	st := trie.Stats()
+	if st.Prefixes != 4 || st.PrefixesV4 != 3 || st.PrefixesV6 != 1 || st.HostRoutes != 1 {
+		t.Errorf("Unexpected counts: %+v", st)
+	}
+
+	// Two roots, eight nodes down to 10.0.0.0/8, one more for /9 and one
+	// for ::/1
+	if st.Nodes != 12 {
+		t.Errorf("Expected 12 nodes, got %d", st.Nodes)
+	}
+	if len(st.DepthHistogram) != 10 || st.DepthHistogram[0] != 2 || st.DepthHistogram[1] != 2 || st.DepthHistogram[9] != 1 {
+		t.Errorf("Unexpected depth histogram: %v", st.DepthHistogram)
+	}
+	if st.EstimatedBytes <= 0 {
+		t.Errorf("Expected a positive memory estimate")
+	}
+}
+
+func TestStatsEstimate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping memory measurement in short mode")
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	trie := NewIPTrie()
+	for i := 0; i < 20000; i++ {
+		_ = trie.Insert(fmt.Sprintf("10.%d.%d.0/24", i/256, i%256), nil)
+		_ = trie.Insert(fmt.Sprintf("2001:db8:%x::1/128", i), nil)
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	measured := int(after.HeapAlloc - before.HeapAlloc)
+	estimated := trie.Stats().EstimatedBytes
+	runtime.KeepAlive(trie)
+
+	if estimated < measured/2 || estimated > measured*2 {
+		t.Errorf("Expected estimate within 2x of measured %d bytes, got %d", measured, estimated)
+	}
+}
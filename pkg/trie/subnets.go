@@ -0,0 +1,117 @@
+package trie
+
+import (
+	"fmt"
+	"iter"
+	"net/netip"
+)
+
+// SubnetState says how much of a subnet is taken by stored prefixes
+type SubnetState uint8
+
+const (
+	// SubnetFree subnets overlap no stored prefix
+	SubnetFree SubnetState = iota
+	// SubnetPartial subnets contain stored prefixes that leave some of
+	// their addresses free
+	SubnetPartial
+	// SubnetUsed subnets are covered entirely by stored prefixes
+	SubnetUsed
+)
+
+var subnetStateNames = map[SubnetState]string{
+	SubnetFree:    "free",
+	SubnetPartial: "partial",
+	SubnetUsed:    "used",
+}
+
+// String returns the lowercase name of the state
+func (s SubnetState) String() string {
+	if name, ok := subnetStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("SubnetState(%d)", s)
+}
+
+// Subnet is one subnet yielded by EnumerateSubnets
+type Subnet struct {
+	Prefix netip.Prefix
+	State  SubnetState
+	// Stored is set when the subnet is itself a stored prefix
+	Stored bool
+}
+
+// EnumerateSubnets returns an iterator over every subnet of length newLen
+// within cidr, in address order, with how much of each is taken by stored
+// prefixes. As with FreeSpace, only prefixes more specific than cidr count.
+// Subnets are produced as the loop asks for them, so there is no limit on
+// their number. The iterator sees the trie as it was when called.
+func (t *IPTrie) EnumerateSubnets(cidr string, newLen int) (iter.Seq[Subnet], error) {
+	pool, used, err := t.allocated(cidr, newLen)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[netip.Prefix]bool)
+	_ = t.walkCovered(pool.String(), func(n *Node) bool {
+		if p, ok := storedPrefix(n.cidr); ok && p.Bits() == newLen && newLen > pool.Bits() {
+			stored[p] = true
+		}
+		return true
+	})
+
+	node := used.root(pool.Addr())
+	start := pool.Addr().AsSlice()
+	for i := 0; i < pool.Bits() && node != nil; i++ {
+		node = node.children[(start[i/8]>>uint(7-i%8))&1]
+	}
+
+	return func(yield func(Subnet) bool) {
+		addr := append([]byte(nil), start...)
+		eachSubnet(node, false, addr, pool.Bits(), newLen, func(b []byte, state SubnetState) bool {
+			a, _ := netip.AddrFromSlice(b)
+			p := netip.PrefixFrom(a, newLen)
+			return yield(Subnet{Prefix: p, State: state, Stored: stored[p]})
+		})
+	}, nil
+}
+
+// eachSubnet calls fn for every block of length newLen under node, setting
+// the bits of addr from depth on, until fn returns false. full marks node
+// as lying inside a stored prefix.
+func eachSubnet(node *spaceNode, full bool, addr []byte, depth, newLen int, fn func([]byte, SubnetState) bool) bool {
+	full = full || node != nil && node.full
+	if depth == newLen {
+		switch {
+		case full || covered(node):
+			return fn(addr, SubnetUsed)
+		case node == nil:
+			return fn(addr, SubnetFree)
+		default:
+			return fn(addr, SubnetPartial)
+		}
+	}
+
+	mask := byte(1) << uint(7-depth%8)
+	for bit := 0; bit < 2; bit++ {
+		var child *spaceNode
+		if node != nil && !full {
+			child = node.children[bit]
+		}
+		if bit == 0 {
+			addr[depth/8] &^= mask
+		} else {
+			addr[depth/8] |= mask
+		}
+		if !eachSubnet(child, full, addr, depth+1, newLen, fn) {
+			return false
+		}
+	}
+	addr[depth/8] &^= mask
+	return true
+}
+
+// covered reports whether the space under node is entirely present, which
+// it can be without node.full when sibling prefixes fill it between them
+func covered(node *spaceNode) bool {
+	return node != nil && (node.full || covered(node.children[0]) && covered(node.children[1]))
+}
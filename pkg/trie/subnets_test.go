@@ -0,0 +1,105 @@
+package trie
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEnumerateSubnets(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/16", "10.0.0.0/24", "10.0.0.0/29", "10.0.0.8/30", "10.0.0.16/28", "10.0.0.40/32", "10.0.0.64/30", "10.0.0.68/30"} {
+		if err := trie.Insert(cidr, nil); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", cidr, err)
+		}
+	}
+
+	subnets, err := trie.EnumerateSubnets("10.0.0.0/24", 29)
+	if err != nil {
+		t.Fatalf("EnumerateSubnets returned error: %v", err)
+	}
+	var got []string
+	free := 0
+	for s := range subnets {
+		if s.State == SubnetFree {
+			free++
+		}
+		if len(got) < 10 {
+			got = append(got, fmt.Sprintf("%s %s %v", s.Prefix, s.State, s.Stored))
+		}
+	}
+	want := []string{
+		"10.0.0.0/29 used true",
+		"10.0.0.8/29 partial false",
+		"10.0.0.16/29 used false",
+		"10.0.0.24/29 used false",
+		"10.0.0.32/29 free false",
+		"10.0.0.40/29 partial false",
+		"10.0.0.48/29 free false",
+		"10.0.0.56/29 free false",
+		"10.0.0.64/29 used false",
+		"10.0.0.72/29 free false",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if free != 26 {
+		t.Errorf("Expected 26 free /29s, got %d", free)
+	}
+
+	// Only prefixes more specific than the covering one count
+	subnets, _ = trie.EnumerateSubnets("10.0.0.0/16", 24)
+	for s := range subnets {
+		if s.Prefix.String() == "10.0.0.0/24" && (s.State != SubnetUsed || !s.Stored) {
+			t.Errorf("Unexpected %+v", s)
+		}
+		if s.Prefix.String() == "10.0.1.0/24" && s.State != SubnetFree {
+			t.Errorf("Unexpected %+v", s)
+		}
+	}
+}
+
+func TestEnumerateSubnetsLazy(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("2001:db8::1/128", nil)
+
+	subnets, err := trie.EnumerateSubnets("2001:db8::/64", 128)
+	if err != nil {
+		t.Fatalf("EnumerateSubnets returned error: %v", err)
+	}
+	var got []string
+	for s := range subnets {
+		got = append(got, s.Prefix.String()+" "+s.State.String())
+		if len(got) == 3 {
+			break
+		}
+	}
+	want := "[2001:db8::/128 free 2001:db8::1/128 used 2001:db8::2/128 free]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Expected %s, got %v", want, got)
+	}
+
+	// The iterator can be ranged over again from the start
+	for s := range subnets {
+		if s.Prefix.String() != "2001:db8::/128" {
+			t.Errorf("Expected a second loop to restart, got %v", s.Prefix)
+		}
+		break
+	}
+}
+
+func TestEnumerateSubnetsErrors(t *testing.T) {
+	trie := NewIPTrie()
+	for _, tt := range []struct {
+		cidr   string
+		newLen int
+	}{
+		{"bogus", 24},
+		{"10.0.0.0/24", 16},
+		{"10.0.0.0/24", 33},
+		{"2001:db8::/32", 129},
+	} {
+		if _, err := trie.EnumerateSubnets(tt.cidr, tt.newLen); err == nil {
+			t.Errorf("EnumerateSubnets(%s, %d): expected error", tt.cidr, tt.newLen)
+		}
+	}
+}
@@ -1,31 +1,141 @@
 package trie
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Node represents a node in the IP trie
 type Node struct {
 	children map[byte]*Node
 	isEnd    bool
+	state    PrefixState
 	metadata map[string]interface{}
 	cidr     string
+
+	// distinct estimates distinct observed addresses, allocated on first use
+	distinct *hllSketch
+	// activity counts observations per minute, allocated on first use
+	activity *activityRing
+	// alerted records when each threshold alert last fired for this prefix
+	alerted map[alertKey]int64
+	// hits counts the lookups this prefix answered, see hits.go
+	hits *hitCounter
+}
+
+// ErrHostBitsSet is returned in CIDRStrict mode for CIDRs whose address has
+// bits set beyond the prefix length
+var ErrHostBitsSet = errors.New("CIDR has host bits set")
+
+// ErrDuplicate is returned by RefuseDuplicate when a CIDR is already stored
+var ErrDuplicate = errors.New("CIDR already exists")
+
+// errInvalidIP and errNoMatch are preallocated so failed lookups do not
+// allocate either
+var (
+	errInvalidIP = errors.New("invalid IP address")
+	errNoMatch   = errors.New("no matching CIDR found")
+)
+
+// MergeFunc combines the metadata already stored for a CIDR with incoming
+// metadata. Returning an error leaves the stored metadata unchanged.
+type MergeFunc func(existing, incoming map[string]interface{}) (map[string]interface{}, error)
+
+// Match is a stored prefix together with its metadata. PrefixLen and Host
+// let callers rank matches by specificity without parsing CIDR.
+type Match struct {
+	CIDR      string
+	PrefixLen int
+	// Host is set for /32 and /128 host routes
+	Host     bool
+	Metadata map[string]interface{}
+}
+
+// match returns the Match for a stored node
+func (n *Node) match() Match {
+	bits := prefixLen(n.cidr)
+	return Match{CIDR: n.cidr, PrefixLen: bits, Host: isHostRoute(n.cidr, bits), Metadata: n.metadata}
+}
+
+// isHostRoute reports whether a stored CIDR of length bits covers a single
+// address
+func isHostRoute(cidr string, bits int) bool {
+	if strings.IndexByte(cidr, ':') >= 0 {
+		return bits == 128
+	}
+	return bits == 32
+}
+
+// prefixLen returns the length after the slash of a stored CIDR
+func prefixLen(cidr string) int {
+	i := strings.LastIndexByte(cidr, '/')
+	n, _ := strconv.Atoi(cidr[i+1:])
+	return n
 }
 
 // IPTrie represents the main trie structure
 type IPTrie struct {
-	root *Node
+	// IPv4 and IPv6 prefixes live in separate tries so that short prefixes
+	// of one family never cover addresses of the other
+	root4 *Node
+	root6 *Node
+	// hosts holds /32 and /128 routes outside the trie, see hosts.go
+	hosts     map[netip.Addr]*Node
+	hostOrder []netip.Addr
+
+	len4 int
+	len6 int
+
+	// pairs links dual-stack counterparts by stored CIDR, see pair.go
+	pairs map[string]string
+	// completions caches the autocomplete index, see complete.go
+	completions completionCache
+
+	cidrMode     CIDRMode
+	matchOrder   MatchOrder
+	normalizeKey func(string) string
+	protectSys   bool
+	// refTypes, when set, are the reference types accepted, see refs.go
+	refTypes map[string]RefType
+	// trackHits gives stored prefixes hit counters, see hits.go
+	trackHits bool
+
+	now        func() time.Time
+	alertHooks []alertHook
+	stateHooks []func(StateChange)
+	// changeHooks are notified of inserts, updates and deletes
+	changeHooks []func(Event)
+
+	// selfCheckFn receives CheckStructure every selfCheckEvery removals,
+	// see check.go
+	selfCheckEvery int
+	selfCheckFn    func(Health)
+	removals       int
 }
 
-// NewIPTrie creates a new IP trie
-func NewIPTrie() *IPTrie {
-	return &IPTrie{
-		root: &Node{
+// NewIPTrie creates a new IP trie configured by opts
+func NewIPTrie(opts ...Option) *IPTrie {
+	t := &IPTrie{
+		root4: &Node{
+			children: make(map[byte]*Node),
+			metadata: make(map[string]interface{}),
+		},
+		root6: &Node{
 			children: make(map[byte]*Node),
 			metadata: make(map[string]interface{}),
 		},
+		hosts: make(map[netip.Addr]*Node),
+	}
+	for _, opt := range opts {
+		opt(t)
 	}
+	return t
 }
 
 // ipToBytes converts an IP address to a slice of bytes for trie traversal
@@ -36,17 +146,74 @@ func ipToBytes(ip net.IP) []byte {
 	return ip.To16()
 }
 
+// unmapCIDR rewrites an IPv4-mapped IPv6 CIDR of /96 or longer, such as
+// ::ffff:10.1.2.3/104, as the IPv4 CIDR it covers, 10.1.2.3/8, because
+// mapped addresses are stored and looked up as IPv4. Host bits are kept.
+// Other CIDRs are returned unchanged.
+func unmapCIDR(cidr string) string {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil || !p.Addr().Is4In6() || p.Bits() < 96 {
+		return cidr
+	}
+	return netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96).String()
+}
+
+// parseCIDR parses a CIDR after unmapCIDR, returning the unmapped CIDR
+// together with its address and network. Every method taking a CIDR parses
+// it here, so a mapped prefix never walks the IPv4 trie with an IPv6
+// length.
+func parseCIDR(cidr string) (string, net.IP, *net.IPNet, error) {
+	cidr = unmapCIDR(cidr)
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("invalid CIDR: %v", err)
+	}
+	return cidr, ip, ipnet, nil
+}
+
+// rootFor returns the root of the trie for the address family of ipBytes
+func (t *IPTrie) rootFor(ipBytes []byte) *Node {
+	if len(ipBytes) == net.IPv4len {
+		return t.root4
+	}
+	return t.root6
+}
+
 // Insert adds an IP CIDR with metadata to the trie
 func (t *IPTrie) Insert(cidr string, metadata map[string]interface{}) error {
-	_, ipnet, err := net.ParseCIDR(cidr)
+	return t.insert(cidr, metadata, true)
+}
+
+// insert stores a CIDR, passing metadata through the key options first
+// unless the caller already did
+func (t *IPTrie) insert(cidr string, metadata map[string]interface{}, prepare bool) error {
+	cidr, ip, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	cidr, err = t.canonicalCIDR(cidr, ip, ipnet)
 	if err != nil {
-		return fmt.Errorf("invalid CIDR: %v", err)
+		return err
+	}
+
+	if prepare {
+		metadata, err = t.prepareMetadata(metadata)
+		if err != nil {
+			return err
+		}
 	}
 
-	node := t.root
 	ipBytes := ipToBytes(ipnet.IP)
+	node := t.rootFor(ipBytes)
 	ones, total := ipnet.Mask.Size()
 
+	// Host routes live in the hash map instead of the trie
+	if ones == total {
+		t.store(t.insertHost(ipBytes), ipBytes, cidr, metadata)
+		return nil
+	}
+
 	// Convert IP to bits and insert into trie
 	for i := 0; i < ones; i++ {
 		byteIndex := i / 8
@@ -62,97 +229,511 @@ func (t *IPTrie) Insert(cidr string, metadata map[string]interface{}) error {
 		node = node.children[bit]
 	}
 
-	// For exact matches (/32 IPv4 or /128 IPv6), we need to handle remaining bits
-	if ones == total {
-		for i := ones; i < total; i++ {
-			byteIndex := i / 8
-			bitIndex := 7 - (i % 8)
-			bit := (ipBytes[byteIndex] >> uint(bitIndex)) & 1
-
-			if node.children[bit] == nil {
-				node.children[bit] = &Node{
-					children: make(map[byte]*Node),
-					metadata: make(map[string]interface{}),
-				}
-			}
-			node = node.children[bit]
-		}
-	}
+	t.store(node, ipBytes, cidr, metadata)
+
+	return nil
+}
 
+// store marks node as holding cidr, keeping the entry counters in step
+func (t *IPTrie) store(node *Node, ipBytes []byte, cidr string, metadata map[string]interface{}) {
+	existed, previous := node.isEnd, node.metadata
+	if !existed {
+		t.adjustLen(ipBytes, 1)
+		t.dropCompletions()
+	} else if node.cidr != cidr {
+		t.renamePair(node.cidr, cidr)
+		t.dropCompletions()
+	}
 	node.isEnd = true
 	node.cidr = cidr
 	node.metadata = metadata
+	if t.trackHits && node.hits == nil {
+		node.hits = &hitCounter{}
+	}
+
+	if existed {
+		t.emit(EventUpdate, cidr, metadata, previous)
+	} else {
+		t.emit(EventInsert, cidr, metadata, nil)
+	}
+}
+
+// adjustLen updates the entry counter for the address family of ipBytes
+func (t *IPTrie) adjustLen(ipBytes []byte, delta int) {
+	if len(ipBytes) == net.IPv4len {
+		t.len4 += delta
+	} else {
+		t.len6 += delta
+	}
+}
+
+// Len returns the number of stored prefixes
+func (t *IPTrie) Len() int {
+	return t.len4 + t.len6
+}
+
+// LenV4 returns the number of stored IPv4 prefixes
+func (t *IPTrie) LenV4() int {
+	return t.len4
+}
+
+// LenV6 returns the number of stored IPv6 prefixes
+func (t *IPTrie) LenV6() int {
+	return t.len6
+}
+
+// canonicalCIDR applies the trie's CIDRMode to a parsed CIDR and returns
+// the string to store for it
+func (t *IPTrie) canonicalCIDR(cidr string, ip net.IP, ipnet *net.IPNet) (string, error) {
+	switch t.cidrMode {
+	case CIDRLenient:
+		return ipnet.String(), nil
+	case CIDRStrict:
+		if !ip.Equal(ipnet.IP) {
+			return "", fmt.Errorf("%w: %s, expected %s", ErrHostBitsSet, cidr, ipnet)
+		}
+	}
+	return cidr, nil
+}
+
+// Upsert inserts a CIDR, or if it is already stored replaces its metadata
+// with the result of merge. A nil merge overwrites like Insert does.
+func (t *IPTrie) Upsert(cidr string, metadata map[string]interface{}, merge MergeFunc) error {
+	cidr, ip, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	if _, err := t.canonicalCIDR(cidr, ip, ipnet); err != nil {
+		return err
+	}
+
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return t.Insert(cidr, metadata)
+	}
 
+	metadata, err = t.prepareMetadata(metadata)
+	if err != nil {
+		return err
+	}
+
+	if merge != nil {
+		if metadata, err = merge(node.metadata, metadata); err != nil {
+			return err
+		}
+	}
+
+	previous := node.metadata
+	node.metadata = metadata
+	t.emit(EventUpdate, node.cidr, metadata, previous)
 	return nil
 }
 
-// Find searches for an IP address and returns matching CIDR and metadata
+// MergeMaps is a MergeFunc that returns a new map holding the existing keys
+// overlaid with the incoming ones
+func MergeMaps(existing, incoming map[string]interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, len(existing)+len(incoming))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range incoming {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// RefuseDuplicate is a MergeFunc that rejects updates to stored CIDRs
+func RefuseDuplicate(existing, incoming map[string]interface{}) (map[string]interface{}, error) {
+	return nil, ErrDuplicate
+}
+
+// Find searches for an IP address and returns matching CIDR and metadata.
+// A default route (0.0.0.0/0 or ::/0) is stored on the family's root node and
+// therefore matches every address of that family that has no more specific
+// prefix.
 func (t *IPTrie) Find(ip string) (string, map[string]interface{}, error) {
-	parsedIP := net.ParseIP(ip)
-	if parsedIP == nil {
-		return "", nil, fmt.Errorf("invalid IP address")
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || addr.Zone() != "" {
+		return "", nil, errInvalidIP
 	}
+	cidr, md, ok := t.FindAddr(addr)
+	if !ok {
+		return "", nil, errNoMatch
+	}
+	return cidr, md, nil
+}
 
-	node := t.root
-	var lastMatch *Node
-	ipBytes := ipToBytes(parsedIP)
-	totalBits := len(ipBytes) * 8
+// FindAddr is Find for a parsed address, reporting whether any prefix
+// matched. It makes no heap allocations, so hot paths that already hold a
+// netip.Addr pay only for the walk. IPv4-mapped IPv6 addresses match IPv4
+// prefixes and any zone is ignored.
+func (t *IPTrie) FindAddr(addr netip.Addr) (string, map[string]interface{}, bool) {
+	n := t.lookupAddr(addr)
+	if n == nil {
+		return "", nil, false
+	}
+	if n.hits != nil {
+		n.hits.record(t.clock())
+	}
+	return n.cidr, n.metadata, true
+}
 
-	for i := 0; i < totalBits; i++ {
+// FindMatch is FindAddr returning the whole Match of the most specific
+// prefix, whatever the trie's match order, for callers that report its
+// length or whether it is a host route
+func (t *IPTrie) FindMatch(addr netip.Addr) (Match, bool) {
+	n := t.lookupAddr(addr)
+	if n == nil {
+		return Match{}, false
+	}
+	if n.hits != nil {
+		n.hits.record(t.clock())
+	}
+	return n.match(), true
+}
+
+// FindExact returns the metadata stored for exactly the given CIDR. Unlike
+// Find it does not fall back to a covering prefix, so the second return value
+// is false unless this CIDR itself was inserted.
+func (t *IPTrie) FindExact(cidr string) (map[string]interface{}, bool) {
+	node, err := t.lookupExact(cidr)
+	if err != nil {
+		return nil, false
+	}
+	return node.metadata, true
+}
+
+// lookupExact returns the node holding exactly the given CIDR
+func (t *IPTrie) lookupExact(cidr string) (*Node, error) {
+	_, _, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	ipBytes := ipToBytes(ipnet.IP)
+	node := t.rootFor(ipBytes)
+	ones, total := ipnet.Mask.Size()
+
+	if ones == total {
+		if node = t.hostRoute(ipBytes); node == nil {
+			return nil, fmt.Errorf("CIDR not found")
+		}
+		return node, nil
+	}
+
+	for i := 0; i < ones; i++ {
+		byteIndex := i / 8
+		bitIndex := 7 - (i % 8)
+		bit := (ipBytes[byteIndex] >> uint(bitIndex)) & 1
+
+		node = node.children[bit]
+		if node == nil {
+			return nil, fmt.Errorf("CIDR not found")
+		}
+	}
+
+	if !node.isEnd {
+		return nil, fmt.Errorf("CIDR not found")
+	}
+
+	return node, nil
+}
+
+// Covers reports whether the given prefix is fully contained in some
+// inserted prefix, including an inserted prefix equal to it
+func (t *IPTrie) Covers(cidr string) (bool, error) {
+	_, _, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return false, err
+	}
+
+	ipBytes := ipToBytes(ipnet.IP)
+	node := t.rootFor(ipBytes)
+	ones, total := ipnet.Mask.Size()
+
+	if ones == total && t.hostRoute(ipBytes) != nil {
+		return true, nil
+	}
+
+	for i := 0; i < ones; i++ {
 		if node.isEnd {
-			lastMatch = node
+			return true, nil
 		}
 
 		byteIndex := i / 8
 		bitIndex := 7 - (i % 8)
 		bit := (ipBytes[byteIndex] >> uint(bitIndex)) & 1
 
+		node = node.children[bit]
+		if node == nil {
+			return false, nil
+		}
+	}
+
+	return node.isEnd, nil
+}
+
+// CoveredBy returns every stored prefix contained within the given prefix,
+// including the prefix itself if it was inserted, in sorted order
+func (t *IPTrie) CoveredBy(cidr string) ([]Match, error) {
+	var matches []Match
+	err := t.walkCovered(cidr, func(n *Node) bool {
+		matches = append(matches, n.match())
+		return true
+	})
+	return matches, err
+}
+
+// walkCovered calls fn for every stored prefix within cidr in sorted order
+func (t *IPTrie) walkCovered(cidr string, fn func(*Node) bool) error {
+	_, _, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	ipBytes := ipToBytes(ipnet.IP)
+	node := t.rootFor(ipBytes)
+	ones, _ := ipnet.Mask.Size()
+
+	for i := 0; i < ones; i++ {
+		byteIndex := i / 8
+		bitIndex := 7 - (i % 8)
+		bit := (ipBytes[byteIndex] >> uint(bitIndex)) & 1
+
 		node = node.children[bit]
 		if node == nil {
 			break
 		}
 	}
 
-	// Check the last node in case it's an exact match
-	if node != nil && node.isEnd {
-		lastMatch = node
+	prefix := netip.PrefixFrom(hostKey(ipBytes), ones)
+	t.walkWithin(node, prefix, fn)
+	return nil
+}
+
+// Supernets returns every stored prefix that contains the given prefix,
+// including the prefix itself if stored, ordered from most to least
+// specific. An invalid CIDR yields no matches.
+func (t *IPTrie) Supernets(cidr string) []Match {
+	_, _, ipnet, err := parseCIDR(cidr)
+	if err != nil {
+		return nil
 	}
 
-	if lastMatch == nil {
-		return "", nil, fmt.Errorf("no matching CIDR found")
+	var matches []Match
+	ipBytes := ipToBytes(ipnet.IP)
+	node := t.rootFor(ipBytes)
+	ones, total := ipnet.Mask.Size()
+
+	if ones == total {
+		if host := t.hostRoute(ipBytes); host != nil {
+			matches = append(matches, host.match())
+		}
 	}
 
-	return lastMatch.cidr, lastMatch.metadata, nil
+	var path []*Node
+	for i := 0; ; i++ {
+		if node.isEnd {
+			path = append(path, node)
+		}
+		if i == ones {
+			break
+		}
+
+		byteIndex := i / 8
+		bitIndex := 7 - (i % 8)
+		bit := (ipBytes[byteIndex] >> uint(bitIndex)) & 1
+
+		node = node.children[bit]
+		if node == nil {
+			break
+		}
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		matches = append(matches, path[i].match())
+	}
+	return matches
 }
 
-// FindAll returns all matching CIDRs and their metadata for an IP
-func (t *IPTrie) FindAll(ip string) ([]struct {
-	CIDR     string
-	Metadata map[string]interface{}
-}, error) {
+// Subtree returns a new trie holding only the stored prefixes within the
+// given prefix, with the same options as t. Metadata maps and lifecycle
+// states are kept; hit counters start from zero. An invalid CIDR yields an
+// empty trie.
+func (t *IPTrie) Subtree(cidr string) *IPTrie {
+	sub := NewIPTrie()
+	copyOptions(sub, t)
+	_ = t.walkCovered(cidr, func(n *Node) bool {
+		// Stored metadata already went through the key options
+		if sub.insert(n.cidr, n.metadata, false) == nil {
+			if node, err := sub.lookupExact(n.cidr); err == nil {
+				node.state = n.state
+			}
+		}
+		return true
+	})
+	return sub
+}
+
+// Clone returns an independent copy of the trie with the same options, so
+// a writer can rebuild or mutate one while readers query the other. The
+// structure is copied in O(n); metadata maps are shared, which is safe as
+// the trie only ever replaces them. Observation counters are not copied.
+// Hit counts are copied into counters of the clone's own, so lookups and
+// ResetHits on one trie leave the other unchanged.
+//
+// The clone keeps the hooks registered with OnChange, OnStateChange and
+// OnThreshold, so a modified copy swapped in through a TrieHolder keeps
+// feeding the logs, replicas and mirrors that follow the trie.
+func (t *IPTrie) Clone() *IPTrie {
+	c := &IPTrie{
+		root4: cloneNode(t.root4),
+		root6: cloneNode(t.root6),
+		hosts: make(map[netip.Addr]*Node, len(t.hosts)),
+		len4:  t.len4,
+		len6:  t.len6,
+	}
+	copyOptions(c, t)
+	c.alertHooks = slices.Clone(t.alertHooks)
+	c.stateHooks = slices.Clone(t.stateHooks)
+	c.changeHooks = slices.Clone(t.changeHooks)
+	for addr, host := range t.hosts {
+		c.hosts[addr] = cloneNode(host)
+	}
+	if len(t.pairs) > 0 {
+		c.pairs = make(map[string]string, len(t.pairs))
+		for k, v := range t.pairs {
+			c.pairs[k] = v
+		}
+	}
+	return c
+}
+
+// copyOptions gives dst the construction options of src. Hooks are not
+// options; they stay with the trie they were registered on.
+func copyOptions(dst, src *IPTrie) {
+	dst.cidrMode = src.cidrMode
+	dst.matchOrder = src.matchOrder
+	dst.normalizeKey = src.normalizeKey
+	dst.protectSys = src.protectSys
+	dst.refTypes = src.refTypes
+	dst.trackHits = src.trackHits
+	dst.now = src.now
+	dst.selfCheckEvery = src.selfCheckEvery
+	dst.selfCheckFn = src.selfCheckFn
+}
+
+// cloneNode copies node and its descendants, sharing metadata but not hit
+// counters
+func cloneNode(node *Node) *Node {
+	c := &Node{
+		children: make(map[byte]*Node, len(node.children)),
+		isEnd:    node.isEnd,
+		state:    node.state,
+		metadata: node.metadata,
+		cidr:     node.cidr,
+		hits:     node.hits.clone(),
+	}
+	for bit, child := range node.children {
+		c.children[bit] = cloneNode(child)
+	}
+	return c
+}
+
+// ConflictPolicy decides the metadata kept when Merge finds a CIDR stored
+// in both tries. Any MergeFunc can be converted to a ConflictPolicy.
+type ConflictPolicy MergeFunc
+
+// KeepExisting is a ConflictPolicy that keeps the receiver's metadata
+func KeepExisting(existing, incoming map[string]interface{}) (map[string]interface{}, error) {
+	return existing, nil
+}
+
+// Overwrite is a ConflictPolicy that takes the other trie's metadata
+func Overwrite(existing, incoming map[string]interface{}) (map[string]interface{}, error) {
+	return incoming, nil
+}
+
+// Merge adds every prefix stored in other to t, resolving CIDRs stored in
+// both with policy. If policy returns an error Merge stops and returns it;
+// prefixes merged up to that point remain.
+func (t *IPTrie) Merge(other *IPTrie, policy ConflictPolicy) error {
+	var err error
+	other.Walk(func(prefix string, md map[string]interface{}) bool {
+		err = t.Upsert(prefix, md, MergeFunc(policy))
+		return err == nil
+	})
+	return err
+}
+
+// Walk calls fn for every stored prefix in sorted order, IPv4 before IPv6
+// and shorter prefixes before the longer prefixes they contain. Returning
+// false from fn stops the walk.
+func (t *IPTrie) Walk(fn func(prefix string, md map[string]interface{}) bool) {
+	t.walkNodes(func(n *Node) bool {
+		return fn(n.cidr, n.metadata)
+	})
+}
+
+// DeleteFunc removes every stored prefix for which fn returns true and
+// returns how many were removed
+func (t *IPTrie) DeleteFunc(fn func(prefix string, md map[string]interface{}) bool) int {
+	var doomed []string
+	t.Walk(func(prefix string, md map[string]interface{}) bool {
+		if fn(prefix, md) {
+			doomed = append(doomed, prefix)
+		}
+		return true
+	})
+
+	removed := 0
+	for _, prefix := range doomed {
+		if _, ok, _ := t.Remove(prefix); ok {
+			removed++
+		}
+	}
+	return removed
+}
+
+// FindAll returns every stored prefix containing an IP, most specific
+// first unless the trie was created with WithMatchOrder(LeastSpecificFirst)
+func (t *IPTrie) FindAll(ip string) ([]Match, error) {
+	path, err := t.matchPath(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, len(path))
+	for _, n := range path {
+		matches = append(matches, n.match())
+	}
+
+	// The path runs least specific first
+	if t.matchOrder == MostSpecificFirst {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+
+	return matches, nil
+}
+
+// matchPath returns the stored prefixes containing an IP, least specific
+// first
+func (t *IPTrie) matchPath(ip string) ([]*Node, error) {
 	parsedIP := net.ParseIP(ip)
 	if parsedIP == nil {
 		return nil, fmt.Errorf("invalid IP address")
 	}
 
-	var matches []struct {
-		CIDR     string
-		Metadata map[string]interface{}
-	}
-
-	node := t.root
+	var path []*Node
 	ipBytes := ipToBytes(parsedIP)
+	node := t.rootFor(ipBytes)
 	totalBits := len(ipBytes) * 8
 
 	for i := 0; i < totalBits; i++ {
 		if node.isEnd {
-			matches = append(matches, struct {
-				CIDR     string
-				Metadata map[string]interface{}
-			}{
-				CIDR:     node.cidr,
-				Metadata: node.metadata,
-			})
+			path = append(path, node)
 		}
 
 		byteIndex := i / 8
@@ -167,42 +748,119 @@ func (t *IPTrie) FindAll(ip string) ([]struct {
 
 	// Check the last node in case it's an exact match
 	if node != nil && node.isEnd {
-		matches = append(matches, struct {
-			CIDR     string
-			Metadata map[string]interface{}
-		}{
-			CIDR:     node.cidr,
-			Metadata: node.metadata,
-		})
+		path = append(path, node)
 	}
 
-	return matches, nil
+	if host := t.hostRoute(ipBytes); host != nil {
+		path = append(path, host)
+	}
+
+	return path, nil
+}
+
+// FindWithin is Find considering only prefixes whose length is between
+// minLen and maxLen inclusive, e.g. 1 and 31 to ignore both the IPv4
+// default route and host routes
+func (t *IPTrie) FindWithin(ip string, minLen, maxLen int) (string, map[string]interface{}, error) {
+	if minLen < 0 || maxLen < minLen {
+		return "", nil, fmt.Errorf("invalid prefix length range /%d-/%d", minLen, maxLen)
+	}
+	path, err := t.matchPath(ip)
+	if err != nil {
+		return "", nil, err
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		if bits := prefixLen(path[i].cidr); bits >= minLen && bits <= maxLen {
+			return path[i].cidr, path[i].metadata, nil
+		}
+	}
+	return "", nil, errNoMatch
+}
+
+// FindValue returns the most specific prefix containing ip whose metadata
+// has key, so a value set on a /16 is inherited by addresses in a /24 that
+// does not override it
+func (t *IPTrie) FindValue(ip, key string) (string, map[string]interface{}, error) {
+	path, err := t.matchPath(ip)
+	if err != nil {
+		return "", nil, err
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		if _, ok := path[i].metadata[key]; ok {
+			return path[i].cidr, path[i].metadata, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no matching CIDR found")
+}
+
+// FindMerged returns the most specific prefix containing ip together with
+// the effective metadata of the address: the metadata of every containing
+// prefix merged from least to most specific, so keys on longer prefixes
+// override those on shorter ones. The returned map is a new map.
+func (t *IPTrie) FindMerged(ip string) (string, map[string]interface{}, error) {
+	path, err := t.matchPath(ip)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(path) == 0 {
+		return "", nil, fmt.Errorf("no matching CIDR found")
+	}
+
+	merged := make(map[string]interface{})
+	for _, n := range path {
+		for k, v := range n.metadata {
+			merged[k] = v
+		}
+	}
+	return path[len(path)-1].cidr, merged, nil
 }
 
 // Delete removes a CIDR and its metadata from the trie
 func (t *IPTrie) Delete(cidr string) error {
-	_, ipnet, err := net.ParseCIDR(cidr)
+	_, removed, err := t.Remove(cidr)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("CIDR not found")
+	}
+	return nil
+}
+
+// Remove deletes a CIDR and returns the metadata it held. The boolean is
+// false, without an error, when the CIDR was not stored.
+func (t *IPTrie) Remove(cidr string) (map[string]interface{}, bool, error) {
+	_, _, ipnet, err := parseCIDR(cidr)
 	if err != nil {
-		return fmt.Errorf("invalid CIDR: %v", err)
+		return nil, false, err
 	}
 
 	var nodes []*Node
-	node := t.root
 	ipBytes := ipToBytes(ipnet.IP)
+	node := t.rootFor(ipBytes)
 	ones, total := ipnet.Mask.Size()
-	totalBits := ones
+
 	if ones == total {
-		totalBits = len(ipBytes) * 8
+		host := t.removeHost(ipBytes)
+		if host == nil {
+			return nil, false, nil
+		}
+		t.adjustLen(ipBytes, -1)
+		t.unpair(host.cidr)
+		t.dropCompletions()
+		t.emit(EventDelete, host.cidr, host.metadata, nil)
+		t.removed()
+		return host.metadata, true, nil
 	}
 
 	// Collect nodes along the path
-	for i := 0; i < totalBits; i++ {
+	for i := 0; i < ones; i++ {
 		byteIndex := i / 8
 		bitIndex := 7 - (i % 8)
 		bit := (ipBytes[byteIndex] >> uint(bitIndex)) & 1
 
 		if node.children[bit] == nil {
-			return fmt.Errorf("CIDR not found")
+			return nil, false, nil
 		}
 		nodes = append(nodes, node)
 		node = node.children[bit]
@@ -210,12 +868,21 @@ func (t *IPTrie) Delete(cidr string) error {
 
 	// Remove the end marker and clean up empty nodes
 	if !node.isEnd {
-		return fmt.Errorf("CIDR not found")
+		return nil, false, nil
 	}
 
+	removed, removedCIDR := node.metadata, node.cidr
+	t.adjustLen(ipBytes, -1)
+	t.unpair(node.cidr)
+	t.dropCompletions()
 	node.isEnd = false
+	node.state = StateActive
 	node.metadata = make(map[string]interface{})
 	node.cidr = ""
+	node.distinct = nil
+	node.activity = nil
+	node.alerted = nil
+	node.hits = nil
 
 	// Clean up empty branches
 	for i := len(nodes) - 1; i >= 0; i-- {
@@ -230,5 +897,7 @@ func (t *IPTrie) Delete(cidr string) error {
 		}
 	}
 
-	return nil
+	t.emit(EventDelete, removedCIDR, removed, nil)
+	t.removed()
+	return removed, true, nil
 }
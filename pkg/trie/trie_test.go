@@ -1,10 +1,13 @@
 package trie
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"net/netip"
 	"testing"
+	"time"
 )
 
 func TestIPv4Insertion(t *testing.T) {
@@ -156,7 +159,983 @@ func TestOverlappingRanges(t *testing.T) {
 	}
 }
 
+func TestFindExact(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("192.168.0.0/16", map[string]interface{}{"scope": "wide"})
+	_ = trie.Insert("192.168.1.0/24", map[string]interface{}{"scope": "narrow"})
+	_ = trie.Insert("2001:db8::/32", map[string]interface{}{"scope": "v6"})
+
+	tests := []struct {
+		name  string
+		cidr  string
+		scope string
+		want  bool
+	}{
+		{name: "exact /16", cidr: "192.168.0.0/16", scope: "wide", want: true},
+		{name: "exact /24", cidr: "192.168.1.0/24", scope: "narrow", want: true},
+		{name: "host bits ignored", cidr: "192.168.1.77/24", scope: "narrow", want: true},
+		{name: "covered but not inserted", cidr: "192.168.2.0/24", want: false},
+		{name: "longer than stored", cidr: "192.168.1.0/25", want: false},
+		{name: "exact IPv6", cidr: "2001:db8::/32", scope: "v6", want: true},
+		{name: "invalid CIDR", cidr: "not-a-cidr", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, ok := trie.FindExact(tt.cidr)
+			if ok != tt.want {
+				t.Fatalf("Expected found=%v for %s, got %v", tt.want, tt.cidr, ok)
+			}
+			if ok && metadata["scope"] != tt.scope {
+				t.Errorf("Expected scope %q, got %v", tt.scope, metadata["scope"])
+			}
+		})
+	}
+}
+
+func TestCovers(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"scope": "wide"})
+	_ = trie.Insert("192.168.1.0/24", map[string]interface{}{"scope": "narrow"})
+
+	tests := []struct {
+		name string
+		cidr string
+		want bool
+	}{
+		{name: "inside /8", cidr: "10.20.0.0/16", want: true},
+		{name: "equal to stored", cidr: "192.168.1.0/24", want: true},
+		{name: "host route inside /24", cidr: "192.168.1.5/32", want: true},
+		{name: "supernet of stored", cidr: "192.168.0.0/16", want: false},
+		{name: "unrelated", cidr: "172.16.0.0/12", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := trie.Covers(tt.cidr)
+			if err != nil {
+				t.Fatalf("Covers(%s) returned error: %v", tt.cidr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected Covers(%s) = %v, got %v", tt.cidr, tt.want, got)
+			}
+		})
+	}
+
+	if _, err := trie.Covers("bogus"); err == nil {
+		t.Errorf("Expected error for invalid CIDR")
+	}
+}
+
+func TestCoveredBy(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16", "11.0.0.0/8"} {
+		if err := trie.Insert(cidr, map[string]interface{}{"cidr": cidr}); err != nil {
+			t.Fatalf("Failed to insert CIDR: %v", err)
+		}
+	}
+
+	matches, err := trie.CoveredBy("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("CoveredBy returned error: %v", err)
+	}
+
+	want := []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16"}
+	if len(matches) != len(want) {
+		t.Fatalf("Expected %d matches, got %d", len(want), len(matches))
+	}
+	for i, m := range matches {
+		if m.CIDR != want[i] {
+			t.Errorf("Match %d: expected %s, got %s", i, want[i], m.CIDR)
+		}
+		if m.Metadata["cidr"] != want[i] {
+			t.Errorf("Match %d: metadata does not belong to %s", i, want[i])
+		}
+	}
+
+	matches, err = trie.CoveredBy("172.16.0.0/12")
+	if err != nil {
+		t.Fatalf("CoveredBy returned error: %v", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("Expected no matches, got %d", len(matches))
+	}
+}
+
+func TestWalk(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.2.0.0/16", "10.0.0.0/8", "10.1.2.0/24", "10.1.0.0/16", "192.168.1.1/32"} {
+		if err := trie.Insert(cidr, map[string]interface{}{"cidr": cidr}); err != nil {
+			t.Fatalf("Failed to insert CIDR: %v", err)
+		}
+	}
+
+	var got []string
+	trie.Walk(func(prefix string, md map[string]interface{}) bool {
+		if md["cidr"] != prefix {
+			t.Errorf("Metadata for %s does not belong to it", prefix)
+		}
+		got = append(got, prefix)
+		return true
+	})
+
+	want := []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.2.0.0/16", "192.168.1.1/32"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected walk order %v, got %v", want, got)
+	}
+
+	count := 0
+	trie.Walk(func(prefix string, md map[string]interface{}) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Errorf("Expected walk to stop after 2 prefixes, visited %d", count)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	appendOwners := func(existing, incoming map[string]interface{}) (map[string]interface{}, error) {
+		owners, _ := existing["owners"].([]string)
+		return map[string]interface{}{"owners": append(owners, incoming["owners"].([]string)...)}, nil
+	}
+
+	tests := []struct {
+		name    string
+		merge   MergeFunc
+		wantErr error
+		want    map[string]interface{}
+	}{
+		{
+			name:  "nil merge overwrites",
+			merge: nil,
+			want:  map[string]interface{}{"owners": []string{"bob"}},
+		},
+		{
+			name:  "merge maps",
+			merge: MergeMaps,
+			want:  map[string]interface{}{"owners": []string{"bob"}, "region": "us-west"},
+		},
+		{
+			name:    "refuse duplicates",
+			merge:   RefuseDuplicate,
+			wantErr: ErrDuplicate,
+			want:    map[string]interface{}{"owners": []string{"alice"}, "region": "us-west"},
+		},
+		{
+			name:  "append to list",
+			merge: appendOwners,
+			want:  map[string]interface{}{"owners": []string{"alice", "bob"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trie := NewIPTrie()
+			if err := trie.Upsert("10.0.0.0/8", map[string]interface{}{"owners": []string{"alice"}, "region": "us-west"}, tt.merge); err != nil {
+				t.Fatalf("First Upsert returned error: %v", err)
+			}
+
+			err := trie.Upsert("10.0.0.0/8", map[string]interface{}{"owners": []string{"bob"}}, tt.merge)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+
+			got, _ := trie.FindExact("10.0.0.0/8")
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected metadata %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if err := NewIPTrie().Upsert("bogus", nil, nil); err == nil {
+		t.Errorf("Expected error for invalid CIDR")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"scope": "wide"})
+	_ = trie.Insert("10.1.0.0/16", map[string]interface{}{"scope": "narrow"})
+
+	md, removed, err := trie.Remove("10.1.0.0/16")
+	if err != nil || !removed {
+		t.Fatalf("Expected 10.1.0.0/16 to be removed, got removed=%v err=%v", removed, err)
+	}
+	if md["scope"] != "narrow" {
+		t.Errorf("Expected removed metadata to be returned, got %v", md)
+	}
+
+	cidr, _, err := trie.Find("10.1.2.3")
+	if err != nil || cidr != "10.0.0.0/8" {
+		t.Errorf("Expected lookup to fall back to 10.0.0.0/8, got %q (%v)", cidr, err)
+	}
+
+	if _, removed, err := trie.Remove("10.1.0.0/16"); removed || err != nil {
+		t.Errorf("Expected second removal to report nothing removed, got removed=%v err=%v", removed, err)
+	}
+	if _, removed, err := trie.Remove("172.16.0.0/12"); removed || err != nil {
+		t.Errorf("Expected unknown CIDR to report nothing removed, got removed=%v err=%v", removed, err)
+	}
+	if _, _, err := trie.Remove("bogus"); err == nil {
+		t.Errorf("Expected error for invalid CIDR")
+	}
+	if err := trie.Delete("10.1.0.0/16"); err == nil {
+		t.Errorf("Expected Delete of missing CIDR to keep returning an error")
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	trie := NewIPTrie()
+	entries := map[string]int{
+		"10.0.0.0/8":     2019,
+		"10.1.0.0/16":    2023,
+		"10.1.2.0/24":    2018,
+		"192.168.1.1/32": 2017,
+		"2001:db8::/32":  2024,
+	}
+	for cidr, year := range entries {
+		_ = trie.Insert(cidr, map[string]interface{}{"year": year})
+	}
+
+	removed := trie.DeleteFunc(func(prefix string, md map[string]interface{}) bool {
+		return md["year"].(int) < 2020
+	})
+	if removed != 3 {
+		t.Errorf("Expected 3 prefixes removed, got %d", removed)
+	}
+
+	var left []string
+	trie.Walk(func(prefix string, md map[string]interface{}) bool {
+		left = append(left, prefix)
+		return true
+	})
+	if fmt.Sprint(left) != fmt.Sprint([]string{"10.1.0.0/16", "2001:db8::/32"}) {
+		t.Errorf("Unexpected remaining prefixes: %v", left)
+	}
+
+	cidr, _, err := trie.Find("10.1.2.3")
+	if err != nil || cidr != "10.1.0.0/16" {
+		t.Errorf("Expected 10.1.2.3 to match 10.1.0.0/16 after pruning, got %q (%v)", cidr, err)
+	}
+}
+
+func TestSupernets(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32", "10.2.0.0/16"} {
+		_ = trie.Insert(cidr, map[string]interface{}{"cidr": cidr})
+	}
+
+	tests := []struct {
+		name string
+		cidr string
+		want []string
+	}{
+		{name: "between stored prefixes", cidr: "10.1.2.0/23", want: []string{"10.1.0.0/16", "10.0.0.0/8"}},
+		{name: "equal prefix included", cidr: "10.1.2.0/24", want: []string{"10.1.2.0/24", "10.1.0.0/16", "10.0.0.0/8"}},
+		{name: "host route", cidr: "10.1.2.3/32", want: []string{"10.1.2.3/32", "10.1.2.0/24", "10.1.0.0/16", "10.0.0.0/8"}},
+		{name: "uncovered", cidr: "172.16.0.0/12", want: nil},
+		{name: "invalid", cidr: "bogus", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range trie.Supernets(tt.cidr) {
+				if m.Metadata["cidr"] != m.CIDR {
+					t.Errorf("Metadata for %s does not belong to it", m.CIDR)
+				}
+				got = append(got, m.CIDR)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSubtree(t *testing.T) {
+	shared := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32", "10.2.0.0/16"} {
+		_ = shared.Insert(cidr, map[string]interface{}{"cidr": cidr})
+	}
+
+	tenant := shared.Subtree("10.1.0.0/16")
+
+	var got []string
+	tenant.Walk(func(prefix string, md map[string]interface{}) bool {
+		got = append(got, prefix)
+		return true
+	})
+	want := []string{"10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected subtree %v, got %v", want, got)
+	}
+
+	if _, _, err := tenant.Find("10.2.0.1"); err == nil {
+		t.Errorf("Expected prefixes outside the subtree to be absent")
+	}
+
+	_ = tenant.Insert("10.1.3.0/24", nil)
+	if _, ok := shared.FindExact("10.1.3.0/24"); ok {
+		t.Errorf("Expected inserts into the subtree not to affect the source trie")
+	}
+
+	if empty := shared.Subtree("bogus"); empty == nil {
+		t.Errorf("Expected an empty trie for an invalid CIDR, got nil")
+	}
+}
+
+func TestSubtreeOptions(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var checks int
+	shared := NewIPTrie(WithCIDRMode(CIDRStrict), WithMatchOrder(LeastSpecificFirst), WithKeyNormalizer(LowerKeys),
+		WithRefTypes(RefType{Name: "ticket"}), WithHitTracking(), WithSelfCheck(1, func(Health) { checks++ }))
+	shared.now = func() time.Time { return now }
+	shared.Insert("10.0.0.0/8", map[string]interface{}{})
+	shared.InsertWithState("10.1.0.0/16", StatePlanned, map[string]interface{}{"owner": "dev"})
+	shared.Insert("10.1.2.0/24", map[string]interface{}{SysKey: map[string]interface{}{"source": "sync"}})
+	shared.protectSys = true
+	shared.Find("10.1.2.3")
+
+	for name, sub := range map[string]*IPTrie{"Subtree": shared.Subtree("10.0.0.0/8"), "Clone": shared.Clone()} {
+		if sub.cidrMode != CIDRStrict || sub.matchOrder != LeastSpecificFirst || sub.normalizeKey == nil ||
+			!sub.protectSys || len(sub.refTypes) != 1 || !sub.trackHits || sub.selfCheckEvery != 1 {
+			t.Errorf("%s: expected the options of the source trie", name)
+		}
+		// Stored metadata is kept even where the options would reject it
+		if md, ok := sub.FindExact("10.1.2.0/24"); !ok || SysMetadata(md)["source"] != "sync" {
+			t.Errorf("%s: expected the stored system metadata, got %v", name, md)
+		}
+		if state, _ := sub.State("10.1.0.0/16"); state != StatePlanned {
+			t.Errorf("%s: expected the lifecycle state to be kept, got %v", name, state)
+		}
+		if err := sub.Insert("10.1.3.1/24", nil); err == nil {
+			t.Errorf("%s: expected strict CIDR mode to reject host bits", name)
+		}
+		sub.Find("10.1.2.3")
+		if u, err := sub.Hits("10.1.2.0/24"); err != nil || !u.LastHit.Equal(now) {
+			t.Errorf("%s: expected hits timed by the source clock, got %+v %v", name, u, err)
+		}
+		checks = 0
+		sub.Delete("10.0.0.0/8")
+		if checks != 1 {
+			t.Errorf("%s: expected the self-check to run, got %d runs", name, checks)
+		}
+	}
+
+	// A subtree counts from zero; a clone's hits are its own
+	sub := shared.Subtree("10.1.0.0/16")
+	if u, _ := sub.Hits("10.1.2.0/24"); u.Hits != 0 {
+		t.Errorf("Expected a subtree to start at 0 hits, got %d", u.Hits)
+	}
+	if u, _ := shared.Hits("10.1.2.0/24"); u.Hits != 1 {
+		t.Errorf("Expected the clone's hit not to count for the source, got %d", u.Hits)
+	}
+}
+
+func TestMixedFamilies(t *testing.T) {
+	trie := NewIPTrie()
+	entries := []string{"::/8", "0.0.0.0/8", "2001:db8::/32", "32.1.13.0/24"}
+	for _, cidr := range entries {
+		if err := trie.Insert(cidr, map[string]interface{}{"cidr": cidr}); err != nil {
+			t.Fatalf("Failed to insert CIDR: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		// 32.1.13.x shares its leading bits with 2001:0db8::
+		{name: "IPv4 not shadowed by IPv6", ip: "32.1.13.5", want: "32.1.13.0/24"},
+		{name: "IPv6 not shadowed by IPv4", ip: "2001:db8::5", want: "2001:db8::/32"},
+		{name: "short IPv6 prefix ignores IPv4", ip: "0.1.2.3", want: "0.0.0.0/8"},
+		{name: "short IPv4 prefix ignores IPv6", ip: "::1", want: "::/8"},
+		{name: "IPv4 miss", ip: "32.1.14.1", want: ""},
+		{name: "IPv6 miss", ip: "2001:db9::1", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cidr, _, err := trie.Find(tt.ip)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Expected no match for %s, got %s", tt.ip, cidr)
+				}
+				return
+			}
+			if cidr != tt.want {
+				t.Errorf("Expected %s to match %s, got %q (%v)", tt.ip, tt.want, cidr, err)
+			}
+
+			matches, _ := trie.FindAll(tt.ip)
+			if len(matches) != 1 {
+				t.Errorf("Expected exactly one match across families, got %v", matches)
+			}
+		})
+	}
+
+	var order []string
+	trie.Walk(func(prefix string, md map[string]interface{}) bool {
+		order = append(order, prefix)
+		return true
+	})
+	want := []string{"0.0.0.0/8", "32.1.13.0/24", "::/8", "2001:db8::/32"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("Expected walk order %v, got %v", want, order)
+	}
+
+	if _, ok := trie.FindExact("::/8"); !ok {
+		t.Errorf("Expected ::/8 to be stored separately from 0.0.0.0/8")
+	}
+	_ = trie.Delete("0.0.0.0/8")
+	if _, ok := trie.FindExact("::/8"); !ok {
+		t.Errorf("Expected deleting 0.0.0.0/8 to leave ::/8 in place")
+	}
+}
+
+func TestMappedCIDRs(t *testing.T) {
+	trie := NewIPTrie()
+	tests := []struct {
+		cidr   string
+		stored string
+	}{
+		// IPv4-mapped prefixes of /96 and longer are stored as IPv4
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8"},
+		{"::ffff:0.0.0.0/100", "0.0.0.0/4"},
+		{"::ffff:10.1.2.3/128", "10.1.2.3/32"},
+		{"::ffff:10.1.0.0/112", "10.1.0.0/16"},
+		// Shorter ones reach outside the mapped range and stay IPv6
+		{"::ffff:0.0.0.0/80", "::ffff:0.0.0.0/80"},
+	}
+	for _, tt := range tests {
+		if err := trie.Insert(tt.cidr, map[string]interface{}{"cidr": tt.cidr}); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", tt.cidr, err)
+		}
+		if md, ok := trie.FindExact(tt.stored); !ok || md["cidr"] != tt.cidr {
+			t.Errorf("Expected %s to be stored as %s, got %v", tt.cidr, tt.stored, md)
+		}
+		if _, ok := trie.FindExact(tt.cidr); !ok {
+			t.Errorf("Expected FindExact(%s) to find the mapped form", tt.cidr)
+		}
+	}
+	if trie.LenV4() != 4 || trie.LenV6() != 1 {
+		t.Errorf("Expected 4 IPv4 and 1 IPv6 prefix, got %d and %d", trie.LenV4(), trie.LenV6())
+	}
+
+	if cidr, _, _ := trie.Find("10.1.2.4"); cidr != "10.1.0.0/16" {
+		t.Errorf("Expected 10.1.0.0/16, got %s", cidr)
+	}
+	if cidr, _, _ := trie.Find("::ffff:10.9.9.9"); cidr != "10.0.0.0/8" {
+		t.Errorf("Expected 10.0.0.0/8, got %s", cidr)
+	}
+	if matches, _ := trie.FindAll("10.1.2.3"); len(matches) != 4 || !matches[0].Host || matches[0].PrefixLen != 32 {
+		t.Errorf("Expected 4 matches led by the host route, got %v", matches)
+	}
+
+	// The other CIDR methods take mapped prefixes too
+	if ok, err := trie.Covers("::ffff:10.1.2.0/120"); err != nil || !ok {
+		t.Errorf("Expected ::ffff:10.1.2.0/120 to be covered, got %v %v", ok, err)
+	}
+	if covered, err := trie.CoveredBy("::ffff:10.1.0.0/112"); err != nil || len(covered) != 2 {
+		t.Errorf("Expected 2 prefixes inside ::ffff:10.1.0.0/112, got %v %v", covered, err)
+	}
+	if supernets := trie.Supernets("::ffff:10.1.2.0/120"); len(supernets) != 3 {
+		t.Errorf("Expected 3 supernets, got %v", supernets)
+	}
+	if err := trie.Upsert("::ffff:10.0.0.0/104", map[string]interface{}{"owner": "ops"}, MergeMaps); err != nil {
+		t.Errorf("Upsert failed: %v", err)
+	}
+	if md, _ := trie.FindExact("10.0.0.0/8"); md["owner"] != "ops" {
+		t.Errorf("Expected Upsert to merge into 10.0.0.0/8, got %v", md)
+	}
+	if _, ok, err := trie.Remove("::ffff:10.1.0.0/112"); err != nil || !ok {
+		t.Errorf("Expected Remove to delete 10.1.0.0/16, got %v %v", ok, err)
+	}
+	strict := NewIPTrie()
+	_ = strict.InsertStrict("10.0.0.0/8", nil)
+	if err := strict.InsertStrict("::ffff:10.0.0.0/104", nil); err != nil {
+		t.Errorf("Expected the mapped form of a stored prefix to update it, got %v", err)
+	}
+
+	// Walks over the result stay intact
+	if _, _, err := trie.Enumerate("", 100, ""); err != nil {
+		t.Errorf("Enumerate failed: %v", err)
+	}
+	if _, err := trie.FreeSpace("0.0.0.0/0", 8); err != nil {
+		t.Errorf("FreeSpace failed: %v", err)
+	}
+	if h := trie.CheckStructure(); h.Orphans != 0 || len(h.Problems) != 0 {
+		t.Errorf("Expected a healthy trie, got %+v", h)
+	}
+}
+
+func TestCIDRModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    CIDRMode
+		cidr    string
+		want    string
+		wantErr error
+	}{
+		{name: "as given keeps host bits", mode: CIDRAsGiven, cidr: "10.20.20.5/24", want: "10.20.20.5/24"},
+		{name: "lenient normalizes", mode: CIDRLenient, cidr: "10.20.20.5/24", want: "10.20.20.0/24"},
+		{name: "lenient canonicalizes IPv6", mode: CIDRLenient, cidr: "2001:DB8:0::1/32", want: "2001:db8::/32"},
+		{name: "strict rejects host bits", mode: CIDRStrict, cidr: "10.20.20.5/24", wantErr: ErrHostBitsSet},
+		{name: "strict accepts canonical", mode: CIDRStrict, cidr: "10.20.20.0/24", want: "10.20.20.0/24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trie := NewIPTrie(WithCIDRMode(tt.mode))
+			err := trie.Insert(tt.cidr, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				if upsertErr := trie.Upsert(tt.cidr, nil, nil); !errors.Is(upsertErr, tt.wantErr) {
+					t.Errorf("Expected Upsert to fail the same way, got %v", upsertErr)
+				}
+				return
+			}
+
+			var stored []string
+			trie.Walk(func(prefix string, md map[string]interface{}) bool {
+				stored = append(stored, prefix)
+				return true
+			})
+			if len(stored) != 1 || stored[0] != tt.want {
+				t.Errorf("Expected stored CIDR %s, got %v", tt.want, stored)
+			}
+		})
+	}
+}
+
+func TestDefaultRoute(t *testing.T) {
+	trie := NewIPTrie()
+	_ = trie.Insert("0.0.0.0/0", map[string]interface{}{"route": "v4-default"})
+	_ = trie.Insert("::/0", map[string]interface{}{"route": "v6-default"})
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"route": "private"})
+
+	tests := []struct {
+		name    string
+		ip      string
+		want    string
+		matches int
+	}{
+		{name: "IPv4 catch-all", ip: "8.8.8.8", want: "0.0.0.0/0", matches: 1},
+		{name: "IPv4 all-zero address", ip: "0.0.0.0", want: "0.0.0.0/0", matches: 1},
+		{name: "IPv4 all-ones address", ip: "255.255.255.255", want: "0.0.0.0/0", matches: 1},
+		{name: "more specific wins", ip: "10.1.1.1", want: "10.0.0.0/8", matches: 2},
+		{name: "IPv6 catch-all", ip: "2001:db8::1", want: "::/0", matches: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cidr, _, err := trie.Find(tt.ip)
+			if err != nil || cidr != tt.want {
+				t.Errorf("Expected %s to match %s, got %q (%v)", tt.ip, tt.want, cidr, err)
+			}
+
+			matches, _ := trie.FindAll(tt.ip)
+			if len(matches) != tt.matches {
+				t.Fatalf("Expected %d matches, got %d", tt.matches, len(matches))
+			}
+			if last := matches[len(matches)-1].CIDR; last != "0.0.0.0/0" && last != "::/0" {
+				t.Errorf("Expected the default route last, got %s", last)
+			}
+		})
+	}
+
+	if ok, _ := trie.Covers("192.0.2.0/24"); !ok {
+		t.Errorf("Expected the default route to cover every IPv4 prefix")
+	}
+
+	if err := trie.Delete("0.0.0.0/0"); err != nil {
+		t.Fatalf("Failed to delete default route: %v", err)
+	}
+	if _, _, err := trie.Find("8.8.8.8"); err == nil {
+		t.Errorf("Expected no match after deleting the IPv4 default route")
+	}
+	if cidr, _, _ := trie.Find("10.1.1.1"); cidr != "10.0.0.0/8" {
+		t.Errorf("Expected other IPv4 prefixes to survive, got %q", cidr)
+	}
+	if cidr, _, _ := trie.Find("2001:db8::1"); cidr != "::/0" {
+		t.Errorf("Expected the IPv6 default route to survive, got %q", cidr)
+	}
+}
+
+func TestLen(t *testing.T) {
+	trie := NewIPTrie()
+	check := func(step string, want4, want6 int) {
+		t.Helper()
+		if trie.LenV4() != want4 || trie.LenV6() != want6 || trie.Len() != want4+want6 {
+			t.Errorf("%s: expected %d/%d/%d, got Len=%d LenV4=%d LenV6=%d", step,
+				want4+want6, want4, want6, trie.Len(), trie.LenV4(), trie.LenV6())
+		}
+	}
+
+	check("empty", 0, 0)
+
+	_ = trie.Insert("0.0.0.0/0", nil)
+	_ = trie.Insert("10.0.0.0/8", nil)
+	_ = trie.Insert("10.0.0.1/32", nil)
+	_ = trie.Insert("2001:db8::/32", nil)
+	_ = trie.Insert("2001:db8::1/128", nil)
+	check("after insert", 3, 2)
+
+	_ = trie.Insert("10.0.0.0/8", map[string]interface{}{"replaced": true})
+	_ = trie.Insert("10.0.0.1/32", map[string]interface{}{"replaced": true})
+	_ = trie.Upsert("2001:db8::/32", nil, MergeMaps)
+	check("after overwrite", 3, 2)
+
+	_ = trie.Delete("10.0.0.1/32")
+	_ = trie.Delete("2001:db8::/32")
+	_ = trie.Delete("172.16.0.0/12")
+	check("after delete", 2, 1)
+
+	trie.DeleteFunc(func(prefix string, md map[string]interface{}) bool { return true })
+	check("after prune", 0, 0)
+}
+
+func TestClone(t *testing.T) {
+	original := NewIPTrie(WithCIDRMode(CIDRLenient))
+	_ = original.Insert("10.0.0.0/8", map[string]interface{}{"scope": "wide"})
+	_ = original.Insert("10.1.0.0/16", map[string]interface{}{"scope": "narrow"})
+	_ = original.Insert("10.1.0.1/32", map[string]interface{}{"scope": "host"})
+
+	clone := original.Clone()
+
+	_ = clone.Insert("10.1.2.0/24", nil)
+	_ = clone.Delete("10.1.0.1/32")
+	_ = clone.Upsert("10.0.0.0/8", map[string]interface{}{"scope": "changed"}, nil)
+	_ = original.Delete("10.1.0.0/16")
+
+	if _, ok := original.FindExact("10.1.2.0/24"); ok {
+		t.Errorf("Expected insert into clone not to reach the original")
+	}
+	if cidr, _, _ := original.Find("10.1.0.1"); cidr != "10.1.0.1/32" {
+		t.Errorf("Expected original to keep its host route, got %q", cidr)
+	}
+	if md, _ := original.FindExact("10.0.0.0/8"); md["scope"] != "wide" {
+		t.Errorf("Expected original metadata to be untouched, got %v", md)
+	}
+	if cidr, _, _ := clone.Find("10.1.9.9"); cidr != "10.1.0.0/16" {
+		t.Errorf("Expected delete in original not to reach the clone, got %q", cidr)
+	}
+	if original.Len() != 2 || clone.Len() != 3 {
+		t.Errorf("Expected independent counters, got original=%d clone=%d", original.Len(), clone.Len())
+	}
+
+	_ = clone.Insert("192.168.1.7/24", nil)
+	if cidr, _, _ := clone.Find("192.168.1.7"); cidr != "192.168.1.0/24" {
+		t.Errorf("Expected clone to keep the original's CIDR mode, got %q", cidr)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	build := func() (*IPTrie, *IPTrie) {
+		a := NewIPTrie()
+		_ = a.Insert("10.0.0.0/8", map[string]interface{}{"source": "a", "owner": "neteng"})
+		_ = a.Insert("192.168.0.0/16", map[string]interface{}{"source": "a"})
+		b := NewIPTrie()
+		_ = b.Insert("10.0.0.0/8", map[string]interface{}{"source": "b"})
+		_ = b.Insert("2001:db8::/32", map[string]interface{}{"source": "b"})
+		return a, b
+	}
+
+	tests := []struct {
+		name    string
+		policy  ConflictPolicy
+		want    string
+		wantErr bool
+	}{
+		{name: "keep existing", policy: KeepExisting, want: "map[owner:neteng source:a]"},
+		{name: "overwrite", policy: Overwrite, want: "map[source:b]"},
+		{name: "callback", policy: ConflictPolicy(MergeMaps), want: "map[owner:neteng source:b]"},
+		{name: "refuse", policy: ConflictPolicy(RefuseDuplicate), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := build()
+			err := a.Merge(b, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error=%v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if a.Len() != 3 {
+				t.Errorf("Expected union of 3 prefixes, got %d", a.Len())
+			}
+			md, _ := a.FindExact("10.0.0.0/8")
+			if fmt.Sprint(md) != tt.want {
+				t.Errorf("Expected %s, got %v", tt.want, md)
+			}
+			if b.Len() != 2 {
+				t.Errorf("Expected the other trie to be left alone")
+			}
+		})
+	}
+}
+
+func TestFindAllOrder(t *testing.T) {
+	cidrs := []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "10.1.2.3/32"}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+		lens []int
+		host []bool
+	}{
+		{name: "most specific first", want: []string{"10.1.2.3/32", "10.1.2.0/24", "10.1.0.0/16", "10.0.0.0/8"}, lens: []int{32, 24, 16, 8}, host: []bool{true, false, false, false}},
+		{name: "least specific first", opts: []Option{WithMatchOrder(LeastSpecificFirst)}, want: cidrs, lens: []int{8, 16, 24, 32}, host: []bool{false, false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trie := NewIPTrie(tt.opts...)
+			for _, cidr := range cidrs {
+				trie.Insert(cidr, nil)
+			}
+
+			matches, err := trie.FindAll("10.1.2.3")
+			if err != nil {
+				t.Fatalf("FindAll returned error: %v", err)
+			}
+			var got []string
+			var lens []int
+			var host []bool
+			for _, m := range matches {
+				got = append(got, m.CIDR)
+				lens = append(lens, m.PrefixLen)
+				host = append(host, m.Host)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) || fmt.Sprint(lens) != fmt.Sprint(tt.lens) || fmt.Sprint(host) != fmt.Sprint(tt.host) {
+				t.Errorf("Expected %v %v %v, got %v %v %v", tt.want, tt.lens, tt.host, got, lens, host)
+			}
+		})
+	}
+}
+
+func TestFindWithin(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "::/0", "2001:db8::/32", "2001:db8::1/128"} {
+		trie.Insert(cidr, map[string]interface{}{"cidr": cidr})
+	}
+
+	tests := []struct {
+		ip             string
+		minLen, maxLen int
+		cidr           string
+	}{
+		{"10.1.2.3", 0, 128, "10.1.2.3/32"},
+		{"10.1.2.3", 0, 31, "10.1.0.0/16"},
+		{"10.1.2.3", 1, 15, "10.0.0.0/8"},
+		{"10.1.2.3", 0, 7, "0.0.0.0/0"},
+		{"10.1.2.3", 17, 31, ""},
+		{"192.0.2.1", 1, 32, ""},
+		{"192.0.2.1", 0, 0, "0.0.0.0/0"},
+		{"2001:db8::1", 0, 127, "2001:db8::/32"},
+		{"2001:db8::1", 33, 128, "2001:db8::1/128"},
+		{"2001:db8::2", 1, 128, "2001:db8::/32"},
+	}
+	for _, tt := range tests {
+		cidr, md, err := trie.FindWithin(tt.ip, tt.minLen, tt.maxLen)
+		if tt.cidr == "" {
+			if err == nil {
+				t.Errorf("%s /%d-/%d: expected no match, got %s", tt.ip, tt.minLen, tt.maxLen, cidr)
+			}
+			continue
+		}
+		if err != nil || cidr != tt.cidr || md["cidr"] != tt.cidr {
+			t.Errorf("%s /%d-/%d: expected %s, got %q, %v", tt.ip, tt.minLen, tt.maxLen, tt.cidr, cidr, err)
+		}
+	}
+
+	for _, r := range [][2]int{{-1, 8}, {16, 8}} {
+		if _, _, err := trie.FindWithin("10.1.2.3", r[0], r[1]); err == nil {
+			t.Errorf("Expected error for range %v", r)
+		}
+	}
+	if _, _, err := trie.FindWithin("bogus", 0, 32); err == nil {
+		t.Errorf("Expected error for an invalid IP")
+	}
+}
+
+func TestFindValue(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"owner": "corp", "site": "any"})
+	trie.Insert("10.1.0.0/16", map[string]interface{}{"owner": "net"})
+	trie.Insert("10.1.2.0/24", map[string]interface{}{"vlan": 12})
+	trie.Insert("10.1.2.3/32", map[string]interface{}{"site": "rack-4"})
+
+	tests := []struct {
+		name  string
+		ip    string
+		key   string
+		cidr  string
+		value interface{}
+	}{
+		{name: "inherited from /16", ip: "10.1.2.3", key: "owner", cidr: "10.1.0.0/16", value: "net"},
+		{name: "host route overrides", ip: "10.1.2.3", key: "site", cidr: "10.1.2.3/32", value: "rack-4"},
+		{name: "inherited from /8", ip: "10.1.2.4", key: "site", cidr: "10.0.0.0/8", value: "any"},
+		{name: "own key", ip: "10.1.2.4", key: "vlan", cidr: "10.1.2.0/24", value: 12},
+		{name: "key nowhere", ip: "10.1.2.4", key: "tenant"},
+		{name: "no prefix", ip: "192.0.2.1", key: "owner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cidr, md, err := trie.FindValue(tt.ip, tt.key)
+			if tt.cidr == "" {
+				if err == nil {
+					t.Errorf("Expected error, got %s", cidr)
+				}
+				return
+			}
+			if err != nil || cidr != tt.cidr || md[tt.key] != tt.value {
+				t.Errorf("Expected %s with %v, got %s %v (%v)", tt.cidr, tt.value, cidr, md, err)
+			}
+		})
+	}
+
+	// The lookup order does not depend on the FindAll option
+	reversed := NewIPTrie(WithMatchOrder(LeastSpecificFirst))
+	reversed.Insert("10.0.0.0/8", map[string]interface{}{"owner": "corp"})
+	reversed.Insert("10.1.0.0/16", map[string]interface{}{"owner": "net"})
+	if cidr, _, _ := reversed.FindValue("10.1.1.1", "owner"); cidr != "10.1.0.0/16" {
+		t.Errorf("Expected the most specific owner, got %s", cidr)
+	}
+}
+
+func TestFindMerged(t *testing.T) {
+	trie := NewIPTrie()
+	trie.Insert("10.0.0.0/8", map[string]interface{}{"owner": "corp", "site": "any"})
+	trie.Insert("10.1.0.0/16", map[string]interface{}{"owner": "net"})
+	trie.Insert("10.1.2.3/32", map[string]interface{}{"site": "rack-4"})
+
+	tests := []struct {
+		name string
+		ip   string
+		cidr string
+		want string
+	}{
+		{name: "host overrides all", ip: "10.1.2.3", cidr: "10.1.2.3/32", want: "map[owner:net site:rack-4]"},
+		{name: "child overrides parent", ip: "10.1.9.9", cidr: "10.1.0.0/16", want: "map[owner:net site:any]"},
+		{name: "single match", ip: "10.9.9.9", cidr: "10.0.0.0/8", want: "map[owner:corp site:any]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cidr, md, err := trie.FindMerged(tt.ip)
+			if err != nil || cidr != tt.cidr || fmt.Sprint(md) != tt.want {
+				t.Errorf("Expected %s %s, got %s %v (%v)", tt.cidr, tt.want, cidr, md, err)
+			}
+		})
+	}
+
+	// The result is a copy
+	_, md, _ := trie.FindMerged("10.1.2.3")
+	md["owner"] = "changed"
+	if stored, _ := trie.FindExact("10.1.0.0/16"); stored["owner"] != "net" {
+		t.Errorf("Expected stored metadata to be untouched, got %v", stored)
+	}
+
+	if _, _, err := trie.FindMerged("192.0.2.1"); err == nil {
+		t.Errorf("Expected error for an address with no match")
+	}
+}
+
 // Benchmarks
+func TestFindAddr(t *testing.T) {
+	trie := NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "192.0.2.7/32", "2001:db8::/32", "2001:db8::1/128"} {
+		if err := trie.Insert(cidr, map[string]interface{}{"cidr": cidr}); err != nil {
+			t.Fatalf("Insert(%s) failed: %v", cidr, err)
+		}
+	}
+
+	tests := []struct {
+		addr netip.Addr
+		cidr string
+	}{
+		{netip.MustParseAddr("10.1.2.3"), "10.1.0.0/16"},
+		{netip.MustParseAddr("10.2.0.1"), "10.0.0.0/8"},
+		{netip.MustParseAddr("::ffff:10.1.2.3"), "10.1.0.0/16"},
+		{netip.MustParseAddr("192.0.2.7"), "192.0.2.7/32"},
+		{netip.MustParseAddr("2001:db8::1"), "2001:db8::1/128"},
+		{netip.MustParseAddr("2001:db8::2%eth0"), "2001:db8::/32"},
+		{netip.MustParseAddr("192.0.2.8"), ""},
+		{netip.Addr{}, ""},
+	}
+	for _, tt := range tests {
+		cidr, md, ok := trie.FindAddr(tt.addr)
+		if cidr != tt.cidr || ok != (tt.cidr != "") {
+			t.Errorf("%s: expected %q, got %q, %v", tt.addr, tt.cidr, cidr, ok)
+		}
+		if ok && md["cidr"] != cidr {
+			t.Errorf("%s: unexpected metadata %v", tt.addr, md)
+		}
+	}
+
+	// Find keeps rejecting zones, as net.ParseIP did
+	if _, _, err := trie.Find("2001:db8::2%eth0"); err == nil || err.Error() != "invalid IP address" {
+		t.Errorf("Expected invalid IP address error, got %v", err)
+	}
+	if _, _, err := trie.Find("192.0.2.8"); err == nil || err.Error() != "no matching CIDR found" {
+		t.Errorf("Expected no matching CIDR error, got %v", err)
+	}
+}
+
+func TestFindMatch(t *testing.T) {
+	// The longest match whatever the order FindAll uses
+	trie := NewIPTrie(WithMatchOrder(LeastSpecificFirst))
+	for _, cidr := range []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32"} {
+		trie.Insert(cidr, map[string]interface{}{"cidr": cidr})
+	}
+	tests := []struct {
+		addr string
+		want Match
+	}{
+		{"10.1.2.3", Match{CIDR: "10.1.2.3/32", PrefixLen: 32, Host: true}},
+		{"10.1.9.9", Match{CIDR: "10.1.0.0/16", PrefixLen: 16}},
+		{"192.0.2.1", Match{CIDR: "0.0.0.0/0"}},
+	}
+	for _, tt := range tests {
+		m, ok := trie.FindMatch(netip.MustParseAddr(tt.addr))
+		if !ok || m.CIDR != tt.want.CIDR || m.PrefixLen != tt.want.PrefixLen || m.Host != tt.want.Host || m.Metadata["cidr"] != m.CIDR {
+			t.Errorf("%s: expected %+v, got %+v %v", tt.addr, tt.want, m, ok)
+		}
+	}
+	if _, ok := trie.FindMatch(netip.MustParseAddr("2001:db8::1")); ok {
+		t.Errorf("Expected no match for an IPv6 address")
+	}
+}
+
+func TestFindAllocs(t *testing.T) {
+	trie := NewIPTrie()
+	for i := 0; i < 256; i++ {
+		_ = trie.Insert(fmt.Sprintf("10.%d.0.0/16", i), map[string]interface{}{"i": i})
+		_ = trie.Insert(fmt.Sprintf("2001:db8:%x::/48", i), map[string]interface{}{"i": i})
+	}
+	_ = trie.Insert("10.1.2.3/32", nil)
+
+	for _, ip := range []string{"10.7.1.1", "10.1.2.3", "2001:db8:7::1", "::ffff:10.7.1.1", "192.0.2.1"} {
+		addr, _ := netip.ParseAddr(ip)
+		if allocs := testing.AllocsPerRun(100, func() { trie.FindAddr(addr) }); allocs != 0 {
+			t.Errorf("FindAddr(%s): expected no allocations, got %v", ip, allocs)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { trie.Find(ip) }); allocs != 0 {
+			t.Errorf("Find(%s): expected no allocations, got %v", ip, allocs)
+		}
+	}
+}
+
 func BenchmarkIPv4Insert(b *testing.B) {
 	trie := NewIPTrie()
 	metadata := map[string]interface{}{"region": "test"}
@@ -213,6 +1192,22 @@ func BenchmarkIPv6Find(b *testing.B) {
 	}
 }
 
+func BenchmarkFindAddr(b *testing.B) {
+	trie := NewIPTrie()
+	metadata := map[string]interface{}{"region": "test"}
+	addrs := make([]netip.Addr, 1000)
+	for i := range addrs {
+		_ = trie.Insert(fmt.Sprintf("192.168.%d.0/24", i%256), metadata)
+		addrs[i] = netip.AddrFrom4([4]byte{192, 168, byte(i), byte(i >> 2)})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.FindAddr(addrs[i%len(addrs)])
+	}
+}
+
 func BenchmarkLargeScale(b *testing.B) {
 	b.Run("1K_CIDRs", func(b *testing.B) { benchmarkWithSize(b, 1000) })
 	b.Run("10K_CIDRs", func(b *testing.B) { benchmarkWithSize(b, 10000) })
@@ -0,0 +1,153 @@
+package trie
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Revision is one recorded mutation of a VersionedTrie. Version is the
+// version the mutation produced; the first mutation produces version 1.
+type Revision struct {
+	Version uint64 `json:"version"`
+	Event
+}
+
+// VersionedTrie records every Insert and Delete as a numbered, timestamped
+// revision and keeps the trie as of each version, so past states can be
+// queried: At(v).Find(ip), or AtTime(t).Find(ip) for "what did this
+// address map to last Tuesday". Versions are persistent tries sharing
+// unchanged nodes, so each one costs about one prefix path. History grows
+// without bound. A VersionedTrie is safe for concurrent use.
+type VersionedTrie struct {
+	mu   sync.RWMutex
+	opts *IPTrie
+	// tries[v] is the trie as of version v; tries[0] is empty
+	tries []*PersistentTrie
+	// log[v-1] is the revision that produced version v
+	log []Revision
+}
+
+// NewVersionedTrie returns an empty versioned trie at version 0, configured
+// by opts
+func NewVersionedTrie(opts ...Option) *VersionedTrie {
+	empty := NewPersistentTrie(opts...)
+	return &VersionedTrie{opts: empty.opts, tries: []*PersistentTrie{empty}}
+}
+
+// Insert stores cidr with metadata, replacing any metadata it had, and
+// returns the new version
+func (v *VersionedTrie) Insert(cidr string, metadata map[string]interface{}) (uint64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cur := v.tries[len(v.tries)-1]
+	next, err := cur.Insert(cidr, metadata)
+	if err != nil {
+		return 0, err
+	}
+	node := next.exact(cidr)
+	e := Event{Type: EventInsert, CIDR: node.cidr, Metadata: node.metadata}
+	if prev := cur.exact(cidr); prev != nil {
+		e.Type, e.Previous = EventUpdate, prev.metadata
+	}
+	return v.record(next, e), nil
+}
+
+// Delete removes cidr and returns the new version. It fails if cidr is not
+// stored.
+func (v *VersionedTrie) Delete(cidr string) (uint64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cur := v.tries[len(v.tries)-1]
+	prev := cur.exact(cidr)
+	next, err := cur.Delete(cidr)
+	if err != nil {
+		return 0, err
+	}
+	return v.record(next, Event{Type: EventDelete, CIDR: prev.cidr, Metadata: prev.metadata}), nil
+}
+
+// record appends a version. Timestamps never go backwards, even if the
+// clock does, so AtTime can search them.
+func (v *VersionedTrie) record(next *PersistentTrie, e Event) uint64 {
+	e.Time = v.opts.clock()
+	if n := len(v.log); n > 0 && e.Time.Before(v.log[n-1].Time) {
+		e.Time = v.log[n-1].Time
+	}
+	version := uint64(len(v.tries))
+	v.tries = append(v.tries, next)
+	v.log = append(v.log, Revision{Version: version, Event: e})
+	return version
+}
+
+// Version returns the current version, 0 before the first mutation
+func (v *VersionedTrie) Version() uint64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return uint64(len(v.tries) - 1)
+}
+
+// Current returns the trie as of the current version
+func (v *VersionedTrie) Current() *PersistentTrie {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.tries[len(v.tries)-1]
+}
+
+// Find looks ip up in the current version
+func (v *VersionedTrie) Find(ip string) (string, map[string]interface{}, error) {
+	return v.Current().Find(ip)
+}
+
+// At returns the trie as of the given version
+func (v *VersionedTrie) At(version uint64) (*PersistentTrie, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if version >= uint64(len(v.tries)) {
+		return nil, fmt.Errorf("version %d does not exist, current is %d", version, len(v.tries)-1)
+	}
+	return v.tries[version], nil
+}
+
+// AtTime returns the trie as it was at t: the last version recorded at or
+// before t, or the empty version 0 if t predates every mutation
+func (v *VersionedTrie) AtTime(t time.Time) *PersistentTrie {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	n := sort.Search(len(v.log), func(i int) bool {
+		return v.log[i].Time.After(t)
+	})
+	return v.tries[n]
+}
+
+// Changes returns the revisions after the given version, oldest first
+func (v *VersionedTrie) Changes(after uint64) []Revision {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if after >= uint64(len(v.log)) {
+		return nil
+	}
+	return append([]Revision(nil), v.log[after:]...)
+}
+
+// History returns the revisions that inserted, updated or deleted cidr,
+// oldest first. CIDRs are compared as networks, so 10.1.2.3/8 and
+// 10.0.0.0/8 share a history.
+func (v *VersionedTrie) History(cidr string) []Revision {
+	want, ok := storedPrefix(cidr)
+	if !ok {
+		return nil
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	var out []Revision
+	for _, r := range v.log {
+		if p, _ := storedPrefix(r.CIDR); p == want {
+			out = append(out, r)
+		}
+	}
+	return out
+}
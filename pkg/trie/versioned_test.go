@@ -0,0 +1,127 @@
+package trie
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionedTrie(t *testing.T) {
+	v := NewVersionedTrie()
+	start := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	now := start
+	v.opts.now = func() time.Time { return now }
+
+	steps := []func() (uint64, error){
+		func() (uint64, error) { return v.Insert("10.0.0.0/8", map[string]interface{}{"owner": "netops"}) },
+		func() (uint64, error) { return v.Insert("10.1.0.0/16", map[string]interface{}{"owner": "dev"}) },
+		func() (uint64, error) { return v.Insert("10.1.0.0/16", map[string]interface{}{"owner": "qa"}) },
+		func() (uint64, error) { return v.Delete("10.1.0.0/16") },
+	}
+	for i, step := range steps {
+		now = start.Add(time.Duration(i) * 24 * time.Hour)
+		version, err := step()
+		if err != nil || version != uint64(i+1) {
+			t.Fatalf("Step %d: expected version %d, got %d, %v", i, i+1, version, err)
+		}
+	}
+	if v.Version() != 4 {
+		t.Errorf("Expected version 4, got %d", v.Version())
+	}
+
+	tests := []struct {
+		version   uint64
+		wantCIDR  string
+		wantOwner string
+	}{
+		{0, "", ""},
+		{1, "10.0.0.0/8", "netops"},
+		{2, "10.1.0.0/16", "dev"},
+		{3, "10.1.0.0/16", "qa"},
+		{4, "10.0.0.0/8", "netops"},
+	}
+	for _, tt := range tests {
+		p, err := v.At(tt.version)
+		if err != nil {
+			t.Fatalf("At(%d) returned error: %v", tt.version, err)
+		}
+		cidr, md, err := p.Find("10.1.2.3")
+		if tt.wantCIDR == "" {
+			if err == nil {
+				t.Errorf("Version %d: expected no match, got %s", tt.version, cidr)
+			}
+			continue
+		}
+		if err != nil || cidr != tt.wantCIDR || md["owner"] != tt.wantOwner {
+			t.Errorf("Version %d: expected %s %s, got %s %v %v", tt.version, tt.wantCIDR, tt.wantOwner, cidr, md, err)
+		}
+	}
+	if _, err := v.At(5); err == nil {
+		t.Errorf("Expected error for a future version")
+	}
+
+	// Point in time queries take the last version recorded by then
+	if cidr, md, _ := v.AtTime(start.Add(36 * time.Hour)).Find("10.1.2.3"); cidr != "10.1.0.0/16" || md["owner"] != "dev" {
+		t.Errorf("Expected the day 1 mapping, got %s %v", cidr, md)
+	}
+	if _, _, err := v.AtTime(start.Add(-time.Hour)).Find("10.1.2.3"); err == nil {
+		t.Errorf("Expected no match before the first mutation")
+	}
+	if cidr, _, _ := v.Find("10.1.2.3"); cidr != "10.0.0.0/8" {
+		t.Errorf("Expected the current mapping 10.0.0.0/8, got %s", cidr)
+	}
+
+	// Failed mutations record nothing
+	if _, err := v.Delete("10.9.0.0/16"); err == nil {
+		t.Errorf("Expected error deleting a CIDR that is not stored")
+	}
+	if _, err := v.Insert("bogus", nil); err == nil {
+		t.Errorf("Expected error for an invalid CIDR")
+	}
+	if v.Version() != 4 {
+		t.Errorf("Expected failed mutations to leave version 4, got %d", v.Version())
+	}
+}
+
+func TestVersionedTrieHistory(t *testing.T) {
+	v := NewVersionedTrie()
+	_, _ = v.Insert("10.0.0.0/8", map[string]interface{}{"owner": "netops"})
+	_, _ = v.Insert("10.1.0.0/16", map[string]interface{}{"owner": "dev"})
+	_, _ = v.Insert("10.1.2.3/16", map[string]interface{}{"owner": "qa"})
+	_, _ = v.Delete("10.1.0.0/16")
+
+	history := v.History("10.1.0.0/16")
+	wantTypes := []EventType{EventInsert, EventUpdate, EventDelete}
+	if len(history) != len(wantTypes) {
+		t.Fatalf("Expected %d revisions, got %v", len(wantTypes), history)
+	}
+	for i, r := range history {
+		if r.Type != wantTypes[i] {
+			t.Errorf("Revision %d: expected %s, got %s", r.Version, wantTypes[i], r.Type)
+		}
+	}
+	if history[1].Previous["owner"] != "dev" || history[2].Metadata["owner"] != "qa" {
+		t.Errorf("Unexpected update or delete metadata: %+v", history)
+	}
+
+	changes := v.Changes(2)
+	if len(changes) != 2 || changes[0].Version != 3 || changes[1].Version != 4 {
+		t.Errorf("Expected versions 3 and 4, got %+v", changes)
+	}
+	if v.Changes(4) != nil {
+		t.Errorf("Expected no changes after the current version")
+	}
+}
+
+func TestVersionedTrieClockSkew(t *testing.T) {
+	v := NewVersionedTrie()
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	v.opts.now = func() time.Time { return now }
+	_, _ = v.Insert("10.0.0.0/8", nil)
+	now = now.Add(-time.Minute)
+	_, _ = v.Insert("10.1.0.0/16", nil)
+
+	changes := v.Changes(0)
+	if changes[1].Time.Before(changes[0].Time) {
+		t.Errorf("Expected timestamps not to go backwards, got %v then %v", changes[0].Time, changes[1].Time)
+	}
+}
@@ -0,0 +1,80 @@
+// Package triedebug exposes a trie's statistics for performance
+// investigations: as an expvar variable, served by /debug/vars next to the
+// net/http/pprof profiles, and through a handler that also dumps the most
+// observed prefixes and the deepest branches of the trie.
+//
+// Both read the current trie of a TrieHolder each time they are queried
+// and walk it whole, so they cost time proportional to its size.
+package triedebug
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// defaultTop is the number of prefixes and branches the handler dumps
+// unless asked for another number
+const defaultTop = 20
+
+// maxTop caps the n parameter of the handler
+const maxTop = 1000
+
+// Report is the response of the handler
+type Report struct {
+	Stats trie.Stats `json:"stats"`
+	// TopPrefixes are the prefixes with the most observations over the
+	// last hour, as counted by IPTrie.Observe
+	TopPrefixes []trie.PrefixHits `json:"top_prefixes"`
+	// DeepestBranches are the longest paths lookups walk
+	DeepestBranches []trie.Branch `json:"deepest_branches"`
+}
+
+// Publish exports the Stats of the trie in holder as the expvar variable
+// name. Like expvar.Publish, it panics if name is already in use.
+func Publish(name string, holder *trie.TrieHolder) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return holder.Load().Stats()
+	}))
+}
+
+// Handler returns a handler serving a Report of the trie in holder as
+// JSON. The n query parameter sets how many prefixes and branches are
+// listed, 20 by default.
+func Handler(holder *trie.TrieHolder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := defaultTop
+		if v := r.URL.Query().Get("n"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil || n < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid n %q", v)})
+				return
+			}
+			n = min(n, maxTop)
+		}
+
+		t := holder.Load()
+		rep := Report{
+			Stats:           t.Stats(),
+			TopPrefixes:     t.TopActive(n),
+			DeepestBranches: t.DeepestBranches(n),
+		}
+		if rep.TopPrefixes == nil {
+			rep.TopPrefixes = []trie.PrefixHits{}
+		}
+		if rep.DeepestBranches == nil {
+			rep.DeepestBranches = []trie.Branch{}
+		}
+		writeJSON(w, http.StatusOK, rep)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,78 @@
+package triedebug
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+func testHolder(t *testing.T) *trie.TrieHolder {
+	tr := trie.NewIPTrie()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "2001:db8::/32"} {
+		if err := tr.Insert(cidr, map[string]interface{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ip := range []string{"10.1.2.3", "10.9.9.9"} {
+		tr.Observe(ip)
+	}
+	h := trie.NewTrieHolder()
+	h.Store(tr)
+	return h
+}
+
+func TestPublish(t *testing.T) {
+	Publish("triedebug_test", testHolder(t))
+	var st trie.Stats
+	if err := json.Unmarshal([]byte(expvar.Get("triedebug_test").String()), &st); err != nil {
+		t.Fatalf("Expected Stats JSON, got error %v", err)
+	}
+	if st.Prefixes != 3 || st.PrefixesV6 != 1 {
+		t.Errorf("Expected 3 prefixes, 1 of them IPv6, got %+v", st)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := Handler(testHolder(t))
+
+	tests := []struct {
+		query    string
+		status   int
+		top      int
+		branches int
+	}{
+		{"", http.StatusOK, 2, 2},
+		{"?n=1", http.StatusOK, 1, 1},
+		{"?n=0", http.StatusOK, 0, 0},
+		{"?n=-1", http.StatusBadRequest, 0, 0},
+		{"?n=lots", http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/trie"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%q: expected status %d, got %d", tt.query, tt.status, rec.Code)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var rep Report
+		if err := json.Unmarshal(rec.Body.Bytes(), &rep); err != nil {
+			t.Fatalf("%q: invalid JSON: %v", tt.query, err)
+		}
+		if rep.Stats.Prefixes != 3 || len(rep.TopPrefixes) != tt.top || len(rep.DeepestBranches) != tt.branches {
+			t.Errorf("%q: unexpected report %+v", tt.query, rep)
+		}
+		if tt.top > 0 && (rep.TopPrefixes[0].CIDR != "10.0.0.0/8" || rep.TopPrefixes[0].Hits != 2) {
+			t.Errorf("%q: expected 10.0.0.0/8 with 2 hits first, got %v", tt.query, rep.TopPrefixes[0])
+		}
+		if tt.branches > 0 && rep.DeepestBranches[0].Prefix.String() != "2001:db8::/32" {
+			t.Errorf("%q: expected 2001:db8::/32 deepest, got %v", tt.query, rep.DeepestBranches[0])
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Package wal makes an IPTrie durable with a write-ahead log. Every change
+// to the trie is appended to a log file as a JSON line before the mutating
+// call returns, and Open rebuilds the trie after a restart from the last
+// snapshot plus the records logged since. Compaction writes a new snapshot
+// and empties the log.
+package wal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// snapshotSuffix is appended to the log path to name the snapshot file
+const snapshotSuffix = ".snapshot"
+
+// Config tunes a Log
+type Config struct {
+	// CompactEvery compacts the log once it holds this many records. Zero
+	// leaves compaction to explicit Compact calls.
+	CompactEvery int
+	// NoSync skips the fsync after each record, trading the last changes
+	// before a crash for write throughput
+	NoSync bool
+}
+
+// record is one logged change. Seq numbers every change since the log was
+// created and keeps counting across compactions.
+type record struct {
+	Seq uint64 `json:"seq"`
+	trie.Event
+}
+
+// snapshot is the compacted state together with the last change it holds
+type snapshot struct {
+	Seq  uint64          `json:"seq"`
+	Trie json.RawMessage `json:"trie"`
+}
+
+// Log appends every change of a trie to a file. Changes made through any
+// trie method are logged, not just Insert and Delete, because the log
+// follows the trie's OnChange events. Lifecycle states and pairs are only
+// saved by compaction. Like the trie itself, a Log needs external locking
+// for concurrent writers.
+type Log struct {
+	path string
+	cfg  Config
+	trie *trie.IPTrie
+
+	mu      sync.Mutex
+	f       *os.File
+	seq     uint64
+	records int
+	err     error
+}
+
+// Open recovers the trie stored at path, or starts an empty one configured
+// by opts, and logs every later change to it. A record torn by a crash
+// while being written is discarded.
+func Open(path string, cfg Config, opts ...trie.Option) (*Log, error) {
+	t, seq, records, good, err := recoverLog(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("wal: %v", err)
+	}
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("wal: %v", err)
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("wal: %v", err)
+	}
+
+	l := &Log{path: path, cfg: cfg, trie: t, f: f, seq: seq, records: records}
+	t.OnChange(l.append)
+	return l, nil
+}
+
+// Recover rebuilds the trie stored at path without opening it for
+// writing. Metadata comes back as its JSON equivalent, as with
+// IPTrie.UnmarshalJSON.
+func Recover(path string, opts ...trie.Option) (*trie.IPTrie, error) {
+	t, _, _, _, err := recoverLog(path, opts)
+	return t, err
+}
+
+// recoverLog loads the snapshot and replays the log over it, returning the
+// trie, the last sequence number, the number of records in the log and the
+// length of its intact part
+func recoverLog(path string, opts []trie.Option) (*trie.IPTrie, uint64, int, int64, error) {
+	t := trie.NewIPTrie(opts...)
+	var seq uint64
+
+	data, err := os.ReadFile(path + snapshotSuffix)
+	switch {
+	case err == nil:
+		var s snapshot
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, 0, 0, 0, fmt.Errorf("wal: invalid snapshot: %v", err)
+		}
+		if err := t.UnmarshalJSON(s.Trie); err != nil {
+			return nil, 0, 0, 0, fmt.Errorf("wal: %v", err)
+		}
+		seq = s.Seq
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, 0, 0, 0, fmt.Errorf("wal: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, seq, 0, 0, nil
+	}
+	if err != nil {
+		return nil, 0, 0, 0, fmt.Errorf("wal: %v", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var good int64
+	records := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything after the last newline is a torn write
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, 0, fmt.Errorf("wal: %v", err)
+		}
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if _, peekErr := r.Peek(1); peekErr == io.EOF {
+				break
+			}
+			return nil, 0, 0, 0, fmt.Errorf("wal: corrupt record at offset %d: %v", good, err)
+		}
+		good += int64(len(line))
+		records++
+
+		// Records up to the snapshot's are left over from a compaction
+		// interrupted before it could empty the log
+		if rec.Seq <= seq {
+			continue
+		}
+		// Logged metadata was prepared when it was first inserted
+		if err := t.Apply(rec.Event); err != nil {
+			return nil, 0, 0, 0, fmt.Errorf("wal: replay record %d: %v", rec.Seq, err)
+		}
+		seq = rec.Seq
+	}
+	return t, seq, records, good, nil
+}
+
+// Trie returns the logged trie. Changes made to it directly are logged as
+// well; check Err afterwards.
+func (l *Log) Trie() *trie.IPTrie {
+	return l.trie
+}
+
+// Insert inserts into the trie and returns once the change is logged
+func (l *Log) Insert(cidr string, metadata map[string]interface{}) error {
+	if err := l.trie.Insert(cidr, metadata); err != nil {
+		return err
+	}
+	return l.Err()
+}
+
+// Delete deletes from the trie and returns once the change is logged
+func (l *Log) Delete(cidr string) error {
+	if err := l.trie.Delete(cidr); err != nil {
+		return err
+	}
+	return l.Err()
+}
+
+// Err returns the error that stopped logging, if any. Once a record fails
+// to be written the log no longer matches the trie and every later change
+// is dropped, until a successful Compact writes the whole trie out again.
+func (l *Log) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// append is the trie's change hook
+func (l *Log) append(e trie.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+
+	e.Previous = nil
+	if e.Type == trie.EventDelete {
+		e.Metadata = nil
+	}
+	line, err := json.Marshal(record{Seq: l.seq + 1, Event: e})
+	if err == nil {
+		_, err = l.f.Write(append(line, '\n'))
+	}
+	if err == nil && !l.cfg.NoSync {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		l.err = fmt.Errorf("wal: append %s %s: %v", e.Type, e.CIDR, err)
+		return
+	}
+	l.seq++
+	l.records++
+
+	if l.cfg.CompactEvery > 0 && l.records >= l.cfg.CompactEvery {
+		l.err = l.compact()
+	}
+}
+
+// Compact writes the whole trie to the snapshot file and empties the log
+func (l *Log) Compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = l.compact()
+	return l.err
+}
+
+// compact does the work of Compact with l.mu held. The snapshot replaces
+// the old one atomically and records the last sequence number it holds,
+// so a crash before the log is emptied only leaves records to skip.
+func (l *Log) compact() error {
+	data, err := l.trie.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("wal: compact: %v", err)
+	}
+	data, err = json.Marshal(snapshot{Seq: l.seq, Trie: data})
+	if err != nil {
+		return fmt.Errorf("wal: compact: %v", err)
+	}
+
+	tmp := l.path + snapshotSuffix + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("wal: compact: %v", err)
+	}
+	if err := os.Rename(tmp, l.path+snapshotSuffix); err != nil {
+		return fmt.Errorf("wal: compact: %v", err)
+	}
+
+	if err := l.f.Truncate(0); err != nil {
+		return fmt.Errorf("wal: compact: %v", err)
+	}
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("wal: compact: %v", err)
+	}
+	l.records = 0
+	return nil
+}
+
+// writeSynced writes data to a new file at path and flushes it to disk
+func writeSynced(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Close closes the log file. Later changes to the trie are not logged.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = errors.New("wal: log closed")
+	}
+	return l.f.Close()
+}
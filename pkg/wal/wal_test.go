@@ -0,0 +1,214 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/metajar/trie-network/pkg/trie"
+)
+
+// populate applies the same changes to a logged trie in every test
+func populate(t *testing.T, l *Log) {
+	t.Helper()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "2001:db8::/32"} {
+		if err := l.Insert(cidr, map[string]interface{}{"owner": cidr}); err != nil {
+			t.Fatalf("Insert(%s) returned error: %v", cidr, err)
+		}
+	}
+	if err := l.Delete("10.1.0.0/16"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	// Changes made on the trie directly are logged too
+	err := l.Trie().Upsert("10.0.0.0/8", map[string]interface{}{"site": "ams"}, trie.MergeMaps)
+	if err != nil {
+		t.Fatalf("Upsert returned error: %v", err)
+	}
+}
+
+// checkRecovered verifies the state left by populate
+func checkRecovered(t *testing.T, got *trie.IPTrie) {
+	t.Helper()
+	if got.Len() != 3 {
+		t.Errorf("Expected 3 prefixes, got %d", got.Len())
+	}
+	if cidr, md, err := got.Find("10.1.9.9"); err != nil || cidr != "10.0.0.0/8" || md["site"] != "ams" || md["owner"] != "10.0.0.0/8" {
+		t.Errorf("Unexpected match %s %v %v", cidr, md, err)
+	}
+	if cidr, _, _ := got.Find("10.1.2.3"); cidr != "10.1.2.3/32" {
+		t.Errorf("Expected the host route, got %s", cidr)
+	}
+}
+
+func TestLogRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trie.wal")
+	l, err := Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	populate(t, l)
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	recovered, err := Recover(path)
+	if err != nil {
+		t.Fatalf("Recover returned error: %v", err)
+	}
+	checkRecovered(t, recovered)
+
+	// Reopening continues the log
+	l, err = Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	checkRecovered(t, l.Trie())
+	if err := l.Insert("192.168.0.0/16", nil); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if recovered, _ := Recover(path); recovered.Len() != 4 {
+		t.Errorf("Expected 4 prefixes after reopening, got %d", recovered.Len())
+	}
+}
+
+func TestLogRecoverPreparedMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trie.wal")
+	opts := []trie.Option{trie.WithReservedKeys(), trie.WithKeyNormalizer(trie.SnakeCaseKeys)}
+	l, err := Open(path, Config{}, opts...)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	// Stored metadata carries the reserved _sys key and normalized keys
+	if err := l.Trie().InsertSource("10.0.0.0/8", "ipam", map[string]interface{}{"ownerTeam": "netops"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Err(); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	recovered, err := Recover(path, opts...)
+	if err != nil {
+		t.Fatalf("Recover returned error: %v", err)
+	}
+	if _, md, err := recovered.Find("10.1.1.1"); err != nil || md["owner_team"] != "netops" || trie.SysMetadata(md) == nil {
+		t.Errorf("Expected the logged metadata back, got %v %v", md, err)
+	}
+	if l, err = Open(path, Config{}, opts...); err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	l.Close()
+}
+
+func TestLogCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trie.wal")
+	l, err := Open(path, Config{CompactEvery: 4, NoSync: true})
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	populate(t, l)
+	if l.records != 2 {
+		t.Errorf("Expected 2 records since the automatic compaction, got %d", l.records)
+	}
+	if _, err := os.Stat(path + snapshotSuffix); err != nil {
+		t.Errorf("Expected a snapshot: %v", err)
+	}
+
+	stale, _ := os.ReadFile(path)
+	if err := l.Compact(); err != nil {
+		t.Fatalf("Compact returned error: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("Expected an empty log after Compact, got %d bytes", info.Size())
+	}
+	l.Close()
+	recovered, err := Recover(path)
+	if err != nil {
+		t.Fatalf("Recover returned error: %v", err)
+	}
+	checkRecovered(t, recovered)
+
+	// A crash after writing the snapshot but before emptying the log
+	// leaves records the snapshot already holds, which replay skips
+	if err := os.WriteFile(path, stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	recovered, err = Recover(path)
+	if err != nil {
+		t.Fatalf("Recover with stale records returned error: %v", err)
+	}
+	checkRecovered(t, recovered)
+}
+
+func TestLogTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trie.wal")
+	l, err := Open(path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	populate(t, l)
+	l.Close()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":7,"type":"insert","cidr":"172.16`)
+	f.Close()
+
+	l, err = Open(path, Config{})
+	if err != nil {
+		t.Fatalf("Expected a torn final record to be dropped, got %v", err)
+	}
+	checkRecovered(t, l.Trie())
+	if err := l.Insert("172.16.0.0/12", nil); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if recovered, err := Recover(path); err != nil || recovered.Len() != 4 {
+		t.Errorf("Expected the log to continue after the torn record, got %v", err)
+	}
+
+	// Corruption before the last record is an error
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append([]byte("garbage\n"), data...), 0o644)
+	if _, err := Recover(path); err == nil {
+		t.Errorf("Expected error for a corrupt record")
+	}
+}
+
+func TestLogErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trie.wal")
+	l, err := Open(path, Config{}, trie.WithCIDRMode(trie.CIDRStrict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Insert("10.1.1.1/8", nil); err == nil {
+		t.Errorf("Expected the trie's own errors to be returned")
+	}
+
+	// Unencodable metadata stops logging until a compaction succeeds
+	if err := l.Insert("10.0.0.0/8", map[string]interface{}{"bad": make(chan int)}); err == nil {
+		t.Errorf("Expected error logging unencodable metadata")
+	}
+	if err := l.Insert("10.2.0.0/16", nil); err == nil {
+		t.Errorf("Expected the log to stay failed")
+	}
+	_ = l.Trie().Delete("10.0.0.0/8")
+	if err := l.Compact(); err != nil {
+		t.Fatalf("Compact returned error: %v", err)
+	}
+	if err := l.Insert("10.3.0.0/16", nil); err != nil {
+		t.Errorf("Expected logging to resume after Compact, got %v", err)
+	}
+	l.Close()
+
+	recovered, err := Recover(path, trie.WithCIDRMode(trie.CIDRStrict))
+	if err != nil || recovered.Len() != 2 {
+		t.Errorf("Expected 2 prefixes, got %v", err)
+	}
+	if err := l.Insert("10.4.0.0/16", nil); err == nil {
+		t.Errorf("Expected error inserting after Close")
+	}
+}